@@ -17,7 +17,8 @@ import (
 	"code.gitea.io/gitea/modules/git"
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/private"
-	repo_module "code.gitea.io/gitea/modules/repository"
+	"code.gitea.io/gitea/modules/util"
+	repo_module "github.com/openmerlin/gitea_data/modules/repository"
 	"github.com/openmerlin/gitea_data/modules/setting"
 
 	"github.com/urfave/cli/v2"
@@ -351,6 +352,12 @@ Gitea or set your environment appropriately.`, "")
 		GitQuarantinePath:               os.Getenv(private.GitQuarantinePath),
 		GitPushOptions:                  pushOptions(),
 	}
+	// the ref updates are sent in batches which are audited as one push
+	pushID, err := util.CryptoRandomString(16)
+	if err != nil {
+		return fail(ctx, "Internal Server Error", "Unable to generate the push ID: %v", err)
+	}
+	hookOptions.GitPushOptions[repo_module.PushOptionPushID] = pushID
 	oldCommitIDs := make([]string, hookBatchSize)
 	newCommitIDs := make([]string, hookBatchSize)
 	refFullNames := make([]git.RefName, hookBatchSize)
@@ -469,11 +476,27 @@ func pushOptions() map[string]string {
 		for idx := 0; idx < pushCount; idx++ {
 			opt := os.Getenv(fmt.Sprintf("GIT_PUSH_OPTION_%d", idx))
 			kv := strings.SplitN(opt, "=", 2)
-			if len(kv) == 2 {
+			if strings.HasPrefix(kv[0], repo_module.PushOptionInternalPrefix) {
+				continue
+			} else if len(kv) == 2 {
 				opts[kv[0]] = kv[1]
+			} else if kv[0] != "" {
+				// a flag like "ci.skip"
+				opts[kv[0]] = "true"
 			}
 		}
 	}
+
+	// pass on how the pusher authenticated which is set by serv command or the http handler
+	for option, env := range map[string]string{
+		repo_module.PushOptionAuthMethod: repo_module.EnvAuthMethod,
+		repo_module.PushOptionAuthID:     repo_module.EnvAuthID,
+		repo_module.PushOptionClientIP:   repo_module.EnvClientIP,
+	} {
+		if value := os.Getenv(env); value != "" {
+			opts[option] = value
+		}
+	}
 	return opts
 }
 
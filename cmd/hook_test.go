@@ -0,0 +1,33 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/modules/private"
+
+	repo_module "github.com/openmerlin/gitea_data/modules/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushOptions(t *testing.T) {
+	t.Setenv(private.GitPushOptionCount, "4")
+	t.Setenv("GIT_PUSH_OPTION_0", "repo.description=a=b")
+	t.Setenv("GIT_PUSH_OPTION_1", "ci.skip")
+	// pushers can't forge the internal options
+	t.Setenv("GIT_PUSH_OPTION_2", repo_module.PushOptionAuthID+"=1")
+	t.Setenv("GIT_PUSH_OPTION_3", repo_module.PushOptionPushID+"=forged")
+	t.Setenv(repo_module.EnvAuthMethod, repo_module.AuthMethodDeployKey)
+	t.Setenv(repo_module.EnvAuthID, "3")
+	t.Setenv(repo_module.EnvClientIP, "")
+
+	assert.Equal(t, map[string]string{
+		"repo.description":               "a=b",
+		"ci.skip":                        "true",
+		repo_module.PushOptionAuthMethod: repo_module.AuthMethodDeployKey,
+		repo_module.PushOptionAuthID:     "3",
+	}, pushOptions())
+}
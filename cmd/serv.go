@@ -26,9 +26,9 @@ import (
 	"code.gitea.io/gitea/modules/pprof"
 	"code.gitea.io/gitea/modules/private"
 	"code.gitea.io/gitea/modules/process"
-	repo_module "code.gitea.io/gitea/modules/repository"
+	repo_module "github.com/openmerlin/gitea_data/modules/repository"
 	"github.com/openmerlin/gitea_data/modules/setting"
-	"code.gitea.io/gitea/services/lfs"
+	"github.com/openmerlin/gitea_data/services/lfs"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/kballard/go-shellquote"
@@ -56,7 +56,7 @@ var CmdServ = &cli.Command{
 	},
 }
 
-func setup(ctx context.Context, debug bool) {
+func setup(ctx context.Context, debug bool) error {
 	if debug {
 		setupConsoleLogger(log.TRACE, false, os.Stderr)
 	} else {
@@ -71,16 +71,15 @@ func setup(ctx context.Context, debug bool) {
 	// `[repository]` `ROOT` is a relative path and $GITEA_WORK_DIR isn't passed to the SSH connection.
 	if _, err := os.Stat(setting.RepoRootPath); err != nil {
 		if os.IsNotExist(err) {
-			_ = fail(ctx, "Incorrect configuration, no repository directory.", "Directory `[repository].ROOT` %q was not found, please check if $GITEA_WORK_DIR is passed to the SSH connection or make `[repository].ROOT` an absolute value.", setting.RepoRootPath)
-		} else {
-			_ = fail(ctx, "Incorrect configuration, repository directory is inaccessible", "Directory `[repository].ROOT` %q is inaccessible. err: %v", setting.RepoRootPath, err)
+			return fail(ctx, "Incorrect configuration, no repository directory.", "Directory `[repository].ROOT` %q was not found, please check if $GITEA_WORK_DIR is passed to the SSH connection or make `[repository].ROOT` an absolute value.", setting.RepoRootPath)
 		}
-		return
+		return fail(ctx, "Incorrect configuration, repository directory is inaccessible", "Directory `[repository].ROOT` %q is inaccessible. err: %v", setting.RepoRootPath, err)
 	}
 
 	if err := git.InitSimple(context.Background()); err != nil {
-		_ = fail(ctx, "Failed to init git", "Failed to init git, err: %v", err)
+		return fail(ctx, "Failed to init git", "Failed to init git, err: %v", err)
 	}
+	return nil
 }
 
 var (
@@ -140,7 +139,9 @@ func runServ(c *cli.Context) error {
 	defer cancel()
 
 	// FIXME: This needs to internationalised
-	setup(ctx, c.Bool("debug"))
+	if err := setup(ctx, c.Bool("debug")); err != nil {
+		return err
+	}
 
 	if setting.SSH.Disabled {
 		println("Gitea: SSH has been disabled")
@@ -160,7 +161,7 @@ func runServ(c *cli.Context) error {
 	}
 	keyID, err := strconv.ParseInt(keys[1], 10, 64)
 	if err != nil {
-		return fail(ctx, "Key ID parsing error", "Invalid key argument: %s", c.Args().Get(1))
+		return fail(ctx, "Key ID parsing error", "Invalid key argument: %s", c.Args().First())
 	}
 
 	cmd := os.Getenv("SSH_ORIGINAL_COMMAND")
@@ -183,52 +184,15 @@ func runServ(c *cli.Context) error {
 		log.Debug("SSH_ORIGINAL_COMMAND: %s", os.Getenv("SSH_ORIGINAL_COMMAND"))
 	}
 
-	words, err := shellquote.Split(cmd)
-	if err != nil {
-		return fail(ctx, "Error parsing arguments", "Failed to parse arguments: %v", err)
-	}
-
-	if len(words) < 2 {
-		if git.CheckGitVersionAtLeast("2.29") == nil {
-			// for AGit Flow
-			if cmd == "ssh_info" {
-				fmt.Print(`{"type":"gitea","version":1}`)
-				return nil
-			}
-		}
-		return fail(ctx, "Too few arguments", "Too few arguments in cmd: %s", cmd)
-	}
-
-	verb := words[0]
-	repoPath := words[1]
-	if repoPath[0] == '/' {
-		repoPath = repoPath[1:]
-	}
-
-	var lfsVerb string
-	if verb == lfsAuthenticateVerb {
-		if !setting.LFS.StartServer {
-			return fail(ctx, "Unknown git command", "LFS authentication request over SSH denied, LFS support is disabled")
-		}
-
-		if len(words) > 2 {
-			lfsVerb = words[2]
-		}
-	}
-
-	// LowerCase and trim the repoPath as that's how they are stored.
-	repoPath = strings.ToLower(strings.TrimSpace(repoPath))
-
-	rr := strings.SplitN(repoPath, "/", 2)
-	if len(rr) != 2 {
-		return fail(ctx, "Invalid repository path", "Invalid repository path: %v", repoPath)
+	if cmd == "ssh_info" && git.CheckGitVersionAtLeast("2.29") == nil {
+		// for AGit Flow
+		fmt.Print(`{"type":"gitea","version":1}`)
+		return nil
 	}
 
-	username := strings.ToLower(rr[0])
-	reponame := strings.ToLower(strings.TrimSuffix(rr[1], ".git"))
-
-	if alphaDashDotPattern.MatchString(reponame) {
-		return fail(ctx, "Invalid repo name", "Invalid repo name: %s", reponame)
+	req, err := parseServCommand(cmd)
+	if err != nil {
+		return failServ(ctx, err)
 	}
 
 	if c.Bool("enable-pprof") {
@@ -236,41 +200,30 @@ func runServ(c *cli.Context) error {
 			return fail(ctx, "Error while trying to create PPROF_DATA_PATH", "Error while trying to create PPROF_DATA_PATH: %v", err)
 		}
 
-		stopCPUProfiler, err := pprof.DumpCPUProfileForUsername(setting.PprofDataPath, username)
+		stopCPUProfiler, err := pprof.DumpCPUProfileForUsername(setting.PprofDataPath, req.ownerName)
 		if err != nil {
 			return fail(ctx, "Unable to start CPU profiler", "Unable to start CPU profile: %v", err)
 		}
 		defer func() {
 			stopCPUProfiler()
-			err := pprof.DumpMemProfileForUsername(setting.PprofDataPath, username)
+			err := pprof.DumpMemProfileForUsername(setting.PprofDataPath, req.ownerName)
 			if err != nil {
 				_ = fail(ctx, "Unable to dump Mem profile", "Unable to dump Mem Profile: %v", err)
 			}
 		}()
 	}
 
-	requestedMode, has := allowedCommands[verb]
-	if !has {
-		return fail(ctx, "Unknown git command", "Unknown git command %s", verb)
-	}
-
-	if verb == lfsAuthenticateVerb {
-		if lfsVerb == "upload" {
-			requestedMode = perm.AccessModeWrite
-		} else if lfsVerb == "download" {
-			requestedMode = perm.AccessModeRead
-		} else {
-			return fail(ctx, "Unknown LFS verb", "Unknown lfs verb %s", lfsVerb)
-		}
-	}
-
-	results, extra := private.ServCommand(ctx, keyID, username, reponame, requestedMode, verb, lfsVerb)
-	if extra.HasError() {
-		return fail(ctx, extra.UserMsg, "ServCommand failed: %s", extra.Error)
+	results, err := servCommand(ctx, keyID, req)
+	if err != nil {
+		return failServ(ctx, err)
 	}
+	verb, lfsVerb := req.verb, req.lfsVerb
 
 	// LFS token authentication
 	if verb == lfsAuthenticateVerb {
+		if results.IsWiki {
+			return fail(ctx, "LFS is not supported for wikis", "LFS authentication request over SSH denied for the wiki of %s/%s", results.OwnerName, results.RepoName)
+		}
 		url := fmt.Sprintf("%s%s/%s.git/info/lfs", setting.AppURL, url.PathEscape(results.OwnerName), url.PathEscape(results.RepoName))
 
 		now := time.Now()
@@ -278,15 +231,15 @@ func runServ(c *cli.Context) error {
 			RegisteredClaims: jwt.RegisteredClaims{
 				ExpiresAt: jwt.NewNumericDate(now.Add(setting.LFS.HTTPAuthExpiry)),
 				NotBefore: jwt.NewNumericDate(now),
+				IssuedAt:  jwt.NewNumericDate(now),
 			},
-			RepoID: results.RepoID,
-			Op:     lfsVerb,
-			UserID: results.UserID,
+			RepoID:      results.RepoID,
+			Op:          lfsVerb,
+			UserID:      results.UserID,
+			DeployKeyID: results.DeployKeyID,
 		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-		// Sign and get the complete encoded token as a string using the secret
-		tokenString, err := token.SignedString(setting.LFS.JWTSecretBytes)
+		// Sign and get the complete encoded token as a string using the active key
+		tokenString, err := lfs.SignToken(&claims)
 		if err != nil {
 			return fail(ctx, "Failed to sign JWT Token", "Failed to sign JWT token: %v", err)
 		}
@@ -305,6 +258,13 @@ func runServ(c *cli.Context) error {
 		return nil
 	}
 
+	// serve the repository the private API resolved rather than the path the client sent
+	repoPath := results.OwnerName + "/" + results.RepoName
+	if results.IsWiki {
+		repoPath += ".wiki"
+	}
+	repoPath = strings.ToLower(repoPath + ".git")
+
 	var gitcmd *exec.Cmd
 	gitBinPath := filepath.Dir(git.GitExecutable) // e.g. /usr/bin
 	gitBinVerb := filepath.Join(gitBinPath, verb) // e.g. /usr/bin/git-upload-pack
@@ -341,6 +301,20 @@ func runServ(c *cli.Context) error {
 		repo_module.EnvKeyID+"="+fmt.Sprintf("%d", results.KeyID),
 		repo_module.EnvAppURL+"="+setting.AppURL,
 	)
+	if results.DeployKeyID > 0 {
+		gitcmd.Env = append(gitcmd.Env,
+			repo_module.EnvAuthMethod+"="+repo_module.AuthMethodDeployKey,
+			repo_module.EnvAuthID+"="+strconv.FormatInt(results.DeployKeyID, 10))
+	} else {
+		gitcmd.Env = append(gitcmd.Env,
+			repo_module.EnvAuthMethod+"="+repo_module.AuthMethodSSHKey,
+			repo_module.EnvAuthID+"="+strconv.FormatInt(results.KeyID, 10))
+	}
+	// SSH_CONNECTION is "client-ip client-port server-ip server-port"
+	if conn := strings.Fields(os.Getenv("SSH_CONNECTION")); len(conn) > 0 {
+		gitcmd.Env = append(gitcmd.Env, repo_module.EnvClientIP+"="+conn[0])
+	}
+	gitcmd.Env = repo_module.HiddenRefsEnv(gitcmd.Env)
 	// to avoid breaking, here only use the minimal environment variables for the "gitea serv" command.
 	// it could be re-considered whether to use the same git.CommonGitCmdEnvs() as "git" command later.
 	gitcmd.Env = append(gitcmd.Env, git.CommonCmdServEnvs()...)
@@ -358,3 +332,100 @@ func runServ(c *cli.Context) error {
 
 	return nil
 }
+
+// servRequest is the git command a serv session has been asked to run
+type servRequest struct {
+	verb      string
+	lfsVerb   string
+	ownerName string
+	repoName  string
+	mode      perm.AccessMode
+}
+
+// servError refuses the command of a serv session, UserMsg is shown to the client and LogMsg is logged
+type servError struct {
+	UserMsg string
+	LogMsg  string
+}
+
+func (err servError) Error() string {
+	return err.UserMsg + ": " + err.LogMsg
+}
+
+// failServ is fail for the refusals of parseServCommand and servCommand
+func failServ(ctx context.Context, err error) error {
+	if se, ok := err.(servError); ok {
+		return fail(ctx, se.UserMsg, "%s", se.LogMsg)
+	}
+	return fail(ctx, "", "%v", err)
+}
+
+// parseServCommand parses the SSH_ORIGINAL_COMMAND of a serv session into the git command, the repository
+// and the access mode it needs
+func parseServCommand(cmd string) (*servRequest, error) {
+	words, err := shellquote.Split(cmd)
+	if err != nil {
+		return nil, servError{UserMsg: "Error parsing arguments", LogMsg: fmt.Sprintf("Failed to parse arguments: %v", err)}
+	}
+	if len(words) < 2 {
+		return nil, servError{UserMsg: "Too few arguments", LogMsg: fmt.Sprintf("Too few arguments in cmd: %s", cmd)}
+	}
+
+	req := &servRequest{verb: words[0]}
+	repoPath := strings.TrimPrefix(words[1], "/")
+
+	if req.verb == lfsAuthenticateVerb {
+		if !setting.LFS.StartServer {
+			return nil, servError{UserMsg: "Unknown git command", LogMsg: "LFS authentication request over SSH denied, LFS support is disabled"}
+		}
+
+		if len(words) > 2 {
+			req.lfsVerb = words[2]
+		}
+	}
+
+	// LowerCase and trim the repoPath as that's how they are stored.
+	repoPath = strings.ToLower(strings.TrimSpace(repoPath))
+
+	rr := strings.SplitN(repoPath, "/", 2)
+	if len(rr) != 2 {
+		return nil, servError{UserMsg: "Invalid repository path", LogMsg: fmt.Sprintf("Invalid repository path: %v", repoPath)}
+	}
+
+	req.ownerName = strings.ToLower(rr[0])
+	req.repoName = strings.ToLower(strings.TrimSuffix(rr[1], ".git"))
+
+	// the names end up in a path below the repository root, so "." and ".." must never get through
+	if req.ownerName == "" || strings.HasPrefix(req.ownerName, ".") || alphaDashDotPattern.MatchString(req.ownerName) {
+		return nil, servError{UserMsg: "Invalid owner name", LogMsg: fmt.Sprintf("Invalid owner name: %s", req.ownerName)}
+	}
+	if req.repoName == "" || strings.HasPrefix(req.repoName, ".") || alphaDashDotPattern.MatchString(req.repoName) {
+		return nil, servError{UserMsg: "Invalid repo name", LogMsg: fmt.Sprintf("Invalid repo name: %s", req.repoName)}
+	}
+
+	var has bool
+	req.mode, has = allowedCommands[req.verb]
+	if !has {
+		return nil, servError{UserMsg: "Unknown git command", LogMsg: fmt.Sprintf("Unknown git command %s", req.verb)}
+	}
+
+	if req.verb == lfsAuthenticateVerb {
+		if req.lfsVerb == "upload" {
+			req.mode = perm.AccessModeWrite
+		} else if req.lfsVerb == "download" {
+			req.mode = perm.AccessModeRead
+		} else {
+			return nil, servError{UserMsg: "Unknown LFS verb", LogMsg: fmt.Sprintf("Unknown lfs verb %s", req.lfsVerb)}
+		}
+	}
+	return req, nil
+}
+
+// servCommand asks the private API whether the key may run the command, and for the repository to run it on
+func servCommand(ctx context.Context, keyID int64, req *servRequest) (*private.ServCommandResults, error) {
+	results, extra := private.ServCommand(ctx, keyID, req.ownerName, req.repoName, req.mode, req.verb, req.lfsVerb)
+	if extra.HasError() {
+		return nil, servError{UserMsg: extra.UserMsg, LogMsg: fmt.Sprintf("ServCommand failed: %s", extra.Error)}
+	}
+	return results, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.gitea.io/gitea/models/perm"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/private"
+	gitea_setting "code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServCommand(t *testing.T) {
+	defer test.MockVariableValue(&setting.LFS.StartServer, true)()
+
+	for cmd, expected := range map[string]*servRequest{
+		"git-upload-pack 'user2/repo1.git'":                  {verb: "git-upload-pack", ownerName: "user2", repoName: "repo1", mode: perm.AccessModeRead},
+		"git-upload-archive '/User2/Repo1.git'":              {verb: "git-upload-archive", ownerName: "user2", repoName: "repo1", mode: perm.AccessModeRead},
+		"git-receive-pack 'user2/repo1.wiki.git'":            {verb: "git-receive-pack", ownerName: "user2", repoName: "repo1.wiki", mode: perm.AccessModeWrite},
+		"git-lfs-authenticate user2/repo1.git download":      {verb: lfsAuthenticateVerb, lfsVerb: "download", ownerName: "user2", repoName: "repo1", mode: perm.AccessModeRead},
+		"git-lfs-authenticate 'user2/repo1.git' upload":      {verb: lfsAuthenticateVerb, lfsVerb: "upload", ownerName: "user2", repoName: "repo1", mode: perm.AccessModeWrite},
+		"git-upload-pack 'user2/repo1.git' --unused-options": {verb: "git-upload-pack", ownerName: "user2", repoName: "repo1", mode: perm.AccessModeRead},
+	} {
+		req, err := parseServCommand(cmd)
+		if assert.NoError(t, err, cmd) {
+			assert.Equal(t, expected, req, cmd)
+		}
+	}
+
+	for cmd, userMsg := range map[string]string{
+		"git-upload-pack 'user2/repo1.git":           "Error parsing arguments",
+		"git-upload-pack":                            "Too few arguments",
+		"git-upload-pack repo1.git":                  "Invalid repository path",
+		"git-upload-pack '../repo1.git'":             "Invalid owner name",
+		"git-upload-pack 'user2/..'":                 "Invalid repo name",
+		"git-upload-pack 'user2/../user3/repo3.git'": "Invalid repo name",
+		"git-upload-pack 'user2/repo 1.git'":         "Invalid repo name",
+		"git-shell 'user2/repo1.git'":                "Unknown git command",
+		"git-lfs-authenticate user2/repo1.git":       "Unknown LFS verb",
+		"git-lfs-authenticate user2/repo1.git push":  "Unknown LFS verb",
+	} {
+		_, err := parseServCommand(cmd)
+		if assert.IsType(t, servError{}, err, cmd) {
+			assert.Equal(t, userMsg, err.(servError).UserMsg, cmd)
+		}
+	}
+
+	// LFS can't be authenticated for if it's disabled
+	setting.LFS.StartServer = false
+	_, err := parseServCommand("git-lfs-authenticate user2/repo1.git download")
+	if assert.IsType(t, servError{}, err) {
+		assert.Equal(t, "Unknown git command", err.(servError).UserMsg)
+	}
+}
+
+func TestServCommand(t *testing.T) {
+	var requested *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/internal/serv/command/42/user2/private" {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(private.Response{Err: "no access", UserMsg: "You do not have permission"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(private.ServCommandResults{KeyID: 42, OwnerName: "user2", RepoName: "repo1", RepoID: 1})
+	}))
+	defer srv.Close()
+	defer test.MockVariableValue(&gitea_setting.LocalURL, srv.URL+"/")()
+	defer test.MockVariableValue(&gitea_setting.InternalToken, "internal-token")()
+
+	req, err := parseServCommand("git-receive-pack 'User2/Repo1.git'")
+	require.NoError(t, err)
+	results, err := servCommand(context.Background(), 42, req)
+	require.NoError(t, err)
+	assert.Equal(t, &private.ServCommandResults{KeyID: 42, OwnerName: "user2", RepoName: "repo1", RepoID: 1}, results)
+
+	// the private route is asked for the parsed repository and the access mode of the command
+	require.NotNil(t, requested)
+	assert.Equal(t, "/api/internal/serv/command/42/user2/repo1", requested.URL.Path)
+	assert.Equal(t, "2", requested.URL.Query().Get("mode"))
+	assert.Equal(t, []string{"git-receive-pack"}, requested.URL.Query()["verb"])
+	assert.Equal(t, "Bearer internal-token", requested.Header.Get("Authorization"))
+
+	// refusals of the private route are shown to the client
+	req, err = parseServCommand("git-upload-pack user2/private.git")
+	require.NoError(t, err)
+	_, err = servCommand(context.Background(), 42, req)
+	if assert.IsType(t, servError{}, err) {
+		assert.Equal(t, "You do not have permission", err.(servError).UserMsg)
+		assert.Contains(t, err.(servError).LogMsg, "no access")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package auth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	auth_model "code.gitea.io/gitea/models/auth"
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/timeutil"
+	"code.gitea.io/gitea/modules/util"
+)
+
+// AccessTokenPolicy restricts where and until when an access token may be used on top of its scope.
+// A token without a policy may be used on every repository its user can access and never expires.
+type AccessTokenPolicy struct {
+	TokenID int64 `xorm:"pk"`
+	// RepoIDs binds the token to these repositories, empty means any repository
+	RepoIDs []int64 `xorm:"TEXT JSON"`
+	// ExpiresUnix is the time after which the token is rejected, 0 means never
+	ExpiresUnix timeutil.TimeStamp `xorm:"INDEX NOT NULL DEFAULT 0"`
+	UpdatedUnix timeutil.TimeStamp `xorm:"updated"`
+}
+
+func init() {
+	db.RegisterModel(new(AccessTokenPolicy))
+}
+
+// IsExpired returns whether the token may no longer be used
+func (p *AccessTokenPolicy) IsExpired() bool {
+	return p.ExpiresUnix > 0 && p.ExpiresUnix <= timeutil.TimeStampNow()
+}
+
+// AllowsRepo returns whether the token may be used on the repository
+func (p *AccessTokenPolicy) AllowsRepo(repoID int64) bool {
+	return len(p.RepoIDs) == 0 || slices.Contains(p.RepoIDs, repoID)
+}
+
+// ErrAccessTokenIDNotExist represents a "AccessTokenIDNotExist" kind of error.
+type ErrAccessTokenIDNotExist struct {
+	ID int64
+}
+
+func (err ErrAccessTokenIDNotExist) Error() string {
+	return fmt.Sprintf("access token does not exist [id: %d]", err.ID)
+}
+
+func (err ErrAccessTokenIDNotExist) Unwrap() error {
+	return util.ErrNotExist
+}
+
+// ErrAccessTokenExpired represents a "AccessTokenExpired" kind of error.
+type ErrAccessTokenExpired struct {
+	ID int64
+}
+
+func (err ErrAccessTokenExpired) Error() string {
+	return fmt.Sprintf("access token has expired [id: %d]", err.ID)
+}
+
+func (err ErrAccessTokenExpired) Unwrap() error {
+	return util.ErrPermissionDenied
+}
+
+// ErrAccessTokenNotBound represents a "AccessTokenNotBound" kind of error.
+type ErrAccessTokenNotBound struct {
+	ID     int64
+	RepoID int64
+}
+
+func (err ErrAccessTokenNotBound) Error() string {
+	return fmt.Sprintf("access token is not bound to the repository [id: %d, repo_id: %d]", err.ID, err.RepoID)
+}
+
+func (err ErrAccessTokenNotBound) Unwrap() error {
+	return util.ErrPermissionDenied
+}
+
+// CheckAccessTokenPolicy returns an error if the token has expired or may not be used on the repository.
+// repoID is 0 for a repository which doesn't exist yet, a token bound to repositories may not create one.
+func CheckAccessTokenPolicy(ctx context.Context, tokenID, repoID int64) error {
+	p, err := GetAccessTokenPolicy(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	if p.IsExpired() {
+		return ErrAccessTokenExpired{ID: tokenID}
+	}
+	if !p.AllowsRepo(repoID) {
+		return ErrAccessTokenNotBound{ID: tokenID, RepoID: repoID}
+	}
+	return nil
+}
+
+// GetAccessTokenPolicy returns the policy of the token, a token without a policy gets an empty one
+func GetAccessTokenPolicy(ctx context.Context, tokenID int64) (*AccessTokenPolicy, error) {
+	p := &AccessTokenPolicy{TokenID: tokenID}
+	if _, err := db.GetEngine(ctx).ID(tokenID).Get(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetAccessTokenPolicy replaces the policy of an existing token
+func SetAccessTokenPolicy(ctx context.Context, p *AccessTokenPolicy) error {
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		has, err := db.GetEngine(ctx).ID(p.TokenID).Exist(new(auth_model.AccessToken))
+		if err != nil {
+			return err
+		} else if !has {
+			return ErrAccessTokenIDNotExist{p.TokenID}
+		}
+		if _, err := db.GetEngine(ctx).ID(p.TokenID).Delete(new(AccessTokenPolicy)); err != nil {
+			return err
+		}
+		return db.Insert(ctx, p)
+	})
+}
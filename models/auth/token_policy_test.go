@@ -0,0 +1,29 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package auth
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/modules/timeutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessTokenPolicy(t *testing.T) {
+	now := timeutil.TimeStampNow()
+
+	unrestricted := &AccessTokenPolicy{TokenID: 1}
+	assert.False(t, unrestricted.IsExpired())
+	assert.True(t, unrestricted.AllowsRepo(1))
+	assert.True(t, unrestricted.AllowsRepo(2))
+
+	bound := &AccessTokenPolicy{TokenID: 1, RepoIDs: []int64{2, 3}, ExpiresUnix: now + 60}
+	assert.False(t, bound.IsExpired())
+	assert.False(t, bound.AllowsRepo(1))
+	assert.True(t, bound.AllowsRepo(3))
+
+	expired := &AccessTokenPolicy{TokenID: 1, ExpiresUnix: now - 1}
+	assert.True(t, expired.IsExpired())
+}
@@ -4,6 +4,7 @@
 package git
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"time"
@@ -16,6 +17,8 @@ import (
 	"code.gitea.io/gitea/modules/timeutil"
 	"code.gitea.io/gitea/modules/util"
 
+	data_repo_module "github.com/openmerlin/gitea_data/modules/repository"
+
 	"xorm.io/builder"
 )
 
@@ -260,20 +263,110 @@ func AddDeletedBranch(ctx context.Context, repoID int64, branchName string, dele
 	return err
 }
 
+// UpdateBranchIfUnchanged sets the commit of the branch row old to the one of branch and marks it as not deleted,
+// unless the row has changed since old was read, e.g. by a concurrent push. It returns whether the row was updated.
+func UpdateBranchIfUnchanged(ctx context.Context, old, branch *Branch) (bool, error) {
+	cnt, err := db.GetEngine(ctx).Where("id=? AND commit_id=? AND is_deleted=?", old.ID, old.CommitID, old.IsDeleted).
+		Cols("commit_id, commit_message, commit_time, is_deleted, deleted_by_id, deleted_unix").
+		Update(&Branch{
+			CommitID:      branch.CommitID,
+			CommitMessage: branch.CommitMessage,
+			CommitTime:    branch.CommitTime,
+		})
+	return cnt > 0, err
+}
+
+// MarkBranchDeletedIfUnchanged marks the branch row old as deleted by deletedByID, unless the row has changed
+// since old was read. It returns whether the row was updated.
+func MarkBranchDeletedIfUnchanged(ctx context.Context, old *Branch, deletedByID int64) (bool, error) {
+	cnt, err := db.GetEngine(ctx).Where("id=? AND commit_id=? AND is_deleted=?", old.ID, old.CommitID, false).
+		Cols("is_deleted, deleted_by_id, deleted_unix").
+		Update(&Branch{
+			IsDeleted:   true,
+			DeletedByID: deletedByID,
+			DeletedUnix: timeutil.TimeStampNow(),
+		})
+	return cnt > 0, err
+}
+
+// RemoveDeletedBranchByID removes a deleted branch and the keep-alive ref of its tip
 func RemoveDeletedBranchByID(ctx context.Context, repoID, branchID int64) error {
-	_, err := db.GetEngine(ctx).Where("repo_id=? AND id=? AND is_deleted = ?", repoID, branchID, true).Delete(new(Branch))
-	return err
+	cnt, err := db.GetEngine(ctx).Where("repo_id=? AND id=? AND is_deleted = ?", repoID, branchID, true).Delete(new(Branch))
+	if err != nil || cnt == 0 {
+		return err
+	}
+	return removeKeepAliveRefs(ctx, repoID, []int64{branchID})
+}
+
+// removeKeepAliveRefs deletes the keep-alive refs of the removed deleted branches of a repository
+func removeKeepAliveRefs(ctx context.Context, repoID int64, branchIDs []int64) error {
+	repo, err := repo_model.GetRepositoryByID(ctx, repoID)
+	if err != nil {
+		if repo_model.IsErrRepoNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var stdin bytes.Buffer
+	for _, id := range branchIDs {
+		fmt.Fprintf(&stdin, "delete %s\n", data_repo_module.KeepAliveRefName(id))
+	}
+	var stderr bytes.Buffer
+	if err := git.NewCommand(ctx, "update-ref", "--stdin").Run(&git.RunOpts{Dir: repo.RepoPath(), Stdin: &stdin, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("delete the keep-alive refs of %s: %w - %s", repo.FullName(), err, stderr.String())
+	}
+	return nil
 }
 
-// RemoveOldDeletedBranches removes old deleted branches
+// RestoreDeletedBranch marks a deleted branch as pushed again by the restorer at the commit it was restored to
+func RestoreDeletedBranch(ctx context.Context, repoID, branchID, restorerID int64, commit *git.Commit) error {
+	cnt, err := db.GetEngine(ctx).Where("repo_id=? AND id=? AND is_deleted=?", repoID, branchID, true).
+		Cols("commit_id, commit_message, pusher_id, commit_time, is_deleted, deleted_by_id, deleted_unix").
+		Update(&Branch{
+			CommitID:      commit.ID.String(),
+			CommitMessage: commit.Summary(),
+			PusherID:      restorerID,
+			CommitTime:    timeutil.TimeStamp(commit.Committer.When.Unix()),
+		})
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return ErrBranchNotExist{RepoID: repoID}
+	}
+	return nil
+}
+
+// RemoveOldDeletedBranches removes old deleted branches and the keep-alive refs of their tips
 func RemoveOldDeletedBranches(ctx context.Context, olderThan time.Duration) {
 	// Nothing to do for shutdown or terminate
 	log.Trace("Doing: DeletedBranchesCleanup")
 
 	deleteBefore := time.Now().Add(-olderThan)
-	_, err := db.GetEngine(ctx).Where("is_deleted=? AND deleted_unix < ?", true, deleteBefore.Unix()).Delete(new(Branch))
-	if err != nil {
+	cond := builder.Eq{"is_deleted": true}.And(builder.Lt{"deleted_unix": deleteBefore.Unix()})
+	var branches []*Branch
+	if err := db.GetEngine(ctx).Where(cond).Cols("id", "repo_id").Find(&branches); err != nil {
 		log.Error("DeletedBranchesCleanup: %v", err)
+		return
+	}
+	if len(branches) == 0 {
+		return
+	}
+	branchIDs := make(map[int64][]int64)
+	ids := make([]int64, 0, len(branches))
+	for _, branch := range branches {
+		branchIDs[branch.RepoID] = append(branchIDs[branch.RepoID], branch.ID)
+		ids = append(ids, branch.ID)
+	}
+	// a branch restored in the meantime is kept
+	if _, err := db.GetEngine(ctx).Where(cond).In("id", ids).Delete(new(Branch)); err != nil {
+		log.Error("DeletedBranchesCleanup: %v", err)
+		return
+	}
+	for repoID, ids := range branchIDs {
+		if err := removeKeepAliveRefs(ctx, repoID, ids); err != nil {
+			log.Error("DeletedBranchesCleanup: %v", err)
+		}
 	}
 }
 
@@ -0,0 +1,100 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package git
+
+import (
+	"context"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/timeutil"
+)
+
+// BranchProtectionFile marks a repository whose protected branch rules are managed as code, by a file
+// on the default branch. Only an admin can enable it, after which pushes of the file replace the rules.
+type BranchProtectionFile struct {
+	ID          int64 `xorm:"pk autoincr"`
+	RepoID      int64 `xorm:"UNIQUE NOT NULL"`
+	EnabledByID int64 `xorm:"NOT NULL"`
+	// AppliedCommitID and AppliedBlobID are the commit and the content of the file the rules were last set from
+	AppliedCommitID string `xorm:"VARCHAR(64)"`
+	AppliedBlobID   string `xorm:"VARCHAR(64)"`
+	AppliedUnix     timeutil.TimeStamp
+	// LastError is why the file at LastErrorCommitID couldn't be applied, it's cleared once a file is applied
+	LastErrorCommitID string `xorm:"VARCHAR(64)"`
+	LastError         string `xorm:"TEXT"`
+	LastErrorUnix     timeutil.TimeStamp
+
+	CreatedUnix timeutil.TimeStamp `xorm:"created"`
+	UpdatedUnix timeutil.TimeStamp `xorm:"updated"`
+}
+
+func init() {
+	db.RegisterModel(new(BranchProtectionFile))
+}
+
+// GetBranchProtectionFile returns the state of the rules managed as code of the repository, nil if they aren't
+func GetBranchProtectionFile(ctx context.Context, repoID int64) (*BranchProtectionFile, error) {
+	file := &BranchProtectionFile{}
+	has, err := db.GetEngine(ctx).Where("repo_id = ?", repoID).Get(file)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, nil
+	}
+	return file, nil
+}
+
+// IsBranchProtectionManagedByFile returns whether the protected branch rules of the repository are managed as code
+func IsBranchProtectionManagedByFile(ctx context.Context, repoID int64) (bool, error) {
+	return db.GetEngine(ctx).Where("repo_id = ?", repoID).Exist(&BranchProtectionFile{})
+}
+
+// SaveBranchProtectionFile records the file the rules of the repository were set from, enabling the management
+// as code by enabledByID if it isn't enabled yet
+func SaveBranchProtectionFile(ctx context.Context, repoID, enabledByID int64, commitID, blobID string) error {
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		file, err := GetBranchProtectionFile(ctx, repoID)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return db.Insert(ctx, &BranchProtectionFile{
+				RepoID:          repoID,
+				EnabledByID:     enabledByID,
+				AppliedCommitID: commitID,
+				AppliedBlobID:   blobID,
+				AppliedUnix:     timeutil.TimeStampNow(),
+			})
+		}
+		file.AppliedCommitID = commitID
+		file.AppliedBlobID = blobID
+		file.AppliedUnix = timeutil.TimeStampNow()
+		file.LastErrorCommitID = ""
+		file.LastError = ""
+		file.LastErrorUnix = 0
+		_, err = db.GetEngine(ctx).ID(file.ID).
+			Cols("applied_commit_id", "applied_blob_id", "applied_unix", "last_error_commit_id", "last_error", "last_error_unix").
+			Update(file)
+		return err
+	})
+}
+
+// SaveBranchProtectionFileError records why the file at commitID couldn't be applied to the repository,
+// the rules stay those of the file applied last
+func SaveBranchProtectionFileError(ctx context.Context, repoID int64, commitID, lastError string) error {
+	_, err := db.GetEngine(ctx).Where("repo_id = ?", repoID).
+		Cols("last_error_commit_id", "last_error", "last_error_unix").
+		Update(&BranchProtectionFile{
+			LastErrorCommitID: commitID,
+			LastError:         lastError,
+			LastErrorUnix:     timeutil.TimeStampNow(),
+		})
+	return err
+}
+
+// DeleteBranchProtectionFile stops managing the rules of the repository as code, the rules themselves are kept
+func DeleteBranchProtectionFile(ctx context.Context, repoID int64) error {
+	_, err := db.GetEngine(ctx).Where("repo_id = ?", repoID).Delete(&BranchProtectionFile{})
+	return err
+}
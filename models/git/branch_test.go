@@ -4,7 +4,10 @@
 package git_test
 
 import (
+	"os/exec"
+	"strings"
 	"testing"
+	"time"
 
 	"code.gitea.io/gitea/models/db"
 	git_model "github.com/openmerlin/gitea_data/models/git"
@@ -12,7 +15,9 @@ import (
 	repo_model "code.gitea.io/gitea/models/repo"
 	"code.gitea.io/gitea/models/unittest"
 	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/timeutil"
 	"code.gitea.io/gitea/modules/util"
+	data_repo_module "github.com/openmerlin/gitea_data/modules/repository"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -88,10 +93,75 @@ func TestRemoveDeletedBranch(t *testing.T) {
 
 	firstBranch := unittest.AssertExistsAndLoadBean(t, &git_model.Branch{ID: 1})
 
+	keepAliveRef := data_repo_module.KeepAliveRefName(firstBranch.ID)
+	runGit(t, repo.RepoPath(), "update-ref", keepAliveRef, firstBranch.CommitID)
+
 	err := git_model.RemoveDeletedBranchByID(db.DefaultContext, repo.ID, 1)
 	assert.NoError(t, err)
 	unittest.AssertNotExistsBean(t, firstBranch)
 	unittest.AssertExistsAndLoadBean(t, &git_model.Branch{ID: 2})
+	assert.Empty(t, runGit(t, repo.RepoPath(), "for-each-ref", keepAliveRef))
+
+	// a branch which isn't deleted is kept
+	assert.NoError(t, git_model.RemoveDeletedBranchByID(db.DefaultContext, repo.ID, 3))
+	unittest.AssertExistsAndLoadBean(t, &git_model.Branch{ID: 3})
+}
+
+func TestRemoveOldDeletedBranches(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	recent := &git_model.Branch{
+		RepoID:      repo.ID,
+		Name:        "recently-deleted",
+		CommitID:    "65f1bf27bc3bf70f64657658635e66094edbcb4d",
+		IsDeleted:   true,
+		DeletedUnix: timeutil.TimeStampNow(),
+	}
+	assert.NoError(t, db.Insert(db.DefaultContext, recent))
+	for _, id := range []int64{1, 2, recent.ID} {
+		runGit(t, repo.RepoPath(), "update-ref", data_repo_module.KeepAliveRefName(id), "65f1bf27bc3bf70f64657658635e66094edbcb4d")
+	}
+
+	// the fixtures were deleted in 2001
+	git_model.RemoveOldDeletedBranches(db.DefaultContext, time.Hour)
+	unittest.AssertNotExistsBean(t, &git_model.Branch{ID: 1})
+	unittest.AssertNotExistsBean(t, &git_model.Branch{ID: 2})
+	unittest.AssertExistsAndLoadBean(t, &git_model.Branch{ID: recent.ID})
+	unittest.AssertExistsAndLoadBean(t, &git_model.Branch{ID: 3})
+	assert.Equal(t, data_repo_module.KeepAliveRefName(recent.ID), runGit(t, repo.RepoPath(), "for-each-ref", "--format=%(refname)", data_repo_module.KeepAliveRefPrefix))
+}
+
+func runGit(t *testing.T, repoPath string, args ...string) string {
+	out, err := exec.Command("git", append([]string{"--git-dir", repoPath}, args...)...).Output()
+	assert.NoError(t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
+
+func TestRestoreDeletedBranch(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+
+	firstBranch := unittest.AssertExistsAndLoadBean(t, &git_model.Branch{ID: 1})
+	assert.True(t, firstBranch.IsDeleted)
+
+	commit := &git.Commit{
+		ID:            git.MustIDFromString(firstBranch.CommitID),
+		CommitMessage: firstBranch.CommitMessage,
+		Committer: &git.Signature{
+			When: firstBranch.CommitTime.AsLocalTime(),
+		},
+	}
+	assert.NoError(t, git_model.RestoreDeletedBranch(db.DefaultContext, repo.ID, firstBranch.ID, 2, commit))
+
+	restored := unittest.AssertExistsAndLoadBean(t, &git_model.Branch{ID: 1})
+	assert.False(t, restored.IsDeleted)
+	assert.EqualValues(t, 0, restored.DeletedByID)
+	assert.EqualValues(t, 2, restored.PusherID)
+	assert.Equal(t, firstBranch.CommitID, restored.CommitID)
+
+	// a branch which isn't deleted can't be restored
+	err := git_model.RestoreDeletedBranch(db.DefaultContext, repo.ID, firstBranch.ID, 2, commit)
+	assert.True(t, git_model.IsErrBranchNotExist(err))
 }
 
 func getDeletedBranch(t *testing.T, branch *git_model.Branch) *git_model.Branch {
@@ -10,7 +10,7 @@ import (
 	git_model "github.com/openmerlin/gitea_data/models/git"
 	repo_model "code.gitea.io/gitea/models/repo"
 	"code.gitea.io/gitea/models/unittest"
-	"github.com/openmerlin/gitea_data/modules/structs"
+	"code.gitea.io/gitea/modules/structs"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -18,6 +18,7 @@ import (
 	"code.gitea.io/gitea/models/unit"
 	user_model "code.gitea.io/gitea/models/user"
 	"code.gitea.io/gitea/modules/base"
+	"code.gitea.io/gitea/modules/container"
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/timeutil"
 	"code.gitea.io/gitea/modules/util"
@@ -58,6 +59,12 @@ type ProtectedBranch struct {
 	ProtectedFilePatterns         string   `xorm:"TEXT"`
 	UnprotectedFilePatterns       string   `xorm:"TEXT"`
 
+	// StatusCheckOnPush requires the status checks of EnableStatusCheck for direct pushes too, not only for merges
+	StatusCheckOnPush bool `xorm:"NOT NULL DEFAULT false"`
+	// StatusCheckPushParent also accepts a pushed head if its n-th parent passes the checks, e.g. 2 for
+	// the branch a merge commit merged. 0 only checks the head.
+	StatusCheckPushParent int `xorm:"NOT NULL DEFAULT 0"`
+
 	CreatedUnix timeutil.TimeStamp `xorm:"created"`
 	UpdatedUnix timeutil.TimeStamp `xorm:"updated"`
 }
@@ -272,6 +279,51 @@ func (protectBranch *ProtectedBranch) IsUnprotectedFile(patterns []glob.Glob, pa
 	return r
 }
 
+// CheckPushStatuses returns the required status contexts which no status matches, and the matching
+// statuses which haven't succeeded. Without required contexts every status has to succeed.
+func (protectBranch *ProtectedBranch) CheckPushStatuses(statuses []*CommitStatus) (missing []string, failed []*CommitStatus) {
+	if len(protectBranch.StatusCheckContexts) == 0 {
+		if len(statuses) == 0 {
+			return []string{"*"}, nil
+		}
+		for _, status := range statuses {
+			if !status.State.IsSuccess() {
+				failed = append(failed, status)
+			}
+		}
+		return nil, failed
+	}
+
+	failedIDs := make(container.Set[int64])
+	for _, required := range protectBranch.StatusCheckContexts {
+		gp, err := glob.Compile(required)
+		if err != nil {
+			log.Warn("Invalid required status context for ProtectedBranch[%d]: %s %v", protectBranch.ID, required, err)
+			gp = glob.MustCompile(glob.QuoteMeta(required))
+		}
+		matched := false
+		for _, status := range statuses {
+			if !gp.Match(status.Context) {
+				continue
+			}
+			matched = true
+			if !status.State.IsSuccess() && failedIDs.Add(status.ID) {
+				failed = append(failed, status)
+			}
+		}
+		if !matched {
+			missing = append(missing, required)
+		}
+	}
+	return missing, failed
+}
+
+// UpdateProtectedBranchPushStatusCheck saves whether direct pushes need passing status checks
+func UpdateProtectedBranchPushStatusCheck(ctx context.Context, protectBranch *ProtectedBranch) error {
+	_, err := db.GetEngine(ctx).ID(protectBranch.ID).Cols("status_check_on_push", "status_check_push_parent").Update(protectBranch)
+	return err
+}
+
 // GetProtectedBranchRuleByName getting protected branch rule by name
 func GetProtectedBranchRuleByName(ctx context.Context, repoID int64, ruleName string) (*ProtectedBranch, error) {
 	rel := &ProtectedBranch{RepoID: repoID, RuleName: ruleName}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"testing"
 
+	api "code.gitea.io/gitea/modules/structs"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -76,3 +78,32 @@ func TestBranchRuleMatch(t *testing.T) {
 		)
 	}
 }
+
+func TestCheckPushStatuses(t *testing.T) {
+	statuses := []*CommitStatus{
+		{ID: 1, Context: "ci/build", State: api.CommitStatusSuccess},
+		{ID: 2, Context: "ci/test", State: api.CommitStatusFailure},
+		{ID: 3, Context: "lint", State: api.CommitStatusPending},
+	}
+
+	pb := ProtectedBranch{StatusCheckContexts: []string{"ci/*", "lint", "docs"}}
+	missing, failed := pb.CheckPushStatuses(statuses)
+	assert.Equal(t, []string{"docs"}, missing)
+	if assert.Len(t, failed, 2) {
+		assert.EqualValues(t, 2, failed[0].ID)
+		assert.EqualValues(t, 3, failed[1].ID)
+	}
+
+	pb = ProtectedBranch{StatusCheckContexts: []string{"ci/build"}}
+	missing, failed = pb.CheckPushStatuses(statuses)
+	assert.Empty(t, missing)
+	assert.Empty(t, failed)
+
+	// without required contexts every status has to succeed
+	pb = ProtectedBranch{}
+	missing, failed = pb.CheckPushStatuses(statuses[:1])
+	assert.Empty(t, missing)
+	assert.Empty(t, failed)
+	missing, _ = pb.CheckPushStatuses(nil)
+	assert.Equal(t, []string{"*"}, missing)
+}
@@ -25,6 +25,12 @@ type ProtectedTag struct {
 	GlobPattern      glob.Glob      `xorm:"-"`
 	AllowlistUserIDs []int64        `xorm:"JSON TEXT"`
 	AllowlistTeamIDs []int64        `xorm:"JSON TEXT"`
+	// Immutable tags can't be moved or deleted once they exist, not even by allowlisted users.
+	Immutable bool `xorm:"NOT NULL DEFAULT false"`
+	// AnyoneCanCreate lets every user who can write code create immutable tags, not only the allowlisted ones
+	AnyoneCanCreate bool `xorm:"NOT NULL DEFAULT false"`
+	// RequireSigned requires immutable tags to be annotated and signed with a verified key
+	RequireSigned bool `xorm:"NOT NULL DEFAULT false"`
 
 	CreatedUnix timeutil.TimeStamp `xorm:"created"`
 	UpdatedUnix timeutil.TimeStamp `xorm:"updated"`
@@ -135,3 +141,49 @@ func IsUserAllowedToControlTag(ctx context.Context, tags []*ProtectedTag, tagNam
 
 	return isAllowed, nil
 }
+
+// IsUserAllowedToCreateTag checks if a user can create the specific tag. It returns true if an immutable
+// protected tag lets anyone create it, otherwise it is the same as IsUserAllowedToControlTag.
+func IsUserAllowedToCreateTag(ctx context.Context, tags []*ProtectedTag, tagName string, userID int64) (bool, error) {
+	for _, tag := range tags {
+		if !tag.Immutable || !tag.AnyoneCanCreate {
+			continue
+		}
+		if err := tag.EnsureCompiledPattern(); err != nil {
+			return false, err
+		}
+		if tag.matchString(tagName) {
+			return true, nil
+		}
+	}
+	return IsUserAllowedToControlTag(ctx, tags, tagName, userID)
+}
+
+// GetImmutableTag returns the first immutable protected tag matching the tag name, or nil if the tag is mutable
+func GetImmutableTag(tags []*ProtectedTag, tagName string) (*ProtectedTag, error) {
+	for _, tag := range tags {
+		if !tag.Immutable {
+			continue
+		}
+		if err := tag.EnsureCompiledPattern(); err != nil {
+			return nil, err
+		}
+		if tag.matchString(tagName) {
+			return tag, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetProtectedTagByNamePattern gets the protected tag of the repository with the name pattern
+func GetProtectedTagByNamePattern(ctx context.Context, repoID int64, pattern string) (*ProtectedTag, error) {
+	tag := new(ProtectedTag)
+	has, err := db.GetEngine(ctx).Where("repo_id = ? AND name_pattern = ?", repoID, pattern).Get(tag)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, nil
+	}
+	return tag, nil
+}
@@ -163,3 +163,75 @@ func TestIsUserAllowedToControlTag(t *testing.T) {
 		}
 	})
 }
+
+func TestImmutableTag(t *testing.T) {
+	protectedTags := []*git_model.ProtectedTag{
+		{
+			NamePattern:      `v-*`,
+			AllowlistUserIDs: []int64{2},
+		},
+		{
+			NamePattern:   `model-v*`,
+			Immutable:     true,
+			RequireSigned: true,
+		},
+		{
+			NamePattern:      `/\Arelease-/`,
+			AllowlistUserIDs: []int64{1},
+			Immutable:        true,
+		},
+	}
+
+	tag, err := git_model.GetImmutableTag(protectedTags, "v-1")
+	assert.NoError(t, err)
+	assert.Nil(t, tag)
+
+	tag, err = git_model.GetImmutableTag(protectedTags, "model-v1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, tag) {
+		assert.True(t, tag.RequireSigned)
+	}
+
+	tag, err = git_model.GetImmutableTag(protectedTags, "release-1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, tag) {
+		assert.False(t, tag.RequireSigned)
+	}
+
+	// without an allowlist, nobody may create an immutable tag
+	isAllowed, err := git_model.IsUserAllowedToControlTag(db.DefaultContext, protectedTags, "model-v1", 3)
+	assert.NoError(t, err)
+	assert.False(t, isAllowed)
+
+	isAllowed, err = git_model.IsUserAllowedToCreateTag(db.DefaultContext, protectedTags, "model-v1", 3)
+	assert.NoError(t, err)
+	assert.False(t, isAllowed)
+
+	isAllowed, err = git_model.IsUserAllowedToControlTag(db.DefaultContext, protectedTags, "release-1", 3)
+	assert.NoError(t, err)
+	assert.False(t, isAllowed)
+
+	isAllowed, err = git_model.IsUserAllowedToControlTag(db.DefaultContext, protectedTags, "release-1", 1)
+	assert.NoError(t, err)
+	assert.True(t, isAllowed)
+
+	// unless the rule lets anyone create them, which doesn't allow to update them
+	protectedTags[1].AnyoneCanCreate = true
+	isAllowed, err = git_model.IsUserAllowedToCreateTag(db.DefaultContext, protectedTags, "model-v1", 3)
+	assert.NoError(t, err)
+	assert.True(t, isAllowed)
+
+	isAllowed, err = git_model.IsUserAllowedToControlTag(db.DefaultContext, protectedTags, "model-v1", 3)
+	assert.NoError(t, err)
+	assert.False(t, isAllowed)
+
+	isAllowed, err = git_model.IsUserAllowedToCreateTag(db.DefaultContext, protectedTags, "release-1", 3)
+	assert.NoError(t, err)
+	assert.False(t, isAllowed)
+
+	// a mutable rule doesn't let anyone create tags
+	protectedTags[0].AnyoneCanCreate = true
+	isAllowed, err = git_model.IsUserAllowedToCreateTag(db.DefaultContext, protectedTags, "v-1", 3)
+	assert.NoError(t, err)
+	assert.False(t, isAllowed)
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/secret"
+	"code.gitea.io/gitea/modules/timeutil"
+	"code.gitea.io/gitea/modules/util"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// Sync states of a downstream mirror
+const (
+	DownstreamMirrorPending  = "pending"
+	DownstreamMirrorSynced   = "synced"
+	DownstreamMirrorRetrying = "retrying"
+	DownstreamMirrorFailed   = "failed"
+)
+
+// ErrDownstreamMirrorNotExist represents a "DownstreamMirrorNotExist" kind of error.
+type ErrDownstreamMirrorNotExist struct {
+	ID     int64
+	RepoID int64
+}
+
+func (err ErrDownstreamMirrorNotExist) Error() string {
+	return fmt.Sprintf("downstream mirror does not exist [id: %d, repo_id: %d]", err.ID, err.RepoID)
+}
+
+// Unwrap unwraps this as a ErrNotExist err
+func (err ErrDownstreamMirrorNotExist) Unwrap() error {
+	return util.ErrNotExist
+}
+
+// DownstreamMirror is a remote every push to a repository is pushed to
+type DownstreamMirror struct {
+	ID        int64  `xorm:"pk autoincr" json:"id"`
+	RepoID    int64  `xorm:"INDEX NOT NULL" json:"repo_id"`
+	RemoteURL string `xorm:"VARCHAR(2048) NOT NULL" json:"remote_url"`
+	Username  string `json:"username,omitempty"`
+	// PasswordEncrypted is the password or token of Username encrypted with the secret key
+	PasswordEncrypted string `xorm:"TEXT" json:"-"`
+	SyncLFS           bool   `xorm:"NOT NULL DEFAULT false" json:"sync_lfs"`
+	// LFSURL overrides the LFS endpoint derived from RemoteURL
+	LFSURL string `xorm:"VARCHAR(2048)" json:"lfs_url,omitempty"`
+
+	Status       string             `xorm:"VARCHAR(16) NOT NULL" json:"status"`
+	LastError    string             `xorm:"TEXT" json:"last_error,omitempty"`
+	FailureCount int                `xorm:"NOT NULL DEFAULT 0" json:"failure_count"`
+	LastAttempt  timeutil.TimeStamp `json:"last_attempt,omitempty"`
+	LastSync     timeutil.TimeStamp `json:"last_sync,omitempty"`
+	CreatedUnix  timeutil.TimeStamp `xorm:"created" json:"created"`
+	UpdatedUnix  timeutil.TimeStamp `xorm:"updated" json:"updated"`
+}
+
+func init() {
+	db.RegisterModel(new(DownstreamMirror))
+}
+
+// SetPassword encrypts the password of the mirror
+func (m *DownstreamMirror) SetPassword(password string) error {
+	if password == "" {
+		m.PasswordEncrypted = ""
+		return nil
+	}
+	encrypted, err := secret.EncryptSecret(setting.SecretKey, password)
+	if err != nil {
+		return err
+	}
+	m.PasswordEncrypted = encrypted
+	return nil
+}
+
+// Password returns the decrypted password of the mirror
+func (m *DownstreamMirror) Password() (string, error) {
+	if m.PasswordEncrypted == "" {
+		return "", nil
+	}
+	return secret.DecryptSecret(setting.SecretKey, m.PasswordEncrypted)
+}
+
+// InsertDownstreamMirror adds a downstream mirror to a repository
+func InsertDownstreamMirror(ctx context.Context, m *DownstreamMirror) error {
+	if m.Status == "" {
+		m.Status = DownstreamMirrorPending
+	}
+	return db.Insert(ctx, m)
+}
+
+// GetDownstreamMirrors returns the downstream mirrors of a repository
+func GetDownstreamMirrors(ctx context.Context, repoID int64) ([]*DownstreamMirror, error) {
+	mirrors := make([]*DownstreamMirror, 0, 2)
+	return mirrors, db.GetEngine(ctx).Where("repo_id = ?", repoID).Asc("id").Find(&mirrors)
+}
+
+// GetDownstreamMirror returns a downstream mirror of a repository
+func GetDownstreamMirror(ctx context.Context, repoID, id int64) (*DownstreamMirror, error) {
+	m := &DownstreamMirror{}
+	has, err := db.GetEngine(ctx).Where("id = ? AND repo_id = ?", id, repoID).Get(m)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrDownstreamMirrorNotExist{ID: id, RepoID: repoID}
+	}
+	return m, nil
+}
+
+// UpdateDownstreamMirrorStatus stores the sync state of a downstream mirror
+func UpdateDownstreamMirrorStatus(ctx context.Context, m *DownstreamMirror) error {
+	_, err := db.GetEngine(ctx).ID(m.ID).Cols("status", "last_error", "failure_count", "last_attempt", "last_sync").Update(m)
+	return err
+}
+
+// DeleteDownstreamMirror removes a downstream mirror from a repository
+func DeleteDownstreamMirror(ctx context.Context, repoID, id int64) error {
+	deleted, err := db.GetEngine(ctx).Where("id = ? AND repo_id = ?", id, repoID).Delete(&DownstreamMirror{})
+	if err != nil {
+		return err
+	} else if deleted == 0 {
+		return ErrDownstreamMirrorNotExist{ID: id, RepoID: repoID}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"context"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/timeutil"
+)
+
+// RepoPathGrant restricts the writes of a deploy key or an access token to a path prefix inside a repository.
+// A credential without grants for a repository may write anywhere its permission allows.
+type RepoPathGrant struct { //revive:disable-line:exported
+	ID            int64              `xorm:"pk autoincr"`
+	RepoID        int64              `xorm:"INDEX NOT NULL"`
+	DeployKeyID   int64              `xorm:"INDEX NOT NULL DEFAULT 0"`
+	AccessTokenID int64              `xorm:"INDEX NOT NULL DEFAULT 0"`
+	Prefix        string             `xorm:"VARCHAR(255) NOT NULL"`
+	CreatedUnix   timeutil.TimeStamp `xorm:"created"`
+}
+
+func init() {
+	db.RegisterModel(new(RepoPathGrant))
+}
+
+// GetPathGrants returns the path prefixes the deploy key or the access token may write to in the repository,
+// only one of the IDs is expected to be set
+func GetPathGrants(ctx context.Context, repoID, deployKeyID, accessTokenID int64) ([]string, error) {
+	prefixes := make([]string, 0, 2)
+	return prefixes, db.GetEngine(ctx).Table("repo_path_grant").
+		Where("repo_id = ? AND deploy_key_id = ? AND access_token_id = ?", repoID, deployKeyID, accessTokenID).
+		Asc("prefix").Cols("prefix").Find(&prefixes)
+}
+
+// SetPathGrants replaces the path prefixes the deploy key or the access token may write to in the repository,
+// an empty list lifts the restriction
+func SetPathGrants(ctx context.Context, repoID, deployKeyID, accessTokenID int64, prefixes []string) error {
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := db.DeleteByBean(ctx, &RepoPathGrant{RepoID: repoID, DeployKeyID: deployKeyID, AccessTokenID: accessTokenID}); err != nil {
+			return err
+		}
+		seen := make(map[string]bool, len(prefixes))
+		for _, prefix := range prefixes {
+			if seen[prefix] {
+				continue
+			}
+			seen[prefix] = true
+			if err := db.Insert(ctx, &RepoPathGrant{RepoID: repoID, DeployKeyID: deployKeyID, AccessTokenID: accessTokenID, Prefix: prefix}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"context"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/timeutil"
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"xorm.io/builder"
+)
+
+// Events of the push audit log
+const (
+	PushAuditPushed    = "pushed"
+	PushAuditRejected  = "rejected"
+	PushAuditLFSUpload = "lfs_upload"
+)
+
+// PushAuditRefUpdate is a ref update of an audited push
+type PushAuditRefUpdate struct {
+	Ref         string `json:"ref"`
+	OldCommitID string `json:"old_sha"`
+	NewCommitID string `json:"new_sha"`
+	Forced      bool   `json:"forced,omitempty"`
+}
+
+// PushAudit is an entry of the append-only audit log of the pushes and LFS uploads to a repository.
+// Entries are kept when the repository is deleted, so the names are stored as they were at the time.
+type PushAudit struct {
+	ID         int64                `xorm:"pk autoincr" json:"id"`
+	RepoID     int64                `xorm:"INDEX NOT NULL" json:"repo_id"`
+	OwnerName  string               `json:"owner"`
+	RepoName   string               `json:"repo"`
+	IsWiki     bool                 `xorm:"NOT NULL DEFAULT false" json:"is_wiki,omitempty"`
+	PusherID   int64                `xorm:"INDEX" json:"pusher_id"`
+	PusherName string               `json:"pusher"`
+	AuthMethod string               `xorm:"VARCHAR(32)" json:"auth_method"`
+	AuthID     int64                `json:"auth_id,omitempty"`
+	ClientIP   string               `xorm:"VARCHAR(64)" json:"client_ip"`
+	Event      string               `xorm:"VARCHAR(16) INDEX NOT NULL" json:"event"`
+	PushID     string               `xorm:"VARCHAR(32) INDEX" json:"-"` // identifies the batches of a pushed event
+	Reason     string               `xorm:"TEXT" json:"reason,omitempty"`
+	RefUpdates []PushAuditRefUpdate `xorm:"JSON TEXT" json:"ref_updates,omitempty"`
+	LFSOid     string               `xorm:"VARCHAR(64)" json:"lfs_oid,omitempty"`
+	LFSSize    int64                `json:"lfs_size,omitempty"`
+	Created    timeutil.TimeStamp   `xorm:"created INDEX" json:"created"`
+}
+
+func init() {
+	db.RegisterModel(new(PushAudit))
+}
+
+// InsertPushAudit appends an entry to the push audit log
+func InsertPushAudit(ctx context.Context, audit *PushAudit) error {
+	return db.Insert(ctx, audit)
+}
+
+// RecordPushAudit appends an entry to the push audit log, or the ref updates of audit to the entry of the same push
+// if an earlier batch of the push has been recorded already
+func RecordPushAudit(ctx context.Context, audit *PushAudit) error {
+	if audit.PushID == "" {
+		return InsertPushAudit(ctx, audit)
+	}
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		existing := &PushAudit{}
+		has, err := db.GetEngine(ctx).Where(builder.Eq{"repo_id": audit.RepoID, "push_id": audit.PushID}).Get(existing)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return db.Insert(ctx, audit)
+		}
+		existing.RefUpdates = append(existing.RefUpdates, audit.RefUpdates...)
+		_, err = db.GetEngine(ctx).ID(existing.ID).Cols("ref_updates").Update(existing)
+		return err
+	})
+}
+
+// FindPushAuditsOptions filters the push audit log, zero values don't filter
+type FindPushAuditsOptions struct {
+	RepoID     int64
+	OwnerName  string
+	RepoName   string
+	PusherID   int64
+	PusherName string
+	Event      string
+	Since      timeutil.TimeStamp
+	Until      timeutil.TimeStamp
+}
+
+// ToConds implements db.FindOptions
+func (opts *FindPushAuditsOptions) ToConds() builder.Cond {
+	cond := builder.NewCond()
+	if opts.RepoID > 0 {
+		cond = cond.And(builder.Eq{"repo_id": opts.RepoID})
+	}
+	if opts.OwnerName != "" {
+		cond = cond.And(builder.Eq{"owner_name": opts.OwnerName})
+	}
+	if opts.RepoName != "" {
+		cond = cond.And(builder.Eq{"repo_name": opts.RepoName})
+	}
+	if opts.PusherID > 0 {
+		cond = cond.And(builder.Eq{"pusher_id": opts.PusherID})
+	}
+	if opts.PusherName != "" {
+		cond = cond.And(builder.Eq{"pusher_name": opts.PusherName})
+	}
+	if opts.Event != "" {
+		cond = cond.And(builder.Eq{"event": opts.Event})
+	}
+	if opts.Since > 0 {
+		cond = cond.And(builder.Gte{"created": opts.Since})
+	}
+	if opts.Until > 0 {
+		cond = cond.And(builder.Lt{"created": opts.Until})
+	}
+	return cond
+}
+
+// IteratePushAudits calls f for every entry of the push audit log matching opts, oldest first
+func IteratePushAudits(ctx context.Context, opts *FindPushAuditsOptions, f func(ctx context.Context, audit *PushAudit) error) error {
+	batchSize := setting.Database.IterateBufferSize
+	cond := opts.ToConds()
+	id := int64(0)
+	for {
+		audits := make([]*PushAudit, 0, batchSize)
+		if err := db.GetEngine(ctx).Where(cond.And(builder.Gt{"id": id})).OrderBy("id ASC").Limit(batchSize).Find(&audits); err != nil {
+			return err
+		}
+		if len(audits) == 0 {
+			return nil
+		}
+
+		for _, audit := range audits {
+			if err := f(ctx, audit); err != nil {
+				return err
+			}
+		}
+		id = audits[len(audits)-1].ID
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repo_test
+
+import (
+	"context"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/models/unittest"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func findPushAudits(t *testing.T, opts *repo_model.FindPushAuditsOptions) []*repo_model.PushAudit {
+	var audits []*repo_model.PushAudit
+	assert.NoError(t, repo_model.IteratePushAudits(db.DefaultContext, opts, func(_ context.Context, audit *repo_model.PushAudit) error {
+		audits = append(audits, audit)
+		return nil
+	}))
+	return audits
+}
+
+func TestRecordPushAudit(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+
+	newAudit := func(pushID, ref string) *repo_model.PushAudit {
+		return &repo_model.PushAudit{
+			RepoID:     1,
+			OwnerName:  "user2",
+			RepoName:   "repo1",
+			PusherID:   2,
+			PusherName: "user2",
+			Event:      repo_model.PushAuditPushed,
+			PushID:     pushID,
+			RefUpdates: []repo_model.PushAuditRefUpdate{{Ref: ref}},
+		}
+	}
+
+	// the batches of a push are recorded in one entry
+	assert.NoError(t, repo_model.RecordPushAudit(db.DefaultContext, newAudit("push1", "refs/heads/a")))
+	assert.NoError(t, repo_model.RecordPushAudit(db.DefaultContext, newAudit("push2", "refs/heads/c")))
+	assert.NoError(t, repo_model.RecordPushAudit(db.DefaultContext, newAudit("push1", "refs/heads/b")))
+	// entries without a push ID are never merged
+	assert.NoError(t, repo_model.RecordPushAudit(db.DefaultContext, newAudit("", "refs/heads/d")))
+	assert.NoError(t, repo_model.RecordPushAudit(db.DefaultContext, newAudit("", "refs/heads/e")))
+
+	audits := findPushAudits(t, &repo_model.FindPushAuditsOptions{RepoID: 1, Event: repo_model.PushAuditPushed})
+	if assert.Len(t, audits, 4) {
+		assert.Equal(t, []repo_model.PushAuditRefUpdate{{Ref: "refs/heads/a"}, {Ref: "refs/heads/b"}}, audits[0].RefUpdates)
+		assert.Equal(t, []repo_model.PushAuditRefUpdate{{Ref: "refs/heads/c"}}, audits[1].RefUpdates)
+		assert.Equal(t, []repo_model.PushAuditRefUpdate{{Ref: "refs/heads/d"}}, audits[2].RefUpdates)
+		assert.Equal(t, []repo_model.PushAuditRefUpdate{{Ref: "refs/heads/e"}}, audits[3].RefUpdates)
+	}
+
+	assert.Empty(t, findPushAudits(t, &repo_model.FindPushAuditsOptions{RepoID: 1, PusherName: "user1"}))
+	assert.Len(t, findPushAudits(t, &repo_model.FindPushAuditsOptions{OwnerName: "user2", RepoName: "repo1", PusherID: 2}), 4)
+}
@@ -4,6 +4,7 @@
 package repo
 
 import (
+	"context"
 	"strings"
 
 	"code.gitea.io/gitea/models/db"
@@ -24,3 +25,20 @@ func LookupRedirect(ownerID int64, repoName string) (int64, error) {
 	}
 	return redirect.RedirectRepoID, nil
 }
+
+// GetRepositoryByNameOrRedirect returns the repository by given name under user,
+// if there is none it follows the redirect left by a rename or transfer of the repository.
+func GetRepositoryByNameOrRedirect(ctx context.Context, ownerID int64, name string) (*Repository, error) {
+	repo, err := GetRepositoryByName(ownerID, name)
+	if err == nil || !IsErrRepoNotExist(err) {
+		return repo, err
+	}
+	repoID, err := LookupRedirect(ownerID, name)
+	if err != nil {
+		if repo_model.IsErrRedirectNotExist(err) {
+			return nil, ErrRepoNotExist{UID: ownerID, Name: name}
+		}
+		return nil, err
+	}
+	return repo_model.GetRepositoryByID(ctx, repoID)
+}
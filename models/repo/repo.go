@@ -42,6 +42,21 @@ func IsErrRepoNotExist(err error) bool {
 	return ok
 }
 
+// IsErrRepoAlreadyExist checks if an error is a ErrRepoAlreadyExist.
+func IsErrRepoAlreadyExist(err error) bool {
+	return repo_model.IsErrRepoAlreadyExist(err)
+}
+
+// IsErrRepoFilesAlreadyExist checks if an error is a ErrRepoFilesAlreadyExist.
+func IsErrRepoFilesAlreadyExist(err error) bool {
+	return repo_model.IsErrRepoFilesAlreadyExist(err)
+}
+
+// IsErrReachLimitOfRepo checks if an error is a ErrReachLimitOfRepo.
+func IsErrReachLimitOfRepo(err error) bool {
+	return repo_model.IsErrReachLimitOfRepo(err)
+}
+
 // RepoPath returns repository path by given user and repository name.
 func RepoPath(userName, repoName string) string { //revive:disable-line:exported
 	return filepath.Join(user_model.UserPath(userName), strings.ToLower(repoName)+".git")
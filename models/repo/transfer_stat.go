@@ -0,0 +1,83 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"context"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/timeutil"
+
+	"xorm.io/builder"
+)
+
+// TransferStat is the hourly aggregate of the bytes a repository transferred through one service
+type TransferStat struct {
+	ID        int64              `xorm:"pk autoincr"`
+	RepoID    int64              `xorm:"UNIQUE(s) INDEX NOT NULL"`
+	OwnerName string             `xorm:"INDEX"`
+	Service   string             `xorm:"UNIQUE(s) VARCHAR(32) NOT NULL"`
+	AuthType  string             `xorm:"UNIQUE(s) VARCHAR(32) NOT NULL"`
+	Hour      timeutil.TimeStamp `xorm:"UNIQUE(s) INDEX NOT NULL"`
+	Requests  int64              `xorm:"NOT NULL DEFAULT 0"`
+	BytesIn   int64              `xorm:"NOT NULL DEFAULT 0"`
+	BytesOut  int64              `xorm:"NOT NULL DEFAULT 0"`
+}
+
+func init() {
+	db.RegisterModel(new(TransferStat))
+}
+
+// AddTransferStat adds the counts of stat to the aggregate of its repository, service, auth type and hour
+func AddTransferStat(ctx context.Context, stat *TransferStat) error {
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		affected, err := db.GetEngine(ctx).
+			Where("repo_id = ? AND service = ? AND auth_type = ? AND hour = ?", stat.RepoID, stat.Service, stat.AuthType, stat.Hour).
+			Incr("requests", stat.Requests).
+			Incr("bytes_in", stat.BytesIn).
+			Incr("bytes_out", stat.BytesOut).
+			NoAutoTime().
+			Update(new(TransferStat))
+		if err != nil || affected > 0 {
+			return err
+		}
+		return db.Insert(ctx, stat)
+	})
+}
+
+// FindTransferStatsOptions filters the hourly transfer aggregates, zero values don't filter
+type FindTransferStatsOptions struct {
+	OwnerName string
+	RepoID    int64
+	Service   string
+	Since     timeutil.TimeStamp
+	Until     timeutil.TimeStamp
+}
+
+// ToConds implements db.FindOptions
+func (opts *FindTransferStatsOptions) ToConds() builder.Cond {
+	cond := builder.NewCond()
+	if opts.OwnerName != "" {
+		cond = cond.And(builder.Eq{"owner_name": opts.OwnerName})
+	}
+	if opts.RepoID > 0 {
+		cond = cond.And(builder.Eq{"repo_id": opts.RepoID})
+	}
+	if opts.Service != "" {
+		cond = cond.And(builder.Eq{"service": opts.Service})
+	}
+	if opts.Since > 0 {
+		cond = cond.And(builder.Gte{"hour": opts.Since})
+	}
+	if opts.Until > 0 {
+		cond = cond.And(builder.Lt{"hour": opts.Until})
+	}
+	return cond
+}
+
+// FindTransferStats returns the hourly transfer aggregates ordered by hour
+func FindTransferStats(ctx context.Context, opts *FindTransferStatsOptions) ([]*TransferStat, error) {
+	stats := make([]*TransferStat, 0, 24)
+	return stats, db.GetEngine(ctx).Where(opts.ToConds()).OrderBy("hour, repo_id, service, auth_type").Find(&stats)
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"context"
+
+	"code.gitea.io/gitea/models/db"
+)
+
+// RepoValidator enables a content validator for the protected branches of a repository
+type RepoValidator struct { //revive:disable-line:exported
+	ID     int64  `xorm:"pk autoincr"`
+	RepoID int64  `xorm:"UNIQUE(s) INDEX NOT NULL"`
+	Name   string `xorm:"UNIQUE(s) VARCHAR(64) NOT NULL"`
+}
+
+func init() {
+	db.RegisterModel(new(RepoValidator))
+}
+
+// GetRepoValidators returns the names of the validators enabled for the repository
+func GetRepoValidators(ctx context.Context, repoID int64) ([]string, error) {
+	names := make([]string, 0, 2)
+	return names, db.GetEngine(ctx).Table("repo_validator").Where("repo_id = ?", repoID).Asc("name").Cols("name").Find(&names)
+}
+
+// SetRepoValidators replaces the validators enabled for the repository
+func SetRepoValidators(ctx context.Context, repoID int64, names []string) error {
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := db.DeleteByBean(ctx, &RepoValidator{RepoID: repoID}); err != nil {
+			return err
+		}
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if err := db.Insert(ctx, &RepoValidator{RepoID: repoID, Name: name}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
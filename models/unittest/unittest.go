@@ -0,0 +1,82 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package unittest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/models/unittest"
+	gitea_setting "code.gitea.io/gitea/modules/setting"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"xorm.io/xorm"
+	"xorm.io/xorm/names"
+)
+
+// GiteaRootPath returns the source directory of the gitea module this module is built on,
+// which has the fixtures and the test repositories
+func GiteaRootPath() string {
+	pc := reflect.ValueOf(unittest.MainTest).Pointer()
+	file, _ := runtime.FuncForPC(pc).FileLine(pc)
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
+
+// MainTest is unittest.MainTest for the packages of this module. Some tables are registered by both gitea
+// and this module, so they are synced one by one before unittest.MainTest syncs all of them at once, and
+// the settings of this module are set up like the ones of gitea.
+func MainTest(m *testing.M, testOpts ...*unittest.TestOptions) {
+	opts := &unittest.TestOptions{}
+	if len(testOpts) > 0 {
+		opts = testOpts[0]
+	}
+	if opts.GiteaRootPath == "" {
+		opts.GiteaRootPath = GiteaRootPath()
+	}
+
+	// the test engine of unittest.MainTest opens the same shared in-memory database,
+	// which exists as long as this engine is open
+	x, err := xorm.NewEngine("sqlite3", "file::memory:?cache=shared&_txlock=immediate")
+	if err != nil {
+		fatalTestError("Error creating test engine: %v\n", err)
+	}
+	x.SetMapper(names.GonicMapper{})
+	db.SetDefaultEngine(context.Background(), x)
+	beans, err := db.NamesToBean()
+	if err != nil {
+		fatalTestError("Error listing tables: %v\n", err)
+	}
+	for _, bean := range beans {
+		if err := x.Sync(bean); err != nil {
+			fatalTestError("Error syncing %T: %v\n", bean, err)
+		}
+	}
+
+	setUp := opts.SetUp
+	opts.SetUp = func() error {
+		setting.AppURL = gitea_setting.AppURL
+		setting.AppSubURL = gitea_setting.AppSubURL
+		setting.AppDataPath = gitea_setting.AppDataPath
+		setting.RepoRootPath = gitea_setting.RepoRootPath
+		setting.Repository.DefaultBranch = gitea_setting.Repository.DefaultBranch
+		setting.Git.HomePath = gitea_setting.Git.HomePath
+		if setUp != nil {
+			return setUp()
+		}
+		return nil
+	}
+	unittest.MainTest(m, opts)
+}
+
+func fatalTestError(fmtStr string, args ...any) {
+	_, _ = os.Stderr.WriteString(fmt.Sprintf(fmtStr, args...))
+	os.Exit(1)
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package admission limits how many expensive git pack processes may run at the same time.
+//
+// Requests which can't be admitted immediately wait in a bounded FIFO queue. When a slot is
+// released the first queued request whose repository and user are below their caps is admitted,
+// so a burst against one repository doesn't block requests for other repositories.
+package admission
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when a request can't be admitted and the wait queue is full
+	ErrQueueFull = errors.New("admission queue is full")
+	// ErrQueueTimeout is returned when a request has waited too long to be admitted
+	ErrQueueTimeout = errors.New("admission queue wait timed out")
+)
+
+// Options represents the limits of a Controller, a limit of 0 means unlimited
+type Options struct {
+	MaxConcurrent  int
+	MaxPerRepo     int
+	MaxPerUser     int
+	MaxQueueLength int
+	QueueTimeout   time.Duration
+}
+
+// Stats represents a snapshot of the state of a Controller
+type Stats struct {
+	Running        int
+	Queued         int
+	RunningPerRepo map[int64]int
+	RunningPerUser map[string]int
+	Admitted       int64
+	Rejected       int64
+	TimedOut       int64
+}
+
+type waiter struct {
+	repoID  int64
+	userKey string
+	ready   chan struct{}
+	elem    *list.Element
+}
+
+// Controller admits requests according to a global, per repository and per user limit
+type Controller struct {
+	mu      sync.Mutex
+	opts    Options
+	running int
+	perRepo map[int64]int
+	perUser map[string]int
+	queue   *list.List
+
+	admitted int64
+	rejected int64
+	timedOut int64
+}
+
+// NewController creates a new admission controller
+func NewController(opts Options) *Controller {
+	return &Controller{
+		opts:    opts,
+		perRepo: make(map[int64]int),
+		perUser: make(map[string]int),
+		queue:   list.New(),
+	}
+}
+
+// Ticket represents an admitted request, it must be released once the request finishes
+type Ticket struct {
+	c       *Controller
+	repoID  int64
+	userKey string
+	once    sync.Once
+}
+
+// Release frees the slot held by the ticket and admits the next waiting request if possible
+func (t *Ticket) Release() {
+	if t == nil || t.c == nil {
+		return
+	}
+	t.once.Do(func() {
+		t.c.mu.Lock()
+		defer t.c.mu.Unlock()
+		t.c.release(t.repoID, t.userKey)
+		t.c.admitWaiters()
+	})
+}
+
+// Acquire blocks until the request is admitted, the queue wait times out or ctx is done
+func (c *Controller) Acquire(ctx context.Context, repoID int64, userKey string) (*Ticket, error) {
+	w := &waiter{repoID: repoID, userKey: userKey, ready: make(chan struct{})}
+
+	c.mu.Lock()
+	// queue up behind the existing waiters so that earlier requests are admitted first
+	w.elem = c.queue.PushBack(w)
+	c.admitWaiters()
+	select {
+	case <-w.ready:
+		c.mu.Unlock()
+		return &Ticket{c: c, repoID: repoID, userKey: userKey}, nil
+	default:
+	}
+	if c.queue.Len() > c.opts.MaxQueueLength {
+		c.queue.Remove(w.elem)
+		c.rejected++
+		c.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if c.opts.QueueTimeout > 0 {
+		timer := time.NewTimer(c.opts.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-w.ready:
+		return &Ticket{c: c, repoID: repoID, userKey: userKey}, nil
+	case <-timeout:
+		if c.abandon(w) {
+			return &Ticket{c: c, repoID: repoID, userKey: userKey}, nil
+		}
+		c.mu.Lock()
+		c.timedOut++
+		c.mu.Unlock()
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		if c.abandon(w) {
+			return &Ticket{c: c, repoID: repoID, userKey: userKey}, nil
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// abandon removes a waiter from the queue, it returns true if the waiter had been admitted in the meantime
+func (c *Controller) abandon(w *waiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-w.ready:
+		return true
+	default:
+	}
+	c.queue.Remove(w.elem)
+	// the waiter may have been blocking others behind it which are now admissible
+	c.admitWaiters()
+	return false
+}
+
+// Stats returns a snapshot of the controller state
+func (c *Controller) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := Stats{
+		Running:        c.running,
+		Queued:         c.queue.Len(),
+		RunningPerRepo: make(map[int64]int, len(c.perRepo)),
+		RunningPerUser: make(map[string]int, len(c.perUser)),
+		Admitted:       c.admitted,
+		Rejected:       c.rejected,
+		TimedOut:       c.timedOut,
+	}
+	for k, v := range c.perRepo {
+		stats.RunningPerRepo[k] = v
+	}
+	for k, v := range c.perUser {
+		stats.RunningPerUser[k] = v
+	}
+	return stats
+}
+
+func (c *Controller) canRun(repoID int64, userKey string) bool {
+	if c.opts.MaxConcurrent > 0 && c.running >= c.opts.MaxConcurrent {
+		return false
+	}
+	if c.opts.MaxPerRepo > 0 && c.perRepo[repoID] >= c.opts.MaxPerRepo {
+		return false
+	}
+	if c.opts.MaxPerUser > 0 && userKey != "" && c.perUser[userKey] >= c.opts.MaxPerUser {
+		return false
+	}
+	return true
+}
+
+func (c *Controller) take(repoID int64, userKey string) {
+	c.running++
+	c.admitted++
+	c.perRepo[repoID]++
+	if userKey != "" {
+		c.perUser[userKey]++
+	}
+}
+
+func (c *Controller) release(repoID int64, userKey string) {
+	c.running--
+	if c.perRepo[repoID]--; c.perRepo[repoID] <= 0 {
+		delete(c.perRepo, repoID)
+	}
+	if userKey != "" {
+		if c.perUser[userKey]--; c.perUser[userKey] <= 0 {
+			delete(c.perUser, userKey)
+		}
+	}
+}
+
+// admitWaiters admits queued requests in FIFO order, skipping those whose repository or user is at its cap
+func (c *Controller) admitWaiters() {
+	for e := c.queue.Front(); e != nil; {
+		if c.opts.MaxConcurrent > 0 && c.running >= c.opts.MaxConcurrent {
+			return
+		}
+		next := e.Next()
+		w := e.Value.(*waiter)
+		if c.canRun(w.repoID, w.userKey) {
+			c.queue.Remove(e)
+			c.take(w.repoID, w.userKey)
+			close(w.ready)
+		}
+		e = next
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package admission
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControllerLimits(t *testing.T) {
+	c := NewController(Options{
+		MaxConcurrent:  3,
+		MaxPerRepo:     2,
+		MaxPerUser:     2,
+		MaxQueueLength: 0,
+	})
+	ctx := context.Background()
+
+	t1, err := c.Acquire(ctx, 1, "user:1")
+	assert.NoError(t, err)
+	t2, err := c.Acquire(ctx, 1, "user:2")
+	assert.NoError(t, err)
+
+	// repo 1 is at its cap and there is no queue
+	_, err = c.Acquire(ctx, 1, "user:3")
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	t3, err := c.Acquire(ctx, 2, "user:1")
+	assert.NoError(t, err)
+
+	// global cap reached
+	_, err = c.Acquire(ctx, 3, "user:3")
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	stats := c.Stats()
+	assert.EqualValues(t, 3, stats.Running)
+	assert.EqualValues(t, 2, stats.RunningPerRepo[1])
+	assert.EqualValues(t, 2, stats.RunningPerUser["user:1"])
+	assert.EqualValues(t, 2, stats.Rejected)
+
+	t1.Release()
+	t1.Release() // releasing twice must be harmless
+	t2.Release()
+	t3.Release()
+
+	stats = c.Stats()
+	assert.EqualValues(t, 0, stats.Running)
+	assert.Empty(t, stats.RunningPerRepo)
+	assert.Empty(t, stats.RunningPerUser)
+}
+
+func TestControllerFairQueue(t *testing.T) {
+	c := NewController(Options{
+		MaxConcurrent:  2,
+		MaxPerRepo:     1,
+		MaxQueueLength: 4,
+		QueueTimeout:   time.Second,
+	})
+	ctx := context.Background()
+
+	busy, err := c.Acquire(ctx, 1, "")
+	assert.NoError(t, err)
+
+	// a second request for repo 1 has to wait
+	waiting := make(chan *Ticket)
+	go func() {
+		ticket, err := c.Acquire(ctx, 1, "")
+		assert.NoError(t, err)
+		waiting <- ticket
+	}()
+	assert.Eventually(t, func() bool { return c.Stats().Queued == 1 }, time.Second, 10*time.Millisecond)
+
+	// a request for another repository is not blocked by the waiting one
+	other, err := c.Acquire(ctx, 2, "")
+	assert.NoError(t, err)
+	other.Release()
+
+	busy.Release()
+	select {
+	case ticket := <-waiting:
+		ticket.Release()
+	case <-time.After(time.Second):
+		assert.Fail(t, "queued request was not admitted")
+	}
+	assert.EqualValues(t, 0, c.Stats().Running)
+}
+
+func TestControllerQueueTimeout(t *testing.T) {
+	c := NewController(Options{
+		MaxConcurrent:  1,
+		MaxQueueLength: 1,
+		QueueTimeout:   50 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	busy, err := c.Acquire(ctx, 1, "")
+	assert.NoError(t, err)
+	defer busy.Release()
+
+	_, err = c.Acquire(ctx, 2, "")
+	assert.ErrorIs(t, err, ErrQueueTimeout)
+
+	stats := c.Stats()
+	assert.EqualValues(t, 0, stats.Queued)
+	assert.EqualValues(t, 1, stats.TimedOut)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.Acquire(cancelled, 2, "")
+	assert.ErrorIs(t, err, context.Canceled)
+}
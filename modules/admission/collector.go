@@ -0,0 +1,74 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package admission
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "gitea_pack_admission_"
+
+// Collector exposes the state of the pack admission controller for prometheus
+type Collector struct {
+	Running  *prometheus.Desc
+	Queued   *prometheus.Desc
+	Admitted *prometheus.Desc
+	Rejected *prometheus.Desc
+	TimedOut *prometheus.Desc
+}
+
+// NewCollector returns a new Collector with all prometheus.Desc initialized
+func NewCollector() Collector {
+	return Collector{
+		Running: prometheus.NewDesc(
+			namespace+"running",
+			"Number of git pack processes currently running",
+			nil, nil,
+		),
+		Queued: prometheus.NewDesc(
+			namespace+"queued",
+			"Number of git pack requests waiting to be admitted",
+			nil, nil,
+		),
+		Admitted: prometheus.NewDesc(
+			namespace+"admitted_total",
+			"Number of git pack requests admitted",
+			nil, nil,
+		),
+		Rejected: prometheus.NewDesc(
+			namespace+"rejected_total",
+			"Number of git pack requests rejected because the queue was full",
+			nil, nil,
+		),
+		TimedOut: prometheus.NewDesc(
+			namespace+"timed_out_total",
+			"Number of git pack requests which timed out in the queue",
+			nil, nil,
+		),
+	}
+}
+
+// Describe returns all possible prometheus.Desc
+func (c Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.Running
+	ch <- c.Queued
+	ch <- c.Admitted
+	ch <- c.Rejected
+	ch <- c.TimedOut
+}
+
+// Collect returns the metrics with values
+func (c Collector) Collect(ch chan<- prometheus.Metric) {
+	controller := PackController()
+	if controller == nil {
+		return
+	}
+	stats := controller.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.Running, prometheus.GaugeValue, float64(stats.Running))
+	ch <- prometheus.MustNewConstMetric(c.Queued, prometheus.GaugeValue, float64(stats.Queued))
+	ch <- prometheus.MustNewConstMetric(c.Admitted, prometheus.CounterValue, float64(stats.Admitted))
+	ch <- prometheus.MustNewConstMetric(c.Rejected, prometheus.CounterValue, float64(stats.Rejected))
+	ch <- prometheus.MustNewConstMetric(c.TimedOut, prometheus.CounterValue, float64(stats.TimedOut))
+}
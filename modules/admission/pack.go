@@ -0,0 +1,42 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package admission
+
+import (
+	"context"
+	"sync"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+var (
+	packController     *Controller
+	packControllerOnce sync.Once
+)
+
+// PackController returns the controller for git pack processes, it is nil if pack admission is disabled
+func PackController() *Controller {
+	packControllerOnce.Do(func() {
+		if !setting.PackAdmission.Enabled {
+			return
+		}
+		packController = NewController(Options{
+			MaxConcurrent:  setting.PackAdmission.MaxConcurrent,
+			MaxPerRepo:     setting.PackAdmission.MaxPerRepo,
+			MaxPerUser:     setting.PackAdmission.MaxPerUser,
+			MaxQueueLength: setting.PackAdmission.MaxQueueLength,
+			QueueTimeout:   setting.PackAdmission.QueueTimeout,
+		})
+	})
+	return packController
+}
+
+// AcquirePack admits a git pack process, it always succeeds if pack admission is disabled
+func AcquirePack(ctx context.Context, repoID int64, userKey string) (*Ticket, error) {
+	c := PackController()
+	if c == nil {
+		return nil, nil
+	}
+	return c.Acquire(ctx, repoID, userKey)
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package branchprotection parses the file which manages the protected branch rules of a repository as code.
+package branchprotection
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/openmerlin/gitea_data/modules/validator"
+
+	"github.com/gobwas/glob"
+	"gopkg.in/yaml.v3"
+)
+
+// FilePath is where the rules are read from, on the default branch
+const FilePath = ".gitea/branch-protection.yaml"
+
+// Rule is a protected branch rule of the file, the fields are named like those of the branch protection API
+type Rule struct {
+	RuleName string `yaml:"rule_name"`
+
+	EnablePush              bool     `yaml:"enable_push"`
+	EnablePushWhitelist     bool     `yaml:"enable_push_whitelist"`
+	PushWhitelistUsernames  []string `yaml:"push_whitelist_usernames"`
+	PushWhitelistTeams      []string `yaml:"push_whitelist_teams"`
+	PushWhitelistDeployKeys bool     `yaml:"push_whitelist_deploy_keys"`
+
+	EnableMergeWhitelist    bool     `yaml:"enable_merge_whitelist"`
+	MergeWhitelistUsernames []string `yaml:"merge_whitelist_usernames"`
+	MergeWhitelistTeams     []string `yaml:"merge_whitelist_teams"`
+
+	EnableStatusCheck     bool     `yaml:"enable_status_check"`
+	StatusCheckContexts   []string `yaml:"status_check_contexts"`
+	StatusCheckOnPush     bool     `yaml:"status_check_on_push"`
+	StatusCheckPushParent int      `yaml:"status_check_push_parent"`
+
+	RequiredApprovals             int64    `yaml:"required_approvals"`
+	EnableApprovalsWhitelist      bool     `yaml:"enable_approvals_whitelist"`
+	ApprovalsWhitelistUsernames   []string `yaml:"approvals_whitelist_usernames"`
+	ApprovalsWhitelistTeams       []string `yaml:"approvals_whitelist_teams"`
+	BlockOnRejectedReviews        bool     `yaml:"block_on_rejected_reviews"`
+	BlockOnOfficialReviewRequests bool     `yaml:"block_on_official_review_requests"`
+	BlockOnOutdatedBranch         bool     `yaml:"block_on_outdated_branch"`
+	DismissStaleApprovals         bool     `yaml:"dismiss_stale_approvals"`
+
+	RequireSignedCommits    bool   `yaml:"require_signed_commits"`
+	ProtectedFilePatterns   string `yaml:"protected_file_patterns"`
+	UnprotectedFilePatterns string `yaml:"unprotected_file_patterns"`
+
+	// Line is where the rule starts in the file
+	Line int `yaml:"-"`
+}
+
+// File is the content of FilePath
+type File struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+func yamlProblem(err error) validator.Problem {
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+	if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return validator.Problem{Path: FilePath, Line: line, Message: m[2]}
+	}
+	return validator.Problem{Path: FilePath, Message: msg}
+}
+
+// Parse reads the rules of the file, it returns the problems if the file isn't valid
+func Parse(content []byte) (*File, []validator.Problem) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, []validator.Problem{yamlProblem(err)}
+	}
+	if len(doc.Content) == 0 {
+		return nil, []validator.Problem{{Path: FilePath, Message: "the file is empty"}}
+	}
+
+	file := &File{}
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, []validator.Problem{yamlProblem(err)}
+		}
+		problems := make([]validator.Problem, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, yamlProblem(errors.New(msg)))
+		}
+		return nil, problems
+	}
+
+	// the decoded rules are in the order of the nodes of the rules sequence
+	if root := doc.Content[0]; root.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value != "rules" || root.Content[i+1].Kind != yaml.SequenceNode {
+				continue
+			}
+			for j, node := range root.Content[i+1].Content {
+				if j < len(file.Rules) && file.Rules[j] != nil {
+					file.Rules[j].Line = node.Line
+				}
+			}
+		}
+	}
+
+	if problems := file.validate(); len(problems) > 0 {
+		return nil, problems
+	}
+	return file, nil
+}
+
+func (f *File) validate() []validator.Problem {
+	var problems []validator.Problem
+	addProblem := func(rule *Rule, format string, args ...any) {
+		problems = append(problems, validator.Problem{Path: FilePath, Line: rule.Line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	seen := make(map[string]bool, len(f.Rules))
+	for i, rule := range f.Rules {
+		if rule == nil {
+			problems = append(problems, validator.Problem{Path: FilePath, Message: fmt.Sprintf("rule %d is empty", i+1)})
+			continue
+		}
+		if rule.RuleName == "" {
+			addProblem(rule, "rule_name is required")
+		} else if _, err := glob.Compile(rule.RuleName, '/'); err != nil {
+			addProblem(rule, "invalid rule_name %q: %v", rule.RuleName, err)
+		} else if name := strings.ToLower(rule.RuleName); seen[name] {
+			addProblem(rule, "duplicate rule_name %q", rule.RuleName)
+		} else {
+			seen[name] = true
+		}
+
+		if !rule.EnablePush && (rule.EnablePushWhitelist || len(rule.PushWhitelistUsernames) > 0 || len(rule.PushWhitelistTeams) > 0 || rule.PushWhitelistDeployKeys) {
+			addProblem(rule, "the push whitelist requires enable_push")
+		}
+		if !rule.EnablePushWhitelist && (len(rule.PushWhitelistUsernames) > 0 || len(rule.PushWhitelistTeams) > 0 || rule.PushWhitelistDeployKeys) {
+			addProblem(rule, "push_whitelist_usernames, push_whitelist_teams and push_whitelist_deploy_keys require enable_push_whitelist")
+		}
+		if !rule.EnableMergeWhitelist && (len(rule.MergeWhitelistUsernames) > 0 || len(rule.MergeWhitelistTeams) > 0) {
+			addProblem(rule, "merge_whitelist_usernames and merge_whitelist_teams require enable_merge_whitelist")
+		}
+		if !rule.EnableApprovalsWhitelist && (len(rule.ApprovalsWhitelistUsernames) > 0 || len(rule.ApprovalsWhitelistTeams) > 0) {
+			addProblem(rule, "approvals_whitelist_usernames and approvals_whitelist_teams require enable_approvals_whitelist")
+		}
+		if rule.RequiredApprovals < 0 {
+			addProblem(rule, "required_approvals must not be negative")
+		}
+
+		if !rule.EnableStatusCheck && (len(rule.StatusCheckContexts) > 0 || rule.StatusCheckOnPush) {
+			addProblem(rule, "status_check_contexts and status_check_on_push require enable_status_check")
+		}
+		for _, pattern := range rule.StatusCheckContexts {
+			if _, err := glob.Compile(pattern); err != nil {
+				addProblem(rule, "invalid status check context %q: %v", pattern, err)
+			}
+		}
+		if rule.StatusCheckPushParent < 0 {
+			addProblem(rule, "status_check_push_parent must not be negative")
+		}
+
+		for _, patterns := range []string{rule.ProtectedFilePatterns, rule.UnprotectedFilePatterns} {
+			for _, pattern := range strings.Split(patterns, ";") {
+				if pattern = strings.TrimSpace(pattern); pattern == "" {
+					continue
+				}
+				if _, err := glob.Compile(strings.ToLower(pattern), '.', '/'); err != nil {
+					addProblem(rule, "invalid file pattern %q: %v", pattern, err)
+				}
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package branchprotection
+
+import (
+	"testing"
+
+	"github.com/openmerlin/gitea_data/modules/validator"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	file, problems := Parse([]byte(`rules:
+  - rule_name: main
+    enable_push: true
+    enable_push_whitelist: true
+    push_whitelist_usernames: [alice]
+    required_approvals: 1
+    enable_status_check: true
+    status_check_contexts: ["ci/*"]
+    status_check_on_push: true
+
+  - rule_name: release/*
+    protected_file_patterns: "*.lock;go.mod"
+`))
+	assert.Empty(t, problems)
+	if assert.Len(t, file.Rules, 2) {
+		assert.Equal(t, "main", file.Rules[0].RuleName)
+		assert.Equal(t, 2, file.Rules[0].Line)
+		assert.Equal(t, []string{"alice"}, file.Rules[0].PushWhitelistUsernames)
+		assert.EqualValues(t, 1, file.Rules[0].RequiredApprovals)
+		assert.True(t, file.Rules[0].StatusCheckOnPush)
+		assert.Equal(t, "release/*", file.Rules[1].RuleName)
+		assert.Equal(t, 11, file.Rules[1].Line)
+	}
+
+	// an empty rule list removes all rules
+	file, problems = Parse([]byte("rules: []\n"))
+	assert.Empty(t, problems)
+	assert.Empty(t, file.Rules)
+
+	_, problems = Parse([]byte(""))
+	assert.Len(t, problems, 1)
+
+	_, problems = Parse([]byte("rules: [a\n"))
+	assert.Len(t, problems, 1)
+	assert.Greater(t, problems[0].Line, 0)
+
+	_, problems = Parse([]byte(`rules:
+  - rule_name: main
+    enable_pushes: true
+`))
+	if assert.Len(t, problems, 1) {
+		assert.Equal(t, 3, problems[0].Line)
+		assert.Contains(t, problems[0].Message, "enable_pushes")
+	}
+
+	_, problems = Parse([]byte(`rules:
+  - rule_name: main
+  - rule_name: Main
+  - enable_push: false
+    push_whitelist_usernames: [alice]
+  - rule_name: "dev/["
+    required_approvals: -1
+    status_check_contexts: [ci]
+`))
+	assert.Equal(t, []string{
+		".gitea/branch-protection.yaml:3: duplicate rule_name \"Main\"",
+		".gitea/branch-protection.yaml:4: rule_name is required",
+		".gitea/branch-protection.yaml:4: the push whitelist requires enable_push",
+		".gitea/branch-protection.yaml:4: push_whitelist_usernames, push_whitelist_teams and push_whitelist_deploy_keys require enable_push_whitelist",
+		".gitea/branch-protection.yaml:6: invalid rule_name \"dev/[\": unexpected end of input",
+		".gitea/branch-protection.yaml:6: required_approvals must not be negative",
+		".gitea/branch-protection.yaml:6: status_check_contexts and status_check_on_push require enable_status_check",
+	}, problemStrings(problems))
+}
+
+func problemStrings(problems []validator.Problem) []string {
+	strs := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		strs = append(strs, problem.String())
+	}
+	return strs
+}
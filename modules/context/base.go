@@ -4,7 +4,19 @@
 package context
 
 import (
+	"net"
+
 	modules_context "code.gitea.io/gitea/modules/context"
 )
 
 type Base = modules_context.Base
+
+// ClientIP returns the IP of the client of the request, which is the remote address without its port.
+// The remote address is returned as is if it has no port, e.g. once a reverse proxy set it to the real IP.
+func ClientIP(b *Base) string {
+	addr := b.RemoteAddr()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
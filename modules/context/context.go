@@ -5,7 +5,18 @@
 package context
 
 import (
+	"fmt"
+
 	modules_context "code.gitea.io/gitea/modules/context"
 )
 
 type Context = modules_context.Context
+
+// ClientKey identifies the client of a request for the per client limits: the signed in user or else the client IP,
+// so that the connections of an anonymous client count together
+func ClientKey(ctx *Context) string {
+	if ctx.Doer != nil {
+		return fmt.Sprintf("user:%d", ctx.Doer.ID)
+	}
+	return "ip:" + ClientIP(ctx.Base)
+}
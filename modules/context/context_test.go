@@ -0,0 +1,33 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package context
+
+import (
+	"testing"
+
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/contexttest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientKey(t *testing.T) {
+	clientKey := func(remoteAddr string, doer *user_model.User) string {
+		ctx, _ := contexttest.MockContext(t, "GET /user2/repo1.git/info/refs")
+		ctx.Req.RemoteAddr = remoteAddr
+		ctx.Doer = doer
+		return ClientKey(ctx)
+	}
+
+	// every connection of a client has another port
+	assert.Equal(t, "ip:192.0.2.1", clientKey("192.0.2.1:50001", nil))
+	assert.Equal(t, "ip:192.0.2.1", clientKey("192.0.2.1:50002", nil))
+	assert.Equal(t, "ip:2001:db8::1", clientKey("[2001:db8::1]:50001", nil))
+
+	// a reverse proxy may have set the real IP already
+	assert.Equal(t, "ip:192.0.2.1", clientKey("192.0.2.1", nil))
+	assert.Equal(t, "ip:2001:db8::1", clientKey("2001:db8::1", nil))
+
+	assert.Equal(t, "user:2", clientKey("192.0.2.1:50001", &user_model.User{ID: 2}))
+}
@@ -4,10 +4,13 @@
 package context
 
 import (
+	"errors"
 	"net/http"
 
 	auth_model "code.gitea.io/gitea/models/auth"
 	repo_model "code.gitea.io/gitea/models/repo"
+
+	data_auth_model "github.com/openmerlin/gitea_data/models/auth"
 )
 
 // CheckRepoScopedToken check whether personal access token has repo scope
@@ -46,3 +49,26 @@ func CheckRepoScopedToken(ctx *Context, repo *repo_model.Repository, level auth_
 		}
 	}
 }
+
+// CheckAccessTokenPolicy checks the expiry and the repository binding of the personal access token the request
+// authenticated with, however the token was sent. repoID is 0 for a repository which doesn't exist yet.
+func CheckAccessTokenPolicy(ctx *Context, tokenID, repoID int64) {
+	if tokenID <= 0 {
+		return
+	}
+
+	err := data_auth_model.CheckAccessTokenPolicy(ctx, tokenID, repoID)
+	var expired data_auth_model.ErrAccessTokenExpired
+	var notBound data_auth_model.ErrAccessTokenNotBound
+	switch {
+	case err == nil:
+	case errors.As(err, &expired):
+		// the client should ask for new credentials
+		ctx.Resp.Header().Set("WWW-Authenticate", `Basic realm="Gitea"`)
+		ctx.PlainText(http.StatusUnauthorized, "The access token has expired.")
+	case errors.As(err, &notBound):
+		ctx.PlainText(http.StatusForbidden, "The access token is not bound to the repository.")
+	default:
+		ctx.ServerError("CheckAccessTokenPolicy", err)
+	}
+}
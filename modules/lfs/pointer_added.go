@@ -0,0 +1,142 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package lfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/git"
+)
+
+// AddedPointers returns the pointers reachable from newCommitID which weren't reachable before ref was updated from oldCommitID.
+// env is passed to git so that the objects of a quarantined push can be read.
+func AddedPointers(ctx context.Context, repoPath string, env []string, ref, oldCommitID, newCommitID string) ([]Pointer, error) {
+	cmd := git.NewCommand(ctx, "rev-list", "--objects", "--no-object-names").AddDynamicArguments(newCommitID)
+	if oldCommitID != git.EmptySHA {
+		cmd.AddArguments("--not").AddDynamicArguments(oldCommitID)
+	} else {
+		// a new ref, everything reachable from the other refs was there before
+		cmd.AddArguments("--not").AddOptionFormat("--exclude=%s", ref).AddArguments("--all")
+	}
+	objects, _, err := cmd.RunStdBytes(&git.RunOpts{Dir: repoPath, Env: env})
+	if err != nil {
+		return nil, fmt.Errorf("rev-list: %w", err)
+	}
+	return pointersOf(ctx, repoPath, env, objects)
+}
+
+// AddedPointersExcluding returns the pointers reachable from newCommitID which aren't reachable from the excluded commits,
+// e.g. the tips of the other refs when the ref was created. The exclusions are passed on stdin as there may be many.
+func AddedPointersExcluding(ctx context.Context, repoPath string, env []string, newCommitID string, excludedCommitIDs []string) ([]Pointer, error) {
+	var revs bytes.Buffer
+	revs.WriteString(newCommitID + "\n")
+	for _, commitID := range excludedCommitIDs {
+		revs.WriteString("^" + commitID + "\n")
+	}
+	objects, _, err := git.NewCommand(ctx, "rev-list", "--objects", "--no-object-names", "--stdin").
+		RunStdBytes(&git.RunOpts{Dir: repoPath, Env: env, Stdin: &revs})
+	if err != nil {
+		return nil, fmt.Errorf("rev-list: %w", err)
+	}
+	return pointersOf(ctx, repoPath, env, objects)
+}
+
+// TipsBeforePush returns the commits the refs of a repository pointed to before a push: the commits the updated refs
+// pointed to, keyed by ref, and the tips of all other refs. They are the exclusions of AddedPointersExcluding for a created ref.
+func TipsBeforePush(ctx context.Context, repoPath string, oldCommitIDs map[string]string) ([]string, error) {
+	stdout, _, err := git.NewCommand(ctx, "for-each-ref", "--format=%(objectname) %(refname)").RunStdString(&git.RunOpts{Dir: repoPath})
+	if err != nil {
+		return nil, fmt.Errorf("for-each-ref: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	tips := make([]string, 0, len(oldCommitIDs))
+	add := func(commitID string) {
+		if commitID != git.EmptySHA && !seen[commitID] {
+			seen[commitID] = true
+			tips = append(tips, commitID)
+		}
+	}
+	for _, commitID := range oldCommitIDs {
+		add(commitID)
+	}
+	for _, line := range strings.Split(stdout, "\n") {
+		if commitID, ref, ok := strings.Cut(line, " "); ok {
+			if _, updated := oldCommitIDs[ref]; !updated {
+				add(commitID)
+			}
+		}
+	}
+	return tips, nil
+}
+
+// pointersOf returns the pointers among the objects listed by rev-list
+func pointersOf(ctx context.Context, repoPath string, env []string, objects []byte) ([]Pointer, error) {
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	var checks bytes.Buffer
+	if err := git.NewCommand(ctx, "cat-file", "--batch-check=%(objecttype) %(objectname) %(objectsize)").
+		Run(&git.RunOpts{Dir: repoPath, Env: env, Stdin: bytes.NewReader(objects), Stdout: &checks}); err != nil {
+		return nil, fmt.Errorf("cat-file --batch-check: %w", err)
+	}
+
+	var candidates bytes.Buffer
+	for _, line := range strings.Split(checks.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "blob" {
+			continue
+		}
+		if size, err := strconv.ParseInt(fields[2], 10, 64); err == nil && size <= blobSizeCutoff {
+			candidates.WriteString(fields[1] + "\n")
+		}
+	}
+	if candidates.Len() == 0 {
+		return nil, nil
+	}
+
+	var contents bytes.Buffer
+	if err := git.NewCommand(ctx, "cat-file", "--batch").
+		Run(&git.RunOpts{Dir: repoPath, Env: env, Stdin: &candidates, Stdout: &contents}); err != nil {
+		return nil, fmt.Errorf("cat-file --batch: %w", err)
+	}
+
+	var pointers []Pointer
+	seen := make(map[string]bool)
+	rd := bufio.NewReader(&contents)
+	for {
+		header, err := rd.ReadString('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected cat-file header %q", header)
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected cat-file header %q", header)
+		}
+		content := make([]byte, size+1) // content is followed by a LF
+		if _, err := io.ReadFull(rd, content); err != nil {
+			return nil, err
+		}
+		p, err := ReadPointerFromBuffer(content[:size])
+		if err != nil || seen[p.Oid] {
+			continue
+		}
+		seen[p.Oid] = true
+		pointers = append(pointers, p)
+	}
+	return pointers, nil
+}
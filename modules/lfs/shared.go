@@ -21,6 +21,8 @@ type BatchRequest struct {
 	Transfers []string   `json:"transfers,omitempty"`
 	Ref       *Reference `json:"ref,omitempty"`
 	Objects   []Pointer  `json:"objects"`
+	// PathHints maps the oids of an upload to the paths of their pointer files, path grants are checked against them
+	PathHints map[string]string `json:"path_hints,omitempty"`
 }
 
 // Reference contains a git reference.
@@ -0,0 +1,296 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package packcache caches the pack streams produced by upload-pack for identical fetch negotiations.
+//
+// Cached responses are stored under "<repo id>/code/" or "<repo id>/wiki/" in an ObjectStorage, concurrent
+// identical requests are coalesced onto a single producer and all entries of a repository are dropped when its
+// refs change. Entries expire after a TTL and the oldest ones are evicted once the cache exceeds its max size.
+package packcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/util"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+	"github.com/openmerlin/gitea_data/modules/storage"
+)
+
+// ProduceFunc writes the pack stream of a request to w
+type ProduceFunc func(w io.Writer) error
+
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+// Cache is a content addressed cache for upload-pack responses
+type Cache struct {
+	store storage.ObjectStorage
+	// ttl is how long an entry is served, 0 serves entries until they are invalidated or evicted
+	ttl time.Duration
+	// maxSize is the size Evict shrinks the cache to, 0 doesn't limit it
+	maxSize int64
+
+	mu          sync.Mutex
+	inflight    map[string]*call
+	generations map[int64]uint64
+}
+
+// NewCache creates a cache which stores its entries in store, see Evict for ttl and maxSize
+func NewCache(store storage.ObjectStorage, ttl time.Duration, maxSize int64) *Cache {
+	return &Cache{
+		store:       store,
+		ttl:         ttl,
+		maxSize:     maxSize,
+		inflight:    make(map[string]*call),
+		generations: make(map[int64]uint64),
+	}
+}
+
+var (
+	defaultCache     *Cache
+	defaultCacheOnce sync.Once
+)
+
+// Enabled returns true if the upload-pack cache is enabled
+func Enabled() bool {
+	return setting.PackCache.Enabled
+}
+
+// GetCache returns the cache backed by the configured pack cache storage
+func GetCache() *Cache {
+	defaultCacheOnce.Do(func() {
+		defaultCache = NewCache(storage.PackCache, setting.PackCache.TTL, setting.PackCache.MaxSize)
+	})
+	return defaultCache
+}
+
+// entryPath keeps the entries of the wiki apart, a client which may only read the wiki mustn't get a pack of the code
+func entryPath(repoID int64, isWiki bool, key string) string {
+	kind := "code"
+	if isWiki {
+		kind = "wiki"
+	}
+	return fmt.Sprintf("%d/%s/%s/%s", repoID, kind, key[:2], key[2:])
+}
+
+// Serve writes the cached response for key to w, producing and storing it first if it isn't cached yet
+func (c *Cache) Serve(ctx context.Context, repoID int64, isWiki bool, key string, w io.Writer, produce ProduceFunc) error {
+	p := entryPath(repoID, isWiki, key)
+
+	if served, err := c.serveStored(p, w); served || err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if cl, ok := c.inflight[p]; ok {
+		c.mu.Unlock()
+		select {
+		case <-cl.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if cl.err == nil {
+			if served, err := c.serveStored(p, w); served || err != nil {
+				return err
+			}
+		}
+		// the producer failed or its result was invalidated, produce our own response without caching it
+		return produce(w)
+	}
+	cl := &call{done: make(chan struct{})}
+	c.inflight[p] = cl
+	generation := c.generations[repoID]
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, p)
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+
+	cl.err = c.produceAndStore(repoID, generation, p, w, produce)
+	return cl.err
+}
+
+// expired returns whether an entry stored at modTime mustn't be served anymore
+func (c *Cache) expired(modTime time.Time) bool {
+	return c.ttl > 0 && time.Since(modTime) > c.ttl
+}
+
+// serveStored copies a stored response to w, it returns false if there is no stored response or it has expired
+func (c *Cache) serveStored(p string, w io.Writer) (bool, error) {
+	obj, err := c.store.Open(p)
+	if err != nil {
+		return false, nil
+	}
+	defer obj.Close()
+	if info, err := obj.Stat(); err != nil || c.expired(info.ModTime()) {
+		// an expired entry is replaced by the response produced now
+		return false, nil
+	}
+
+	log.Trace("Serving upload-pack response from cache: %s", p)
+	if _, err := io.Copy(w, obj); err != nil {
+		return true, fmt.Errorf("unable to copy cached response %s: %w", p, err)
+	}
+	return true, nil
+}
+
+func (c *Cache) produceAndStore(repoID int64, generation uint64, p string, w io.Writer, produce ProduceFunc) error {
+	tmp, err := os.CreateTemp("", "gitea-pack-cache-*")
+	if err != nil {
+		log.Error("Unable to create temporary file for pack cache: %v", err)
+		return produce(w)
+	}
+	defer func() {
+		_ = tmp.Close()
+		if err := util.Remove(tmp.Name()); err != nil {
+			log.Error("Unable to remove temporary pack cache file %s: %v", tmp.Name(), err)
+		}
+	}()
+
+	// keep filling the cache even if the client goes away, waiting requests may need the response
+	tw := &teeWriter{client: w, file: tmp}
+	if err := produce(tw); err != nil {
+		return err
+	}
+	if tw.fileErr != nil {
+		return tw.fileErr
+	}
+
+	c.mu.Lock()
+	invalidated := c.generations[repoID] != generation
+	c.mu.Unlock()
+	if invalidated {
+		return errors.New("pack cache entry was invalidated while it was produced")
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := c.store.Save(p, tmp, size); err != nil {
+		log.Error("Unable to save pack cache entry %s: %v", p, err)
+		return err
+	}
+	return tw.clientErr
+}
+
+// Invalidate removes all cached responses of a repository
+func (c *Cache) Invalidate(repoID int64) error {
+	c.mu.Lock()
+	c.generations[repoID]++
+	c.mu.Unlock()
+
+	err := c.store.IterateObjects(strconv.FormatInt(repoID, 10), func(path string, obj storage.Object) error {
+		_ = obj.Close()
+		return c.store.Delete(path)
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Evict removes the entries which have expired, then the oldest entries until the cache is no larger than
+// its max size. Entries are only served while they haven't expired, the max size may be exceeded until the
+// next eviction.
+func (c *Cache) Evict(ctx context.Context) error {
+	var (
+		entries []entry
+		total   int64
+		removed int
+	)
+	err := c.store.IterateObjects("", func(path string, obj storage.Object) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		info, err := obj.Stat()
+		_ = obj.Close()
+		if err != nil {
+			return err
+		}
+		if c.expired(info.ModTime()) {
+			removed++
+			return c.store.Delete(path)
+		}
+		entries = append(entries, entry{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if c.maxSize > 0 && total > c.maxSize {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+		for _, e := range entries {
+			if total <= c.maxSize {
+				break
+			}
+			if err := c.store.Delete(e.path); err != nil {
+				return err
+			}
+			total -= e.size
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Trace("Evicted %d entries from the pack cache, %d bytes are left", removed, total)
+	}
+	return nil
+}
+
+// Invalidate removes all cached responses of a repository from the default cache
+func Invalidate(repoID int64) {
+	if !Enabled() {
+		return
+	}
+	if err := GetCache().Invalidate(repoID); err != nil {
+		log.Error("Unable to invalidate the pack cache of repository %d: %v", repoID, err)
+	}
+}
+
+type teeWriter struct {
+	client    io.Writer
+	clientErr error
+	file      io.Writer
+	fileErr   error
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if t.fileErr == nil {
+		_, t.fileErr = t.file.Write(p)
+	}
+	if t.clientErr == nil {
+		_, t.clientErr = t.client.Write(p)
+	}
+	if t.fileErr != nil && t.clientErr != nil {
+		return 0, t.clientErr
+	}
+	return len(p), nil
+}
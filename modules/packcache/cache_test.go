@@ -0,0 +1,220 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package packcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+	"github.com/openmerlin/gitea_data/modules/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+func TestRequestKey(t *testing.T) {
+	const (
+		oid1 = "1111111111111111111111111111111111111111"
+		oid2 = "2222222222222222222222222222222222222222"
+	)
+	req := func(agent string, wants ...string) []byte {
+		s := pktLine("want " + wants[0] + " multi_ack_detailed side-band-64k thin-pack ofs-delta agent=" + agent + "\n")
+		for _, want := range wants[1:] {
+			s += pktLine("want " + want + "\n")
+		}
+		return []byte(s + "0000" + pktLine("done\n"))
+	}
+
+	key1, ok := RequestKey(1, false, "", req("git/2.40", oid1, oid2))
+	assert.True(t, ok)
+	// the order of wants and the client agent don't change the response
+	key2, ok := RequestKey(1, false, "", req("git/2.43", oid2, oid1))
+	assert.True(t, ok)
+	assert.Equal(t, key1, key2)
+
+	key3, ok := RequestKey(2, false, "", req("git/2.40", oid1, oid2))
+	assert.True(t, ok)
+	assert.NotEqual(t, key1, key3)
+	// a client may read the wiki but not the code
+	wikiKey, ok := RequestKey(1, true, "", req("git/2.40", oid1, oid2))
+	assert.True(t, ok)
+	assert.NotEqual(t, key1, wikiKey)
+
+	// incomplete negotiations are not cacheable
+	_, ok = RequestKey(1, false, "", []byte(pktLine("want "+oid1+"\n")+"0000"))
+	assert.False(t, ok)
+
+	// protocol v2 ls-refs is not cacheable
+	_, ok = RequestKey(1, false, "version=2", []byte(pktLine("command=ls-refs\n")+"0001"+pktLine("done\n")+"0000"))
+	assert.False(t, ok)
+
+	v2Key, ok := RequestKey(1, false, "version=2", []byte(pktLine("command=fetch\n")+pktLine("agent=git/2.40\n")+"0001"+pktLine("want "+oid1+"\n")+pktLine("done\n")+"0000"))
+	assert.True(t, ok)
+	assert.NotEqual(t, key1, v2Key)
+
+	_, ok = RequestKey(1, false, "", []byte("zzzz"))
+	assert.False(t, ok)
+}
+
+func newTestCache(t *testing.T) *Cache {
+	c, _ := newLimitedTestCache(t, 0, 0)
+	return c
+}
+
+// newLimitedTestCache returns a cache with a TTL and a max size, and the directory of its entries
+func newLimitedTestCache(t *testing.T, ttl time.Duration, maxSize int64) (*Cache, string) {
+	dir := t.TempDir()
+	store, err := storage.NewLocalStorage(context.Background(), &setting.Storage{Path: dir})
+	assert.NoError(t, err)
+	return NewCache(store, ttl, maxSize), dir
+}
+
+func TestCacheServe(t *testing.T) {
+	c := newTestCache(t)
+	key := strings.Repeat("ab", 32)
+
+	var produced atomic.Int32
+	release := make(chan struct{})
+	produce := func(w io.Writer) error {
+		produced.Add(1)
+		<-release
+		_, err := w.Write([]byte("PACK-data"))
+		return err
+	}
+
+	var wg sync.WaitGroup
+	outputs := make([]bytes.Buffer, 4)
+	for i := range outputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, c.Serve(context.Background(), 1, false, key, &outputs[i], produce))
+		}(i)
+	}
+	assert.Eventually(t, func() bool { return produced.Load() == 1 }, time.Second, 10*time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, produced.Load())
+	for i := range outputs {
+		assert.Equal(t, "PACK-data", outputs[i].String())
+	}
+
+	// served from the storage now
+	var out bytes.Buffer
+	assert.NoError(t, c.Serve(context.Background(), 1, false, key, &out, produce))
+	assert.EqualValues(t, 1, produced.Load())
+	assert.Equal(t, "PACK-data", out.String())
+
+	// refs changed
+	assert.NoError(t, c.Invalidate(1))
+	out.Reset()
+	assert.NoError(t, c.Serve(context.Background(), 1, false, key, &out, produce))
+	assert.EqualValues(t, 2, produced.Load())
+
+	// invalidating a repository without entries is fine
+	assert.NoError(t, c.Invalidate(2))
+}
+
+func TestCacheServeProducerError(t *testing.T) {
+	c := newTestCache(t)
+	key := strings.Repeat("cd", 32)
+
+	failed := errors.New("upload-pack failed")
+	assert.ErrorIs(t, c.Serve(context.Background(), 1, false, key, io.Discard, func(w io.Writer) error {
+		_, _ = w.Write([]byte("partial"))
+		return failed
+	}), failed)
+
+	// nothing was stored
+	var out bytes.Buffer
+	assert.NoError(t, c.Serve(context.Background(), 1, false, key, &out, func(w io.Writer) error {
+		_, err := w.Write([]byte("complete"))
+		return err
+	}))
+	assert.Equal(t, "complete", out.String())
+}
+
+func TestCacheServeWiki(t *testing.T) {
+	c := newTestCache(t)
+	key := strings.Repeat("ef", 32)
+	serve := func(isWiki bool, content string) string {
+		var out bytes.Buffer
+		assert.NoError(t, c.Serve(context.Background(), 1, isWiki, key, &out, func(w io.Writer) error {
+			_, err := w.Write([]byte(content))
+			return err
+		}))
+		return out.String()
+	}
+
+	assert.Equal(t, "PACK-code", serve(false, "PACK-code"))
+	// the same negotiation of the wiki doesn't get the pack of the code
+	assert.Equal(t, "PACK-wiki", serve(true, "PACK-wiki"))
+	assert.Equal(t, "PACK-code", serve(false, "PACK-other"))
+	assert.Equal(t, "PACK-wiki", serve(true, "PACK-other"))
+
+	// the wiki belongs to the repository
+	assert.NoError(t, c.Invalidate(1))
+	assert.Equal(t, "PACK-new", serve(true, "PACK-new"))
+}
+
+func TestCacheEvict(t *testing.T) {
+	c, dir := newLimitedTestCache(t, time.Hour, 10)
+	var produced atomic.Int32
+	serve := func(repoID int64, key, content string) string {
+		var out bytes.Buffer
+		assert.NoError(t, c.Serve(context.Background(), repoID, false, key, &out, func(w io.Writer) error {
+			produced.Add(1)
+			_, err := w.Write([]byte(content))
+			return err
+		}))
+		return out.String()
+	}
+	// age sets the modification time of an entry, which is when it was stored
+	age := func(repoID int64, key string, d time.Duration) {
+		modTime := time.Now().Add(-d)
+		assert.NoError(t, os.Chtimes(filepath.Join(dir, entryPath(repoID, false, key)), modTime, modTime))
+	}
+	exists := func(repoID int64, key string) bool {
+		_, err := os.Stat(filepath.Join(dir, entryPath(repoID, false, key)))
+		return err == nil
+	}
+	key1, key2, key3 := strings.Repeat("01", 32), strings.Repeat("02", 32), strings.Repeat("03", 32)
+
+	serve(1, key1, "PACK-1")
+	age(1, key1, 2*time.Hour)
+	// an expired entry isn't served even before it's evicted
+	assert.Equal(t, "PACK-1b", serve(1, key1, "PACK-1b"))
+	assert.EqualValues(t, 2, produced.Load())
+	age(1, key1, 2*time.Hour)
+
+	serve(2, key2, "PACK-2")
+	age(2, key2, 30*time.Minute)
+	serve(2, key3, "PACK-3")
+	assert.NoError(t, c.Evict(context.Background()))
+	assert.False(t, exists(1, key1), "expired")
+	// 12 bytes are left, more than the max size of 10, so the oldest entry is evicted too
+	assert.False(t, exists(2, key2), "oldest")
+	assert.True(t, exists(2, key3))
+	assert.Equal(t, "PACK-3", serve(2, key3, "PACK-3b"))
+
+	// nothing to evict
+	assert.NoError(t, c.Evict(context.Background()))
+	assert.True(t, exists(2, key3))
+	assert.NoError(t, newTestCache(t).Evict(context.Background()))
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package packcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parsePktLines splits a pkt-line stream into its data lines, special packets (flush, delim, response-end)
+// are kept as their 4 byte representation so that the structure of the request is part of the key.
+func parsePktLines(body []byte) ([]string, error) {
+	var lines []string
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("truncated pkt-line length")
+		}
+		length, err := strconv.ParseUint(string(body[:4]), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pkt-line length %q", body[:4])
+		}
+		switch {
+		case length < 4:
+			lines = append(lines, string(body[:4]))
+			body = body[4:]
+		case int(length) > len(body):
+			return nil, fmt.Errorf("truncated pkt-line")
+		default:
+			lines = append(lines, strings.TrimSuffix(string(body[4:length]), "\n"))
+			body = body[length:]
+		}
+	}
+	return lines, nil
+}
+
+// capabilities which don't change the response and would only make identical requests differ
+func isIgnoredCapability(capability string) bool {
+	return strings.HasPrefix(capability, "agent=") || strings.HasPrefix(capability, "session-id=")
+}
+
+// RequestKey returns the content address of a stateless-rpc upload-pack request to the repository or its wiki.
+// It returns false if the request is not a complete fetch negotiation and should not be cached.
+func RequestKey(repoID int64, isWiki bool, protocol string, body []byte) (string, bool) {
+	lines, err := parsePktLines(body)
+	if err != nil {
+		return "", false
+	}
+
+	var (
+		wants, haves, others []string
+		done, isFetch        bool
+	)
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "want "):
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				return "", false
+			}
+			wants = append(wants, fields[1])
+			// protocol v0/v1 sends the capabilities after the first want
+			for _, capability := range fields[2:] {
+				if !isIgnoredCapability(capability) {
+					others = append(others, capability)
+				}
+			}
+			isFetch = true
+		case strings.HasPrefix(line, "have "):
+			haves = append(haves, strings.TrimPrefix(line, "have "))
+		case line == "done":
+			done = true
+		case line == "command=fetch":
+			isFetch = true
+			others = append(others, line)
+		case strings.HasPrefix(line, "command="):
+			// ls-refs and other protocol v2 commands are cheap, don't cache them
+			return "", false
+		case isIgnoredCapability(line):
+		default:
+			others = append(others, line)
+		}
+	}
+	if !isFetch || !done || len(wants) == 0 {
+		return "", false
+	}
+
+	sort.Strings(wants)
+	sort.Strings(haves)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "repo %d\nwiki %t\nprotocol %s\n", repoID, isWiki, protocol)
+	for _, want := range wants {
+		fmt.Fprintf(&buf, "want %s\n", want)
+	}
+	for _, have := range haves {
+		fmt.Fprintf(&buf, "have %s\n", have)
+	}
+	for _, other := range others {
+		fmt.Fprintf(&buf, "%s\n", other)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:]), true
+}
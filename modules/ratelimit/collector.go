@@ -0,0 +1,44 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package ratelimit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "gitea_rate_limit_"
+
+// Collector exposes the rate limit rejections for prometheus
+type Collector struct {
+	Rejected *prometheus.Desc
+}
+
+// NewCollector returns a new Collector with all prometheus.Desc initialized
+func NewCollector() Collector {
+	return Collector{
+		Rejected: prometheus.NewDesc(
+			namespace+"rejected_total",
+			"Number of requests rejected because a rate limit budget was exhausted",
+			[]string{"route", "kind"}, nil,
+		),
+	}
+}
+
+// Describe returns all possible prometheus.Desc
+func (c Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.Rejected
+}
+
+// Collect returns the metrics with values
+func (c Collector) Collect(ch chan<- prometheus.Metric) {
+	limiter := GetLimiter()
+	if limiter == nil {
+		return
+	}
+	for route, kinds := range limiter.Rejected() {
+		for kind, count := range kinds {
+			ch <- prometheus.MustNewConstMetric(c.Rejected, prometheus.CounterValue, float64(count), route, string(kind))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/nosql"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// Kind is the budget a cost is taken from
+type Kind string
+
+const (
+	// KindRequests counts every request as 1
+	KindRequests Kind = "requests"
+	// KindBytes counts the bytes sent to the client
+	KindBytes Kind = "bytes"
+)
+
+type rejectionKey struct {
+	route string
+	kind  Kind
+}
+
+// Limiter applies the budgets of the configured routes
+type Limiter struct {
+	store Store
+	rules map[string]*setting.RateLimitRule
+
+	mu       sync.Mutex
+	rejected map[rejectionKey]int64
+}
+
+// NewLimiter creates a Limiter which keeps its buckets in store
+func NewLimiter(store Store, rules map[string]*setting.RateLimitRule) *Limiter {
+	return &Limiter{
+		store:    store,
+		rules:    rules,
+		rejected: make(map[rejectionKey]int64),
+	}
+}
+
+func (l *Limiter) limit(route string, kind Kind) (Limit, bool) {
+	rule, ok := l.rules[route]
+	if !ok {
+		return Limit{}, false
+	}
+	switch kind {
+	case KindRequests:
+		if rule.Requests <= 0 {
+			return Limit{}, false
+		}
+		return Every(rule.Requests, rule.Period, rule.Burst), true
+	case KindBytes:
+		if rule.Bytes <= 0 {
+			return Limit{}, false
+		}
+		return Every(rule.Bytes, rule.Period, rule.Bytes), true
+	}
+	return Limit{}, false
+}
+
+// Take takes cost from the budget of kind of a client on route.
+// It returns false if the route has no such budget. Store errors are logged and the request is allowed.
+func (l *Limiter) Take(ctx context.Context, route string, kind Kind, clientKey string, cost int64) (Result, bool) {
+	limit, ok := l.limit(route, kind)
+	if !ok {
+		return Result{}, false
+	}
+	// a single response larger than the whole budget is allowed once the bucket is full
+	if cost > limit.Burst {
+		cost = limit.Burst
+	}
+
+	res, err := l.store.Take(ctx, route+":"+string(kind)+":"+clientKey, limit, cost)
+	if err != nil {
+		log.Error("Rate limit for %s on %s failed: %v", clientKey, route, err)
+		return Result{}, false
+	}
+	if !res.Allowed {
+		l.mu.Lock()
+		l.rejected[rejectionKey{route: route, kind: kind}]++
+		l.mu.Unlock()
+	}
+	return res, true
+}
+
+// Rejected returns the number of rejections per route and kind
+func (l *Limiter) Rejected() map[string]map[Kind]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rejected := make(map[string]map[Kind]int64, len(l.rejected))
+	for key, count := range l.rejected {
+		if rejected[key.route] == nil {
+			rejected[key.route] = make(map[Kind]int64)
+		}
+		rejected[key.route][key.kind] = count
+	}
+	return rejected
+}
+
+var (
+	defaultLimiter     *Limiter
+	defaultLimiterOnce sync.Once
+)
+
+// GetLimiter returns the limiter for the configured routes, it is nil if rate limiting is disabled
+func GetLimiter() *Limiter {
+	defaultLimiterOnce.Do(func() {
+		if !setting.RateLimit.Enabled {
+			return
+		}
+		var store Store
+		switch setting.RateLimit.Store {
+		case "redis":
+			store = NewRedisStore(nosql.GetManager().GetRedisClient(setting.RateLimit.ConnStr), "gitea:ratelimit:")
+		default:
+			store = NewMemoryStore()
+		}
+		defaultLimiter = NewLimiter(store, setting.RateLimit.Routes)
+	})
+	return defaultLimiter
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openmerlin/gitea_data/modules/context"
+)
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// take takes cost from a budget and writes the RateLimit-* headers, it responds 429 and returns false if the budget is exhausted
+func take(ctx *context.Context, route string, kind Kind, cost int64) bool {
+	limiter := GetLimiter()
+	if limiter == nil {
+		return true
+	}
+	res, limited := limiter.Take(ctx, route, kind, context.ClientKey(ctx), cost)
+	if !limited {
+		return true
+	}
+
+	header := ctx.Resp.Header()
+	header.Set("RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+	header.Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+	header.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+	if res.Allowed {
+		return true
+	}
+
+	header.Set("Retry-After", ceilSeconds(res.RetryAfter))
+	ctx.Error(http.StatusTooManyRequests, fmt.Sprintf("rate limit of %s exceeded", kind))
+	return false
+}
+
+// Middleware counts every request of route against the request budget of its client
+func Middleware(route string) func(ctx *context.Context) {
+	return func(ctx *context.Context) {
+		take(ctx, route, KindRequests, 1)
+	}
+}
+
+// TakeBytes counts n bytes about to be sent against the byte budget of the client.
+// It returns false if the budget is exhausted, the response has been written then.
+func TakeBytes(ctx *context.Context, route string, n int64) bool {
+	return take(ctx, route, KindBytes, n)
+}
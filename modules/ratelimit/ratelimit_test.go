@@ -0,0 +1,115 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/modules/contexttest"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMemoryStore() (*MemoryStore, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestMemoryStore(t *testing.T) {
+	s, now := newTestMemoryStore()
+	ctx := context.Background()
+	limit := Every(10, 10*time.Second, 3)
+
+	for i := 2; i >= 0; i-- {
+		res, err := s.Take(ctx, "a", limit, 1)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.EqualValues(t, 3, res.Limit)
+		assert.EqualValues(t, i, res.Remaining)
+	}
+
+	res, err := s.Take(ctx, "a", limit, 1)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.EqualValues(t, time.Second, res.RetryAfter)
+	assert.EqualValues(t, 3*time.Second, res.Reset)
+
+	// other clients have their own bucket
+	res, err = s.Take(ctx, "b", limit, 1)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	*now = now.Add(time.Second)
+	res, err = s.Take(ctx, "a", limit, 1)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.EqualValues(t, 0, res.Remaining)
+
+	// full buckets are dropped
+	*now = now.Add(time.Hour)
+	_, _ = s.Take(ctx, "c", limit, 1)
+	assert.Len(t, s.buckets, 1)
+}
+
+func TestLimiter(t *testing.T) {
+	s, _ := newTestMemoryStore()
+	l := NewLimiter(s, map[string]*setting.RateLimitRule{
+		"git":          {Requests: 2, Burst: 2, Period: time.Minute},
+		"lfs_download": {Requests: 0, Bytes: 100, Period: time.Minute},
+	})
+	ctx := context.Background()
+
+	_, limited := l.Take(ctx, "unknown", KindRequests, "ip:1", 1)
+	assert.False(t, limited)
+	_, limited = l.Take(ctx, "lfs_download", KindRequests, "ip:1", 1)
+	assert.False(t, limited)
+
+	for i := 0; i < 2; i++ {
+		res, limited := l.Take(ctx, "git", KindRequests, "ip:1", 1)
+		assert.True(t, limited)
+		assert.True(t, res.Allowed)
+	}
+	res, _ := l.Take(ctx, "git", KindRequests, "ip:1", 1)
+	assert.False(t, res.Allowed)
+
+	// a response larger than the byte budget is allowed once with a full bucket
+	res, _ = l.Take(ctx, "lfs_download", KindBytes, "user:1", 1000)
+	assert.True(t, res.Allowed)
+	res, _ = l.Take(ctx, "lfs_download", KindBytes, "user:1", 10)
+	assert.False(t, res.Allowed)
+
+	assert.Equal(t, map[string]map[Kind]int64{
+		"git":          {KindRequests: 1},
+		"lfs_download": {KindBytes: 1},
+	}, l.Rejected())
+}
+
+func TestMiddlewareClientIP(t *testing.T) {
+	s, _ := newTestMemoryStore()
+	defaultLimiterOnce.Do(func() {})
+	defer test.MockVariableValue(&defaultLimiter, NewLimiter(s, map[string]*setting.RateLimitRule{
+		"git": {Requests: 2, Burst: 2, Period: time.Minute},
+	}))()
+
+	request := func(remoteAddr string) int {
+		ctx, resp := contexttest.MockContext(t, "GET /user2/repo1.git/info/refs")
+		ctx.Req.RemoteAddr = remoteAddr
+		Middleware("git")(ctx)
+		return resp.Code
+	}
+
+	// every connection of a client comes from another port, they share the bucket of the client IP
+	assert.Equal(t, http.StatusOK, request("192.0.2.1:50001"))
+	assert.Equal(t, http.StatusOK, request("192.0.2.1:50002"))
+	assert.Equal(t, http.StatusTooManyRequests, request("192.0.2.1:50003"))
+	assert.Equal(t, http.StatusOK, request("192.0.2.2:50001"))
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills and takes from a bucket atomically, the clock of the redis server is used
+// so that all nodes of a cluster agree on the refill.
+//
+// KEYS[1] bucket key, ARGV[1] rate per second, ARGV[2] burst, ARGV[3] cost
+// returns {allowed, tokens}
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore keeps the buckets in a redis compatible server so that they are shared by all nodes
+type RedisStore struct {
+	client redis.Scripter
+	prefix string
+}
+
+// NewRedisStore creates a RedisStore which prefixes all bucket keys with prefix
+func NewRedisStore(client redis.Scripter, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// Take implements Store
+func (s *RedisStore) Take(ctx context.Context, key string, limit Limit, cost int64) (Result, error) {
+	args := []any{
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64),
+		limit.Burst,
+		cost,
+	}
+	values, err := takeScript.Run(ctx, s.client, []string{s.prefix + key}, args...).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("unable to take from rate limit bucket %s: %w", key, err)
+	}
+	if len(values) != 2 {
+		return Result{}, fmt.Errorf("unexpected rate limit script result: %v", values)
+	}
+
+	allowed, _ := values[0].(int64)
+	tokensStr, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("unexpected rate limit tokens %q: %w", tokensStr, err)
+	}
+	return newResult(allowed == 1, tokens, limit, cost), nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package ratelimit throttles clients with token buckets kept in a pluggable store.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket which holds at most Burst tokens and is refilled with Rate tokens per second
+type Limit struct {
+	Rate  float64
+	Burst int64
+}
+
+// Every returns a limit which refills n tokens every period
+func Every(n int64, period time.Duration, burst int64) Limit {
+	return Limit{Rate: float64(n) / period.Seconds(), Burst: burst}
+}
+
+// Result is the state of a bucket after taking tokens from it
+type Result struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the rejected cost is available, it is 0 if the request was allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets of all clients
+type Store interface {
+	// Take removes cost tokens from the bucket of key if it holds enough of them
+	Take(ctx context.Context, key string, limit Limit, cost int64) (Result, error)
+}
+
+// newResult computes the result for a bucket holding tokens after the cost was taken (or rejected)
+func newResult(allowed bool, tokens float64, limit Limit, cost int64) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int64(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((float64(cost) - tokens) / limit.Rate)
+	}
+	return res
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/openmerlin/gitea_data/modules/git"
+)
+
+// BackupRefPrefix is the hidden namespace of the tips overwritten by force pushes,
+// a backup is stored as BackupRefPrefix<branch>/<unix nano timestamp>
+const BackupRefPrefix = "refs/gitea-backup/"
+
+// KeepAliveRefPrefix is the hidden namespace of the tips of deleted branches, which keeps them
+// from garbage collection until restored. A tip is stored as KeepAliveRefPrefix<branch ID>.
+const KeepAliveRefPrefix = "refs/gitea-deleted/"
+
+// KeepAliveRefName returns the ref which keeps the tip of the deleted branch of the branch table row branchID
+func KeepAliveRefName(branchID int64) string {
+	return KeepAliveRefPrefix + strconv.FormatInt(branchID, 10)
+}
+
+// hiddenRefPrefixes are never advertised to clients
+var hiddenRefPrefixes = []string{BackupRefPrefix, KeepAliveRefPrefix}
+
+// HiddenRefsEnv returns env with the config which hides the backup and keep-alive refs from the ref advertisement
+// of upload-pack and receive-pack, receive-pack also refuses to update hidden refs. The config is added after the
+// GIT_CONFIG_COUNT entries which env has already, git only reads the last GIT_CONFIG_COUNT.
+func HiddenRefsEnv(env []string) []string {
+	count := 0
+	for _, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "GIT_CONFIG_COUNT="); ok {
+			count, _ = strconv.Atoi(value)
+		}
+	}
+
+	hidden := make([]string, 0, len(env)+2*len(hiddenRefPrefixes)+1)
+	hidden = append(hidden, env...)
+	for i, prefix := range hiddenRefPrefixes {
+		hidden = append(hidden,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=transfer.hideRefs", count+i),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", count+i, prefix),
+		)
+	}
+	return append(hidden, fmt.Sprintf("GIT_CONFIG_COUNT=%d", count+len(hiddenRefPrefixes)))
+}
+
+func containsHiddenRef(b []byte) bool {
+	for _, prefix := range hiddenRefPrefixes {
+		if bytes.Contains(b, []byte("\t"+prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateServerInfo updates the files read by dumb HTTP clients. update-server-info doesn't know
+// about hidden refs, so the backup and keep-alive refs are removed from info/refs afterwards.
+func UpdateServerInfo(ctx context.Context, repoPath string) error {
+	if stdout, _, err := git.NewCommand(ctx, "update-server-info").RunStdString(&git.RunOpts{Dir: repoPath}); err != nil {
+		return fmt.Errorf("update-server-info: %w - %s", err, stdout)
+	}
+
+	infoRefs := filepath.Join(repoPath, "info", "refs")
+	content, err := os.ReadFile(infoRefs)
+	if err != nil {
+		return err
+	}
+	if !containsHiddenRef(content) {
+		return nil
+	}
+	var filtered bytes.Buffer
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if !containsHiddenRef(line) {
+			filtered.Write(line)
+		}
+	}
+	// update-server-info replaces the file the same way, so readers never see a partial file
+	tmp := infoRefs + ".tmp"
+	if err := os.WriteFile(tmp, filtered.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, infoRefs)
+}
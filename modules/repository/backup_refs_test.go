@@ -0,0 +1,60 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repository
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHiddenRefsEnv(t *testing.T) {
+	assert.Equal(t, []string{
+		"GIT_CONFIG_KEY_0=transfer.hideRefs",
+		"GIT_CONFIG_VALUE_0=" + BackupRefPrefix,
+		"GIT_CONFIG_KEY_1=transfer.hideRefs",
+		"GIT_CONFIG_VALUE_1=" + KeepAliveRefPrefix,
+		"GIT_CONFIG_COUNT=2",
+	}, HiddenRefsEnv(nil))
+
+	env := HiddenRefsEnv([]string{"GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=uploadpack.hideRefs", "GIT_CONFIG_VALUE_0=refs/custom/"})
+	assert.Equal(t, []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=uploadpack.hideRefs",
+		"GIT_CONFIG_VALUE_0=refs/custom/",
+		"GIT_CONFIG_KEY_1=transfer.hideRefs",
+		"GIT_CONFIG_VALUE_1=" + BackupRefPrefix,
+		"GIT_CONFIG_KEY_2=transfer.hideRefs",
+		"GIT_CONFIG_VALUE_2=" + KeepAliveRefPrefix,
+		"GIT_CONFIG_COUNT=3",
+	}, env)
+
+	// upload-pack honours both the config of the environment and the hidden refs
+	repoPath := filepath.Join(t.TempDir(), "repo.git")
+	require.NoError(t, exec.Command("git", "init", "--bare", repoPath).Run())
+	runGit := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", repoPath}, args...)...)
+		out, err := cmd.Output()
+		require.NoError(t, err, "git %v", args)
+		return strings.TrimSpace(string(out))
+	}
+	commitID := runGit("commit-tree", runGit("hash-object", "-t", "tree", "-w", "/dev/null"), "-m", "commit")
+	for _, ref := range []string{"refs/heads/main", "refs/custom/a", BackupRefPrefix + "main/1", KeepAliveRefPrefix + "1"} {
+		runGit("update-ref", ref, commitID)
+	}
+
+	cmd := exec.Command("git", "upload-pack", "--advertise-refs", repoPath)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "refs/heads/main")
+	assert.NotContains(t, string(out), "refs/custom/")
+	assert.NotContains(t, string(out), BackupRefPrefix)
+	assert.NotContains(t, string(out), KeepAliveRefPrefix)
+}
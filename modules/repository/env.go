@@ -17,4 +17,26 @@ const (
 	EnvIsInternal   = "GITEA_INTERNAL_PUSH"
 	EnvAppURL       = "GITEA_ROOT_URL"
 	EnvActionPerm   = "GITEA_ACTION_PERM"
+	EnvAuthMethod   = "GITEA_AUTH_METHOD" // how the pusher authenticated, see the AuthMethod constants
+	EnvAuthID       = "GITEA_AUTH_ID"     // ID of the SSH key, deploy key or actions task used to authenticate
+	EnvClientIP     = "GITEA_CLIENT_IP"
+)
+
+// Authentication methods of a push
+const (
+	AuthMethodSSHKey      = "ssh_key"
+	AuthMethodDeployKey   = "deploy_key"
+	AuthMethodActionsTask = "actions"
+	AuthMethodAccessToken = "token"
+)
+
+// The hook command passes the authentication of the push to the private API as push options under these names,
+// pushers can't set them because options with the gitea.internal. prefix are dropped before.
+const (
+	PushOptionInternalPrefix = "gitea.internal."
+	PushOptionAuthMethod     = PushOptionInternalPrefix + "auth_method"
+	PushOptionAuthID         = PushOptionInternalPrefix + "auth_id"
+	PushOptionClientIP       = PushOptionInternalPrefix + "client_ip"
+	// PushOptionPushID identifies the push across the batches the hook sends its ref updates in
+	PushOptionPushID = PushOptionInternalPrefix + "push_id"
 )
@@ -0,0 +1,28 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"time"
+)
+
+// BranchSync settings for reconciling the branch table with the branches on disk,
+// which drift apart when refs are changed without going through the hooks
+var BranchSync = struct {
+	Enabled bool
+	// Interval is how often all repositories are synced
+	Interval time.Duration
+}{
+	Enabled:  false,
+	Interval: 24 * time.Hour,
+}
+
+func loadBranchSyncFrom(rootCfg ConfigProvider) {
+	sec := rootCfg.Section("repository.branch_sync")
+	BranchSync.Enabled = sec.Key("ENABLED").MustBool(false)
+	BranchSync.Interval = sec.Key("INTERVAL").MustDuration(24 * time.Hour)
+	if BranchSync.Interval < time.Minute {
+		BranchSync.Interval = time.Minute
+	}
+}
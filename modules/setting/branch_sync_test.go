@@ -0,0 +1,36 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadBranchSync(t *testing.T) {
+	oldBranchSync := BranchSync
+	defer func() {
+		BranchSync = oldBranchSync
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[repository.branch_sync]
+ENABLED = true
+`)
+	assert.NoError(t, err)
+	loadBranchSyncFrom(cfg)
+	assert.True(t, BranchSync.Enabled)
+	assert.EqualValues(t, 24*time.Hour, BranchSync.Interval)
+
+	cfg, err = NewConfigProviderFromData(`
+[repository.branch_sync]
+INTERVAL = 1s
+`)
+	assert.NoError(t, err)
+	loadBranchSyncFrom(cfg)
+	assert.False(t, BranchSync.Enabled)
+	assert.EqualValues(t, time.Minute, BranchSync.Interval)
+}
@@ -0,0 +1,25 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"time"
+)
+
+// DeletedBranch settings for the keep-alive refs, which keep the tips of deleted branches restorable
+var DeletedBranch = struct {
+	// KeepAlivePruneInterval is how often the keep-alive refs of all repositories are pruned,
+	// whether the branch sync is enabled or not
+	KeepAlivePruneInterval time.Duration
+}{
+	KeepAlivePruneInterval: 24 * time.Hour,
+}
+
+func loadDeletedBranchFrom(rootCfg ConfigProvider) {
+	sec := rootCfg.Section("repository.deleted_branch")
+	DeletedBranch.KeepAlivePruneInterval = sec.Key("KEEP_ALIVE_PRUNE_INTERVAL").MustDuration(24 * time.Hour)
+	if DeletedBranch.KeepAlivePruneInterval < time.Minute {
+		DeletedBranch.KeepAlivePruneInterval = time.Minute
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"time"
+)
+
+// DownstreamMirror settings for pushing the updated refs of every push to the downstream mirrors of a repository
+var DownstreamMirror = struct {
+	Enabled bool
+	// MaxAttempts is how often a sync is tried before the mirror is marked as failed
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry, it doubles with every attempt up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// Timeout bounds a single push to a mirror including its LFS uploads
+	Timeout time.Duration
+	// AlertUsers are the names of the users mailed when a mirror fails
+	AlertUsers []string
+}{
+	Enabled:         false,
+	MaxAttempts:     8,
+	RetryBackoff:    30 * time.Second,
+	MaxRetryBackoff: time.Hour,
+	Timeout:         10 * time.Minute,
+}
+
+func loadDownstreamMirrorFrom(rootCfg ConfigProvider) {
+	sec := rootCfg.Section("repository.downstream_mirror")
+	DownstreamMirror.Enabled = sec.Key("ENABLED").MustBool(false)
+	DownstreamMirror.MaxAttempts = sec.Key("MAX_ATTEMPTS").MustInt(8)
+	if DownstreamMirror.MaxAttempts < 1 {
+		DownstreamMirror.MaxAttempts = 1
+	}
+	DownstreamMirror.RetryBackoff = sec.Key("RETRY_BACKOFF").MustDuration(30 * time.Second)
+	DownstreamMirror.MaxRetryBackoff = sec.Key("MAX_RETRY_BACKOFF").MustDuration(time.Hour)
+	DownstreamMirror.Timeout = sec.Key("TIMEOUT").MustDuration(10 * time.Minute)
+	DownstreamMirror.AlertUsers = sec.Key("ALERT_USERS").Strings(",")
+}
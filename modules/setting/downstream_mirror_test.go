@@ -0,0 +1,43 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDownstreamMirror(t *testing.T) {
+	oldDownstreamMirror := DownstreamMirror
+	defer func() {
+		DownstreamMirror = oldDownstreamMirror
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[repository.downstream_mirror]
+ENABLED = true
+`)
+	assert.NoError(t, err)
+	loadDownstreamMirrorFrom(cfg)
+	assert.True(t, DownstreamMirror.Enabled)
+	assert.EqualValues(t, 8, DownstreamMirror.MaxAttempts)
+	assert.EqualValues(t, 30*time.Second, DownstreamMirror.RetryBackoff)
+	assert.EqualValues(t, time.Hour, DownstreamMirror.MaxRetryBackoff)
+	assert.Empty(t, DownstreamMirror.AlertUsers)
+
+	cfg, err = NewConfigProviderFromData(`
+[repository.downstream_mirror]
+ENABLED = true
+MAX_ATTEMPTS = 0
+RETRY_BACKOFF = 1m
+ALERT_USERS = admin, ops
+`)
+	assert.NoError(t, err)
+	loadDownstreamMirrorFrom(cfg)
+	assert.EqualValues(t, 1, DownstreamMirror.MaxAttempts)
+	assert.EqualValues(t, time.Minute, DownstreamMirror.RetryBackoff)
+	assert.Equal(t, []string{"admin", "ops"}, DownstreamMirror.AlertUsers)
+}
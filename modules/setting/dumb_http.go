@@ -0,0 +1,43 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DumbHTTPModePublish uploads the packs and refs of a repository to the dumb HTTP storage after every push
+	DumbHTTPModePublish = "publish"
+	// DumbHTTPModeEdge serves the dumb HTTP routes from the storage without local repositories
+	DumbHTTPModeEdge = "edge"
+)
+
+// DumbHTTP settings for serving the dumb HTTP protocol through an object storage
+var DumbHTTP = struct {
+	// Mode is empty (disabled), "publish" or "edge"
+	Mode string
+
+	Storage *Storage
+}{}
+
+func loadDumbHTTPFrom(rootCfg ConfigProvider) (err error) {
+	sec, _ := rootCfg.GetSection("git.dumb_http")
+	if sec != nil {
+		if err := sec.MapTo(&DumbHTTP); err != nil {
+			return fmt.Errorf("failed to map git.dumb_http settings: %v", err)
+		}
+	}
+
+	DumbHTTP.Mode = strings.ToLower(strings.TrimSpace(DumbHTTP.Mode))
+	switch DumbHTTP.Mode {
+	case "", DumbHTTPModePublish, DumbHTTPModeEdge:
+	default:
+		return fmt.Errorf("unknown git.dumb_http MODE: %q", DumbHTTP.Mode)
+	}
+
+	DumbHTTP.Storage, err = getStorage(rootCfg, "dumb-http", "", sec)
+	return err
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDumbHTTP(t *testing.T) {
+	oldDumbHTTP := DumbHTTP
+	defer func() {
+		DumbHTTP = oldDumbHTTP
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[git.dumb_http]
+MODE = Edge
+STORAGE_TYPE = minio
+MINIO_BUCKET = mirrors
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, loadDumbHTTPFrom(cfg))
+	assert.Equal(t, DumbHTTPModeEdge, DumbHTTP.Mode)
+	assert.EqualValues(t, "minio", DumbHTTP.Storage.Type)
+	assert.Equal(t, "mirrors", DumbHTTP.Storage.MinioConfig.Bucket)
+
+	cfg, err = NewConfigProviderFromData(`
+[git.dumb_http]
+MODE = replica
+`)
+	assert.NoError(t, err)
+	assert.Error(t, loadDumbHTTPFrom(cfg))
+}
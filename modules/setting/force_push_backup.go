@@ -0,0 +1,36 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"time"
+)
+
+// ForcePushBackup settings for keeping the tips overwritten by force pushes under hidden backup refs.
+// Protected branches refuse force pushes anyway, so this is about the unprotected branches.
+var ForcePushBackup = struct {
+	Enabled bool
+	// MaxAge is how long a backup is kept, 0 keeps backups until MaxPerBranch is exceeded
+	MaxAge time.Duration
+	// MaxPerBranch is how many backups of a branch are kept, 0 doesn't limit them
+	MaxPerBranch int
+	// PruneInterval is how often the backups of all repositories are pruned
+	PruneInterval time.Duration
+}{
+	Enabled:       false,
+	MaxAge:        30 * 24 * time.Hour,
+	MaxPerBranch:  10,
+	PruneInterval: 24 * time.Hour,
+}
+
+func loadForcePushBackupFrom(rootCfg ConfigProvider) {
+	sec := rootCfg.Section("repository.force_push_backup")
+	ForcePushBackup.Enabled = sec.Key("ENABLED").MustBool(false)
+	ForcePushBackup.MaxAge = sec.Key("MAX_AGE").MustDuration(30 * 24 * time.Hour)
+	ForcePushBackup.MaxPerBranch = sec.Key("MAX_PER_BRANCH").MustInt(10)
+	ForcePushBackup.PruneInterval = sec.Key("PRUNE_INTERVAL").MustDuration(24 * time.Hour)
+	if ForcePushBackup.PruneInterval < time.Minute {
+		ForcePushBackup.PruneInterval = time.Minute
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadForcePushBackup(t *testing.T) {
+	oldForcePushBackup := ForcePushBackup
+	defer func() {
+		ForcePushBackup = oldForcePushBackup
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[repository.force_push_backup]
+ENABLED = true
+`)
+	assert.NoError(t, err)
+	loadForcePushBackupFrom(cfg)
+	assert.True(t, ForcePushBackup.Enabled)
+	assert.EqualValues(t, 30*24*time.Hour, ForcePushBackup.MaxAge)
+	assert.EqualValues(t, 10, ForcePushBackup.MaxPerBranch)
+	assert.EqualValues(t, 24*time.Hour, ForcePushBackup.PruneInterval)
+
+	cfg, err = NewConfigProviderFromData(`
+[repository.force_push_backup]
+ENABLED = true
+MAX_AGE = 0
+MAX_PER_BRANCH = 3
+PRUNE_INTERVAL = 1s
+`)
+	assert.NoError(t, err)
+	loadForcePushBackupFrom(cfg)
+	assert.EqualValues(t, 0, ForcePushBackup.MaxAge)
+	assert.EqualValues(t, 3, ForcePushBackup.MaxPerBranch)
+	assert.EqualValues(t, time.Minute, ForcePushBackup.PruneInterval)
+}
@@ -6,6 +6,7 @@ package setting
 import (
 	"encoding/base64"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"code.gitea.io/gitea/modules/generate"
@@ -18,8 +19,14 @@ var LFS = struct {
 	JWTSecretBase64 string        `ini:"LFS_JWT_SECRET"`
 	JWTSecretBytes  []byte        `ini:"-"`
 	HTTPAuthExpiry  time.Duration `ini:"LFS_HTTP_AUTH_EXPIRY"`
-	MaxFileSize     int64         `ini:"LFS_MAX_FILE_SIZE"`
-	LocksPagingNum  int           `ini:"LFS_LOCKS_PAGING_NUM"`
+	// ActionLinkExpiry is how long the tokens of the action links in batch responses are valid
+	ActionLinkExpiry time.Duration `ini:"LFS_ACTION_LINK_EXPIRY"`
+	// JWTSigningAlgorithm is the algorithm of the key generated to sign tokens, the LFS_JWT_SECRET signs HS256 tokens
+	JWTSigningAlgorithm string `ini:"LFS_JWT_SIGNING_ALGORITHM"`
+	// JWTKeysFile keeps the keys signing and verifying tokens, so that keys can be rotated
+	JWTKeysFile    string `ini:"LFS_JWT_KEYS_FILE"`
+	MaxFileSize    int64  `ini:"LFS_MAX_FILE_SIZE"`
+	LocksPagingNum int    `ini:"LFS_LOCKS_PAGING_NUM"`
 
 	Storage *Storage
 }{}
@@ -56,6 +63,16 @@ func loadLFSFrom(rootCfg ConfigProvider) error {
 	}
 
 	LFS.HTTPAuthExpiry = sec.Key("LFS_HTTP_AUTH_EXPIRY").MustDuration(24 * time.Hour)
+	LFS.ActionLinkExpiry = sec.Key("LFS_ACTION_LINK_EXPIRY").MustDuration(10 * time.Minute)
+	if LFS.ActionLinkExpiry <= 0 {
+		LFS.ActionLinkExpiry = 10 * time.Minute
+	}
+	LFS.JWTSigningAlgorithm = sec.Key("LFS_JWT_SIGNING_ALGORITHM").In("HS256",
+		[]string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"})
+	LFS.JWTKeysFile = sec.Key("LFS_JWT_KEYS_FILE").MustString("lfs/jwt_keys.json")
+	if !filepath.IsAbs(LFS.JWTKeysFile) {
+		LFS.JWTKeysFile = filepath.Join(AppDataPath, LFS.JWTKeysFile)
+	}
 
 	if !LFS.StartServer || !InstallLock {
 		return nil
@@ -4,7 +4,9 @@
 package setting
 
 import (
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -99,3 +101,44 @@ STORAGE_TYPE = minio
 	assert.EqualValues(t, "gitea", LFS.Storage.MinioConfig.Bucket)
 	assert.EqualValues(t, "lfs/", LFS.Storage.MinioConfig.BasePath)
 }
+
+func Test_LFSActionLinkExpiry(t *testing.T) {
+	cfg, err := NewConfigProviderFromData(``)
+	assert.NoError(t, err)
+	assert.NoError(t, loadLFSFrom(cfg))
+	assert.EqualValues(t, 10*time.Minute, LFS.ActionLinkExpiry)
+
+	cfg, err = NewConfigProviderFromData(`
+[server]
+LFS_ACTION_LINK_EXPIRY = 2m
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, loadLFSFrom(cfg))
+	assert.EqualValues(t, 2*time.Minute, LFS.ActionLinkExpiry)
+}
+
+func Test_LFSJWTKeys(t *testing.T) {
+	cfg, err := NewConfigProviderFromData(``)
+	assert.NoError(t, err)
+	assert.NoError(t, loadLFSFrom(cfg))
+	assert.EqualValues(t, "HS256", LFS.JWTSigningAlgorithm)
+	assert.EqualValues(t, filepath.Join(AppDataPath, "lfs/jwt_keys.json"), LFS.JWTKeysFile)
+
+	cfg, err = NewConfigProviderFromData(`
+[server]
+LFS_JWT_SIGNING_ALGORITHM = EdDSA
+LFS_JWT_KEYS_FILE = /etc/gitea/lfs_keys.json
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, loadLFSFrom(cfg))
+	assert.EqualValues(t, "EdDSA", LFS.JWTSigningAlgorithm)
+	assert.EqualValues(t, "/etc/gitea/lfs_keys.json", LFS.JWTKeysFile)
+
+	cfg, err = NewConfigProviderFromData(`
+[server]
+LFS_JWT_SIGNING_ALGORITHM = none
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, loadLFSFrom(cfg))
+	assert.EqualValues(t, "HS256", LFS.JWTSigningAlgorithm)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// OIDCFederationRule maps the tokens of a trusted OIDC issuer to repository access, e.g. for CI jobs
+type OIDCFederationRule struct {
+	Name   string
+	Issuer string
+	// Audience must be one of the "aud" claims of the token
+	Audience string
+	// JWKSURL defaults to the jwks_uri of the discovery document of the issuer
+	JWKSURL string
+	// Subject must match the whole "sub" claim of the token
+	Subject *regexp.Regexp
+	// Repos are the "owner/name" patterns of the repositories the token may access, "*" matches within a name
+	Repos []string
+	// Permission is "read" or "write"
+	Permission string
+	// User is the name of the user the token acts as, the built-in actions identity if it is empty
+	User string
+}
+
+// OIDCFederation settings for accepting the JWTs of trusted OIDC issuers instead of long-lived access tokens
+var OIDCFederation = struct {
+	Enabled bool
+	// JWKSCacheTTL is how long the keys of an issuer are used before they are fetched again
+	JWKSCacheTTL time.Duration
+	// ClockSkew is tolerated when checking the expiry of the tokens
+	ClockSkew time.Duration
+	Rules     []*OIDCFederationRule
+}{
+	Enabled:      false,
+	JWKSCacheTTL: time.Hour,
+	ClockSkew:    time.Minute,
+}
+
+func loadOIDCFederationFrom(rootCfg ConfigProvider) {
+	sec := rootCfg.Section("oidc_federation")
+	OIDCFederation.Enabled = sec.Key("ENABLED").MustBool(false)
+	OIDCFederation.JWKSCacheTTL = sec.Key("JWKS_CACHE_TTL").MustDuration(time.Hour)
+	OIDCFederation.ClockSkew = sec.Key("CLOCK_SKEW").MustDuration(time.Minute)
+
+	OIDCFederation.Rules = nil
+	for _, ruleSec := range sec.ChildSections() {
+		name := strings.TrimPrefix(ruleSec.Name(), "oidc_federation.")
+		rule := &OIDCFederationRule{
+			Name:       name,
+			Issuer:     strings.TrimSuffix(ruleSec.Key("ISSUER").String(), "/"),
+			Audience:   ruleSec.Key("AUDIENCE").String(),
+			JWKSURL:    ruleSec.Key("JWKS_URL").String(),
+			Repos:      ruleSec.Key("REPOS").Strings(","),
+			Permission: ruleSec.Key("PERMISSION").In("read", []string{"read", "write"}),
+			User:       ruleSec.Key("USER").String(),
+		}
+		if rule.Issuer == "" || rule.Audience == "" || len(rule.Repos) == 0 {
+			log.Error("oidc_federation.%s needs ISSUER, AUDIENCE and REPOS, it is ignored", name)
+			continue
+		}
+		subject := ruleSec.Key("SUBJECT").String()
+		if subject == "" {
+			log.Error("oidc_federation.%s needs a SUBJECT, it is ignored", name)
+			continue
+		}
+		var err error
+		if rule.Subject, err = regexp.Compile("^(?:" + subject + ")$"); err != nil {
+			log.Error("oidc_federation.%s has an invalid SUBJECT, it is ignored: %v", name, err)
+			continue
+		}
+		OIDCFederation.Rules = append(OIDCFederation.Rules, rule)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadOIDCFederation(t *testing.T) {
+	oldOIDCFederation := OIDCFederation
+	defer func() {
+		OIDCFederation = oldOIDCFederation
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[oidc_federation]
+ENABLED = true
+CLOCK_SKEW = 30s
+
+[oidc_federation.pipelines]
+ISSUER = https://token.actions.githubusercontent.com/
+AUDIENCE = https://hub.example.com
+SUBJECT = repo:org/pipelines:ref:refs/heads/.*
+REPOS = org/model-*, org/datasets
+PERMISSION = write
+USER = ci-bot
+
+[oidc_federation.no_subject]
+ISSUER = https://ci.example.com
+AUDIENCE = hub
+REPOS = org/*
+
+[oidc_federation.bad_subject]
+ISSUER = https://ci.example.com
+AUDIENCE = hub
+SUBJECT = (
+REPOS = org/*
+`)
+	assert.NoError(t, err)
+	loadOIDCFederationFrom(cfg)
+	assert.True(t, OIDCFederation.Enabled)
+	assert.EqualValues(t, time.Hour, OIDCFederation.JWKSCacheTTL)
+	assert.EqualValues(t, 30*time.Second, OIDCFederation.ClockSkew)
+
+	if assert.Len(t, OIDCFederation.Rules, 1) {
+		rule := OIDCFederation.Rules[0]
+		assert.Equal(t, "pipelines", rule.Name)
+		assert.Equal(t, "https://token.actions.githubusercontent.com", rule.Issuer)
+		assert.Equal(t, []string{"org/model-*", "org/datasets"}, rule.Repos)
+		assert.Equal(t, "write", rule.Permission)
+		assert.Equal(t, "ci-bot", rule.User)
+		assert.True(t, rule.Subject.MatchString("repo:org/pipelines:ref:refs/heads/main"))
+		// the subject has to match completely
+		assert.False(t, rule.Subject.MatchString("repo:org/pipelines:ref:refs/heads/main:extra\nrepo:other"))
+		assert.False(t, rule.Subject.MatchString("xrepo:org/pipelines:ref:refs/heads/main"))
+	}
+}
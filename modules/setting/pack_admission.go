@@ -0,0 +1,48 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// PackAdmission settings limit how many git upload-pack/receive-pack processes may run at once.
+// A limit of 0 means unlimited, a MAX_QUEUE_LENGTH of 0 rejects every request which can't run immediately,
+// and a QUEUE_TIMEOUT of 0 lets queued requests wait until they are admitted or canceled.
+var PackAdmission = struct {
+	Enabled        bool
+	MaxConcurrent  int
+	MaxPerRepo     int
+	MaxPerUser     int
+	MaxQueueLength int
+	QueueTimeout   time.Duration
+	RetryAfter     time.Duration
+}{
+	Enabled:        false,
+	MaxConcurrent:  32,
+	MaxPerRepo:     8,
+	MaxPerUser:     4,
+	MaxQueueLength: 128,
+	QueueTimeout:   30 * time.Second,
+	RetryAfter:     10 * time.Second,
+}
+
+func loadPackAdmissionFrom(rootCfg ConfigProvider) {
+	mustMapSetting(rootCfg, "git.pack_admission", &PackAdmission)
+	// MapTo ignores durations which aren't positive
+	PackAdmission.QueueTimeout = rootCfg.Section("git.pack_admission").Key("QUEUE_TIMEOUT").MustDuration(PackAdmission.QueueTimeout)
+
+	if PackAdmission.MaxQueueLength < 0 {
+		PackAdmission.MaxQueueLength = 0
+	}
+	if PackAdmission.QueueTimeout < 0 {
+		log.Warn("git.pack_admission QUEUE_TIMEOUT must not be negative, set to 0 (no timeout)")
+		PackAdmission.QueueTimeout = 0
+	}
+	if PackAdmission.RetryAfter < time.Second {
+		PackAdmission.RetryAfter = time.Second
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPackAdmission(t *testing.T) {
+	oldPackAdmission := PackAdmission
+	defer func() {
+		PackAdmission = oldPackAdmission
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[git.pack_admission]
+ENABLED = true
+MAX_CONCURRENT = 10
+MAX_PER_REPO = 3
+QUEUE_TIMEOUT = 0
+RETRY_AFTER = 5s
+`)
+	assert.NoError(t, err)
+	loadPackAdmissionFrom(cfg)
+
+	assert.True(t, PackAdmission.Enabled)
+	assert.EqualValues(t, 10, PackAdmission.MaxConcurrent)
+	assert.EqualValues(t, 3, PackAdmission.MaxPerRepo)
+	assert.EqualValues(t, 4, PackAdmission.MaxPerUser)
+	assert.EqualValues(t, 0, PackAdmission.QueueTimeout)
+	assert.EqualValues(t, 5*time.Second, PackAdmission.RetryAfter)
+
+	cfg, err = NewConfigProviderFromData(`
+[git.pack_admission]
+QUEUE_TIMEOUT = -1s
+`)
+	assert.NoError(t, err)
+	loadPackAdmissionFrom(cfg)
+	assert.EqualValues(t, 0, PackAdmission.QueueTimeout)
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"fmt"
+	"time"
+)
+
+// PackCache settings for caching the responses of identical upload-pack negotiations
+var PackCache = struct {
+	Enabled bool
+	// MaxRequestSize is the largest negotiation request body which is considered for caching
+	MaxRequestSize int64
+	// MaxSize is the size in bytes the cache is shrunk to by evicting its oldest entries, 0 doesn't limit it
+	MaxSize int64
+	// TTL is how long an entry is served, 0 serves entries until the refs of their repository change
+	TTL time.Duration `ini:"-"`
+	// EvictInterval is how often the expired and the oldest entries are evicted
+	EvictInterval time.Duration
+
+	Storage *Storage
+}{
+	Enabled:        false,
+	MaxRequestSize: 64 * 1024,
+	MaxSize:        10 * 1024 * 1024 * 1024,
+	TTL:            24 * time.Hour,
+	EvictInterval:  time.Hour,
+}
+
+func loadPackCacheFrom(rootCfg ConfigProvider) (err error) {
+	sec, _ := rootCfg.GetSection("git.pack_cache")
+	if sec != nil {
+		if err := sec.MapTo(&PackCache); err != nil {
+			return fmt.Errorf("failed to map git.pack_cache settings: %v", err)
+		}
+		// MapTo skips the durations which aren't positive, but a TTL of 0 is valid
+		PackCache.TTL = sec.Key("TTL").MustDuration(PackCache.TTL)
+	}
+	if PackCache.EvictInterval < time.Minute {
+		PackCache.EvictInterval = time.Minute
+	}
+
+	PackCache.Storage, err = getStorage(rootCfg, "pack-cache", "", sec)
+	return err
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPackCache(t *testing.T) {
+	oldPackCache := PackCache
+	defer func() {
+		PackCache = oldPackCache
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[git.pack_cache]
+ENABLED = true
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, loadPackCacheFrom(cfg))
+	assert.True(t, PackCache.Enabled)
+	assert.EqualValues(t, 10*1024*1024*1024, PackCache.MaxSize)
+	assert.EqualValues(t, 24*time.Hour, PackCache.TTL)
+	assert.EqualValues(t, time.Hour, PackCache.EvictInterval)
+
+	cfg, err = NewConfigProviderFromData(`
+[git.pack_cache]
+ENABLED = true
+MAX_SIZE = 1048576
+TTL = 0
+EVICT_INTERVAL = 1s
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, loadPackCacheFrom(cfg))
+	assert.EqualValues(t, 1048576, PackCache.MaxSize)
+	assert.EqualValues(t, 0, PackCache.TTL)
+	assert.EqualValues(t, time.Minute, PackCache.EvictInterval)
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PushEvents settings for publishing an event for every ref update to external sinks
+var PushEvents = struct {
+	Enabled bool
+	// Sinks is the list of enabled sinks: webhook, kafka and file
+	Sinks []string
+
+	Webhook struct {
+		URL     string
+		Secret  string
+		Timeout time.Duration
+	} `ini:"-"`
+	Kafka struct {
+		// URL is the base URL of a Kafka REST proxy
+		URL     string
+		Topic   string
+		Timeout time.Duration
+	} `ini:"-"`
+	File struct {
+		Path string
+	} `ini:"-"`
+}{
+	Enabled: false,
+}
+
+func loadPushEventsFrom(rootCfg ConfigProvider) error {
+	sec := rootCfg.Section("push_events")
+	PushEvents.Enabled = sec.Key("ENABLED").MustBool(false)
+	PushEvents.Sinks = nil
+	for _, sink := range sec.Key("SINKS").Strings(",") {
+		sink = strings.ToLower(sink)
+		switch sink {
+		case "webhook", "kafka", "file":
+			PushEvents.Sinks = append(PushEvents.Sinks, sink)
+		default:
+			return fmt.Errorf("unknown push_events sink: %q", sink)
+		}
+	}
+
+	sec = rootCfg.Section("push_events.webhook")
+	PushEvents.Webhook.URL = sec.Key("URL").String()
+	PushEvents.Webhook.Secret = sec.Key("SECRET").String()
+	PushEvents.Webhook.Timeout = sec.Key("TIMEOUT").MustDuration(10 * time.Second)
+
+	sec = rootCfg.Section("push_events.kafka")
+	PushEvents.Kafka.URL = strings.TrimSuffix(sec.Key("URL").String(), "/")
+	PushEvents.Kafka.Topic = sec.Key("TOPIC").MustString("gitea-push-events")
+	PushEvents.Kafka.Timeout = sec.Key("TIMEOUT").MustDuration(10 * time.Second)
+
+	sec = rootCfg.Section("push_events.file")
+	PushEvents.File.Path = sec.Key("PATH").MustString(filepath.Join(AppDataPath, "push_events.jsonl"))
+
+	if !PushEvents.Enabled {
+		return nil
+	}
+	for _, sink := range PushEvents.Sinks {
+		switch {
+		case sink == "webhook" && PushEvents.Webhook.URL == "":
+			return fmt.Errorf("push_events.webhook URL is required")
+		case sink == "kafka" && PushEvents.Kafka.URL == "":
+			return fmt.Errorf("push_events.kafka URL is required")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPushEvents(t *testing.T) {
+	oldPushEvents := PushEvents
+	defer func() {
+		PushEvents = oldPushEvents
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[push_events]
+ENABLED = true
+SINKS = webhook, Kafka
+
+[push_events.webhook]
+URL = https://hub.example.com/hooks/push
+SECRET = s3cret
+
+[push_events.kafka]
+URL = http://kafka-rest:8082/
+TIMEOUT = 3s
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, loadPushEventsFrom(cfg))
+	assert.True(t, PushEvents.Enabled)
+	assert.Equal(t, []string{"webhook", "kafka"}, PushEvents.Sinks)
+	assert.Equal(t, "s3cret", PushEvents.Webhook.Secret)
+	assert.EqualValues(t, 10*time.Second, PushEvents.Webhook.Timeout)
+	assert.Equal(t, "http://kafka-rest:8082", PushEvents.Kafka.URL)
+	assert.Equal(t, "gitea-push-events", PushEvents.Kafka.Topic)
+	assert.EqualValues(t, 3*time.Second, PushEvents.Kafka.Timeout)
+
+	cfg, err = NewConfigProviderFromData(`
+[push_events]
+ENABLED = true
+SINKS = webhook
+`)
+	assert.NoError(t, err)
+	assert.Error(t, loadPushEventsFrom(cfg))
+
+	cfg, err = NewConfigProviderFromData(`
+[push_events]
+SINKS = nats
+`)
+	assert.NoError(t, err)
+	assert.Error(t, loadPushEventsFrom(cfg))
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// PushPolicy settings for asking an external policy evaluator whether a push is allowed.
+// The evaluator runs in pre-receive after the built-in checks passed.
+var PushPolicy = struct {
+	Enabled bool
+	// Evaluator is either http, an OPA compatible endpoint at URL, or command, a local program run with Command
+	Evaluator string
+	URL       string
+	Command   []string
+	Timeout   time.Duration
+	// FailOpen allows the push if the evaluator can't be reached or fails
+	FailOpen bool
+	// InlineFiles are glob patterns of changed files whose content is included in the push description
+	InlineFiles     []string
+	MaxInlineSize   int64
+	MaxChangedFiles int
+}{
+	Enabled: false,
+}
+
+func loadPushPolicyFrom(rootCfg ConfigProvider) error {
+	sec := rootCfg.Section("push_policy")
+	PushPolicy.Enabled = sec.Key("ENABLED").MustBool(false)
+	PushPolicy.Evaluator = strings.ToLower(sec.Key("EVALUATOR").MustString("http"))
+	PushPolicy.URL = sec.Key("URL").String()
+	PushPolicy.Timeout = sec.Key("TIMEOUT").MustDuration(10 * time.Second)
+	PushPolicy.FailOpen = sec.Key("FAIL_OPEN").MustBool(false)
+	PushPolicy.InlineFiles = []string{"README.md"}
+	if sec.HasKey("INLINE_FILES") {
+		PushPolicy.InlineFiles = sec.Key("INLINE_FILES").Strings(",")
+	}
+	PushPolicy.MaxInlineSize = mustBytes(sec, "MAX_INLINE_SIZE")
+	if PushPolicy.MaxInlineSize < 0 {
+		PushPolicy.MaxInlineSize = 64 * 1024
+	}
+	PushPolicy.MaxChangedFiles = sec.Key("MAX_CHANGED_FILES").MustInt(10000)
+
+	var err error
+	if PushPolicy.Command, err = shellquote.Split(sec.Key("COMMAND").String()); err != nil {
+		return fmt.Errorf("invalid push_policy COMMAND: %w", err)
+	}
+
+	if !PushPolicy.Enabled {
+		return nil
+	}
+	switch PushPolicy.Evaluator {
+	case "http":
+		if PushPolicy.URL == "" {
+			return fmt.Errorf("push_policy URL is required for the http evaluator")
+		}
+	case "command":
+		if len(PushPolicy.Command) == 0 {
+			return fmt.Errorf("push_policy COMMAND is required for the command evaluator")
+		}
+	default:
+		return fmt.Errorf("unknown push_policy evaluator: %q", PushPolicy.Evaluator)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPushPolicy(t *testing.T) {
+	oldPushPolicy := PushPolicy
+	defer func() {
+		PushPolicy = oldPushPolicy
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[push_policy]
+ENABLED = true
+EVALUATOR = command
+COMMAND = opa eval --stdin-input --format raw "data.gitea.push.verdict"
+MAX_INLINE_SIZE = 1KiB
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, loadPushPolicyFrom(cfg))
+	assert.True(t, PushPolicy.Enabled)
+	assert.Equal(t, "command", PushPolicy.Evaluator)
+	assert.Equal(t, []string{"opa", "eval", "--stdin-input", "--format", "raw", "data.gitea.push.verdict"}, PushPolicy.Command)
+	assert.EqualValues(t, 10*time.Second, PushPolicy.Timeout)
+	assert.False(t, PushPolicy.FailOpen)
+	assert.Equal(t, []string{"README.md"}, PushPolicy.InlineFiles)
+	assert.EqualValues(t, 1024, PushPolicy.MaxInlineSize)
+
+	cfg, err = NewConfigProviderFromData(`
+[push_policy]
+ENABLED = true
+`)
+	assert.NoError(t, err)
+	assert.Error(t, loadPushPolicyFrom(cfg))
+
+	cfg, err = NewConfigProviderFromData(`
+[push_policy]
+ENABLED = true
+EVALUATOR = http
+URL = http://opa:8181/v1/data/gitea/push/verdict
+INLINE_FILES = README.md, *.yaml
+FAIL_OPEN = true
+`)
+	assert.NoError(t, err)
+	assert.NoError(t, loadPushPolicyFrom(cfg))
+	assert.True(t, PushPolicy.FailOpen)
+	assert.Equal(t, []string{"README.md", "*.yaml"}, PushPolicy.InlineFiles)
+	assert.EqualValues(t, 64*1024, PushPolicy.MaxInlineSize)
+}
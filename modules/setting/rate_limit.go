@@ -0,0 +1,81 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"strings"
+	"time"
+
+	"code.gitea.io/gitea/modules/log"
+)
+
+// RateLimitRule is the token bucket budget of a route.
+// Requests and Bytes are refilled every Period, a value of 0 disables the budget.
+type RateLimitRule struct {
+	Requests int64
+	// Burst is the bucket size for requests, it defaults to Requests
+	Burst  int64
+	Bytes  int64 `ini:"-"`
+	Period time.Duration
+}
+
+// RateLimit settings for throttling git and LFS clients, keyed on the signed in user or the client IP
+var RateLimit = struct {
+	Enabled bool
+	// Store is "memory" for single nodes or "redis" for clusters
+	Store   string
+	ConnStr string
+	Routes  map[string]*RateLimitRule `ini:"-"`
+}{
+	Enabled: false,
+	Store:   "memory",
+	ConnStr: "redis://127.0.0.1:6379/0",
+}
+
+// the routes which can be rate limited with their default budgets
+func defaultRateLimitRules() map[string]*RateLimitRule {
+	return map[string]*RateLimitRule{
+		"git":          {Requests: 1200, Period: time.Minute},
+		"lfs":          {Requests: 600, Period: time.Minute},
+		"lfs_download": {Requests: 3000, Bytes: 50 << 30, Period: time.Hour},
+	}
+}
+
+func loadRateLimitFrom(rootCfg ConfigProvider) {
+	sec := rootCfg.Section("rate_limit")
+	if err := sec.MapTo(&RateLimit); err != nil {
+		log.Fatal("Failed to map rate_limit settings: %v", err)
+	}
+	RateLimit.Store = strings.ToLower(sec.Key("STORE").In("memory", []string{"memory", "redis"}))
+
+	RateLimit.Routes = defaultRateLimitRules()
+	for name, rule := range RateLimit.Routes {
+		routeSec, _ := rootCfg.GetSection("rate_limit." + name)
+		if routeSec == nil {
+			continue
+		}
+		if err := routeSec.MapTo(rule); err != nil {
+			log.Fatal("Failed to map rate_limit.%s settings: %v", name, err)
+		}
+		if routeSec.HasKey("BYTES") {
+			rule.Bytes = mustBytes(routeSec, "BYTES")
+		}
+	}
+
+	for name, rule := range RateLimit.Routes {
+		if rule.Period <= 0 {
+			log.Warn("rate_limit.%s PERIOD must be positive, set to 1m", name)
+			rule.Period = time.Minute
+		}
+		if rule.Requests < 0 {
+			rule.Requests = 0
+		}
+		if rule.Bytes < 0 {
+			rule.Bytes = 0
+		}
+		if rule.Burst <= 0 {
+			rule.Burst = rule.Requests
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package setting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRateLimit(t *testing.T) {
+	oldRateLimit := RateLimit
+	defer func() {
+		RateLimit = oldRateLimit
+	}()
+
+	cfg, err := NewConfigProviderFromData(`
+[rate_limit]
+ENABLED = true
+STORE = redis
+
+[rate_limit.git]
+REQUESTS = 100
+BURST = 20
+PERIOD = 10s
+
+[rate_limit.lfs_download]
+BYTES = 1GiB
+`)
+	assert.NoError(t, err)
+	loadRateLimitFrom(cfg)
+
+	assert.True(t, RateLimit.Enabled)
+	assert.Equal(t, "redis", RateLimit.Store)
+
+	git := RateLimit.Routes["git"]
+	assert.EqualValues(t, 100, git.Requests)
+	assert.EqualValues(t, 20, git.Burst)
+	assert.EqualValues(t, 10*time.Second, git.Period)
+
+	lfs := RateLimit.Routes["lfs"]
+	assert.EqualValues(t, 600, lfs.Requests)
+	assert.EqualValues(t, 600, lfs.Burst)
+	assert.EqualValues(t, time.Minute, lfs.Period)
+
+	download := RateLimit.Routes["lfs_download"]
+	assert.EqualValues(t, 1<<30, download.Bytes)
+	assert.EqualValues(t, 3000, download.Requests)
+	assert.EqualValues(t, time.Hour, download.Period)
+}
@@ -151,6 +151,25 @@ func loadCommonSettingsFrom(cfg ConfigProvider) error {
 	loadCamoFrom(cfg)
 	loadI18nFrom(cfg)
 	loadGitFrom(cfg)
+	loadPackAdmissionFrom(cfg)
+	loadRateLimitFrom(cfg)
+	if err := loadPackCacheFrom(cfg); err != nil {
+		return err
+	}
+	if err := loadDumbHTTPFrom(cfg); err != nil {
+		return err
+	}
+	if err := loadPushEventsFrom(cfg); err != nil {
+		return err
+	}
+	if err := loadPushPolicyFrom(cfg); err != nil {
+		return err
+	}
+	loadForcePushBackupFrom(cfg)
+	loadDownstreamMirrorFrom(cfg)
+	loadOIDCFederationFrom(cfg)
+	loadBranchSyncFrom(cfg)
+	loadDeletedBranchFrom(cfg)
 	loadMirrorFrom(cfg)
 	loadMarkupFrom(cfg)
 	loadOtherFrom(cfg)
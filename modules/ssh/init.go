@@ -0,0 +1,128 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/graceful"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/process"
+	gitea_ssh "code.gitea.io/gitea/modules/ssh"
+	"code.gitea.io/gitea/modules/util"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// Init starts the built-in SSH server if START_SSH_SERVER is set, otherwise it prepares the files used with OpenSSH
+func Init() error {
+	if setting.SSH.Disabled {
+		graceful.GetManager().InformCleanup()
+		return nil
+	}
+
+	if !setting.SSH.StartBuiltinServer {
+		graceful.GetManager().InformCleanup()
+
+		if err := os.MkdirAll(setting.SSH.KeyTestPath, 0o700); err != nil {
+			return fmt.Errorf("failed to create directory %q for ssh key test: %w", setting.SSH.KeyTestPath, err)
+		}
+		if len(setting.SSH.TrustedUserCAKeys) > 0 && setting.SSH.AuthorizedPrincipalsEnabled {
+			caKeysFileName := setting.SSH.TrustedUserCAKeysFile
+			if err := os.MkdirAll(filepath.Dir(caKeysFileName), 0o700); err != nil {
+				return fmt.Errorf("failed to create directory %q for ssh trusted ca keys: %w", filepath.Dir(caKeysFileName), err)
+			}
+			if err := os.WriteFile(caKeysFileName, []byte(strings.Join(setting.SSH.TrustedUserCAKeys, "\n")), 0o600); err != nil {
+				return fmt.Errorf("failed to write ssh trusted ca keys to %q: %w", caKeysFileName, err)
+			}
+		}
+		return nil
+	}
+
+	if err := Listen(setting.SSH.ListenHost, setting.SSH.ListenPort, setting.SSH.ServerCiphers, setting.SSH.ServerKeyExchanges, setting.SSH.ServerMACs); err != nil {
+		return err
+	}
+	log.Info("SSH server started on %s. Cipher list (%v), key exchange algorithms (%v), MACs (%v)",
+		net.JoinHostPort(setting.SSH.ListenHost, strconv.Itoa(setting.SSH.ListenPort)),
+		setting.SSH.ServerCiphers, setting.SSH.ServerKeyExchanges, setting.SSH.ServerMACs,
+	)
+	return nil
+}
+
+// hostKeys returns the existing host keys, a key is generated if there is none
+func hostKeys() ([]string, error) {
+	if len(setting.SSH.ServerHostKeys) == 0 {
+		return nil, fmt.Errorf("no SSH_SERVER_HOST_KEYS configured")
+	}
+	keys := make([]string, 0, len(setting.SSH.ServerHostKeys))
+	for _, key := range setting.SSH.ServerHostKeys {
+		isExist, err := util.IsExist(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check if %s exists: %w", key, err)
+		}
+		if isExist {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > 0 {
+		return keys, nil
+	}
+
+	key := setting.SSH.ServerHostKeys[0]
+	if err := os.MkdirAll(filepath.Dir(key), 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create dir of %s: %w", key, err)
+	}
+	if err := gitea_ssh.GenKeyPair(key); err != nil {
+		return nil, fmt.Errorf("unable to generate host key %s: %w", key, err)
+	}
+	log.Trace("New private key is generated: %s", key)
+	return []string{key}, nil
+}
+
+// Listen starts the built-in SSH server on the given address
+func Listen(host string, port int, ciphers, keyExchanges, macs []string) error {
+	srv := newServer(net.JoinHostPort(host, strconv.Itoa(port)), ciphers, keyExchanges, macs)
+
+	keys, err := hostKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		log.Info("Adding SSH host key: %s", key)
+		if err := srv.SetOption(ssh.HostKeyFile(key)); err != nil {
+			return fmt.Errorf("unable to set host key %s: %w", key, err)
+		}
+	}
+
+	go func() {
+		_, _, finished := process.GetManager().AddTypedContext(graceful.GetManager().HammerContext(), "Service: Built-in SSH server", process.SystemProcessType, true)
+		defer finished()
+		listen(srv)
+	}()
+	return nil
+}
+
+func listen(server *ssh.Server) {
+	gracefulServer := graceful.NewServer("tcp", server.Addr, "SSH")
+	gracefulServer.PerWriteTimeout = setting.SSH.PerWriteTimeout
+	gracefulServer.PerWritePerKbTimeout = setting.SSH.PerWritePerKbTimeout
+
+	err := gracefulServer.ListenAndServe(server.Serve, setting.SSH.UseProxyProtocol)
+	if err != nil {
+		select {
+		case <-graceful.GetManager().IsShutdown():
+			log.Critical("Failed to start SSH server: %v", err)
+		default:
+			log.Fatal("Failed to start SSH server: %v", err)
+		}
+	}
+	log.Info("SSH Listener: %s Closed", server.Addr)
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package ssh is the built-in SSH server. Unlike the server of Gitea it keeps no database connection:
+// keys are authenticated through the /ssh/authorized_keys private route and every session runs
+// the serv command, which authorizes the repository access through /serv/command.
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/process"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type contextKey string
+
+const giteaKeyID = contextKey("gitea-key-id")
+
+// errKeyNotExist is returned by lookupKey for keys which aren't registered
+var errKeyNotExist = errors.New("public key does not exist")
+
+// authorizedKeyIDPattern finds the key ID in the command of an authorized_keys line
+var authorizedKeyIDPattern = regexp.MustCompile(`\bkey-(\d+)\b`)
+
+// gitProtocolPattern is what a client may pass as GIT_PROTOCOL, e.g. "version=2"
+var gitProtocolPattern = regexp.MustCompile(`^[\w.=:-]*$`)
+
+var (
+	// lookupKey returns the ID of the public key with the authorized_keys content
+	lookupKey = lookupKeyByPrivateAPI
+	// servCommand returns the command serving a session of the key
+	servCommand = func(ctx context.Context, keyID int64) *exec.Cmd {
+		return exec.CommandContext(ctx, setting.AppPath, "--config="+setting.CustomConf, "serv", "key-"+strconv.FormatInt(keyID, 10))
+	}
+)
+
+// lookupKeyByPrivateAPI asks the private API for the authorized_keys line of the key and takes the key ID from its command
+func lookupKeyByPrivateAPI(ctx context.Context, content string) (int64, error) {
+	line, extra := private.AuthorizedPublicKeyByContent(ctx, content)
+	if extra.StatusCode == http.StatusNotFound {
+		return 0, errKeyNotExist
+	} else if extra.HasError() {
+		return 0, extra.Error
+	}
+	match := authorizedKeyIDPattern.FindStringSubmatch(line)
+	if match == nil {
+		return 0, fmt.Errorf("no key ID in the authorized_keys line, check SSH_AUTHORIZED_KEYS_COMMAND_TEMPLATE")
+	}
+	return strconv.ParseInt(match[1], 10, 64)
+}
+
+func getExitStatusFromError(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 1
+	}
+
+	waitStatus, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		if exitErr.Success() {
+			return 0
+		}
+		return 1
+	}
+
+	return waitStatus.ExitStatus()
+}
+
+// sshConnection returns the value of SSH_CONNECTION for a session: "client-ip client-port server-ip server-port"
+func sshConnection(session ssh.Session) string {
+	clientHost, clientPort, err := net.SplitHostPort(session.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	serverHost, serverPort, err := net.SplitHostPort(session.LocalAddr().String())
+	if err != nil {
+		return ""
+	}
+	return strings.Join([]string{clientHost, clientPort, serverHost, serverPort}, " ")
+}
+
+func sessionHandler(session ssh.Session) {
+	keyID, ok := session.Context().Value(giteaKeyID).(int64)
+	if !ok {
+		_ = session.Exit(1)
+		return
+	}
+
+	command := session.RawCommand()
+	log.Trace("SSH: Payload: %v", command)
+
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+
+	gitProtocol := ""
+	for _, env := range session.Environ() {
+		if value, found := strings.CutPrefix(env, "GIT_PROTOCOL="); found && gitProtocolPattern.MatchString(value) {
+			gitProtocol = value
+			break
+		}
+	}
+
+	cmd := servCommand(ctx, keyID)
+	cmd.Env = append(
+		os.Environ(),
+		"SSH_ORIGINAL_COMMAND="+command,
+		"SSH_CONNECTION="+sshConnection(session),
+		"SKIP_MINWINSVC=1",
+		"GIT_PROTOCOL="+gitProtocol,
+	)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Error("SSH: StdoutPipe: %v", err)
+		return
+	}
+	defer stdout.Close()
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		log.Error("SSH: StderrPipe: %v", err)
+		return
+	}
+	defer stderr.Close()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Error("SSH: StdinPipe: %v", err)
+		return
+	}
+	defer stdin.Close()
+
+	process.SetSysProcAttribute(cmd)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+
+	if err = cmd.Start(); err != nil {
+		log.Error("SSH: Start: %v", err)
+		_ = session.Exit(1)
+		return
+	}
+
+	go func() {
+		defer stdin.Close()
+		if _, err := io.Copy(stdin, session); err != nil {
+			log.Error("Failed to write session to stdin. %s", err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer stdout.Close()
+		if _, err := io.Copy(session, stdout); err != nil {
+			log.Error("Failed to write stdout to session. %s", err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer stderr.Close()
+		if _, err := io.Copy(session.Stderr(), stderr); err != nil {
+			log.Error("Failed to write stderr to session. %s", err)
+		}
+	}()
+
+	// Ensure all the output has been written before we wait on the command to exit
+	wg.Wait()
+
+	err = cmd.Wait()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			log.Error("SSH: Wait: %v", err)
+		}
+	}
+
+	if err := session.Exit(getExitStatusFromError(err)); err != nil && !errors.Is(err, io.EOF) {
+		log.Error("Session failed to exit. %s", err)
+	}
+}
+
+func publicKeyHandler(ctx ssh.Context, key ssh.PublicKey) bool {
+	// the "ssh.Context" is not thread-safe, so requests use the immutable parent "Context"
+	parentCtx := reflect.ValueOf(ctx).Elem().FieldByName("Context").Interface().(context.Context)
+
+	if ctx.User() != setting.SSH.BuiltinServerUser {
+		log.Warn("Invalid SSH username %s - must use %s for all git operations via ssh", ctx.User(), setting.SSH.BuiltinServerUser)
+		log.Warn("Failed authentication attempt from %s", ctx.RemoteAddr())
+		return false
+	}
+
+	// principals are matched by prefix by the private API, so certificates are left to OpenSSH
+	if _, ok := key.(*gossh.Certificate); ok {
+		log.Warn("Certificate Rejected: certificates are not supported by the built-in SSH server")
+		log.Warn("Failed authentication attempt from %s", ctx.RemoteAddr())
+		return false
+	}
+
+	keyID, err := lookupKey(parentCtx, strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key))))
+	if err != nil {
+		if errors.Is(err, errKeyNotExist) {
+			log.Warn("Unknown public key: %s from %s", gossh.FingerprintSHA256(key), ctx.RemoteAddr())
+		} else {
+			log.Error("Unable to look up public key %s: %v", gossh.FingerprintSHA256(key), err)
+		}
+		log.Warn("Failed authentication attempt from %s", ctx.RemoteAddr())
+		return false
+	}
+
+	if log.IsDebug() { // <- FingerprintSHA256 is kinda expensive so only calculate it if necessary
+		log.Debug("Successfully authenticated: %s Public Key Fingerprint: %s", ctx.RemoteAddr(), gossh.FingerprintSHA256(key))
+	}
+	ctx.SetValue(giteaKeyID, keyID)
+	return true
+}
+
+// sshConnectionFailed logs a failed connection
+// -  this mainly exists to give a nice function name in logging
+func sshConnectionFailed(conn net.Conn, err error) {
+	log.Warn("Failed connection from %s with error: %v", conn.RemoteAddr(), err)
+	log.Warn("Failed authentication attempt from %s", conn.RemoteAddr())
+}
+
+// newServer returns a server which only serves git commands: no shells, ptys, forwarding or subsystems
+func newServer(addr string, ciphers, keyExchanges, macs []string) *ssh.Server {
+	return &ssh.Server{
+		Addr:             addr,
+		PublicKeyHandler: publicKeyHandler,
+		Handler:          sessionHandler,
+		ServerConfigCallback: func(ctx ssh.Context) *gossh.ServerConfig {
+			config := &gossh.ServerConfig{}
+			config.KeyExchanges = keyExchanges
+			config.MACs = macs
+			config.Ciphers = ciphers
+			return config
+		},
+		ConnectionFailedCallback: sshConnectionFailed,
+		PtyCallback: func(ctx ssh.Context, pty ssh.Pty) bool {
+			return false
+		},
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// writeClientKey writes a new private key for the ssh client and returns its path and authorized_keys content
+func writeClientKey(t *testing.T, dir, name string) (string, string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := gossh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600))
+
+	sshPub, err := gossh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return keyPath, strings.TrimSpace(string(gossh.MarshalAuthorizedKey(sshPub)))
+}
+
+// startTestServer serves git over ssh from the built-in server, the serv command is replaced by git-shell
+func startTestServer(t *testing.T, dir string, keys map[string]int64) string {
+	oldUser, oldLookupKey, oldServCommand := setting.SSH.BuiltinServerUser, lookupKey, servCommand
+	t.Cleanup(func() {
+		setting.SSH.BuiltinServerUser, lookupKey, servCommand = oldUser, oldLookupKey, oldServCommand
+	})
+	setting.SSH.BuiltinServerUser = "git"
+	lookupKey = func(_ context.Context, content string) (int64, error) {
+		if id, ok := keys[content]; ok {
+			return id, nil
+		}
+		return 0, errKeyNotExist
+	}
+	servCommand = func(ctx context.Context, keyID int64) *exec.Cmd {
+		// record what serv would get to see before handing the command to git-shell
+		script := fmt.Sprintf(`echo "%d $SSH_CONNECTION" >> %q && exec git shell -c "$SSH_ORIGINAL_COMMAND"`, keyID, filepath.Join(dir, "sessions"))
+		return exec.CommandContext(ctx, "sh", "-c", script)
+	}
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := gossh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := newServer(ln.Addr().String(), nil, nil, nil)
+	srv.AddHostKey(hostKey)
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+	return ln.Addr().String()
+}
+
+func runGit(t *testing.T, dir, keyPath string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_SSH_COMMAND=ssh -i "+keyPath+" -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o LogLevel=ERROR",
+		"GIT_AUTHOR_NAME=Tester", "GIT_AUTHOR_EMAIL=tester@example.com",
+		"GIT_COMMITTER_NAME=Tester", "GIT_COMMITTER_EMAIL=tester@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// TestGitOverSSH runs git against the built-in server to test its authentication and how it hands sessions over,
+// the serv command isn't run: its parsing and its private API call are tested in cmd
+func TestGitOverSSH(t *testing.T) {
+	for _, bin := range []string{"ssh", "git", "sh"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is required: %v", bin, err)
+		}
+	}
+
+	dir := t.TempDir()
+	keyPath, authorizedKey := writeClientKey(t, dir, "id_known")
+	unknownKeyPath, _ := writeClientKey(t, dir, "id_unknown")
+	addr := startTestServer(t, dir, map[string]int64{authorizedKey: 42})
+
+	bare := filepath.Join(dir, "repo.git")
+	_, err := runGit(t, dir, keyPath, "init", "--bare", "-q", bare)
+	require.NoError(t, err)
+	remote := "ssh://git@" + addr + bare
+
+	work := filepath.Join(dir, "work")
+	_, err = runGit(t, dir, keyPath, "init", "-q", work)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(work, "README.md"), []byte("over ssh\n"), 0o644))
+	_, err = runGit(t, work, keyPath, "add", "README.md")
+	require.NoError(t, err)
+	_, err = runGit(t, work, keyPath, "commit", "-q", "-m", "initial")
+	require.NoError(t, err)
+
+	t.Run("Push", func(t *testing.T) {
+		out, err := runGit(t, work, keyPath, "push", remote, "HEAD:refs/heads/main")
+		require.NoError(t, err, out)
+	})
+
+	t.Run("Clone", func(t *testing.T) {
+		clone := filepath.Join(dir, "clone")
+		out, err := runGit(t, dir, keyPath, "clone", "-q", "-b", "main", remote, clone)
+		require.NoError(t, err, out)
+		content, err := os.ReadFile(filepath.Join(clone, "README.md"))
+		require.NoError(t, err)
+		assert.Equal(t, "over ssh\n", string(content))
+	})
+
+	t.Run("Session", func(t *testing.T) {
+		sessions, err := os.ReadFile(filepath.Join(dir, "sessions"))
+		require.NoError(t, err)
+		for _, session := range strings.Split(strings.TrimSpace(string(sessions)), "\n") {
+			// the key ID and the client address are handed to serv
+			assert.True(t, strings.HasPrefix(session, "42 127.0.0.1 "), session)
+		}
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		out, err := runGit(t, dir, unknownKeyPath, "ls-remote", remote)
+		assert.Error(t, err)
+		assert.Contains(t, out, "Permission denied")
+	})
+
+	t.Run("WrongUser", func(t *testing.T) {
+		out, err := runGit(t, dir, keyPath, "ls-remote", "ssh://root@"+addr+bare)
+		assert.Error(t, err)
+		assert.Contains(t, out, "Permission denied")
+	})
+}
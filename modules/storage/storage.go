@@ -134,6 +134,12 @@ var (
 	Actions ObjectStorage = uninitializedStorage
 	// Actions Artifacts represents actions artifacts storage
 	ActionsArtifacts ObjectStorage = uninitializedStorage
+
+	// PackCache represents the storage of cached upload-pack responses
+	PackCache ObjectStorage = uninitializedStorage
+
+	// DumbHTTP represents the storage of the published dumb HTTP files of repositories
+	DumbHTTP ObjectStorage = uninitializedStorage
 )
 
 // Init init the stoarge
@@ -146,6 +152,8 @@ func Init() error {
 		initRepoArchives,
 		initPackages,
 		initActions,
+		initPackCache,
+		initDumbHTTP,
 	} {
 		if err := f(); err != nil {
 			return err
@@ -229,3 +237,23 @@ func initActions() (err error) {
 	ActionsArtifacts, err = NewStorage(setting.Actions.ArtifactStorage.Type, setting.Actions.ArtifactStorage)
 	return err
 }
+
+func initPackCache() (err error) {
+	if !setting.PackCache.Enabled {
+		PackCache = discardStorage("PackCache isn't enabled")
+		return nil
+	}
+	log.Info("Initialising PackCache storage with type: %s", setting.PackCache.Storage.Type)
+	PackCache, err = NewStorage(setting.PackCache.Storage.Type, setting.PackCache.Storage)
+	return err
+}
+
+func initDumbHTTP() (err error) {
+	if setting.DumbHTTP.Mode == "" {
+		DumbHTTP = discardStorage("DumbHTTP isn't enabled")
+		return nil
+	}
+	log.Info("Initialising DumbHTTP storage with type: %s", setting.DumbHTTP.Storage.Type)
+	DumbHTTP, err = NewStorage(setting.DumbHTTP.Storage.Type, setting.DumbHTTP.Storage)
+	return err
+}
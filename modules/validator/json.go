@@ -0,0 +1,48 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// JSONFileValidator checks that a file is valid JSON
+type JSONFileValidator struct {
+	name string
+	path string
+}
+
+// Name implements Validator
+func (v *JSONFileValidator) Name() string { return v.name }
+
+// Match implements Validator
+func (v *JSONFileValidator) Match(path string) bool { return path == v.path }
+
+// Validate implements Validator
+func (v *JSONFileValidator) Validate(path string, content []byte) []Problem {
+	var value any
+	err := json.Unmarshal(content, &value)
+	if err == nil {
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		// Offset is the number of bytes read when the error occurred
+		line, column := position(content, int(syntaxErr.Offset)-1)
+		return []Problem{{Path: path, Line: line, Column: column, Message: syntaxErr.Error()}}
+	}
+	return []Problem{{Path: path, Message: err.Error()}}
+}
+
+// position returns the 1-based line and column of the byte at index in content
+func position(content []byte, index int) (line, column int) {
+	index = max(0, min(index, len(content)))
+	before := content[:index]
+	line = bytes.Count(before, []byte{'\n'}) + 1
+	column = index - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
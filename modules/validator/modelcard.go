@@ -0,0 +1,167 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package validator
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed modelcard.schema.json
+var modelCardSchemaJSON string
+
+var modelCardSchema = jsonschema.MustCompileString("modelcard.schema.json", modelCardSchemaJSON)
+
+// ModelCardValidator checks that README.md starts with a YAML front matter block with the model card metadata
+type ModelCardValidator struct{}
+
+// Name implements Validator
+func (v *ModelCardValidator) Name() string { return "model-card" }
+
+// Match implements Validator
+func (v *ModelCardValidator) Match(path string) bool { return path == "README.md" }
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// Validate implements Validator
+func (v *ModelCardValidator) Validate(path string, content []byte) []Problem {
+	frontMatter, ok := extractFrontMatter(content)
+	if !ok {
+		return []Problem{{Path: path, Line: 1, Message: "missing YAML front matter, the file must start with a block delimited by --- lines"}}
+	}
+	// the front matter starts on the line after the opening ---
+	const lineOffset = 1
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(frontMatter, &doc); err != nil {
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return []Problem{{Path: path, Line: line + lineOffset, Message: "invalid YAML: " + m[2]}}
+		}
+		return []Problem{{Path: path, Line: 1 + lineOffset, Message: "invalid YAML: " + strings.TrimPrefix(err.Error(), "yaml: ")}}
+	}
+	if len(doc.Content) == 0 {
+		return []Problem{{Path: path, Line: 1 + lineOffset, Message: "the front matter is empty"}}
+	}
+
+	var value any
+	if err := doc.Decode(&value); err != nil {
+		return []Problem{{Path: path, Line: 1 + lineOffset, Message: "invalid YAML: " + err.Error()}}
+	}
+	err := modelCardSchema.Validate(toJSONValue(value))
+	if err == nil {
+		return nil
+	}
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []Problem{{Path: path, Line: 1 + lineOffset, Message: err.Error()}}
+	}
+
+	var problems []Problem
+	for _, leaf := range leafErrors(validationErr) {
+		node := lookupNode(doc.Content[0], leaf.InstanceLocation)
+		field := strings.TrimPrefix(leaf.InstanceLocation, "/")
+		message := leaf.Message
+		if field != "" {
+			message = field + ": " + message
+		}
+		problems = append(problems, Problem{Path: path, Line: node.Line + lineOffset, Column: node.Column, Message: message})
+	}
+	return problems
+}
+
+// extractFrontMatter returns the YAML between the leading --- line and the next --- or ... line
+func extractFrontMatter(content []byte) ([]byte, bool) {
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if len(lines) == 0 || string(bytes.TrimRight(lines[0], "\r\n")) != "---" {
+		return nil, false
+	}
+	var frontMatter []byte
+	for _, line := range lines[1:] {
+		switch string(bytes.TrimRight(line, " \t\r\n")) {
+		case "---", "...":
+			return frontMatter, true
+		}
+		frontMatter = append(frontMatter, line...)
+	}
+	return nil, false
+}
+
+// leafErrors returns the innermost errors, they name the failing keyword and value
+func leafErrors(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range err.Causes {
+		leaves = append(leaves, leafErrors(cause)...)
+	}
+	return leaves
+}
+
+// lookupNode returns the node at the JSON pointer, or the closest existing parent
+func lookupNode(node *yaml.Node, pointer string) *yaml.Node {
+	if pointer == "" {
+		return node
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == token {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(token); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
+
+// toJSONValue converts a decoded YAML value to the types of a decoded JSON value
+func toJSONValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(value))
+		for k, v := range value {
+			m[k] = toJSONValue(v)
+		}
+		return m
+	case map[any]any:
+		m := make(map[string]any, len(value))
+		for k, v := range value {
+			m[fmt.Sprint(k)] = toJSONValue(v)
+		}
+		return m
+	case []any:
+		l := make([]any, len(value))
+		for i, v := range value {
+			l[i] = toJSONValue(v)
+		}
+		return l
+	case time.Time:
+		return value.Format(time.RFC3339)
+	default:
+		return value
+	}
+}
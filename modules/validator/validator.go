@@ -0,0 +1,69 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package validator contains the built-in content validators which can be enabled for the protected branches of a repository.
+package validator
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Problem is a single validation failure of a file
+type Problem struct {
+	Path string
+	// Line and Column are 1-based, they are 0 if the problem isn't located in the file
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Line > 0 && p.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", p.Path, p.Line, p.Column, p.Message)
+	case p.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", p.Path, p.Line, p.Message)
+	default:
+		return fmt.Sprintf("%s: %s", p.Path, p.Message)
+	}
+}
+
+// Validator checks the content of the files it matches
+type Validator interface {
+	Name() string
+	// Match returns whether the file at path is checked by the validator
+	Match(path string) bool
+	Validate(path string, content []byte) []Problem
+}
+
+var validators = map[string]Validator{}
+
+// Register adds a validator, it panics if the name is taken
+func Register(v Validator) {
+	if _, ok := validators[v.Name()]; ok {
+		panic(fmt.Sprintf("validator %q is already registered", v.Name()))
+	}
+	validators[v.Name()] = v
+}
+
+// Get returns the validator with the name
+func Get(name string) (Validator, bool) {
+	v, ok := validators[name]
+	return v, ok
+}
+
+// Names returns the names of all validators
+func Names() []string {
+	names := make([]string, 0, len(validators))
+	for name := range validators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register(&ModelCardValidator{})
+	Register(&JSONFileValidator{name: "config-json", path: "config.json"})
+}
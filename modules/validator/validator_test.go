@@ -0,0 +1,70 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelCardValidator(t *testing.T) {
+	v, ok := Get("model-card")
+	assert.True(t, ok)
+	assert.True(t, v.Match("README.md"))
+	assert.False(t, v.Match("docs/README.md"))
+
+	assert.Empty(t, v.Validate("README.md", []byte(`---
+license: apache-2.0
+tags:
+  - text-generation
+---
+# Model
+`)))
+
+	problems := v.Validate("README.md", []byte("# Model\n"))
+	assert.Len(t, problems, 1)
+	assert.Equal(t, 1, problems[0].Line)
+
+	problems = v.Validate("README.md", []byte(`---
+tags:
+  - text-generation
+---
+`))
+	assert.Len(t, problems, 1)
+	assert.Equal(t, 2, problems[0].Line)
+	assert.Contains(t, problems[0].Message, "license")
+
+	problems = v.Validate("README.md", []byte(`---
+license: mit
+tags:
+  - text-generation
+  - 42
+---
+`))
+	assert.Len(t, problems, 1)
+	assert.Equal(t, "README.md:5:5: tags/1: expected string, but got number", problems[0].String())
+
+	problems = v.Validate("README.md", []byte(`---
+license: mit
+tags: [a
+---
+`))
+	assert.Len(t, problems, 1)
+	assert.Contains(t, problems[0].Message, "invalid YAML")
+	assert.Greater(t, problems[0].Line, 1)
+}
+
+func TestJSONFileValidator(t *testing.T) {
+	v, ok := Get("config-json")
+	assert.True(t, ok)
+	assert.True(t, v.Match("config.json"))
+
+	assert.Empty(t, v.Validate("config.json", []byte(`{"hidden_size": 768}`)))
+
+	problems := v.Validate("config.json", []byte("{\n  \"hidden_size\": 768,\n  \"layers\" 12\n}\n"))
+	assert.Len(t, problems, 1)
+	assert.Equal(t, 3, problems[0].Line)
+	assert.Equal(t, 12, problems[0].Column)
+}
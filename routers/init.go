@@ -19,7 +19,6 @@ import (
 	"code.gitea.io/gitea/modules/markup"
 	"code.gitea.io/gitea/modules/markup/external"
 	"github.com/openmerlin/gitea_data/modules/setting"
-	"code.gitea.io/gitea/modules/ssh"
 	"code.gitea.io/gitea/modules/storage"
 	"code.gitea.io/gitea/modules/svg"
 	"code.gitea.io/gitea/modules/system"
@@ -43,6 +42,17 @@ import (
 	"code.gitea.io/gitea/services/task"
 	"code.gitea.io/gitea/services/uinotification"
 	"code.gitea.io/gitea/services/webhook"
+	"github.com/openmerlin/gitea_data/modules/ssh"
+	data_storage "github.com/openmerlin/gitea_data/modules/storage"
+	"github.com/openmerlin/gitea_data/services/downstream"
+	"github.com/openmerlin/gitea_data/services/dumbhttp"
+	"github.com/openmerlin/gitea_data/services/lfs"
+	packcache_service "github.com/openmerlin/gitea_data/services/packcache"
+	"github.com/openmerlin/gitea_data/services/pushevent"
+	"github.com/openmerlin/gitea_data/services/pushoptions"
+	"github.com/openmerlin/gitea_data/services/refbackup"
+	data_repo_service "github.com/openmerlin/gitea_data/services/repository"
+	"github.com/openmerlin/gitea_data/services/transfer"
 	"github.com/openmerlin/gitea_data/routers/private"
 	web_routers "github.com/openmerlin/gitea_data/routers/web"
 )
@@ -111,12 +121,17 @@ func InitWebInstalled(ctx context.Context) {
 
 	setting.LoadSettings()
 	mustInit(storage.Init)
+	mustInit(data_storage.Init)
 
 	mailer.NewContext(ctx)
 	mustInit(cache.NewContext)
 	mustInit(feed_service.Init)
 	mustInit(uinotification.Init)
 	mustInit(archiver.Init)
+	mustInit(dumbhttp.Init)
+	mustInit(pushevent.Init)
+	mustInit(downstream.Init)
+	mustInit(lfs.InitSigningKeys)
 
 	highlight.NewContext()
 	external.RegisterRenderers()
@@ -136,6 +151,11 @@ func InitWebInstalled(ctx context.Context) {
 	mustInitCtx(ctx, models.Init)
 	mustInitCtx(ctx, authmodel.Init)
 	mustInitCtx(ctx, repo_service.Init)
+	mustInit(transfer.Init)
+	mustInit(data_repo_service.InitBranchSync)
+	mustInit(data_repo_service.InitKeepAlivePrune)
+	mustInit(refbackup.InitPrune)
+	mustInit(packcache_service.Init)
 
 	// Booting long running goroutines.
 	mustInit(indexer_service.Init)
@@ -156,7 +176,10 @@ func InitWebInstalled(ctx context.Context) {
 	auth.Init()
 	mustInit(svg.Init)
 
-	actions_service.Init()
+	mustInit(func() error {
+		// ci.skip hides the pushed commits from the actions notifier
+		return pushoptions.Init(actions_service.Init)
+	})
 
 	// Finally start up the cron
 	//cron.NewContext(ctx)
@@ -0,0 +1,97 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	auth_model "code.gitea.io/gitea/models/auth"
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/timeutil"
+	"code.gitea.io/gitea/modules/util"
+	"code.gitea.io/gitea/modules/web"
+
+	data_auth_model "github.com/openmerlin/gitea_data/models/auth"
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+)
+
+// AccessTokenPolicyOption binds an access token to repositories and sets its expiry
+type AccessTokenPolicyOption struct {
+	// RepoIDs are the repositories the token may be used on, empty means any repository
+	RepoIDs []int64 `json:"repo_ids"`
+	// ExpiresUnix is the time after which the token is rejected, 0 means never
+	ExpiresUnix int64 `json:"expires_unix"`
+}
+
+func accessTokenPolicyError(ctx *context.PrivateContext, action string, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, util.ErrNotExist) {
+		status = http.StatusNotFound
+	}
+	ctx.JSON(status, private.Response{
+		Err:     fmt.Sprintf("Unable to %s: %v", action, err),
+		UserMsg: err.Error(),
+	})
+}
+
+// GetAccessTokenPolicy returns the repositories and the expiry an access token is limited to
+func GetAccessTokenPolicy(ctx *context.PrivateContext) {
+	tokenID := ctx.ParamsInt64(":id")
+	exist, err := db.GetEngine(ctx).ID(tokenID).Exist(new(auth_model.AccessToken))
+	if err != nil {
+		accessTokenPolicyError(ctx, "get access token policy", err)
+		return
+	} else if !exist {
+		accessTokenPolicyError(ctx, "get access token policy", data_auth_model.ErrAccessTokenIDNotExist{ID: tokenID})
+		return
+	}
+
+	policy, err := data_auth_model.GetAccessTokenPolicy(ctx, tokenID)
+	if err != nil {
+		accessTokenPolicyError(ctx, "get access token policy", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, policy)
+}
+
+// SetAccessTokenPolicy replaces the repositories and the expiry an access token is limited to
+func SetAccessTokenPolicy(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*AccessTokenPolicyOption)
+	if form.ExpiresUnix < 0 {
+		ctx.JSON(http.StatusBadRequest, private.Response{
+			UserMsg: "expires_unix must not be negative",
+		})
+		return
+	}
+
+	repoIDs := make([]int64, 0, len(form.RepoIDs))
+	for _, repoID := range form.RepoIDs {
+		exist, err := db.GetEngine(ctx).ID(repoID).Exist(new(repo_model.Repository))
+		if err != nil {
+			accessTokenPolicyError(ctx, "set access token policy", err)
+			return
+		} else if !exist {
+			ctx.JSON(http.StatusNotFound, private.Response{
+				UserMsg: fmt.Sprintf("repository %d does not exist", repoID),
+			})
+			return
+		}
+		repoIDs = append(repoIDs, repoID)
+	}
+
+	policy := &data_auth_model.AccessTokenPolicy{
+		TokenID:     ctx.ParamsInt64(":id"),
+		RepoIDs:     repoIDs,
+		ExpiresUnix: timeutil.TimeStamp(form.ExpiresUnix),
+	}
+	if err := data_auth_model.SetAccessTokenPolicy(ctx, policy); err != nil {
+		accessTokenPolicyError(ctx, "set access token policy", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, policy)
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/web"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/modules/branchprotection"
+	branchprotection_service "github.com/openmerlin/gitea_data/services/branchprotection"
+)
+
+// BranchProtectionFileOption enables or disables managing the protected branch rules of a repository as code
+type BranchProtectionFileOption struct {
+	Enabled bool `json:"enabled"`
+}
+
+// BranchProtectionFileInfo is the response of the branch protection file APIs
+type BranchProtectionFileInfo struct {
+	Path            string     `json:"path"`
+	Enabled         bool       `json:"enabled"`
+	EnabledByID     int64      `json:"enabled_by_id,omitempty"`
+	AppliedCommitID string     `json:"applied_commit_id,omitempty"`
+	Applied         *time.Time `json:"applied,omitempty"`
+	// LastError is why the file pushed at LastErrorCommitID couldn't be applied, the rules are those applied before
+	LastErrorCommitID string     `json:"last_error_commit_id,omitempty"`
+	LastError         string     `json:"last_error,omitempty"`
+	LastErrorTime     *time.Time `json:"last_error_time,omitempty"`
+}
+
+func respondBranchProtectionFile(ctx *context.PrivateContext, repo *repo_model.Repository) {
+	state, err := git_model.GetBranchProtectionFile(ctx, repo.ID)
+	if err != nil {
+		repoLifecycleError(ctx, "get branch protection file", err)
+		return
+	}
+	info := &BranchProtectionFileInfo{Path: branchprotection.FilePath}
+	if state != nil {
+		info.Enabled = true
+		info.EnabledByID = state.EnabledByID
+		info.AppliedCommitID = state.AppliedCommitID
+		if state.AppliedUnix > 0 {
+			applied := state.AppliedUnix.AsTime()
+			info.Applied = &applied
+		}
+		if state.LastError != "" {
+			lastErrorTime := state.LastErrorUnix.AsTime()
+			info.LastErrorCommitID = state.LastErrorCommitID
+			info.LastError = state.LastError
+			info.LastErrorTime = &lastErrorTime
+		}
+	}
+	ctx.JSON(http.StatusOK, info)
+}
+
+// assertBranchProtectionFile rejects a push to the default branch of a repository whose protected branch rules are
+// managed as code if it changes the rule file to an invalid one. Only admins may change the file directly,
+// everybody else has to merge a pull request. It returns false if a response has been written.
+func (ctx *preReceiveContext) assertBranchProtectionFile(oldCommitID, newCommitID, branchName string) bool {
+	repo := ctx.Repo.Repository
+	if branchName != repo.DefaultBranch || newCommitID == git.EmptySHA {
+		return true
+	}
+	managed, err := git_model.IsBranchProtectionManagedByFile(ctx, repo.ID)
+	if err != nil {
+		log.Error("Unable to get the branch protection file of %-v: %v", repo, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to get the branch protection file: %v", err),
+		})
+		return false
+	}
+	if !managed {
+		return true
+	}
+
+	repoPath := repo.RepoPath()
+	oldBlobID, err := branchprotection_service.BlobID(ctx, repoPath, oldCommitID, ctx.env)
+	if err == nil {
+		var newBlobID string
+		if newBlobID, err = branchprotection_service.BlobID(ctx, repoPath, newCommitID, ctx.env); err == nil && oldBlobID == newBlobID {
+			return true
+		}
+	}
+	if err != nil {
+		log.Error("Unable to read %s in %-v: %v", branchprotection.FilePath, repo, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to read %s: %v", branchprotection.FilePath, err),
+		})
+		return false
+	}
+
+	// deploy keys act as the owner, who is an admin of a repository of an individual user
+	isAdmin := ctx.opts.DeployKeyID == 0 && (ctx.user.IsAdmin || ctx.userPerm.IsAdmin())
+	if ctx.opts.PullRequestID == 0 && !isAdmin {
+		log.Warn("Forbidden: %s in %-v can only be changed by a pull request", branchprotection.FilePath, repo)
+		ctx.JSON(http.StatusForbidden, private.Response{
+			UserMsg: fmt.Sprintf("%s manages the branch protection and can only be changed by a pull request", branchprotection.FilePath),
+		})
+		return false
+	}
+
+	if err := branchprotection_service.Check(ctx, repo, newCommitID, ctx.env); err != nil {
+		if branchprotection_service.IsErrInvalidFile(err) {
+			log.Warn("Forbidden: invalid %s in %-v: %v", branchprotection.FilePath, repo, err)
+			ctx.JSON(http.StatusForbidden, private.Response{
+				UserMsg: fmt.Sprintf("invalid branch protection file:\n%v", err),
+			})
+			return false
+		}
+		log.Error("Unable to check %s in %-v: %v", branchprotection.FilePath, repo, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to check %s: %v", branchprotection.FilePath, err),
+		})
+		return false
+	}
+	return true
+}
+
+// applyBranchProtectionFile applies the rule file pushed to the default branch in post-receive.
+// The push has been accepted already, so a failure is kept for GetBranchProtectionFile instead of being returned.
+func applyBranchProtectionFile(ctx *context.PrivateContext, repo *repo_model.Repository, opts *private.HookOptions) {
+	if opts.IsWiki {
+		return
+	}
+	for i, refFullName := range opts.RefFullNames {
+		if !refFullName.IsBranch() || refFullName.BranchName() != repo.DefaultBranch || opts.NewCommitIDs[i] == git.EmptySHA {
+			continue
+		}
+		if err := branchprotection_service.Apply(ctx, repo, opts.NewCommitIDs[i]); err != nil {
+			log.Error("Unable to apply %s of %s at %s: %v", branchprotection.FilePath, repo.FullName(), opts.NewCommitIDs[i], err)
+		}
+	}
+}
+
+// assertNotManagedByFile rejects changing the protected branch rules of a repository managed as code,
+// it returns false if a response has been written
+func assertNotManagedByFile(ctx *context.PrivateContext, repoID int64, action string) bool {
+	managed, err := git_model.IsBranchProtectionManagedByFile(ctx, repoID)
+	if err != nil {
+		repoLifecycleError(ctx, action, err)
+		return false
+	}
+	if managed {
+		ctx.JSON(http.StatusConflict, private.Response{
+			UserMsg: fmt.Sprintf("the branch protection of the repository is managed by %s", branchprotection.FilePath),
+		})
+		return false
+	}
+	return true
+}
+
+// GetBranchProtectionFile returns whether the protected branch rules of a repository are managed as code,
+// and why the file pushed last couldn't be applied if it couldn't
+func GetBranchProtectionFile(ctx *context.PrivateContext) {
+	repo, ok := loadStatusRepo(ctx, "get branch protection file")
+	if !ok {
+		return
+	}
+	respondBranchProtectionFile(ctx, repo)
+}
+
+// SetBranchProtectionFile lets an admin enable or disable managing the protected branch rules of a repository
+// as code. Enabling applies the file on the default branch, and can be repeated to apply it again.
+func SetBranchProtectionFile(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*BranchProtectionFileOption)
+	repo, doer, ok := loadBranchRepo(ctx, "set branch protection file")
+	if !ok {
+		return
+	}
+
+	var err error
+	if form.Enabled {
+		err = branchprotection_service.Enable(ctx, doer, repo)
+	} else {
+		err = branchprotection_service.Disable(ctx, doer, repo)
+	}
+	switch {
+	case err == nil:
+	case err == branchprotection_service.ErrNotAdmin:
+		ctx.JSON(http.StatusForbidden, private.Response{
+			UserMsg: err.Error(),
+		})
+		return
+	case branchprotection_service.IsErrInvalidFile(err):
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			UserMsg: fmt.Sprintf("invalid branch protection file:\n%v", err),
+		})
+		return
+	default:
+		repoLifecycleError(ctx, "set branch protection file", err)
+		return
+	}
+	respondBranchProtectionFile(ctx, repo)
+}
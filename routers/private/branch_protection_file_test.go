@@ -0,0 +1,209 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	perm_model "code.gitea.io/gitea/models/perm"
+	access_model "code.gitea.io/gitea/models/perm/access"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/web"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commitRuleFile returns a new commit of repoPath whose tree only has the rule file with content,
+// or nothing if content is empty
+func commitRuleFile(t *testing.T, repoPath, content string) string {
+	gitStdin := func(stdin string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", repoPath}, args...)...)
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.Output()
+		require.NoError(t, err, "git %v", args)
+		return strings.TrimSpace(string(out))
+	}
+	var rootEntries string
+	if content != "" {
+		blob := gitStdin(content, "hash-object", "-w", "--stdin")
+		dir := gitStdin("100644 blob "+blob+"\tbranch-protection.yaml\n", "mktree")
+		rootEntries = "040000 tree " + dir + "\t.gitea\n"
+	}
+	return gitStdin("", "commit-tree", gitStdin(rootEntries, "mktree"), "-m", "branch protection")
+}
+
+func TestAssertBranchProtectionFile(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	t.Cleanup(func() { removeBranchProtectionFile(t, 1) })
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	user := unittest.AssertExistsAndLoadBean(t, &user_model.User{ID: 4})
+	repoPath := repo.RepoPath()
+
+	oldCommitID := commitRuleFile(t, repoPath, "rules:\n  - rule_name: master\n")
+	// the same file in another commit
+	sameCommitID := commitRuleFile(t, repoPath, "rules:\n  - rule_name: master\n")
+	changedCommitID := commitRuleFile(t, repoPath, "rules:\n  - rule_name: \"*\"\n")
+	invalidCommitID := commitRuleFile(t, repoPath, "rules:\n  - rule_name: release/*\n")
+	removedCommitID := commitRuleFile(t, repoPath, "")
+
+	type pusher struct {
+		mode          perm_model.AccessMode
+		pullRequestID int64
+		deployKeyID   int64
+	}
+	writer := pusher{mode: perm_model.AccessModeWrite}
+	admin := pusher{mode: perm_model.AccessModeAdmin}
+	check := func(t *testing.T, p pusher, newCommitID, branchName string) (bool, int, *private.Response) {
+		privateCtx, resp := mockPrivateContext(t, "POST /api/internal/hook/pre-receive/user2/repo1")
+		privateCtx.Repo = &context.Repository{Repository: repo}
+		ctx := &preReceiveContext{
+			PrivateContext: privateCtx,
+			user:           user,
+			userPerm:       access_model.Permission{AccessMode: p.mode},
+			opts:           &private.HookOptions{PullRequestID: p.pullRequestID, DeployKeyID: p.deployKeyID},
+		}
+		if ctx.assertBranchProtectionFile(oldCommitID, newCommitID, branchName) {
+			return true, resp.Code, nil
+		}
+		res := &private.Response{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), res))
+		return false, resp.Code, res
+	}
+
+	t.Run("NotManaged", func(t *testing.T) {
+		ok, _, _ := check(t, writer, invalidCommitID, "master")
+		assert.True(t, ok)
+	})
+
+	require.NoError(t, git_model.SaveBranchProtectionFile(db.DefaultContext, repo.ID, 2, oldCommitID, ""))
+
+	t.Run("Unchanged", func(t *testing.T) {
+		ok, _, _ := check(t, writer, sameCommitID, "master")
+		assert.True(t, ok)
+		// only the file on the default branch manages the rules
+		ok, _, _ = check(t, writer, invalidCommitID, "branch2")
+		assert.True(t, ok)
+		ok, _, _ = check(t, writer, git.EmptySHA, "master")
+		assert.True(t, ok)
+	})
+
+	t.Run("PullRequest", func(t *testing.T) {
+		ok, code, res := check(t, writer, changedCommitID, "master")
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Equal(t, ".gitea/branch-protection.yaml manages the branch protection and can only be changed by a pull request", res.UserMsg)
+
+		ok, _, _ = check(t, pusher{mode: perm_model.AccessModeWrite, pullRequestID: 1}, changedCommitID, "master")
+		assert.True(t, ok)
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		ok, _, _ := check(t, admin, changedCommitID, "master")
+		assert.True(t, ok)
+
+		// a deploy key acts as the owner, but isn't an admin
+		ok, code, _ := check(t, pusher{mode: perm_model.AccessModeAdmin, deployKeyID: 1}, changedCommitID, "master")
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusForbidden, code)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		ok, code, res := check(t, admin, invalidCommitID, "master")
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Contains(t, res.UserMsg, "a rule must match the default branch master")
+
+		ok, code, res = check(t, pusher{mode: perm_model.AccessModeWrite, pullRequestID: 1}, removedCommitID, "master")
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Contains(t, res.UserMsg, "the file is required while the branch protection is managed as code")
+	})
+}
+
+func TestGetBranchProtectionFile(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	t.Cleanup(func() { removeBranchProtectionFile(t, 1) })
+
+	get := func(t *testing.T) *BranchProtectionFileInfo {
+		ctx, resp := mockPrivateContext(t, "GET /api/internal/repos/user2/repo1/branch_protection_file")
+		ctx.SetParams(":owner", "user2")
+		ctx.SetParams(":repo", "repo1")
+		GetBranchProtectionFile(ctx)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		info := &BranchProtectionFileInfo{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), info))
+		return info
+	}
+
+	info := get(t)
+	assert.False(t, info.Enabled)
+	assert.Nil(t, info.Applied)
+
+	const appliedCommitID, failedCommitID = "65f1bf27bc3bf70f64657658635e66094edbcb4d", "78fb907e3a3309eae4fe8fef030874cebbf1cd5e"
+	require.NoError(t, git_model.SaveBranchProtectionFile(db.DefaultContext, 1, 2, appliedCommitID, "blob"))
+	require.NoError(t, git_model.SaveBranchProtectionFileError(db.DefaultContext, 1, failedCommitID, "update rule master: failed"))
+	info = get(t)
+	assert.True(t, info.Enabled)
+	assert.EqualValues(t, 2, info.EnabledByID)
+	assert.Equal(t, appliedCommitID, info.AppliedCommitID)
+	assert.NotNil(t, info.Applied)
+	assert.Equal(t, failedCommitID, info.LastErrorCommitID)
+	assert.Equal(t, "update rule master: failed", info.LastError)
+	assert.NotNil(t, info.LastErrorTime)
+
+	// applying a file clears the error
+	require.NoError(t, git_model.SaveBranchProtectionFile(db.DefaultContext, 1, 2, failedCommitID, "blob2"))
+	info = get(t)
+	assert.Equal(t, failedCommitID, info.AppliedCommitID)
+	assert.Empty(t, info.LastError)
+	assert.Nil(t, info.LastErrorTime)
+}
+
+func TestRenameBranchManagedByFile(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	t.Cleanup(func() { removeBranchProtectionFile(t, 1) })
+	require.NoError(t, git_model.SaveBranchProtectionFile(db.DefaultContext, 1, 2, "", ""))
+	require.NoError(t, db.Insert(db.DefaultContext, &git_model.ProtectedBranch{RepoID: 1, RuleName: "branch2"}))
+
+	rename := func(t *testing.T, from string) (int, *private.Response) {
+		ctx, resp := mockPrivateContext(t, "POST /api/internal/repos/user2/repo1/branches/rename")
+		ctx.SetParams(":owner", "user2")
+		ctx.SetParams(":repo", "repo1")
+		web.SetForm(ctx, &RenameBranchOption{From: from, To: from + "-renamed"})
+		RenameBranch(ctx)
+		res := &private.Response{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), res))
+		return resp.Code, res
+	}
+
+	// the rule named after the branch and the default branch would be renamed along
+	for _, from := range []string{"branch2", "master"} {
+		code, res := rename(t, from)
+		assert.Equal(t, http.StatusConflict, code, from)
+		assert.Equal(t, "the branch protection of the repository is managed by .gitea/branch-protection.yaml", res.UserMsg)
+	}
+	unittest.AssertExistsAndLoadBean(t, &git_model.ProtectedBranch{RepoID: 1, RuleName: "branch2"})
+
+	// the other branches can be renamed
+	code, _ := rename(t, "no-such-branch")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+// removeBranchProtectionFile removes the state of the repository, whose table has no fixtures to be reset to
+func removeBranchProtectionFile(t *testing.T, repoID int64) {
+	require.NoError(t, git_model.DeleteBranchProtectionFile(db.DefaultContext, repoID))
+}
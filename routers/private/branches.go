@@ -0,0 +1,271 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.gitea.io/gitea/models/db"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/util"
+	"code.gitea.io/gitea/modules/web"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	repo_service "github.com/openmerlin/gitea_data/services/repository"
+)
+
+// RenameBranchOption are the options of RenameBranch
+type RenameBranchOption struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// BranchInfo is a row of the branch table in the responses of the branch APIs
+type BranchInfo struct {
+	ID            int64      `json:"id"`
+	Name          string     `json:"name"`
+	CommitID      string     `json:"commit_id"`
+	CommitMessage string     `json:"commit_message"`
+	CommitTime    time.Time  `json:"commit_time"`
+	Pusher        string     `json:"pusher"`
+	IsDeleted     bool       `json:"is_deleted"`
+	DeletedBy     string     `json:"deleted_by,omitempty"`
+	Deleted       *time.Time `json:"deleted,omitempty"`
+	Updated       time.Time  `json:"updated"`
+}
+
+func toBranchInfo(branch *git_model.Branch) *BranchInfo {
+	info := &BranchInfo{
+		ID:            branch.ID,
+		Name:          branch.Name,
+		CommitID:      branch.CommitID,
+		CommitMessage: branch.CommitMessage,
+		CommitTime:    branch.CommitTime.AsTime(),
+		IsDeleted:     branch.IsDeleted,
+		Updated:       branch.UpdatedUnix.AsTime(),
+	}
+	if branch.Pusher != nil {
+		info.Pusher = branch.Pusher.Name
+	}
+	if branch.IsDeleted {
+		deleted := branch.DeletedUnix.AsTime()
+		info.Deleted = &deleted
+		if branch.DeletedBy != nil {
+			info.DeletedBy = branch.DeletedBy.Name
+		}
+	}
+	return info
+}
+
+// branchError writes the response of a failed branch operation
+func branchError(ctx *context.PrivateContext, action string, err error) {
+	status := 0
+	switch {
+	case git_model.IsErrBranchNotExist(err):
+		status = http.StatusNotFound
+	case git_model.IsErrBranchAlreadyExists(err), repo_service.IsErrBranchCommitNotExist(err):
+		status = http.StatusConflict
+	case git_model.IsErrBranchNameConflict(err), repo_service.IsErrInvalidBranchName(err):
+		status = http.StatusUnprocessableEntity
+	case repo_service.IsErrBranchProtected(err):
+		status = http.StatusForbidden
+	}
+	if status == 0 {
+		repoLifecycleError(ctx, action, err)
+		return
+	}
+	ctx.JSON(status, private.Response{
+		Err:     fmt.Sprintf("Unable to %s: %v", action, err),
+		UserMsg: err.Error(),
+	})
+}
+
+// keepDeletedBranches keeps the tips of the branches deleted by the push of post-receive alive,
+// so that they can be restored. Failures are only logged as the push has been accepted already.
+func keepDeletedBranches(ctx *context.PrivateContext, repo *repo_model.Repository, opts *private.HookOptions) {
+	// the branch table only has the branches of the repository itself
+	if opts.IsWiki {
+		return
+	}
+	for i, refFullName := range opts.RefFullNames {
+		if !refFullName.IsBranch() || opts.NewCommitIDs[i] != git.EmptySHA || opts.OldCommitIDs[i] == git.EmptySHA {
+			continue
+		}
+		if err := repo_service.KeepDeletedBranch(ctx, repo, refFullName.BranchName(), opts.OldCommitIDs[i]); err != nil {
+			log.Error("Unable to keep the deleted branch %s of %s alive: %v", refFullName.BranchName(), repo.FullName(), err)
+		}
+	}
+}
+
+// loadBranchRepo loads the repository and the doer of a branch operation, ok is false if a response has been written
+func loadBranchRepo(ctx *context.PrivateContext, action string) (*repo_model.Repository, *user_model.User, bool) {
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return nil, nil, false
+	} else if repo == nil {
+		repoLifecycleError(ctx, action, repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return nil, nil, false
+	}
+	doer := loadDoer(ctx, owner)
+	if doer == nil {
+		return nil, nil, false
+	}
+	return repo, doer, true
+}
+
+// ListBranches returns the branches of the branch table, the deleted parameter is false (default), true or all
+func ListBranches(ctx *context.PrivateContext) {
+	repo, ok := loadStatusRepo(ctx, "list branches")
+	if !ok {
+		return
+	}
+
+	opts := git_model.FindBranchOptions{
+		ListOptions: db.ListOptions{
+			Page:     ctx.FormInt("page"),
+			PageSize: ctx.FormInt("limit"),
+		},
+		RepoID:  repo.ID,
+		Keyword: ctx.FormTrim("keyword"),
+	}
+	switch deleted := ctx.FormString("deleted"); deleted {
+	case "", "false":
+		opts.IsDeletedBranch = util.OptionalBoolFalse
+	case "true":
+		opts.IsDeletedBranch = util.OptionalBoolTrue
+	case "all":
+		opts.IsDeletedBranch = util.OptionalBoolNone
+	default:
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			UserMsg: fmt.Sprintf("invalid deleted %q, it must be true, false or all", deleted),
+		})
+		return
+	}
+	opts.SetDefaultValues()
+
+	total, err := git_model.CountBranches(ctx, opts)
+	if err != nil {
+		repoLifecycleError(ctx, "count branches", err)
+		return
+	}
+	branches, err := git_model.FindBranches(ctx, opts)
+	if err != nil {
+		repoLifecycleError(ctx, "list branches", err)
+		return
+	}
+	if err := branches.LoadPusher(ctx); err != nil {
+		repoLifecycleError(ctx, "load pushers", err)
+		return
+	}
+	if err := branches.LoadDeletedBy(ctx); err != nil {
+		repoLifecycleError(ctx, "load deleters", err)
+		return
+	}
+
+	infos := make([]*BranchInfo, 0, len(branches))
+	for _, branch := range branches {
+		infos = append(infos, toBranchInfo(branch))
+	}
+	ctx.SetTotalCountHeader(total)
+	ctx.JSON(http.StatusOK, infos)
+}
+
+// GetDeletedBranch returns a deleted branch of the branch table
+func GetDeletedBranch(ctx *context.PrivateContext) {
+	repo, ok := loadStatusRepo(ctx, "get deleted branch")
+	if !ok {
+		return
+	}
+	branch, err := git_model.GetDeletedBranchByID(ctx, repo.ID, ctx.ParamsInt64(":id"))
+	if err != nil {
+		branchError(ctx, "get deleted branch", err)
+		return
+	}
+	if err := branch.LoadPusher(ctx); err != nil {
+		repoLifecycleError(ctx, "load pusher", err)
+		return
+	}
+	if err := branch.LoadDeletedBy(ctx); err != nil {
+		repoLifecycleError(ctx, "load deleter", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toBranchInfo(branch))
+}
+
+// RestoreBranch recreates a deleted branch as the doer, at its last commit or at the tip kept alive when it was deleted
+func RestoreBranch(ctx *context.PrivateContext) {
+	repo, doer, ok := loadBranchRepo(ctx, "restore branch")
+	if !ok {
+		return
+	}
+	branch, err := repo_service.RestoreBranch(ctx, doer, repo, ctx.ParamsInt64(":id"))
+	if err != nil {
+		branchError(ctx, "restore branch", err)
+		return
+	}
+	if err := branch.LoadPusher(ctx); err != nil {
+		repoLifecycleError(ctx, "load pusher", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toBranchInfo(branch))
+}
+
+// RenameBranch renames a branch as the doer, the old name redirects to the new one
+func RenameBranch(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*RenameBranchOption)
+	if form.From == "" || form.To == "" {
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			UserMsg: "from and to are required",
+		})
+		return
+	}
+	repo, doer, ok := loadBranchRepo(ctx, "rename branch")
+	if !ok {
+		return
+	}
+	// renaming the default branch or a branch with a rule of its own changes the rules along with the branch
+	rule, err := git_model.GetProtectedBranchRuleByName(ctx, repo.ID, form.From)
+	if err != nil {
+		repoLifecycleError(ctx, "rename branch", err)
+		return
+	}
+	if (rule != nil || form.From == repo.DefaultBranch) && !assertNotManagedByFile(ctx, repo.ID, "rename branch") {
+		return
+	}
+	if err := repo_service.RenameBranch(ctx, doer, repo, form.From, form.To); err != nil {
+		branchError(ctx, "rename branch", err)
+		return
+	}
+	branch, err := git_model.GetBranch(ctx, repo.ID, form.To)
+	if err != nil {
+		branchError(ctx, "get branch", err)
+		return
+	}
+	if err := branch.LoadPusher(ctx); err != nil {
+		repoLifecycleError(ctx, "load pusher", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toBranchInfo(branch))
+}
+
+// SyncBranches reconciles the branch table of a repository with its branches on disk
+func SyncBranches(ctx *context.PrivateContext) {
+	repo, doer, ok := loadBranchRepo(ctx, "sync branches")
+	if !ok {
+		return
+	}
+	result, err := repo_service.SyncBranches(ctx, repo, doer.ID)
+	if err != nil {
+		repoLifecycleError(ctx, "sync branches", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,339 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	api "code.gitea.io/gitea/modules/structs"
+	"code.gitea.io/gitea/modules/web"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/services/pushevent"
+)
+
+// maxStatusPairs limits the repository/commit pairs of a batch query
+const maxStatusPairs = 100
+
+// CreateCommitStatusOption reports a status for a commit
+type CreateCommitStatusOption struct {
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	TargetURL   string `json:"target_url"`
+	Description string `json:"description"`
+}
+
+// CommitStatusPair names a commit of a repository
+type CommitStatusPair struct {
+	RepoID int64  `json:"repo_id"`
+	SHA    string `json:"sha"`
+}
+
+// CommitStatusPairsOption queries the combined statuses of several commits
+type CommitStatusPairsOption struct {
+	Pairs []CommitStatusPair `json:"pairs"`
+}
+
+// CommitStatusInfo is a status of a commit in the responses of the commit status APIs
+type CommitStatusInfo struct {
+	ID          int64     `json:"id"`
+	Index       int64     `json:"index"`
+	SHA         string    `json:"sha"`
+	State       string    `json:"state"`
+	Context     string    `json:"context"`
+	TargetURL   string    `json:"target_url"`
+	Description string    `json:"description"`
+	CreatorID   int64     `json:"creator_id"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// CombinedStatusInfo is the state of a commit over the latest status of every context
+type CombinedStatusInfo struct {
+	RepoID   int64               `json:"repo_id"`
+	SHA      string              `json:"sha"`
+	State    string              `json:"state"`
+	Statuses []*CommitStatusInfo `json:"statuses"`
+}
+
+func toCommitStatusInfo(status *git_model.CommitStatus) *CommitStatusInfo {
+	return &CommitStatusInfo{
+		ID:          status.ID,
+		Index:       status.Index,
+		SHA:         status.SHA,
+		State:       string(status.State),
+		Context:     status.Context,
+		TargetURL:   status.TargetURL,
+		Description: status.Description,
+		CreatorID:   status.CreatorID,
+		Created:     status.CreatedUnix.AsTime(),
+		Updated:     status.UpdatedUnix.AsTime(),
+	}
+}
+
+func toCommitStatusInfos(statuses []*git_model.CommitStatus) []*CommitStatusInfo {
+	infos := make([]*CommitStatusInfo, 0, len(statuses))
+	for _, status := range statuses {
+		infos = append(infos, toCommitStatusInfo(status))
+	}
+	return infos
+}
+
+// combinedState returns the worst state of the latest statuses, pending if the commit has none
+func combinedState(statuses []*git_model.CommitStatus) api.CommitStatusState {
+	if len(statuses) == 0 {
+		return api.CommitStatusPending
+	}
+	return git_model.CalcCommitStatus(statuses).State
+}
+
+func toCombinedStatusInfo(repoID int64, sha string, statuses []*git_model.CommitStatus) *CombinedStatusInfo {
+	return &CombinedStatusInfo{
+		RepoID:   repoID,
+		SHA:      sha,
+		State:    string(combinedState(statuses)),
+		Statuses: toCommitStatusInfos(statuses),
+	}
+}
+
+func isValidCommitStatusState(state api.CommitStatusState) bool {
+	switch state {
+	case api.CommitStatusPending, api.CommitStatusSuccess, api.CommitStatusError, api.CommitStatusFailure, api.CommitStatusWarning:
+		return true
+	}
+	return false
+}
+
+// loadStatusRepo loads the repository of the request, ok is false if a response has been written
+func loadStatusRepo(ctx *context.PrivateContext, action string) (*repo_model.Repository, bool) {
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return nil, false
+	} else if repo == nil {
+		repoLifecycleError(ctx, action, repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return nil, false
+	}
+	repo.Owner = owner
+	return repo, true
+}
+
+// resolveCommit returns the full ID of the commit a SHA, branch or tag names, the default branch if ref is empty.
+// ok is false if a response has been written.
+func resolveCommit(ctx *context.PrivateContext, repo *repo_model.Repository, ref string) (string, bool) {
+	if ref == "" {
+		ref = repo.DefaultBranch
+	}
+	gitRepo, err := git.OpenRepository(ctx, repo.RepoPath())
+	if err != nil {
+		log.Error("Unable to open repository %-v: %v", repo, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to open repository %s: %v", repo.FullName(), err),
+		})
+		return "", false
+	}
+	defer gitRepo.Close()
+
+	sha, err := gitRepo.ConvertToSHA1(ref)
+	if err == nil {
+		// a SHA may name an object which isn't a commit
+		_, err = gitRepo.GetCommit(sha.String())
+	}
+	if err != nil {
+		if git.IsErrNotExist(err) {
+			ctx.JSON(http.StatusNotFound, private.Response{
+				UserMsg: fmt.Sprintf("commit %s does not exist", ref),
+			})
+			return "", false
+		}
+		log.Error("Unable to resolve %s in %-v: %v", ref, repo, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to resolve %s: %v", ref, err),
+		})
+		return "", false
+	}
+	return sha.String(), true
+}
+
+// CreateCommitStatus reports a status for a commit as the doer and publishes it as an event
+func CreateCommitStatus(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*CreateCommitStatusOption)
+	state := api.CommitStatusState(form.State)
+	if !isValidCommitStatusState(state) {
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			UserMsg: fmt.Sprintf("invalid state %q", form.State),
+		})
+		return
+	}
+
+	repo, ok := loadStatusRepo(ctx, "create commit status")
+	if !ok {
+		return
+	}
+	doer := loadDoer(ctx, repo.Owner)
+	if doer == nil {
+		return
+	}
+	if !git.IsValidSHAPattern(ctx.Params(":sha")) {
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			UserMsg: fmt.Sprintf("invalid commit ID %q", ctx.Params(":sha")),
+		})
+		return
+	}
+	sha, ok := resolveCommit(ctx, repo, ctx.Params(":sha"))
+	if !ok {
+		return
+	}
+
+	status := &git_model.CommitStatus{
+		State:       state,
+		Context:     form.Context,
+		TargetURL:   form.TargetURL,
+		Description: form.Description,
+	}
+	if err := git_model.NewCommitStatus(ctx, git_model.NewCommitStatusOptions{
+		Repo:         repo,
+		Creator:      doer,
+		SHA:          sha,
+		CommitStatus: status,
+	}); err != nil {
+		repoLifecycleError(ctx, "create commit status", err)
+		return
+	}
+
+	latest, _, err := git_model.GetLatestCommitStatus(ctx, repo.ID, sha, db.ListOptions{ListAll: true})
+	if err != nil {
+		repoLifecycleError(ctx, "get commit statuses", err)
+		return
+	}
+	pushevent.NotifyCommitStatus(pushevent.Repository{
+		ID:        repo.ID,
+		OwnerName: repo.OwnerName,
+		Name:      repo.Name,
+	}, doer.ID, doer.Name, &pushevent.CommitStatus{
+		SHA:           sha,
+		Context:       status.Context,
+		State:         string(status.State),
+		TargetURL:     status.TargetURL,
+		Description:   status.Description,
+		CombinedState: string(combinedState(latest)),
+	})
+
+	ctx.JSON(http.StatusCreated, toCommitStatusInfo(status))
+}
+
+// ListCommitStatuses returns all statuses of a commit, newest first unless sort is given
+func ListCommitStatuses(ctx *context.PrivateContext) {
+	repo, ok := loadStatusRepo(ctx, "list commit statuses")
+	if !ok {
+		return
+	}
+	sha, ok := resolveCommit(ctx, repo, ctx.Params(":sha"))
+	if !ok {
+		return
+	}
+
+	statuses, total, err := git_model.GetCommitStatuses(ctx, repo, sha, &git_model.CommitStatusOptions{
+		ListOptions: db.ListOptions{
+			Page:     ctx.FormInt("page"),
+			PageSize: ctx.FormInt("limit"),
+		},
+		State:    ctx.FormTrim("state"),
+		SortType: ctx.FormTrim("sort"),
+	})
+	if err != nil {
+		repoLifecycleError(ctx, "list commit statuses", err)
+		return
+	}
+	ctx.SetTotalCountHeader(total)
+	ctx.JSON(http.StatusOK, toCommitStatusInfos(statuses))
+}
+
+// ListRefCommitStatuses returns the latest status of every context of the commit the ref parameter names
+func ListRefCommitStatuses(ctx *context.PrivateContext) {
+	repo, ok := loadStatusRepo(ctx, "list commit statuses")
+	if !ok {
+		return
+	}
+	sha, ok := resolveCommit(ctx, repo, ctx.FormString("ref"))
+	if !ok {
+		return
+	}
+
+	statuses, _, err := git_model.GetLatestCommitStatus(ctx, repo.ID, sha, db.ListOptions{ListAll: true})
+	if err != nil {
+		repoLifecycleError(ctx, "list commit statuses", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toCommitStatusInfos(statuses))
+}
+
+// GetCombinedCommitStatus returns the combined state of the commit the ref parameter names
+func GetCombinedCommitStatus(ctx *context.PrivateContext) {
+	repo, ok := loadStatusRepo(ctx, "get combined commit status")
+	if !ok {
+		return
+	}
+	sha, ok := resolveCommit(ctx, repo, ctx.FormString("ref"))
+	if !ok {
+		return
+	}
+
+	statuses, _, err := git_model.GetLatestCommitStatus(ctx, repo.ID, sha, db.ListOptions{ListAll: true})
+	if err != nil {
+		repoLifecycleError(ctx, "get combined commit status", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toCombinedStatusInfo(repo.ID, sha, statuses))
+}
+
+// GetCombinedCommitStatuses returns the combined states of a batch of commits, in the order of the pairs
+func GetCombinedCommitStatuses(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*CommitStatusPairsOption)
+	if len(form.Pairs) > maxStatusPairs {
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			UserMsg: fmt.Sprintf("at most %d commits can be queried at once", maxStatusPairs),
+		})
+		return
+	}
+
+	// the statuses are queried per repository
+	var repoIDs []int64
+	commitIDs := make(map[int64][]string)
+	for _, pair := range form.Pairs {
+		if len(pair.SHA) != git.SHAFullLength || !git.IsValidSHAPattern(pair.SHA) {
+			ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+				UserMsg: fmt.Sprintf("invalid full commit ID %q", pair.SHA),
+			})
+			return
+		}
+		if _, ok := commitIDs[pair.RepoID]; !ok {
+			repoIDs = append(repoIDs, pair.RepoID)
+		}
+		commitIDs[pair.RepoID] = append(commitIDs[pair.RepoID], pair.SHA)
+	}
+
+	statuses := make(map[int64]map[string][]*git_model.CommitStatus, len(repoIDs))
+	for _, repoID := range repoIDs {
+		repoStatuses, err := git_model.GetLatestCommitStatusForRepoCommitIDs(ctx, repoID, commitIDs[repoID])
+		if err != nil {
+			repoLifecycleError(ctx, "get combined commit statuses", err)
+			return
+		}
+		statuses[repoID] = repoStatuses
+	}
+
+	infos := make([]*CombinedStatusInfo, 0, len(form.Pairs))
+	for _, pair := range form.Pairs {
+		infos = append(infos, toCombinedStatusInfo(pair.RepoID, pair.SHA, statuses[pair.RepoID][pair.SHA]))
+	}
+	ctx.JSON(http.StatusOK, infos)
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"net/http"
+	"testing"
+
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/private"
+	api "code.gitea.io/gitea/modules/structs"
+	"code.gitea.io/gitea/modules/web"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the heads of master and home-md-img-check of user2/repo1, and of master of user2/repo2
+const (
+	repo1Master   = "65f1bf27bc3bf70f64657658635e66094edbcb4d"
+	repo1ImgCheck = "78fb907e3a3309eae4fe8fef030874cebbf1cd5e"
+	repo2Master   = "1032bbf17fbc0d9c95bb5418dabe8f8c99278700"
+)
+
+func createCommitStatus(t *testing.T, owner, repo, sha, doer string, form *CreateCommitStatusOption) (int, []byte) {
+	ctx, resp := mockPrivateContext(t, "POST /api/internal/repos/"+owner+"/"+repo+"/statuses/"+sha)
+	ctx.SetParams(":owner", owner)
+	ctx.SetParams(":repo", repo)
+	ctx.SetParams(":sha", sha)
+	if doer != "" {
+		ctx.Req.Form.Set("doer", doer)
+	}
+	web.SetForm(ctx, form)
+	CreateCommitStatus(ctx)
+	return resp.Code, resp.Body.Bytes()
+}
+
+func TestCombinedState(t *testing.T) {
+	assert.Equal(t, api.CommitStatusPending, combinedState(nil))
+	assert.Equal(t, api.CommitStatusSuccess, combinedState([]*git_model.CommitStatus{
+		{State: api.CommitStatusSuccess},
+	}))
+	assert.Equal(t, api.CommitStatusFailure, combinedState([]*git_model.CommitStatus{
+		{State: api.CommitStatusSuccess},
+		{State: api.CommitStatusFailure},
+		{State: api.CommitStatusPending},
+	}))
+}
+
+func TestCreateCommitStatus(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+
+	code, body := createCommitStatus(t, "user2", "repo1", repo1Master, "", &CreateCommitStatusOption{
+		State:     "success",
+		Context:   " ci/build ",
+		TargetURL: "https://ci.example.com/1",
+	})
+	require.Equal(t, http.StatusCreated, code, string(body))
+	info := &CommitStatusInfo{}
+	require.NoError(t, json.Unmarshal(body, info))
+	assert.Equal(t, repo1Master, info.SHA)
+	assert.Equal(t, "ci/build", info.Context)
+	// the owner is the creator without a doer
+	assert.EqualValues(t, 2, info.CreatorID)
+	unittest.AssertExistsAndLoadBean(t, &git_model.CommitStatus{ID: info.ID, RepoID: 1, SHA: repo1Master, Context: "ci/build"})
+
+	// statuses are reported for commit IDs, not for refs
+	code, _ = createCommitStatus(t, "user2", "repo1", "master", "", &CreateCommitStatusOption{State: "success"})
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+
+	for name, c := range map[string]struct {
+		owner, repo, sha, doer, state string
+		code                          int
+	}{
+		"InvalidState":     {"user2", "repo1", repo1Master, "", "done", http.StatusUnprocessableEntity},
+		"UnknownCommit":    {"user2", "repo1", "0123456789012345678901234567890123456789", "", "success", http.StatusNotFound},
+		"UnknownRepo":      {"user2", "repo0", repo1Master, "", "success", http.StatusNotFound},
+		"OrgWithoutDoer":   {"org3", "repo3", repo1Master, "", "success", http.StatusBadRequest},
+		"OrgUnknownCommit": {"org3", "repo3", repo1Master, "user2", "success", http.StatusNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			code, body := createCommitStatus(t, c.owner, c.repo, c.sha, c.doer, &CreateCommitStatusOption{State: c.state})
+			assert.Equal(t, c.code, code, string(body))
+		})
+	}
+}
+
+func TestGetCombinedCommitStatuses(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+
+	for _, status := range []struct{ sha, context, state string }{
+		{repo1Master, "ci/build", "success"},
+		{repo1Master, "ci/test", "success"},
+		{repo1ImgCheck, "ci/build", "success"},
+		{repo1ImgCheck, "ci/test", "failure"},
+	} {
+		code, body := createCommitStatus(t, "user2", "repo1", status.sha, "", &CreateCommitStatusOption{State: status.state, Context: status.context})
+		require.Equal(t, http.StatusCreated, code, string(body))
+	}
+	// a later status of a context replaces the earlier one
+	code, body := createCommitStatus(t, "user2", "repo1", repo1ImgCheck, "", &CreateCommitStatusOption{State: "success", Context: "ci/build"})
+	require.Equal(t, http.StatusCreated, code, string(body))
+
+	query := func(t *testing.T, pairs ...CommitStatusPair) (int, []*CombinedStatusInfo, *private.Response) {
+		ctx, resp := mockPrivateContext(t, "POST /api/internal/commit_statuses/combined")
+		web.SetForm(ctx, &CommitStatusPairsOption{Pairs: pairs})
+		GetCombinedCommitStatuses(ctx)
+		if resp.Code != http.StatusOK {
+			res := &private.Response{}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), res))
+			return resp.Code, nil, res
+		}
+		var infos []*CombinedStatusInfo
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &infos))
+		return resp.Code, infos, nil
+	}
+
+	// several commits of a repository are answered in the order of the pairs
+	code, infos, _ := query(t,
+		CommitStatusPair{RepoID: 1, SHA: repo1ImgCheck},
+		CommitStatusPair{RepoID: 2, SHA: repo2Master},
+		CommitStatusPair{RepoID: 1, SHA: repo1Master},
+		CommitStatusPair{RepoID: 1, SHA: repo1ImgCheck},
+	)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, infos, 4)
+	assert.Equal(t, repo1ImgCheck, infos[0].SHA)
+	assert.Equal(t, "failure", infos[0].State)
+	assert.Len(t, infos[0].Statuses, 2)
+	assert.EqualValues(t, 2, infos[1].RepoID)
+	assert.Equal(t, "pending", infos[1].State)
+	assert.Empty(t, infos[1].Statuses)
+	assert.Equal(t, repo1Master, infos[2].SHA)
+	assert.Equal(t, "success", infos[2].State)
+	assert.Equal(t, infos[0], infos[3])
+
+	code, infos, _ = query(t)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, infos)
+
+	code, _, res := query(t, CommitStatusPair{RepoID: 1, SHA: repo1Master[:10]})
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Contains(t, res.UserMsg, "invalid full commit ID")
+
+	pairs := make([]CommitStatusPair, maxStatusPairs+1)
+	for i := range pairs {
+		pairs[i] = CommitStatusPair{RepoID: 1, SHA: repo1Master}
+	}
+	code, _, _ = query(t, pairs...)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+}
+
+func TestGetCombinedCommitStatus(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+
+	code, body := createCommitStatus(t, "user2", "repo1", repo1Master, "", &CreateCommitStatusOption{State: "warning", Context: "lint"})
+	require.Equal(t, http.StatusCreated, code, string(body))
+
+	ctx, resp := mockPrivateContext(t, "GET /api/internal/repos/user2/repo1/status")
+	ctx.SetParams(":owner", "user2")
+	ctx.SetParams(":repo", "repo1")
+	// the default branch without a ref
+	GetCombinedCommitStatus(ctx)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	info := &CombinedStatusInfo{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), info))
+	assert.Equal(t, repo1Master, info.SHA)
+	assert.Equal(t, "warning", info.State)
+	if assert.Len(t, info.Statuses, 1) {
+		assert.Equal(t, "lint", info.Statuses[0].Context)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/modules/validator"
+)
+
+const (
+	// maxValidatedFileSize is the largest file the content validators read
+	maxValidatedFileSize = 5 << 20
+	// maxReportedProblems limits the problems sent back to the pusher
+	maxReportedProblems = 20
+)
+
+// validateBranchContent runs the content validators enabled for the repository on the files changed by the push.
+// It returns false if a response has been written.
+func validateBranchContent(ctx *preReceiveContext, oldCommitID, newCommitID, branchName string) bool {
+	if ctx.opts.IsWiki {
+		return true
+	}
+	repo := ctx.Repo.Repository
+	names, err := repo_model.GetRepoValidators(ctx, repo.ID)
+	if err != nil {
+		log.Error("Unable to get the validators of %-v: %v", repo, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to get the validators: %v", err),
+		})
+		return false
+	}
+	validators := make([]validator.Validator, 0, len(names))
+	for _, name := range names {
+		v, ok := validator.Get(name)
+		if !ok {
+			log.Warn("Unknown validator %q is enabled for %-v", name, repo)
+			continue
+		}
+		validators = append(validators, v)
+	}
+	if len(validators) == 0 {
+		return true
+	}
+
+	var files []string
+	if oldCommitID == git.EmptySHA {
+		stdout, _, err := git.NewCommand(ctx, "ls-tree", "-r", "--name-only", "-z").AddDynamicArguments(newCommitID).
+			RunStdString(&git.RunOpts{Dir: repo.RepoPath(), Env: ctx.env})
+		if err != nil {
+			log.Error("Unable to list the files of %s in %-v: %v", newCommitID, repo, err)
+			ctx.JSON(http.StatusInternalServerError, private.Response{
+				Err: fmt.Sprintf("Unable to list the files of %s: %v", newCommitID, err),
+			})
+			return false
+		}
+		files = strings.Split(strings.TrimSuffix(stdout, "\x00"), "\x00")
+	} else {
+		files, err = git.GetAffectedFiles(ctx.Repo.GitRepo, oldCommitID, newCommitID, ctx.env)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, private.Response{
+				Err: fmt.Sprintf("Unable to get the files changed from %s to %s: %v", oldCommitID, newCommitID, err),
+			})
+			return false
+		}
+	}
+
+	matched := make(map[string][]validator.Validator)
+	var paths []string
+	for _, file := range files {
+		for _, v := range validators {
+			if v.Match(file) {
+				if _, ok := matched[file]; !ok {
+					paths = append(paths, file)
+				}
+				matched[file] = append(matched[file], v)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return true
+	}
+
+	problems, err := runValidators(ctx, newCommitID, paths, matched)
+	if err != nil {
+		log.Error("Unable to validate the content of %s in %-v: %v", newCommitID, repo, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to validate the content of %s: %v", newCommitID, err),
+		})
+		return false
+	}
+	if len(problems) == 0 {
+		return true
+	}
+
+	log.Warn("Forbidden: Branch: %s in %-v has %d content validation problems", branchName, repo, len(problems))
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "branch %s failed content validation:", branchName)
+	for i, problem := range problems {
+		if i == maxReportedProblems {
+			fmt.Fprintf(&msg, "\n  ... and %d more", len(problems)-i)
+			break
+		}
+		msg.WriteString("\n  " + problem)
+	}
+	ctx.JSON(http.StatusForbidden, private.Response{
+		UserMsg: msg.String(),
+	})
+	return false
+}
+
+// runValidators reads the paths at commitID and validates them, paths deleted by the push are skipped
+func runValidators(ctx *preReceiveContext, commitID string, paths []string, matched map[string][]validator.Validator) ([]string, error) {
+	repoPath := ctx.Repo.Repository.RepoPath()
+
+	var objects bytes.Buffer
+	for _, path := range paths {
+		objects.WriteString(commitID + ":" + path + "\n")
+	}
+	var checks bytes.Buffer
+	if err := git.NewCommand(ctx, "cat-file", "--batch-check=%(objecttype) %(objectsize)").
+		Run(&git.RunOpts{Dir: repoPath, Env: ctx.env, Stdin: &objects, Stdout: &checks}); err != nil {
+		return nil, fmt.Errorf("cat-file --batch-check: %w", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(checks.String(), "\n"), "\n")
+	if len(lines) != len(paths) {
+		return nil, fmt.Errorf("unexpected cat-file --batch-check output for %d files: %d lines", len(paths), len(lines))
+	}
+
+	var problems []string
+	for i, path := range paths {
+		fields := strings.Fields(lines[i])
+		if len(fields) != 2 || fields[0] != "blob" {
+			// deleted by the push
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		if size > maxValidatedFileSize {
+			problems = append(problems, fmt.Sprintf("%s: the file is too large to be validated", path))
+			continue
+		}
+		content, _, err := git.NewCommand(ctx, "cat-file", "blob").AddDynamicArguments(commitID + ":" + path).
+			RunStdBytes(&git.RunOpts{Dir: repoPath, Env: ctx.env})
+		if err != nil {
+			return nil, fmt.Errorf("cat-file blob %s: %w", path, err)
+		}
+		for _, v := range matched[path] {
+			for _, problem := range v.Validate(path, content) {
+				problems = append(problems, fmt.Sprintf("[%s] %s", v.Name(), problem))
+			}
+		}
+	}
+	return problems, nil
+}
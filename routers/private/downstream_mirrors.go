@@ -0,0 +1,141 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/util"
+	"code.gitea.io/gitea/modules/web"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/services/downstream"
+)
+
+// DownstreamMirrorOption adds a downstream mirror to a repository
+type DownstreamMirrorOption struct {
+	RemoteURL string `json:"remote_url"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	SyncLFS   bool   `json:"sync_lfs"`
+	// LFSURL overrides the LFS endpoint derived from RemoteURL
+	LFSURL string `json:"lfs_url"`
+}
+
+// loadDownstreamMirrorRepo loads the repository of a downstream mirrors API, it returns nil if a response has been written
+func loadDownstreamMirrorRepo(ctx *context.PrivateContext, action string) *repo_model.Repository {
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return nil
+	} else if repo == nil {
+		repoLifecycleError(ctx, action, repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return nil
+	}
+	return repo
+}
+
+func downstreamMirrorError(ctx *context.PrivateContext, action string, err error) {
+	if errors.Is(err, util.ErrNotExist) {
+		ctx.JSON(http.StatusNotFound, private.Response{
+			Err:     fmt.Sprintf("Unable to %s: %v", action, err),
+			UserMsg: err.Error(),
+		})
+		return
+	}
+	repoLifecycleError(ctx, action, err)
+}
+
+// ListDownstreamMirrors returns the downstream mirrors of a repository with their sync status
+func ListDownstreamMirrors(ctx *context.PrivateContext) {
+	repo := loadDownstreamMirrorRepo(ctx, "list downstream mirrors")
+	if repo == nil {
+		return
+	}
+	mirrors, err := repo_model.GetDownstreamMirrors(ctx, repo.ID)
+	if err != nil {
+		repoLifecycleError(ctx, "list downstream mirrors", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, mirrors)
+}
+
+// AddDownstreamMirror adds a downstream mirror to a repository and queues its first sync
+func AddDownstreamMirror(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*DownstreamMirrorOption)
+	repo := loadDownstreamMirrorRepo(ctx, "add downstream mirror")
+	if repo == nil {
+		return
+	}
+	if err := downstream.ValidateRemoteURL(form.RemoteURL); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			Err:     fmt.Sprintf("Invalid remote URL: %v", err),
+			UserMsg: fmt.Sprintf("invalid remote URL: %v", err),
+		})
+		return
+	}
+
+	m := &repo_model.DownstreamMirror{
+		RepoID:    repo.ID,
+		RemoteURL: form.RemoteURL,
+		Username:  form.Username,
+		SyncLFS:   form.SyncLFS,
+		LFSURL:    form.LFSURL,
+	}
+	if err := m.SetPassword(form.Password); err != nil {
+		repoLifecycleError(ctx, "add downstream mirror", err)
+		return
+	}
+	if err := repo_model.InsertDownstreamMirror(ctx, m); err != nil {
+		repoLifecycleError(ctx, "add downstream mirror", err)
+		return
+	}
+	if downstream.Enabled() {
+		if err := downstream.SyncAll(m); err != nil {
+			repoLifecycleError(ctx, "sync downstream mirror", err)
+			return
+		}
+	}
+	ctx.JSON(http.StatusCreated, m)
+}
+
+// DeleteDownstreamMirror removes a downstream mirror from a repository, queued syncs of it are dropped
+func DeleteDownstreamMirror(ctx *context.PrivateContext) {
+	repo := loadDownstreamMirrorRepo(ctx, "delete downstream mirror")
+	if repo == nil {
+		return
+	}
+	if err := repo_model.DeleteDownstreamMirror(ctx, repo.ID, ctx.ParamsInt64(":id")); err != nil {
+		downstreamMirrorError(ctx, "delete downstream mirror", err)
+		return
+	}
+	ctx.PlainText(http.StatusOK, "success")
+}
+
+// SyncDownstreamMirror queues a sync of all branches and tags to a downstream mirror, e.g. after it failed
+func SyncDownstreamMirror(ctx *context.PrivateContext) {
+	repo := loadDownstreamMirrorRepo(ctx, "sync downstream mirror")
+	if repo == nil {
+		return
+	}
+	m, err := repo_model.GetDownstreamMirror(ctx, repo.ID, ctx.ParamsInt64(":id"))
+	if err != nil {
+		downstreamMirrorError(ctx, "sync downstream mirror", err)
+		return
+	}
+	if !downstream.Enabled() {
+		ctx.JSON(http.StatusServiceUnavailable, private.Response{
+			UserMsg: "downstream mirroring is disabled",
+		})
+		return
+	}
+	if err := downstream.SyncAll(m); err != nil {
+		repoLifecycleError(ctx, "sync downstream mirror", err)
+		return
+	}
+	ctx.JSON(http.StatusAccepted, m)
+}
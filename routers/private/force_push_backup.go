@@ -0,0 +1,107 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/web"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/services/refbackup"
+)
+
+// RestoreBackupOption are the options of RestoreBackup
+type RestoreBackupOption struct {
+	Ref string `json:"ref"`
+	// Branch is reset to the backup, it defaults to the branch the backup was taken of
+	Branch string `json:"branch"`
+}
+
+// backupForcePushes keeps the branch tips overwritten by the force pushes of post-receive.
+// Failures are only logged as the push has been accepted already.
+func backupForcePushes(ctx *context.PrivateContext, repo *repo_model.Repository, opts *private.HookOptions) {
+	if !refbackup.Enabled() {
+		return
+	}
+	repoPath := repo.RepoPath()
+	backedUp := false
+	for i, refFullName := range opts.RefFullNames {
+		if !refFullName.IsBranch() {
+			continue
+		}
+		ref, err := refbackup.BackupForcePush(ctx, repoPath, refFullName.BranchName(), opts.OldCommitIDs[i], opts.NewCommitIDs[i])
+		if err != nil {
+			log.Error("Unable to back up the force push of %s in %s: %v", refFullName, repo.FullName(), err)
+			continue
+		}
+		if ref != "" {
+			log.Trace("Backed up the force push of %s in %s to %s", refFullName, repo.FullName(), ref)
+			backedUp = true
+		}
+	}
+	if backedUp {
+		if err := refbackup.Prune(ctx, repoPath); err != nil {
+			log.Error("Unable to prune the force push backups of %s: %v", repo.FullName(), err)
+		}
+	}
+}
+
+// ListBackups returns the force push backups of a repository newest first, optionally only those of a branch
+func ListBackups(ctx *context.PrivateContext) {
+	owner, repo, ok := loadLifecycleRepo(ctx, true)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "list backups", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	backups, err := refbackup.List(ctx, repo.RepoPath(), ctx.FormString("branch"))
+	if err != nil {
+		repoLifecycleError(ctx, "list backups", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, backups)
+}
+
+// RestoreBackup resets a branch to a force push backup, the replaced tip is backed up itself
+func RestoreBackup(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*RestoreBackupOption)
+	owner, repo, ok := loadLifecycleRepo(ctx, true)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "restore backup", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	doer := loadDoer(ctx, owner)
+	if doer == nil {
+		return
+	}
+
+	restored, err := refbackup.Restore(ctx, doer, repo, form.Ref, form.Branch)
+	if err != nil {
+		if refbackup.IsErrBackupNotExist(err) {
+			ctx.JSON(http.StatusNotFound, private.Response{
+				Err:     fmt.Sprintf("Unable to restore backup: %v", err),
+				UserMsg: err.Error(),
+			})
+			return
+		}
+		if refbackup.IsErrNotFastForward(err) {
+			ctx.JSON(http.StatusForbidden, private.Response{
+				Err:     fmt.Sprintf("Unable to restore backup: %v", err),
+				UserMsg: err.Error(),
+			})
+			return
+		}
+		branchError(ctx, "restore backup", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, restored)
+}
@@ -19,6 +19,11 @@ import (
 	"code.gitea.io/gitea/modules/util"
 	"code.gitea.io/gitea/modules/web"
 	repo_service "code.gitea.io/gitea/services/repository"
+	"github.com/openmerlin/gitea_data/modules/packcache"
+	"github.com/openmerlin/gitea_data/services/downstream"
+	"github.com/openmerlin/gitea_data/services/dumbhttp"
+	"github.com/openmerlin/gitea_data/services/pushevent"
+	"github.com/openmerlin/gitea_data/services/pushoptions"
 )
 
 // HookPostReceive updates services and users
@@ -28,16 +33,23 @@ func HookPostReceive(ctx *gitea_context.PrivateContext) {
 	// We don't rely on RepoAssignment here because:
 	// a) we don't need the git repo in this function
 	// b) our update function will likely change the repository in the db so we will need to refresh it
-	// c) we don't always need the repo
 
 	ownerName := ctx.Params(":owner")
 	repoName := ctx.Params(":repo")
 
-	// defer getting the repository at this point - as we should only retrieve it if we're going to call update
-	var repo *repo_model.Repository
+	// the audit log, the backups and most of the services below need the repository
+	repo := loadRepository(ctx, ownerName, repoName)
+	if ctx.Written() {
+		// Error handled in loadRepository
+		return
+	}
+	wasEmpty := repo.IsEmpty
+
+	auditPush(ctx, repo, opts)
+	backupForcePushes(ctx, repo, opts)
+	keepDeletedBranches(ctx, repo, opts)
 
 	updates := make([]*repo_module.PushUpdateOptions, 0, len(opts.OldCommitIDs))
-	wasEmpty := false
 
 	for i := range opts.OldCommitIDs {
 		refFullName := opts.RefFullNames[i]
@@ -47,15 +59,6 @@ func HookPostReceive(ctx *gitea_context.PrivateContext) {
 		// or other less-standard refs spaces are ignored since there
 		// may be a very large number of them).
 		if refFullName.IsBranch() || refFullName.IsTag() {
-			if repo == nil {
-				repo = loadRepository(ctx, ownerName, repoName)
-				if ctx.Written() {
-					// Error handled in loadRepository
-					return
-				}
-				wasEmpty = repo.IsEmpty
-			}
-
 			option := &repo_module.PushUpdateOptions{
 				RefFullName:  refFullName,
 				OldCommitID:  opts.OldCommitIDs[i],
@@ -74,7 +77,15 @@ func HookPostReceive(ctx *gitea_context.PrivateContext) {
 		}
 	}
 
-	if repo != nil && len(updates) > 0 {
+	// Some push options have to take effect before the updates are processed.
+	// The refs have been updated already, so a failed option mustn't stop the rest of post-receive.
+	if hasPushOptions(opts) {
+		if err := pushoptions.Prepare(ctx, newPush(repo, opts, nil), opts.GitPushOptions); err != nil {
+			log.Error("Failed to prepare the push options of %s/%s Error: %v", ownerName, repoName, err)
+		}
+	}
+
+	if len(updates) > 0 {
 		if err := repo_service.PushUpdates(updates); err != nil {
 			log.Error("Failed to Update: %s/%s Total Updates: %d", ownerName, repoName, len(updates))
 			for i, update := range updates {
@@ -87,28 +98,39 @@ func HookPostReceive(ctx *gitea_context.PrivateContext) {
 			})
 			return
 		}
+		applyBranchProtectionFile(ctx, repo, opts)
 	}
 
 	// Handle Push Options
-	if len(opts.GitPushOptions) > 0 {
-		// load the repository
-		if repo == nil {
-			repo = loadRepository(ctx, ownerName, repoName)
-			if ctx.Written() {
-				// Error handled in loadRepository
-				return
-			}
-			wasEmpty = repo.IsEmpty
+	if hasPushOptions(opts) {
+		if err := pushoptions.Apply(ctx, newPush(repo, opts, nil), opts.GitPushOptions); err != nil {
+			log.Error("Failed to apply the push options of %s/%s Error: %v", ownerName, repoName, err)
 		}
+	}
 
-		repo.IsPrivate = opts.GitPushOptions.Bool(private.GitPushOptionRepoPrivate, repo.IsPrivate)
-		repo.IsTemplate = opts.GitPushOptions.Bool(private.GitPushOptionRepoTemplate, repo.IsTemplate)
-		if err := repo_model.UpdateRepositoryCols(ctx, repo, "is_private", "is_template"); err != nil {
-			log.Error("Failed to Update: %s/%s Error: %v", ownerName, repoName, err)
-			ctx.JSON(http.StatusInternalServerError, private.HookPostReceiveResult{
-				Err: fmt.Sprintf("Failed to Update: %s/%s Error: %v", ownerName, repoName, err),
-			})
-		}
+	// Cached upload-pack responses are based on the old refs
+	if packcache.Enabled() && len(opts.OldCommitIDs) > 0 {
+		packcache.Invalidate(repo.ID)
+	}
+
+	// Publish the new refs and objects for the dumb HTTP edge servers
+	if dumbhttp.IsPublisher() && len(opts.OldCommitIDs) > 0 {
+		dumbhttp.AddToPublishQueue(&dumbhttp.PublishRequest{
+			RepoID:    repo.ID,
+			OwnerName: ownerName,
+			RepoName:  repo.Name,
+			IsWiki:    opts.IsWiki,
+		})
+	}
+
+	// Tell the external consumers about every ref update
+	if setting.PushEvents.Enabled && len(opts.OldCommitIDs) > 0 && pushoptions.Bool(opts.GitPushOptions, "notify", true) {
+		notifyPushEvents(ctx, repo, opts)
+	}
+
+	// Push the updated refs to the downstream mirrors
+	if downstream.Enabled() && !opts.IsWiki && len(opts.OldCommitIDs) > 0 {
+		notifyDownstreamMirrors(ctx, repo, opts)
 	}
 
 	results := make([]private.HookPostReceiveBranchResult, 0, len(opts.OldCommitIDs))
@@ -237,3 +259,38 @@ func HookPostReceive(ctx *gitea_context.PrivateContext) {
 		RepoWasEmpty: wasEmpty,
 	})
 }
+
+func notifyPushEvents(ctx *gitea_context.PrivateContext, repo *repo_model.Repository, opts *private.HookOptions) {
+	updates := make([]pushevent.RefUpdate, 0, len(opts.OldCommitIDs))
+	for i := range opts.OldCommitIDs {
+		updates = append(updates, pushevent.RefUpdate{
+			Ref:         opts.RefFullNames[i].String(),
+			OldCommitID: opts.OldCommitIDs[i],
+			NewCommitID: opts.NewCommitIDs[i],
+		})
+	}
+
+	target := pushevent.Repository{
+		ID:        repo.ID,
+		OwnerName: repo.OwnerName,
+		Name:      repo.Name,
+		IsWiki:    opts.IsWiki,
+		Path:      repo.RepoPath(),
+	}
+	if opts.IsWiki {
+		target.Path = repo.WikiPath()
+	}
+	pushevent.Notify(ctx, target, opts.UserID, opts.UserName, updates)
+}
+
+func notifyDownstreamMirrors(ctx *gitea_context.PrivateContext, repo *repo_model.Repository, opts *private.HookOptions) {
+	updates := make([]downstream.RefUpdate, 0, len(opts.OldCommitIDs))
+	for i := range opts.OldCommitIDs {
+		updates = append(updates, downstream.RefUpdate{
+			Ref:         opts.RefFullNames[i].String(),
+			OldCommitID: opts.OldCommitIDs[i],
+			NewCommitID: opts.NewCommitIDs[i],
+		})
+	}
+	downstream.Notify(ctx, repo, updates)
+}
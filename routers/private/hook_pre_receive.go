@@ -10,7 +10,6 @@ import (
 
 	"code.gitea.io/gitea/models"
 	asymkey_model "code.gitea.io/gitea/models/asymkey"
-	git_model "code.gitea.io/gitea/models/git"
 	issues_model "code.gitea.io/gitea/models/issues"
 	perm_model "code.gitea.io/gitea/models/perm"
 	access_model "code.gitea.io/gitea/models/perm/access"
@@ -22,6 +21,8 @@ import (
 	"code.gitea.io/gitea/modules/private"
 	"code.gitea.io/gitea/modules/web"
 	pull_service "code.gitea.io/gitea/services/pull"
+
+	data_git_model "github.com/openmerlin/gitea_data/models/git"
 )
 
 type preReceiveContext struct {
@@ -39,9 +40,12 @@ type preReceiveContext struct {
 	canWriteCode        bool
 	checkedCanWriteCode bool
 
-	protectedTags    []*git_model.ProtectedTag
+	protectedTags    []*data_git_model.ProtectedTag
 	gotProtectedTags bool
 
+	pathGrants    []string
+	gotPathGrants bool
+
 	env []string
 
 	opts *private.HookOptions
@@ -111,6 +115,19 @@ func HookPreReceive(ctx *gitea_context.PrivateContext) {
 		opts:           opts,
 	}
 
+	recorder := &rejectionRecorder{ResponseWriter: ctx.Resp}
+	ctx.Resp = recorder
+	defer func() {
+		if ctx.WrittenStatus() >= http.StatusBadRequest {
+			auditRejectedPush(ourCtx, recorder.reason())
+		}
+	}()
+
+	checkPushOptions(ourCtx)
+	if ctx.Written() {
+		return
+	}
+
 	// Iterate across the provided old commit IDs
 	for i := range opts.OldCommitIDs {
 		oldCommitID := opts.OldCommitIDs[i]
@@ -125,13 +142,20 @@ func HookPreReceive(ctx *gitea_context.PrivateContext) {
 		case git.SupportProcReceive && refFullName.IsFor():
 			preReceiveFor(ourCtx, oldCommitID, newCommitID, refFullName)
 		default:
-			ourCtx.AssertCanWriteCode()
+			if ourCtx.AssertCanWriteCode() {
+				ourCtx.assertPathGrants(oldCommitID, newCommitID, refFullName)
+			}
 		}
 		if ctx.Written() {
 			return
 		}
 	}
 
+	checkPushPolicy(ourCtx)
+	if ctx.Written() {
+		return
+	}
+
 	ctx.PlainText(http.StatusOK, "ok")
 }
 
@@ -139,7 +163,7 @@ func preReceiveBranch(ctx *preReceiveContext, oldCommitID, newCommitID string, r
 	branchName := refFullName.BranchName()
 	ctx.branchName = branchName
 
-	if !ctx.AssertCanWriteCode() {
+	if !ctx.AssertCanWriteCode() || !ctx.assertPathGrants(oldCommitID, newCommitID, refFullName) {
 		return
 	}
 
@@ -154,7 +178,13 @@ func preReceiveBranch(ctx *preReceiveContext, oldCommitID, newCommitID string, r
 		return
 	}
 
-	protectBranch, err := git_model.GetFirstMatchProtectedBranchRule(ctx, repo.ID, branchName)
+	// The rule file of a repository managed as code has to stay valid, whether the branch is protected or not
+	if !ctx.assertBranchProtectionFile(oldCommitID, newCommitID, branchName) {
+		return
+	}
+
+	// the local rule carries the push status check settings as well
+	protectBranch, err := data_git_model.GetFirstMatchProtectedBranchRule(ctx, repo.ID, branchName)
 	if err != nil {
 		log.Error("Unable to get protected branch: %s in %-v Error: %v", branchName, repo, err)
 		ctx.JSON(http.StatusInternalServerError, private.Response{
@@ -221,6 +251,16 @@ func preReceiveBranch(ctx *preReceiveContext, oldCommitID, newCommitID string, r
 		}
 	}
 
+	// Run the content validators enabled for the repository, they can't be overridden either
+	if !validateBranchContent(ctx, oldCommitID, newCommitID, branchName) {
+		return
+	}
+
+	// Require the status checks for direct pushes if the rule asks for it, merges check them below
+	if ctx.opts.PullRequestID == 0 && protectBranch.EnableStatusCheck && !ctx.assertPushStatusChecks(protectBranch, newCommitID, branchName) {
+		return
+	}
+
 	// Now there are several tests which can be overridden:
 	//
 	// 4. Check protected file patterns - this is overridable from the UI
@@ -369,7 +409,7 @@ func preReceiveBranch(ctx *preReceiveContext, oldCommitID, newCommitID string, r
 }
 
 func preReceiveTag(ctx *preReceiveContext, oldCommitID, newCommitID string, refFullName git.RefName) {
-	if !ctx.AssertCanWriteCode() {
+	if !ctx.AssertCanWriteCode() || !ctx.assertPathGrants(oldCommitID, newCommitID, refFullName) {
 		return
 	}
 
@@ -377,7 +417,7 @@ func preReceiveTag(ctx *preReceiveContext, oldCommitID, newCommitID string, refF
 
 	if !ctx.gotProtectedTags {
 		var err error
-		ctx.protectedTags, err = git_model.GetProtectedTags(ctx, ctx.Repo.Repository.ID)
+		ctx.protectedTags, err = data_git_model.GetProtectedTags(ctx, ctx.Repo.Repository.ID)
 		if err != nil {
 			log.Error("Unable to get protected tags for %-v Error: %v", ctx.Repo.Repository, err)
 			ctx.JSON(http.StatusInternalServerError, private.Response{
@@ -388,7 +428,13 @@ func preReceiveTag(ctx *preReceiveContext, oldCommitID, newCommitID string, refF
 		ctx.gotProtectedTags = true
 	}
 
-	isAllowed, err := git_model.IsUserAllowedToControlTag(ctx, ctx.protectedTags, tagName, ctx.opts.UserID)
+	var isAllowed bool
+	var err error
+	if oldCommitID == git.EmptySHA {
+		isAllowed, err = data_git_model.IsUserAllowedToCreateTag(ctx, ctx.protectedTags, tagName, ctx.opts.UserID)
+	} else {
+		isAllowed, err = data_git_model.IsUserAllowedToControlTag(ctx, ctx.protectedTags, tagName, ctx.opts.UserID)
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, private.Response{
 			Err: err.Error(),
@@ -402,6 +448,41 @@ func preReceiveTag(ctx *preReceiveContext, oldCommitID, newCommitID string, refF
 		})
 		return
 	}
+
+	immutableTag, err := data_git_model.GetImmutableTag(ctx.protectedTags, tagName)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: err.Error(),
+		})
+		return
+	}
+	if immutableTag == nil {
+		return
+	}
+	if oldCommitID != git.EmptySHA {
+		log.Warn("Forbidden: Tag %s in %-v is immutable", tagName, ctx.Repo.Repository)
+		ctx.JSON(http.StatusForbidden, private.Response{
+			UserMsg: fmt.Sprintf("Tag %s is immutable and can't be moved or deleted", tagName),
+		})
+		return
+	}
+	if !immutableTag.RequireSigned {
+		return
+	}
+	if err := verifyTag(ctx, ctx.Repo.GitRepo.Path, newCommitID, ctx.env); err != nil {
+		if !isErrUnverifiedTag(err) {
+			log.Error("Unable to verify tag %s in %-v: %v", tagName, ctx.Repo.Repository, err)
+			ctx.JSON(http.StatusInternalServerError, private.Response{
+				Err: fmt.Sprintf("Unable to verify tag %s: %v", tagName, err),
+			})
+			return
+		}
+		log.Warn("Forbidden: Tag %s in %-v is not signed with a verified key: %v", tagName, ctx.Repo.Repository, err)
+		ctx.JSON(http.StatusForbidden, private.Response{
+			UserMsg: fmt.Sprintf("Tag %s must be an annotated tag signed with a verified key: %v", tagName, err.(*errUnverifiedTag).reason),
+		})
+		return
+	}
 }
 
 func preReceiveFor(ctx *preReceiveContext, oldCommitID, newCommitID string, refFullName git.RefName) {
@@ -5,10 +5,14 @@ package private
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	asymkey_model "code.gitea.io/gitea/models/asymkey"
 	"code.gitea.io/gitea/modules/git"
@@ -119,3 +123,111 @@ func isErrUnverifiedCommit(err error) bool {
 	_, ok := err.(*errUnverifiedCommit)
 	return ok
 }
+
+type errUnverifiedTag struct {
+	sha    string
+	reason string
+}
+
+func (e *errUnverifiedTag) Error() string {
+	return fmt.Sprintf("Unverified tag %s: %s", e.sha, e.reason)
+}
+
+func isErrUnverifiedTag(err error) bool {
+	_, ok := err.(*errUnverifiedTag)
+	return ok
+}
+
+// signatureBegins are the armor lines which start the signature appended to a tag message
+var signatureBegins = [][]byte{
+	[]byte("\n-----BEGIN PGP SIGNATURE-----"),
+	[]byte("\n-----BEGIN SSH SIGNATURE-----"),
+}
+
+// verifyTag checks that the tag object sha is an annotated tag signed with a verified key
+func verifyTag(ctx context.Context, repoPath, sha string, env []string) error {
+	objectType, _, err := git.NewCommand(ctx, "cat-file", "-t").AddDynamicArguments(sha).RunStdString(&git.RunOpts{Dir: repoPath, Env: env})
+	if err != nil {
+		return fmt.Errorf("cat-file -t %s: %w", sha, err)
+	}
+	if strings.TrimSpace(objectType) != "tag" {
+		return &errUnverifiedTag{sha: sha, reason: "not an annotated tag"}
+	}
+
+	data, _, err := git.NewCommand(ctx, "cat-file", "tag").AddDynamicArguments(sha).RunStdBytes(&git.RunOpts{Dir: repoPath, Env: env})
+	if err != nil {
+		return fmt.Errorf("cat-file tag %s: %w", sha, err)
+	}
+	tag, parseErr := parseSignedTag(sha, data)
+	if parseErr != nil {
+		return parseErr
+	}
+	if tag.Signature == nil {
+		return &errUnverifiedTag{sha: sha, reason: "not signed"}
+	}
+	if verification := asymkey_model.ParseCommitWithSignature(ctx, tag); !verification.Verified {
+		return &errUnverifiedTag{sha: sha, reason: verification.Reason}
+	}
+	return nil
+}
+
+// parseSignedTag reads a tag object into a commit so that its signature can be verified like the signature of a commit,
+// the tagger stands in for the committer and the payload is the tag object without the signature
+func parseSignedTag(sha string, data []byte) (*git.Commit, error) {
+	id, err := git.NewIDFromString(sha)
+	if err != nil {
+		return nil, err
+	}
+	tag := &git.Commit{ID: id}
+
+	header, message, _ := bytes.Cut(data, []byte("\n\n"))
+	for _, line := range strings.Split(string(header), "\n") {
+		if tagger, ok := strings.CutPrefix(line, "tagger "); ok {
+			if tag.Committer, err = parseTagger(tagger); err != nil {
+				return nil, err
+			}
+			tag.Author = tag.Committer
+		}
+	}
+	tag.CommitMessage = string(message)
+
+	for _, begin := range signatureBegins {
+		if idx := bytes.LastIndex(data, begin); idx >= len(header) {
+			tag.Signature = &git.CommitGPGSignature{
+				Signature: string(data[idx+1:]),
+				Payload:   string(data[:idx+1]),
+			}
+			tag.CommitMessage = string(data[len(header)+2 : idx+1])
+			break
+		}
+	}
+	return tag, nil
+}
+
+// parseTagger parses "Name <email> timestamp timezone"
+func parseTagger(line string) (*git.Signature, error) {
+	emailStart := strings.LastIndexByte(line, '<')
+	emailEnd := strings.LastIndexByte(line, '>')
+	if emailStart < 0 || emailEnd < emailStart {
+		return nil, fmt.Errorf("malformed tagger: %q", line)
+	}
+	sig := &git.Signature{
+		Name:  strings.TrimSpace(line[:emailStart]),
+		Email: line[emailStart+1 : emailEnd],
+	}
+	fields := strings.Fields(line[emailEnd+1:])
+	if len(fields) == 0 {
+		return sig, nil
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed tagger time: %q", line)
+	}
+	sig.When = time.Unix(seconds, 0)
+	if len(fields) > 1 {
+		if tz, err := time.Parse("-0700", fields[1]); err == nil {
+			sig.When = sig.When.In(tz.Location())
+		}
+	}
+	return sig, nil
+}
@@ -41,3 +41,30 @@ func TestVerifyCommits(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSignedTag(t *testing.T) {
+	payload := "object 72920278f2f999e3005801e5d5b8ab8139d3641c\n" +
+		"type commit\n" +
+		"tag v1.0\n" +
+		"tagger Jane Doe <jane@example.com> 1700000000 +0100\n" +
+		"\n" +
+		"Release v1.0\n"
+	signature := "-----BEGIN SSH SIGNATURE-----\nU1NIU0lH\n-----END SSH SIGNATURE-----\n"
+
+	tag, err := parseSignedTag("d766f2917716d45be24bfa968b8409544941be32", []byte(payload+signature))
+	assert.NoError(t, err)
+	assert.Equal(t, "d766f2917716d45be24bfa968b8409544941be32", tag.ID.String())
+	assert.Equal(t, "Jane Doe", tag.Committer.Name)
+	assert.Equal(t, "jane@example.com", tag.Committer.Email)
+	assert.EqualValues(t, 1700000000, tag.Committer.When.Unix())
+	assert.Equal(t, "Release v1.0\n", tag.CommitMessage)
+	if assert.NotNil(t, tag.Signature) {
+		assert.Equal(t, payload, tag.Signature.Payload)
+		assert.Equal(t, signature, tag.Signature.Signature)
+	}
+
+	tag, err = parseSignedTag("d766f2917716d45be24bfa968b8409544941be32", []byte(payload))
+	assert.NoError(t, err)
+	assert.Nil(t, tag.Signature)
+	assert.Equal(t, "Release v1.0\n", tag.CommitMessage)
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/web"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+)
+
+// ImmutableTagOption makes the tags matching NamePattern immutable, an existing protected tag
+// with the same pattern keeps its allowlists. Only allowlisted users may create the tags,
+// unless AnyoneCanCreate lets every user who can write code create them.
+type ImmutableTagOption struct {
+	NamePattern     string `json:"name_pattern"`
+	AnyoneCanCreate bool   `json:"anyone_can_create"`
+	RequireSigned   bool   `json:"require_signed"`
+}
+
+// ProtectedTagInfo is the response of the protected tags APIs
+type ProtectedTagInfo struct {
+	ID               int64   `json:"id"`
+	NamePattern      string  `json:"name_pattern"`
+	AllowlistUserIDs []int64 `json:"allowlist_user_ids"`
+	AllowlistTeamIDs []int64 `json:"allowlist_team_ids"`
+	Immutable        bool    `json:"immutable"`
+	AnyoneCanCreate  bool    `json:"anyone_can_create"`
+	RequireSigned    bool    `json:"require_signed"`
+}
+
+func toProtectedTagInfo(pt *git_model.ProtectedTag) *ProtectedTagInfo {
+	return &ProtectedTagInfo{
+		ID:               pt.ID,
+		NamePattern:      pt.NamePattern,
+		AllowlistUserIDs: pt.AllowlistUserIDs,
+		AllowlistTeamIDs: pt.AllowlistTeamIDs,
+		Immutable:        pt.Immutable,
+		AnyoneCanCreate:  pt.AnyoneCanCreate,
+		RequireSigned:    pt.RequireSigned,
+	}
+}
+
+// ListProtectedTags returns the protected tags of a repository including their immutability
+func ListProtectedTags(ctx *context.PrivateContext) {
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "list protected tags", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	tags, err := git_model.GetProtectedTags(ctx, repo.ID)
+	if err != nil {
+		repoLifecycleError(ctx, "list protected tags", err)
+		return
+	}
+	infos := make([]*ProtectedTagInfo, 0, len(tags))
+	for _, pt := range tags {
+		infos = append(infos, toProtectedTagInfo(pt))
+	}
+	ctx.JSON(http.StatusOK, infos)
+}
+
+// SetImmutableTag makes the tags matching a pattern immutable
+func SetImmutableTag(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*ImmutableTagOption)
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "set immutable tag", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+
+	pt, err := git_model.GetProtectedTagByNamePattern(ctx, repo.ID, form.NamePattern)
+	if err != nil {
+		repoLifecycleError(ctx, "set immutable tag", err)
+		return
+	}
+	isNew := pt == nil
+	if isNew {
+		pt = &git_model.ProtectedTag{RepoID: repo.ID, NamePattern: form.NamePattern}
+	}
+	if err := pt.EnsureCompiledPattern(); form.NamePattern == "" || err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			Err:     fmt.Sprintf("Invalid tag name pattern %q: %v", form.NamePattern, err),
+			UserMsg: fmt.Sprintf("invalid tag name pattern %q", form.NamePattern),
+		})
+		return
+	}
+	pt.Immutable = true
+	pt.AnyoneCanCreate = form.AnyoneCanCreate
+	pt.RequireSigned = form.RequireSigned
+
+	if isNew {
+		err = git_model.InsertProtectedTag(ctx, pt)
+	} else {
+		err = git_model.UpdateProtectedTag(ctx, pt)
+	}
+	if err != nil {
+		repoLifecycleError(ctx, "set immutable tag", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toProtectedTagInfo(pt))
+}
@@ -76,8 +76,48 @@ func Routes() *web.Route {
 	r.Post("/manager/add-logger", bind(private.LoggerOptions{}), AddLogger)
 	r.Post("/manager/remove-logger/{logger}/{writer}", RemoveLogger)
 	r.Get("/manager/processes", Processes)
+	r.Get("/transfer/stats", TransferStats)
+	r.Get("/push_options", PushOptions)
+	r.Get("/push_audit", PushAudits)
 	r.Post("/mail/send", SendEmail)
 	r.Post("/restore_repo", RestoreRepo)
+	r.Post("/repos/{owner}", bind(CreateRepoOption{}), CreateRepo)
+	r.Post("/repos/{owner}/{repo}/rename", bind(RenameRepoOption{}), RenameRepo)
+	r.Post("/repos/{owner}/{repo}/transfer", bind(TransferRepoOption{}), TransferRepo)
+	r.Post("/repos/{owner}/{repo}/archive", ArchiveRepo)
+	r.Post("/repos/{owner}/{repo}/unarchive", UnarchiveRepo)
+	r.Delete("/repos/{owner}/{repo}", DeleteRepo)
+	r.Get("/repos/{owner}/{repo}/validators", GetRepoValidators)
+	r.Put("/repos/{owner}/{repo}/validators", bind(RepoValidatorsOption{}), SetRepoValidators)
+	r.Get("/repos/{owner}/{repo}/protected_tags", ListProtectedTags)
+	r.Put("/repos/{owner}/{repo}/immutable_tags", bind(ImmutableTagOption{}), SetImmutableTag)
+	r.Get("/repos/{owner}/{repo}/push_status_check", GetPushStatusCheck)
+	r.Put("/repos/{owner}/{repo}/push_status_check", bind(PushStatusCheckOption{}), SetPushStatusCheck)
+	r.Get("/repos/{owner}/{repo}/branch_protection_file", GetBranchProtectionFile)
+	r.Put("/repos/{owner}/{repo}/branch_protection_file", bind(BranchProtectionFileOption{}), SetBranchProtectionFile)
+	r.Get("/repos/{owner}/{repo}/status", GetCombinedCommitStatus)
+	r.Get("/repos/{owner}/{repo}/statuses", ListRefCommitStatuses)
+	r.Get("/repos/{owner}/{repo}/statuses/{sha}", ListCommitStatuses)
+	r.Post("/repos/{owner}/{repo}/statuses/{sha}", bind(CreateCommitStatusOption{}), CreateCommitStatus)
+	r.Get("/repos/{owner}/{repo}/branches", ListBranches)
+	r.Post("/repos/{owner}/{repo}/branches/rename", bind(RenameBranchOption{}), RenameBranch)
+	r.Post("/repos/{owner}/{repo}/branches/sync", SyncBranches)
+	r.Get("/repos/{owner}/{repo}/branches/deleted/{id}", GetDeletedBranch)
+	r.Post("/repos/{owner}/{repo}/branches/deleted/{id}/restore", RestoreBranch)
+	r.Post("/commit_statuses/combined", bind(CommitStatusPairsOption{}), GetCombinedCommitStatuses)
+	r.Get("/repos/{owner}/{repo}/backups", ListBackups)
+	r.Post("/repos/{owner}/{repo}/backups/restore", bind(RestoreBackupOption{}), RestoreBackup)
+	r.Get("/lfs/signing_keys", ListLFSSigningKeys)
+	r.Post("/lfs/signing_keys", bind(LFSSigningKeyOption{}), AddLFSSigningKey)
+	r.Post("/lfs/signing_keys/{kid}/activate", ActivateLFSSigningKey)
+	r.Get("/access_tokens/{id}/policy", GetAccessTokenPolicy)
+	r.Put("/access_tokens/{id}/policy", bind(AccessTokenPolicyOption{}), SetAccessTokenPolicy)
+	r.Get("/repos/{owner}/{repo}/path_grants", GetPathGrants)
+	r.Put("/repos/{owner}/{repo}/path_grants", bind(PathGrantsOption{}), SetPathGrants)
+	r.Get("/repos/{owner}/{repo}/downstream_mirrors", ListDownstreamMirrors)
+	r.Post("/repos/{owner}/{repo}/downstream_mirrors", bind(DownstreamMirrorOption{}), AddDownstreamMirror)
+	r.Delete("/repos/{owner}/{repo}/downstream_mirrors/{id}", DeleteDownstreamMirror)
+	r.Post("/repos/{owner}/{repo}/downstream_mirrors/{id}/sync", SyncDownstreamMirror)
 	r.Post("/actions/generate_actions_runner_token", GenerateActionsRunnerToken)
 
 	return r
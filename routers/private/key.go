@@ -52,7 +52,11 @@ func AuthorizedPublicKeyByContent(ctx *context.PrivateContext) {
 
 	publicKey, err := asymkey_model.SearchPublicKeyByContent(ctx, content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, private.Response{
+		status := http.StatusInternalServerError
+		if asymkey_model.IsErrKeyNotExist(err) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, private.Response{
 			Err: err.Error(),
 		})
 		return
@@ -0,0 +1,76 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/util"
+	"code.gitea.io/gitea/modules/web"
+	"code.gitea.io/gitea/services/auth/source/oauth2"
+
+	"github.com/openmerlin/gitea_data/services/lfs"
+)
+
+// LFSSigningKeyOption adds a key to the keys signing LFS tokens
+type LFSSigningKeyOption struct {
+	// Algorithm defaults to LFS_JWT_SIGNING_ALGORITHM
+	Algorithm string `json:"algorithm"`
+	// Stage only publishes the key, it signs once it is activated. Otherwise it replaces the active key right away.
+	Stage bool `json:"stage"`
+}
+
+func lfsSigningKeyError(ctx *context.PrivateContext, action string, err error) {
+	var invalidAlgorithm oauth2.ErrInvalidAlgorithmType
+	switch {
+	case errors.Is(err, util.ErrNotExist):
+		ctx.JSON(http.StatusNotFound, private.Response{
+			UserMsg: err.Error(),
+		})
+	case errors.As(err, &invalidAlgorithm):
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			UserMsg: err.Error(),
+		})
+	default:
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to %s: %v", action, err),
+		})
+	}
+}
+
+// ListLFSSigningKeys returns the keys signing and verifying LFS tokens, the active key first
+func ListLFSSigningKeys(ctx *context.PrivateContext) {
+	keys, err := lfs.SigningKeys()
+	if err != nil {
+		lfsSigningKeyError(ctx, "list LFS signing keys", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, keys)
+}
+
+// AddLFSSigningKey generates a new key for LFS tokens. A rotation without downtime stages the key,
+// waits until every service verifying tokens has fetched it, and then activates it.
+func AddLFSSigningKey(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*LFSSigningKeyOption)
+	key, err := lfs.AddSigningKey(form.Algorithm, !form.Stage)
+	if err != nil {
+		lfsSigningKeyError(ctx, "add LFS signing key", err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, key)
+}
+
+// ActivateLFSSigningKey makes a staged key sign LFS tokens, the replaced key verifies until its tokens have expired
+func ActivateLFSSigningKey(ctx *context.PrivateContext) {
+	key, err := lfs.ActivateSigningKey(ctx.Params(":kid"))
+	if err != nil {
+		lfsSigningKeyError(ctx, "activate LFS signing key", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, key)
+}
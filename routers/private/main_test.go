@@ -4,14 +4,21 @@
 package private
 
 import (
-	"path/filepath"
+	"net/http/httptest"
 	"testing"
 
-	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/contexttest"
+
+	"github.com/openmerlin/gitea_data/models/unittest"
 )
 
 func TestMain(m *testing.M) {
-	unittest.MainTest(m, &unittest.TestOptions{
-		GiteaRootPath: filepath.Join("..", ".."),
-	})
+	unittest.MainTest(m)
+}
+
+// mockPrivateContext returns a context of the internal API for reqPath, e.g. "POST /api/internal/..."
+func mockPrivateContext(t *testing.T, reqPath string) (*context.PrivateContext, *httptest.ResponseRecorder) {
+	apiCtx, resp := contexttest.MockAPIContext(t, reqPath)
+	return &context.PrivateContext{Base: apiCtx.Base}, resp
 }
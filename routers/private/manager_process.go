@@ -15,6 +15,7 @@ import (
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/private"
 	process_module "code.gitea.io/gitea/modules/process"
+	"github.com/openmerlin/gitea_data/modules/admission"
 )
 
 // Processes prints out the processes
@@ -48,11 +49,18 @@ func Processes(ctx *context.PrivateContext) {
 		processes, processCount = process_module.GetManager().Processes(flat, noSystem)
 	}
 
+	var packAdmission *admission.Stats
+	if controller := admission.PackController(); controller != nil {
+		stats := controller.Stats()
+		packAdmission = &stats
+	}
+
 	if json {
 		ctx.JSON(http.StatusOK, map[string]any{
 			"TotalNumberOfGoroutines": goroutineCount,
 			"TotalNumberOfProcesses":  processCount,
 			"Processes":               processes,
+			"PackAdmission":           packAdmission,
 		})
 		return
 	}
@@ -60,6 +68,13 @@ func Processes(ctx *context.PrivateContext) {
 	ctx.Resp.Header().Set("Content-Type", "text/plain;charset=utf-8")
 	ctx.Resp.WriteHeader(http.StatusOK)
 
+	if packAdmission != nil {
+		if err := writePackAdmission(ctx.Resp, packAdmission); err != nil {
+			log.Error("Unable to write out pack admission state: %v", err)
+			return
+		}
+	}
+
 	if err := writeProcesses(ctx.Resp, processes, processCount, goroutineCount, "", flat); err != nil {
 		log.Error("Unable to write out process stacktrace: %v", err)
 		if !ctx.Written() {
@@ -71,6 +86,12 @@ func Processes(ctx *context.PrivateContext) {
 	}
 }
 
+func writePackAdmission(out io.Writer, stats *admission.Stats) error {
+	_, err := fmt.Fprintf(out, "Pack Admission: Running: %d\tQueued: %d\tAdmitted: %d\tRejected: %d\tTimed out: %d\n",
+		stats.Running, stats.Queued, stats.Admitted, stats.Rejected, stats.TimedOut)
+	return err
+}
+
 func writeProcesses(out io.Writer, processes []*process_module.Process, processCount int, goroutineCount int64, indent string, flat bool) error {
 	if goroutineCount > 0 {
 		if _, err := fmt.Fprintf(out, "%sTotal Number of Goroutines: %d\n", indent, goroutineCount); err != nil {
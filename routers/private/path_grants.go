@@ -0,0 +1,197 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	asymkey_model "code.gitea.io/gitea/models/asymkey"
+	auth_model "code.gitea.io/gitea/models/auth"
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/web"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	repo_module "github.com/openmerlin/gitea_data/modules/repository"
+	"github.com/openmerlin/gitea_data/services/pathgrant"
+)
+
+// emptyTreeSHA is the tree without entries, the changes of a branch without a base are diffed against it
+const emptyTreeSHA = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// PathGrantsOption restricts the writes of a deploy key or an access token to Prefixes,
+// exactly one of the IDs must be set and an empty list lifts the restriction
+type PathGrantsOption struct {
+	DeployKeyID   int64    `json:"deploy_key_id"`
+	AccessTokenID int64    `json:"access_token_id"`
+	Prefixes      []string `json:"prefixes"`
+}
+
+// PathGrantsInfo is the response of the path grants APIs
+type PathGrantsInfo struct {
+	DeployKeyID   int64    `json:"deploy_key_id,omitempty"`
+	AccessTokenID int64    `json:"access_token_id,omitempty"`
+	Prefixes      []string `json:"prefixes"`
+}
+
+// loadPathGrants returns the prefixes the credential of the push may write to, nil if it isn't restricted.
+// It returns false if a response has been written.
+func (ctx *preReceiveContext) loadPathGrants() ([]string, bool) {
+	if ctx.gotPathGrants {
+		return ctx.pathGrants, true
+	}
+	var accessTokenID int64
+	if ctx.opts.GitPushOptions[repo_module.PushOptionAuthMethod] == repo_module.AuthMethodAccessToken {
+		accessTokenID, _ = strconv.ParseInt(ctx.opts.GitPushOptions[repo_module.PushOptionAuthID], 10, 64)
+	}
+	prefixes, err := pathgrant.Get(ctx, ctx.Repo.Repository.ID, ctx.opts.DeployKeyID, accessTokenID)
+	if err != nil {
+		log.Error("Unable to get the path grants of the pusher in %-v: %v", ctx.Repo.Repository, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to get the path grants: %v", err),
+		})
+		return nil, false
+	}
+	ctx.pathGrants, ctx.gotPathGrants = prefixes, true
+	return prefixes, true
+}
+
+// assertPathGrants rejects the ref update if the credential of the push is restricted to path prefixes
+// and the update changes files outside of them. Restricted credentials may only create and update branches.
+// It returns false if a response has been written.
+func (ctx *preReceiveContext) assertPathGrants(oldCommitID, newCommitID string, refFullName git.RefName) bool {
+	prefixes, ok := ctx.loadPathGrants()
+	if !ok {
+		return false
+	} else if prefixes == nil {
+		return true
+	}
+
+	repo := ctx.Repo.Repository
+	if ctx.opts.IsWiki || !refFullName.IsBranch() || newCommitID == git.EmptySHA {
+		log.Warn("Forbidden: the path restricted credential of the pusher may not update %s in %-v", refFullName, repo)
+		ctx.JSON(http.StatusForbidden, private.Response{
+			UserMsg: fmt.Sprintf("the credential is restricted to %s and may only push branches", strings.Join(prefixes, ", ")),
+		})
+		return false
+	}
+
+	base := oldCommitID
+	if base == git.EmptySHA {
+		// a new branch only brings the changes since it forked off the default branch
+		stdout, _, err := git.NewCommand(ctx, "merge-base").AddDynamicArguments(repo.DefaultBranch, newCommitID).RunStdString(&git.RunOpts{Dir: repo.RepoPath(), Env: ctx.env})
+		if err != nil {
+			base = emptyTreeSHA
+		} else {
+			base = strings.TrimSpace(stdout)
+		}
+	}
+	files, err := pathgrant.ChangedPaths(ctx, repo.RepoPath(), ctx.env, base, newCommitID)
+	if err != nil {
+		log.Error("Unable to get the files changed from %s to %s in %-v: %v", base, newCommitID, repo, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to get the files changed from %s to %s: %v", base, newCommitID, err),
+		})
+		return false
+	}
+	for _, file := range files {
+		if !pathgrant.Allowed(prefixes, file) {
+			log.Warn("Forbidden: the path restricted credential of the pusher may not change %s in %-v", file, repo)
+			ctx.JSON(http.StatusForbidden, private.Response{
+				UserMsg: fmt.Sprintf("the credential may not change %s, it is restricted to %s", file, strings.Join(prefixes, ", ")),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// checkPathGrantCredential makes sure the deploy key belongs to the repository and the access token exists.
+// It returns false if a response has been written.
+func checkPathGrantCredential(ctx *context.PrivateContext, repo *repo_model.Repository, deployKeyID, accessTokenID int64) bool {
+	if (deployKeyID > 0) == (accessTokenID > 0) {
+		ctx.JSON(http.StatusBadRequest, private.Response{
+			UserMsg: "exactly one of deploy_key_id and access_token_id is required",
+		})
+		return false
+	}
+
+	if deployKeyID > 0 {
+		key, err := asymkey_model.GetDeployKeyByID(ctx, deployKeyID)
+		if err != nil && !asymkey_model.IsErrDeployKeyNotExist(err) {
+			repoLifecycleError(ctx, "check deploy key", err)
+			return false
+		}
+		if err != nil || key.RepoID != repo.ID {
+			ctx.JSON(http.StatusNotFound, private.Response{
+				UserMsg: fmt.Sprintf("deploy key %d does not exist in the repository", deployKeyID),
+			})
+			return false
+		}
+		return true
+	}
+
+	exist, err := db.GetEngine(ctx).ID(accessTokenID).Exist(new(auth_model.AccessToken))
+	if err != nil {
+		repoLifecycleError(ctx, "check access token", err)
+		return false
+	}
+	if !exist {
+		ctx.JSON(http.StatusNotFound, private.Response{
+			UserMsg: fmt.Sprintf("access token %d does not exist", accessTokenID),
+		})
+		return false
+	}
+	return true
+}
+
+// GetPathGrants returns the path prefixes a deploy key or an access token may write to in a repository
+func GetPathGrants(ctx *context.PrivateContext) {
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "get path grants", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	deployKeyID, accessTokenID := ctx.FormInt64("deploy_key_id"), ctx.FormInt64("access_token_id")
+	if !checkPathGrantCredential(ctx, repo, deployKeyID, accessTokenID) {
+		return
+	}
+
+	prefixes, err := repo_model.GetPathGrants(ctx, repo.ID, deployKeyID, accessTokenID)
+	if err != nil {
+		repoLifecycleError(ctx, "get path grants", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, &PathGrantsInfo{DeployKeyID: deployKeyID, AccessTokenID: accessTokenID, Prefixes: prefixes})
+}
+
+// SetPathGrants replaces the path prefixes a deploy key or an access token may write to in a repository
+func SetPathGrants(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*PathGrantsOption)
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "set path grants", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	if !checkPathGrantCredential(ctx, repo, form.DeployKeyID, form.AccessTokenID) {
+		return
+	}
+
+	prefixes, err := pathgrant.Set(ctx, repo.ID, form.DeployKeyID, form.AccessTokenID, form.Prefixes)
+	if err != nil {
+		repoLifecycleError(ctx, "set path grants", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, &PathGrantsInfo{DeployKeyID: form.DeployKeyID, AccessTokenID: form.AccessTokenID, Prefixes: prefixes})
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"bytes"
+	gocontext "context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/timeutil"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	repo_module "github.com/openmerlin/gitea_data/modules/repository"
+)
+
+// maxRejectionReason bounds the part of a rejection response kept as the reason of the audit entry
+const maxRejectionReason = 4096
+
+// rejectionRecorder keeps the body of an error response so that the rejection can be audited with its reason
+type rejectionRecorder struct {
+	context.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *rejectionRecorder) Write(b []byte) (int, error) {
+	if w.WrittenStatus() >= http.StatusBadRequest && w.body.Len() < maxRejectionReason {
+		w.body.Write(b[:min(len(b), maxRejectionReason-w.body.Len())])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// reason returns the message shown to the pusher, or the internal error if there is none
+func (w *rejectionRecorder) reason() string {
+	var resp private.Response
+	if err := json.Unmarshal(w.body.Bytes(), &resp); err != nil {
+		return strings.TrimSpace(w.body.String())
+	}
+	if resp.UserMsg != "" {
+		return resp.UserMsg
+	}
+	return resp.Err
+}
+
+// newPushAudit fills in the pusher and how it authenticated, which the hook passes as internal push options
+func newPushAudit(repo *repo_model.Repository, opts *private.HookOptions, event string) *repo_model.PushAudit {
+	authID, _ := strconv.ParseInt(opts.GitPushOptions[repo_module.PushOptionAuthID], 10, 64)
+	return &repo_model.PushAudit{
+		RepoID:     repo.ID,
+		OwnerName:  repo.OwnerName,
+		RepoName:   repo.Name,
+		IsWiki:     opts.IsWiki,
+		PusherID:   opts.UserID,
+		PusherName: opts.UserName,
+		AuthMethod: opts.GitPushOptions[repo_module.PushOptionAuthMethod],
+		AuthID:     authID,
+		ClientIP:   opts.GitPushOptions[repo_module.PushOptionClientIP],
+		Event:      event,
+	}
+}
+
+// auditRefUpdates returns the ref updates of the hook, env gives access to the quarantined objects in pre-receive
+func auditRefUpdates(ctx *context.PrivateContext, repoPath string, env []string, opts *private.HookOptions) []repo_model.PushAuditRefUpdate {
+	updates := make([]repo_model.PushAuditRefUpdate, 0, len(opts.RefFullNames))
+	for i, refFullName := range opts.RefFullNames {
+		update := repo_model.PushAuditRefUpdate{
+			Ref:         refFullName.String(),
+			OldCommitID: opts.OldCommitIDs[i],
+			NewCommitID: opts.NewCommitIDs[i],
+		}
+		if update.OldCommitID != git.EmptySHA && update.NewCommitID != git.EmptySHA {
+			_, _, err := git.NewCommand(ctx, "merge-base", "--is-ancestor").AddDynamicArguments(update.OldCommitID, update.NewCommitID).RunStdString(&git.RunOpts{Dir: repoPath, Env: env})
+			if err != nil && err.IsExitCode(1) {
+				update.Forced = true
+			} else if err != nil {
+				log.Warn("Unable to check whether the update of %s in %s is forced: %v", update.Ref, repoPath, err)
+			}
+		}
+		updates = append(updates, update)
+	}
+	return updates
+}
+
+func insertPushAudit(ctx *context.PrivateContext, audit *repo_model.PushAudit) {
+	if err := repo_model.InsertPushAudit(ctx, audit); err != nil {
+		log.Error("Unable to record the %s push audit of %s/%s: %v", audit.Event, audit.OwnerName, audit.RepoName, err)
+	}
+}
+
+// auditRejectedPush records a push rejected by pre-receive
+func auditRejectedPush(ctx *preReceiveContext, reason string) {
+	repo := ctx.Repo.Repository
+	audit := newPushAudit(repo, ctx.opts, repo_model.PushAuditRejected)
+	if ctx.user != nil {
+		audit.PusherName = ctx.user.Name
+	} else if user, err := user_model.GetPossibleUserByID(ctx, ctx.opts.UserID); err == nil {
+		audit.PusherName = user.Name
+	}
+	repoPath := repo.RepoPath()
+	if ctx.opts.IsWiki {
+		repoPath = repo.WikiPath()
+	}
+	audit.RefUpdates = auditRefUpdates(ctx.PrivateContext, repoPath, ctx.env, ctx.opts)
+	audit.Reason = reason
+	insertPushAudit(ctx.PrivateContext, audit)
+}
+
+// auditPush records the ref updates of post-receive, the batches of a push of many refs are recorded in one entry.
+// Wiki pushes aren't recorded as the hook doesn't send their updates to post-receive.
+func auditPush(ctx *context.PrivateContext, repo *repo_model.Repository, opts *private.HookOptions) {
+	audit := newPushAudit(repo, opts, repo_model.PushAuditPushed)
+	audit.PushID = opts.GitPushOptions[repo_module.PushOptionPushID]
+	audit.RefUpdates = auditRefUpdates(ctx, repo.RepoPath(), nil, opts)
+	if err := repo_model.RecordPushAudit(ctx, audit); err != nil {
+		log.Error("Unable to record the %s push audit of %s/%s: %v", audit.Event, audit.OwnerName, audit.RepoName, err)
+	}
+}
+
+// PushAudits exports the push audit log as JSON lines, oldest first.
+// since and until are unix timestamps, pusher is a user name. As the entries are streamed,
+// an export which failed midway ends with a line holding the error instead of an entry.
+func PushAudits(ctx *context.PrivateContext) {
+	opts := &repo_model.FindPushAuditsOptions{
+		RepoID:     ctx.FormInt64("repo_id"),
+		OwnerName:  ctx.FormString("owner"),
+		RepoName:   ctx.FormString("repo"),
+		PusherID:   ctx.FormInt64("pusher_id"),
+		PusherName: ctx.FormString("pusher"),
+		Event:      ctx.FormString("event"),
+		Since:      timeutil.TimeStamp(ctx.FormInt64("since")),
+		Until:      timeutil.TimeStamp(ctx.FormInt64("until")),
+	}
+
+	ctx.Resp.Header().Set("Content-Type", "application/x-ndjson")
+	ctx.Resp.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(ctx.Resp)
+	err := repo_model.IteratePushAudits(ctx, opts, func(_ gocontext.Context, audit *repo_model.PushAudit) error {
+		return enc.Encode(audit)
+	})
+	if err != nil {
+		log.Error("Unable to export the push audit log: %v", err)
+		_ = enc.Encode(private.Response{Err: fmt.Sprintf("Unable to export the push audit log: %v", err)})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	perm_model "code.gitea.io/gitea/models/perm"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+
+	repo_module "github.com/openmerlin/gitea_data/modules/repository"
+	"github.com/openmerlin/gitea_data/services/pushoptions"
+)
+
+func newPush(repo *repo_model.Repository, opts *private.HookOptions, env []string) *pushoptions.Push {
+	push := &pushoptions.Push{
+		Repo:     repo,
+		IsWiki:   opts.IsWiki,
+		PusherID: opts.UserID,
+		Env:      env,
+		Updates:  make([]pushoptions.RefUpdate, 0, len(opts.RefFullNames)),
+	}
+	for i, refFullName := range opts.RefFullNames {
+		push.Updates = append(push.Updates, pushoptions.RefUpdate{
+			RefFullName: refFullName,
+			OldCommitID: opts.OldCommitIDs[i],
+			NewCommitID: opts.NewCommitIDs[i],
+		})
+	}
+	return push
+}
+
+// hasPushOptions returns whether the pusher sent push options, the hook adds the internal ones to every push
+func hasPushOptions(opts *private.HookOptions) bool {
+	for name := range opts.GitPushOptions {
+		if !strings.HasPrefix(name, repo_module.PushOptionInternalPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPushOptions validates the registered push options and checks the pusher may send them
+func checkPushOptions(ctx *preReceiveContext) {
+	if !hasPushOptions(ctx.opts) || !ctx.loadPusherAndPermission() {
+		return
+	}
+
+	mode := ctx.userPerm.AccessMode
+	if ctx.opts.DeployKeyID != 0 {
+		mode = ctx.deployKeyAccessMode
+	}
+	if ctx.opts.IsWiki && mode > perm_model.AccessModeWrite {
+		// a wiki push doesn't give admin access to the repository settings
+		mode = perm_model.AccessModeWrite
+	}
+
+	err := pushoptions.Validate(ctx, newPush(ctx.Repo.Repository, ctx.opts, ctx.env), ctx.opts.GitPushOptions, mode)
+	if err == nil {
+		return
+	}
+	if pushoptions.IsErrInvalidOption(err) {
+		log.Warn("Forbidden: invalid push option for %-v: %v", ctx.Repo.Repository, err)
+		ctx.JSON(http.StatusForbidden, private.Response{
+			UserMsg: err.Error(),
+		})
+		return
+	}
+	log.Error("Unable to check the push options for %-v: %v", ctx.Repo.Repository, err)
+	ctx.JSON(http.StatusInternalServerError, private.Response{
+		Err: fmt.Sprintf("Unable to check the push options: %v", err),
+	})
+}
+
+// PushOptions returns the markdown documentation of the supported push options
+func PushOptions(ctx *context.PrivateContext) {
+	ctx.Resp.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	ctx.PlainText(http.StatusOK, pushoptions.Documentation())
+}
@@ -0,0 +1,27 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/modules/private"
+
+	repo_module "github.com/openmerlin/gitea_data/modules/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasPushOptions(t *testing.T) {
+	opts := &private.HookOptions{GitPushOptions: map[string]string{
+		repo_module.PushOptionAuthMethod: repo_module.AuthMethodSSHKey,
+		repo_module.PushOptionPushID:     "0123",
+	}}
+	assert.False(t, hasPushOptions(opts))
+
+	opts.GitPushOptions["ci.skip"] = "true"
+	assert.True(t, hasPushOptions(opts))
+
+	assert.False(t, hasPushOptions(&private.HookOptions{}))
+}
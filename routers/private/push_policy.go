@@ -0,0 +1,58 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"net/http"
+
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+
+	"github.com/openmerlin/gitea_data/services/pushpolicy"
+)
+
+// checkPushPolicy asks the policy evaluator about the whole push once all ref updates passed the built-in checks
+func checkPushPolicy(ctx *preReceiveContext) {
+	if !pushpolicy.Enabled() || !ctx.loadPusherAndPermission() {
+		return
+	}
+
+	repo := ctx.Repo.Repository
+	repoPath := repo.RepoPath()
+	if ctx.opts.IsWiki {
+		repoPath = repo.WikiPath()
+	}
+	updates := make([]pushpolicy.RefUpdate, 0, len(ctx.opts.RefFullNames))
+	for i, refFullName := range ctx.opts.RefFullNames {
+		updates = append(updates, pushpolicy.RefUpdate{
+			Ref:         refFullName.String(),
+			OldCommitID: ctx.opts.OldCommitIDs[i],
+			NewCommitID: ctx.opts.NewCommitIDs[i],
+		})
+	}
+
+	verdict := pushpolicy.Check(ctx, pushpolicy.Repository{
+		ID:            repo.ID,
+		OwnerName:     repo.OwnerName,
+		Name:          repo.Name,
+		IsWiki:        ctx.opts.IsWiki,
+		IsPrivate:     repo.IsPrivate,
+		DefaultBranch: repo.DefaultBranch,
+	}, pushpolicy.Pusher{
+		ID:          ctx.user.ID,
+		Name:        ctx.user.Name,
+		IsAdmin:     ctx.user.IsAdmin,
+		DeployKeyID: ctx.opts.DeployKeyID,
+	}, repoPath, ctx.env, updates)
+	if !verdict.Allow {
+		log.Warn("Forbidden: push to %-v by %s was denied by the push policy: %s", repo, ctx.user.Name, verdict.Message)
+		msg := verdict.Message
+		if msg == "" {
+			msg = "push denied by policy"
+		}
+		ctx.JSON(http.StatusForbidden, private.Response{
+			UserMsg: msg,
+		})
+	}
+}
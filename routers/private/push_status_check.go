@@ -0,0 +1,183 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/modules/base"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/web"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+)
+
+// PushStatusCheckOption makes direct pushes to the branches of a protection rule require its status checks
+type PushStatusCheckOption struct {
+	RuleName string `json:"rule_name"`
+	Enabled  bool   `json:"enabled"`
+	// Parent also accepts a pushed head whose n-th parent passes the checks
+	Parent int `json:"parent"`
+}
+
+// PushStatusCheckInfo is the response of the push status check APIs
+type PushStatusCheckInfo struct {
+	RuleName            string   `json:"rule_name"`
+	EnableStatusCheck   bool     `json:"enable_status_check"`
+	StatusCheckContexts []string `json:"status_check_contexts"`
+	Enabled             bool     `json:"enabled"`
+	Parent              int      `json:"parent"`
+}
+
+func toPushStatusCheckInfo(rule *git_model.ProtectedBranch) *PushStatusCheckInfo {
+	return &PushStatusCheckInfo{
+		RuleName:            rule.RuleName,
+		EnableStatusCheck:   rule.EnableStatusCheck,
+		StatusCheckContexts: rule.StatusCheckContexts,
+		Enabled:             rule.StatusCheckOnPush,
+		Parent:              rule.StatusCheckPushParent,
+	}
+}
+
+// formatStatusFailures describes why a commit doesn't pass the required status checks
+func formatStatusFailures(missing []string, failed []*git_model.CommitStatus) string {
+	var parts []string
+	if len(missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(missing, ", "))
+	}
+	if len(failed) > 0 {
+		contexts := make([]string, 0, len(failed))
+		for _, status := range failed {
+			contexts = append(contexts, fmt.Sprintf("%s (%s)", status.Context, status.State))
+		}
+		parts = append(parts, "failed "+strings.Join(contexts, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// checkCommitStatuses returns whether the commit passes the required status checks of the rule,
+// and if not the description of the failures
+func (ctx *preReceiveContext) checkCommitStatuses(rule *git_model.ProtectedBranch, commitID string) (bool, string, error) {
+	statuses, _, err := git_model.GetLatestCommitStatus(ctx, ctx.Repo.Repository.ID, commitID, db.ListOptions{ListAll: true})
+	if err != nil {
+		return false, "", err
+	}
+	missing, failed := rule.CheckPushStatuses(statuses)
+	if len(missing) == 0 && len(failed) == 0 {
+		return true, "", nil
+	}
+	return false, formatStatusFailures(missing, failed), nil
+}
+
+// assertPushStatusChecks rejects a direct push to a branch whose protection rule requires the status checks
+// on push, unless the new head or its nominated parent passes them. It returns false if a response has been written.
+func (ctx *preReceiveContext) assertPushStatusChecks(rule *git_model.ProtectedBranch, newCommitID, branchName string) bool {
+	repo := ctx.Repo.Repository
+	if !rule.EnableStatusCheck || !rule.StatusCheckOnPush {
+		return true
+	}
+
+	ok, failures, err := ctx.checkCommitStatuses(rule, newCommitID)
+	if err != nil {
+		log.Error("Unable to get the commit statuses of %s in %-v: %v", newCommitID, repo, err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to get the commit statuses of %s: %v", newCommitID, err),
+		})
+		return false
+	}
+	if ok {
+		return true
+	}
+
+	msg := fmt.Sprintf("branch %s requires passing status checks, commit %s has %s", branchName, base.ShortSha(newCommitID), failures)
+	if rule.StatusCheckPushParent > 0 {
+		stdout, _, err := git.NewCommand(ctx, "rev-parse", "--verify", "--quiet").
+			AddDynamicArguments(fmt.Sprintf("%s^%d", newCommitID, rule.StatusCheckPushParent)).
+			RunStdString(&git.RunOpts{Dir: repo.RepoPath(), Env: ctx.env})
+		if err != nil {
+			msg += fmt.Sprintf(" and has no parent %d", rule.StatusCheckPushParent)
+		} else {
+			parentID := strings.TrimSpace(stdout)
+			ok, failures, err := ctx.checkCommitStatuses(rule, parentID)
+			if err != nil {
+				log.Error("Unable to get the commit statuses of %s in %-v: %v", parentID, repo, err)
+				ctx.JSON(http.StatusInternalServerError, private.Response{
+					Err: fmt.Sprintf("Unable to get the commit statuses of %s: %v", parentID, err),
+				})
+				return false
+			}
+			if ok {
+				return true
+			}
+			msg += fmt.Sprintf(", its parent %d %s has %s", rule.StatusCheckPushParent, base.ShortSha(parentID), failures)
+		}
+	}
+
+	log.Warn("Forbidden: Branch: %s in %-v requires passing status checks: %s", branchName, repo, msg)
+	ctx.JSON(http.StatusForbidden, private.Response{
+		UserMsg: msg,
+	})
+	return false
+}
+
+// loadProtectedBranchRule returns the protection rule named by the request, it returns nil if a response has been written
+func loadProtectedBranchRule(ctx *context.PrivateContext, action, ruleName string) *git_model.ProtectedBranch {
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return nil
+	} else if repo == nil {
+		repoLifecycleError(ctx, action, repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return nil
+	}
+	rule, err := git_model.GetProtectedBranchRuleByName(ctx, repo.ID, ruleName)
+	if err != nil {
+		repoLifecycleError(ctx, action, err)
+		return nil
+	}
+	if rule == nil {
+		ctx.JSON(http.StatusNotFound, private.Response{
+			UserMsg: fmt.Sprintf("branch protection rule %q does not exist", ruleName),
+		})
+		return nil
+	}
+	return rule
+}
+
+// GetPushStatusCheck returns whether direct pushes to the branches of a protection rule require its status checks
+func GetPushStatusCheck(ctx *context.PrivateContext) {
+	rule := loadProtectedBranchRule(ctx, "get push status check", ctx.FormString("rule_name"))
+	if rule == nil {
+		return
+	}
+	ctx.JSON(http.StatusOK, toPushStatusCheckInfo(rule))
+}
+
+// SetPushStatusCheck sets whether direct pushes to the branches of a protection rule require its status checks
+func SetPushStatusCheck(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*PushStatusCheckOption)
+	if form.Parent < 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+			UserMsg: fmt.Sprintf("invalid parent %d", form.Parent),
+		})
+		return
+	}
+	rule := loadProtectedBranchRule(ctx, "set push status check", form.RuleName)
+	if rule == nil || !assertNotManagedByFile(ctx, rule.RepoID, "set push status check") {
+		return
+	}
+	rule.StatusCheckOnPush = form.Enabled
+	rule.StatusCheckPushParent = form.Parent
+	if err := git_model.UpdateProtectedBranchPushStatusCheck(ctx, rule); err != nil {
+		repoLifecycleError(ctx, "set push status check", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toPushStatusCheckInfo(rule))
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"net/http"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/private"
+	api "code.gitea.io/gitea/modules/structs"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssertPushStatusChecks(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	user := unittest.AssertExistsAndLoadBean(t, &user_model.User{ID: 2})
+	// the head of home-md-img-check of user2/repo1 and its parent, the head of master
+	const head, parent = "78fb907e3a3309eae4fe8fef030874cebbf1cd5e", "65f1bf27bc3bf70f64657658635e66094edbcb4d"
+
+	check := func(t *testing.T, rule *git_model.ProtectedBranch, commitID string) (bool, *private.Response, int) {
+		privateCtx, resp := mockPrivateContext(t, "POST /api/internal/hook/pre-receive/user2/repo1")
+		privateCtx.Repo = &context.Repository{Repository: repo}
+		ctx := &preReceiveContext{PrivateContext: privateCtx}
+		ok := ctx.assertPushStatusChecks(rule, commitID, "branch2")
+		if ok {
+			return true, nil, resp.Code
+		}
+		res := &private.Response{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), res))
+		return false, res, resp.Code
+	}
+	rule := &git_model.ProtectedBranch{
+		RepoID:              repo.ID,
+		RuleName:            "branch2",
+		EnableStatusCheck:   true,
+		StatusCheckContexts: []string{"ci/build"},
+		StatusCheckOnPush:   true,
+	}
+
+	t.Run("NotOnPush", func(t *testing.T) {
+		rule := *rule
+		rule.StatusCheckOnPush = false
+		ok, _, _ := check(t, &rule, head)
+		assert.True(t, ok)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		ok, res, code := check(t, rule, head)
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Equal(t, "branch branch2 requires passing status checks, commit 78fb907e3a has missing ci/build", res.UserMsg)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		// without required contexts every status has to succeed, the fixtures haven't
+		rule := *rule
+		rule.StatusCheckContexts = nil
+		ok, res, code := check(t, &rule, "1234123412341234123412341234123412341234")
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Contains(t, res.UserMsg, "commit 1234123412 has failed ")
+	})
+
+	require.NoError(t, git_model.NewCommitStatus(db.DefaultContext, git_model.NewCommitStatusOptions{
+		Repo:         repo,
+		Creator:      user,
+		SHA:          parent,
+		CommitStatus: &git_model.CommitStatus{State: api.CommitStatusSuccess, Context: "ci/build"},
+	}))
+
+	t.Run("ParentNotAccepted", func(t *testing.T) {
+		ok, _, _ := check(t, rule, head)
+		assert.False(t, ok)
+	})
+
+	t.Run("Parent", func(t *testing.T) {
+		rule := *rule
+		rule.StatusCheckPushParent = 1
+		ok, _, _ := check(t, &rule, head)
+		assert.True(t, ok)
+	})
+
+	t.Run("NoSuchParent", func(t *testing.T) {
+		rule := *rule
+		rule.StatusCheckPushParent = 2
+		ok, res, _ := check(t, &rule, head)
+		assert.False(t, ok)
+		assert.Equal(t, "branch branch2 requires passing status checks, commit 78fb907e3a has missing ci/build and has no parent 2", res.UserMsg)
+	})
+
+	t.Run("Passed", func(t *testing.T) {
+		ok, _, _ := check(t, rule, parent)
+		assert.True(t, ok)
+	})
+}
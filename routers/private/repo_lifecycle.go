@@ -0,0 +1,258 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+
+	"code.gitea.io/gitea/models/db"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/web"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	repo_service "github.com/openmerlin/gitea_data/services/repository"
+)
+
+// CreateRepoOption are the options of CreateRepo
+type CreateRepoOption struct {
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+	Private       bool   `json:"private"`
+	AutoInit      bool   `json:"auto_init"`
+	// LFSPatterns are tracked with LFS by the .gitattributes of the initial commit, they imply AutoInit
+	LFSPatterns []string `json:"lfs_patterns"`
+}
+
+// RenameRepoOption are the options of RenameRepo
+type RenameRepoOption struct {
+	NewName string `json:"new_name"`
+}
+
+// TransferRepoOption are the options of TransferRepo
+type TransferRepoOption struct {
+	NewOwner string `json:"new_owner"`
+}
+
+// RepoInfo is the response of the repository lifecycle APIs
+type RepoInfo struct {
+	ID            int64  `json:"id"`
+	Owner         string `json:"owner"`
+	Name          string `json:"name"`
+	DefaultBranch string `json:"default_branch"`
+	Private       bool   `json:"private"`
+	Archived      bool   `json:"archived"`
+	Empty         bool   `json:"empty"`
+}
+
+func toRepoInfo(repo *repo_model.Repository) *RepoInfo {
+	return &RepoInfo{
+		ID:            repo.ID,
+		Owner:         repo.OwnerName,
+		Name:          repo.Name,
+		DefaultBranch: repo.DefaultBranch,
+		Private:       repo.IsPrivate,
+		Archived:      repo.IsArchived,
+		Empty:         repo.IsEmpty,
+	}
+}
+
+// repoLifecycleError responds with the status matching err
+func repoLifecycleError(ctx *context.PrivateContext, action string, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case user_model.IsErrUserNotExist(err), repo_model.IsErrRepoNotExist(err):
+		status = http.StatusNotFound
+	case repo_model.IsErrRepoAlreadyExist(err), repo_model.IsErrRepoFilesAlreadyExist(err):
+		status = http.StatusConflict
+	case db.IsErrNameReserved(err), db.IsErrNamePatternNotAllowed(err), db.IsErrNameCharsNotAllowed(err):
+		status = http.StatusUnprocessableEntity
+	case repo_model.IsErrReachLimitOfRepo(err):
+		status = http.StatusForbidden
+	}
+	if status == http.StatusInternalServerError {
+		log.Error("Unable to %s: %v", action, err)
+	}
+	ctx.JSON(status, private.Response{
+		Err:     fmt.Sprintf("Unable to %s: %v", action, err),
+		UserMsg: err.Error(),
+	})
+}
+
+// loadDoer returns the user named by the doer parameter, the owner acts itself if it's an individual user
+func loadDoer(ctx *context.PrivateContext, owner *user_model.User) *user_model.User {
+	name := ctx.FormString("doer")
+	if name == "" {
+		if owner.IsOrganization() {
+			ctx.JSON(http.StatusBadRequest, private.Response{
+				Err: "The doer parameter is required for repositories of organizations",
+			})
+			return nil
+		}
+		return owner
+	}
+	doer, err := user_model.GetUserByName(ctx, name)
+	if err != nil {
+		repoLifecycleError(ctx, "load doer", err)
+		return nil
+	}
+	return doer
+}
+
+// loadLifecycleRepo loads the owner and the repository of the request, following redirects if followRedirect is set.
+// The repository is nil if it doesn't exist, ok is false if a response has been written.
+func loadLifecycleRepo(ctx *context.PrivateContext, followRedirect bool) (owner *user_model.User, repo *repo_model.Repository, ok bool) {
+	owner, err := user_model.GetUserByName(ctx, ctx.Params(":owner"))
+	if err != nil {
+		repoLifecycleError(ctx, "load owner", err)
+		return nil, nil, false
+	}
+	if followRedirect {
+		repo, err = repo_model.GetRepositoryByNameOrRedirect(ctx, owner.ID, ctx.Params(":repo"))
+	} else {
+		repo, err = repo_model.GetRepositoryByName(owner.ID, ctx.Params(":repo"))
+	}
+	if err != nil {
+		if repo_model.IsErrRepoNotExist(err) {
+			return owner, nil, true
+		}
+		repoLifecycleError(ctx, "load repository", err)
+		return nil, nil, false
+	}
+	return owner, repo, true
+}
+
+// CreateRepo creates a repository under the owner, an existing repository of the same name is returned unchanged
+func CreateRepo(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*CreateRepoOption)
+	owner, err := user_model.GetUserByName(ctx, ctx.Params(":owner"))
+	if err != nil {
+		repoLifecycleError(ctx, "load owner", err)
+		return
+	}
+
+	repo, err := repo_model.GetRepositoryByName(owner.ID, form.Name)
+	if err == nil {
+		ctx.JSON(http.StatusOK, toRepoInfo(repo))
+		return
+	} else if !repo_model.IsErrRepoNotExist(err) {
+		repoLifecycleError(ctx, "load repository", err)
+		return
+	}
+
+	doer := loadDoer(ctx, owner)
+	if doer == nil {
+		return
+	}
+	opts := repo_service.CreateRepoOptions{GitAttributes: repo_service.LFSGitAttributes(form.LFSPatterns)}
+	opts.Name = form.Name
+	opts.Description = form.Description
+	opts.DefaultBranch = form.DefaultBranch
+	opts.IsPrivate = form.Private
+	opts.AutoInit = form.AutoInit || len(form.LFSPatterns) > 0
+	opts.Readme = "Default"
+	repo, err = repo_service.CreateRepository(ctx, doer, owner, opts)
+	if err != nil {
+		repoLifecycleError(ctx, "create repository", err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, toRepoInfo(repo))
+}
+
+// RenameRepo renames a repository, the old name redirects to the repository afterwards
+func RenameRepo(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*RenameRepoOption)
+	owner, repo, ok := loadLifecycleRepo(ctx, true)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "rename repository", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	doer := loadDoer(ctx, owner)
+	if doer == nil {
+		return
+	}
+	if err := repo_service.RenameRepository(ctx, doer, repo, form.NewName); err != nil {
+		repoLifecycleError(ctx, "rename repository", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toRepoInfo(repo))
+}
+
+// TransferRepo transfers a repository to another owner, the old owner redirects to the repository afterwards
+func TransferRepo(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*TransferRepoOption)
+	owner, repo, ok := loadLifecycleRepo(ctx, true)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "transfer repository", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	newOwner, err := user_model.GetUserByName(ctx, form.NewOwner)
+	if err != nil {
+		repoLifecycleError(ctx, "load new owner", err)
+		return
+	}
+	doer := loadDoer(ctx, owner)
+	if doer == nil {
+		return
+	}
+	if err := repo_service.TransferRepository(ctx, doer, newOwner, repo); err != nil {
+		repoLifecycleError(ctx, "transfer repository", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toRepoInfo(repo))
+}
+
+// ArchiveRepo makes a repository read-only
+func ArchiveRepo(ctx *context.PrivateContext) {
+	setRepoArchived(ctx, true)
+}
+
+// UnarchiveRepo makes an archived repository writable again
+func UnarchiveRepo(ctx *context.PrivateContext) {
+	setRepoArchived(ctx, false)
+}
+
+func setRepoArchived(ctx *context.PrivateContext, archived bool) {
+	owner, repo, ok := loadLifecycleRepo(ctx, true)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "archive repository", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	if err := repo_service.SetRepositoryArchived(ctx, repo, archived); err != nil {
+		repoLifecycleError(ctx, "archive repository", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, toRepoInfo(repo))
+}
+
+// DeleteRepo deletes a repository, deleting a repository which doesn't exist succeeds.
+// Redirects aren't followed so that a stale name never deletes the repository it was renamed to.
+func DeleteRepo(ctx *context.PrivateContext) {
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return
+	} else if repo == nil {
+		ctx.PlainText(http.StatusOK, "success")
+		return
+	}
+	doer := loadDoer(ctx, owner)
+	if doer == nil {
+		return
+	}
+	if err := repo_service.DeleteRepository(ctx, doer, repo); err != nil {
+		repoLifecycleError(ctx, "delete repository", err)
+		return
+	}
+	ctx.PlainText(http.StatusOK, "success")
+}
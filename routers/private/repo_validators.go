@@ -0,0 +1,77 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/web"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/modules/validator"
+)
+
+// RepoValidatorsOption are the content validators enabled for the protected branches of a repository
+type RepoValidatorsOption struct {
+	Validators []string `json:"validators"`
+}
+
+// RepoValidatorsInfo is the response of the repository validators APIs
+type RepoValidatorsInfo struct {
+	Validators []string `json:"validators"`
+	Available  []string `json:"available"`
+}
+
+func respondRepoValidators(ctx *context.PrivateContext, repo *repo_model.Repository) {
+	names, err := repo_model.GetRepoValidators(ctx, repo.ID)
+	if err != nil {
+		repoLifecycleError(ctx, "get repository validators", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, &RepoValidatorsInfo{
+		Validators: names,
+		Available:  validator.Names(),
+	})
+}
+
+// GetRepoValidators returns the content validators enabled for a repository
+func GetRepoValidators(ctx *context.PrivateContext) {
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "get repository validators", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	respondRepoValidators(ctx, repo)
+}
+
+// SetRepoValidators replaces the content validators enabled for a repository
+func SetRepoValidators(ctx *context.PrivateContext) {
+	form := web.GetForm(ctx).(*RepoValidatorsOption)
+	owner, repo, ok := loadLifecycleRepo(ctx, false)
+	if !ok {
+		return
+	} else if repo == nil {
+		repoLifecycleError(ctx, "set repository validators", repo_model.ErrRepoNotExist{OwnerName: owner.Name, Name: ctx.Params(":repo")})
+		return
+	}
+	for _, name := range form.Validators {
+		if _, ok := validator.Get(name); !ok {
+			ctx.JSON(http.StatusUnprocessableEntity, private.Response{
+				Err:     fmt.Sprintf("Unknown validator %q", name),
+				UserMsg: fmt.Sprintf("unknown validator %q", name),
+			})
+			return
+		}
+	}
+	if err := repo_model.SetRepoValidators(ctx, repo.ID, form.Validators); err != nil {
+		repoLifecycleError(ctx, "set repository validators", err)
+		return
+	}
+	respondRepoValidators(ctx, repo)
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package private
+
+import (
+	"fmt"
+	"net/http"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/timeutil"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/services/transfer"
+)
+
+// TransferStats returns the hourly transfer aggregates of repositories.
+// since and until are unix timestamps, the aggregates of this server are flushed first.
+func TransferStats(ctx *context.PrivateContext) {
+	transfer.Flush(ctx)
+
+	stats, err := repo_model.FindTransferStats(ctx, &repo_model.FindTransferStatsOptions{
+		OwnerName: ctx.FormString("owner"),
+		RepoID:    ctx.FormInt64("repo_id"),
+		Service:   ctx.FormString("service"),
+		Since:     timeutil.TimeStamp(ctx.FormInt64("since")),
+		Until:     timeutil.TimeStamp(ctx.FormInt64("until")),
+	})
+	if err != nil {
+		log.Error("Unable to find transfer stats: %v", err)
+		ctx.JSON(http.StatusInternalServerError, private.Response{
+			Err: fmt.Sprintf("Unable to find transfer stats: %v", err),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, stats)
+}
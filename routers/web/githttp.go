@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"code.gitea.io/gitea/modules/context"
+	"github.com/openmerlin/gitea_data/modules/ratelimit"
 	"github.com/openmerlin/gitea_data/modules/setting"
 	"code.gitea.io/gitea/modules/web"
 	context_service "code.gitea.io/gitea/services/context"
@@ -39,5 +40,5 @@ func gitHTTPRouters(m *web.Route) {
 		m.GetOptions("/objects/{head:[0-9a-f]{2}}/{hash:[0-9a-f]{38}}", repo.GetLooseObject)
 		m.GetOptions("/objects/pack/pack-{file:[0-9a-f]{40}}.pack", repo.GetPackFile)
 		m.GetOptions("/objects/pack/pack-{file:[0-9a-f]{40}}.idx", repo.GetIdxFile)
-	}, ignSignInAndCsrf, requireSignIn, repo.HTTPGitEnabledHandler, repo.CorsHandler(), context_service.UserAssignmentWeb())
+	}, ignSignInAndCsrf, requireSignIn, ratelimit.Middleware("git"), repo.HTTPGitEnabledHandler, repo.CorsHandler(), context_service.UserAssignmentWeb())
 }
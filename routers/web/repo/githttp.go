@@ -8,7 +8,9 @@ import (
 	"bytes"
 	"compress/gzip"
 	gocontext "context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path"
@@ -19,6 +21,7 @@ import (
 	"time"
 
 	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/process"
 	"code.gitea.io/gitea/modules/structs"
 
 	actions_model "github.com/openmerlin/gitea_data/models/actions"
@@ -27,12 +30,19 @@ import (
 	access_model "github.com/openmerlin/gitea_data/models/perm/access"
 	repo_model "github.com/openmerlin/gitea_data/models/repo"
 	"github.com/openmerlin/gitea_data/models/unit"
+	"github.com/openmerlin/gitea_data/modules/admission"
 	"github.com/openmerlin/gitea_data/modules/context"
 	"github.com/openmerlin/gitea_data/modules/git"
+	"github.com/openmerlin/gitea_data/modules/packcache"
 	repo_module "github.com/openmerlin/gitea_data/modules/repository"
 	"github.com/openmerlin/gitea_data/modules/setting"
+	"github.com/openmerlin/gitea_data/modules/storage"
 	"github.com/openmerlin/gitea_data/modules/util"
+	"github.com/openmerlin/gitea_data/services/dumbhttp"
+	"github.com/openmerlin/gitea_data/services/oidcauth"
+	"github.com/openmerlin/gitea_data/services/pathgrant"
 	repo_service "github.com/openmerlin/gitea_data/services/repository"
+	"github.com/openmerlin/gitea_data/services/transfer"
 
 	"github.com/go-chi/cors"
 )
@@ -82,6 +92,11 @@ func httpBase(ctx *context.Context) *serviceHandler {
 		isPull = ctx.Req.Method == "GET"
 	}
 
+	if !isPull && dumbhttp.IsEdge() {
+		ctx.PlainText(http.StatusForbidden, "This server is a read-only mirror, push to the primary server instead.")
+		return nil
+	}
+
 	var accessMode perm.AccessMode
 	if isPull {
 		accessMode = perm.AccessModeRead
@@ -158,6 +173,15 @@ func httpBase(ctx *context.Context) *serviceHandler {
 			return nil
 		}
 
+		var repoID int64
+		if repoExist {
+			repoID = repo.ID
+		}
+		context.CheckAccessTokenPolicy(ctx, pathgrant.AccessTokenID(ctx), repoID)
+		if ctx.Written() {
+			return nil
+		}
+
 		if ctx.IsBasicAuth && ctx.Data["IsApiToken"] != true && ctx.Data["IsActionsToken"] != true {
 			_, err = auth_model.GetTwoFactorByUID(ctx, ctx.Doer.ID)
 			if err == nil {
@@ -183,7 +207,14 @@ func httpBase(ctx *context.Context) *serviceHandler {
 			repo_module.EnvAppURL + "=" + setting.AppURL,
 		}
 
+		oidcIdentity := oidcauth.GetIdentity(ctx.Data)
 		if repoExist {
+			// the rules of a federated token limit the access before the write permission check is delayed
+			if oidcIdentity != nil && oidcIdentity.RepoMode(repo.OwnerName, repo.Name) < accessMode {
+				ctx.PlainText(http.StatusForbidden, "User permission denied")
+				return nil
+			}
+
 			// Because of special ref "refs/for" .. , need delay write permission check
 			if git.SupportProcReceive {
 				accessMode = perm.AccessModeRead
@@ -214,6 +245,9 @@ func httpBase(ctx *context.Context) *serviceHandler {
 					}
 					environ = append(environ, fmt.Sprintf("%s=%d", repo_module.EnvActionPerm, perm.AccessModeWrite))
 				}
+			} else if oidcIdentity != nil && oidcIdentity.IsVirtual() {
+				// the hooks enforce the access the rules grant, like for actions tasks
+				environ = append(environ, fmt.Sprintf("%s=%d", repo_module.EnvActionPerm, oidcIdentity.RepoMode(repo.OwnerName, repo.Name)))
 			} else {
 				p, err := access_model.GetUserRepoPermission(ctx, repo, ctx.Doer)
 				if err != nil {
@@ -242,6 +276,17 @@ func httpBase(ctx *context.Context) *serviceHandler {
 		} else {
 			environ = append(environ, repo_module.EnvRepoIsWiki+"=false")
 		}
+
+		environ = append(environ,
+			repo_module.EnvAuthMethod+"="+transfer.AuthType(ctx),
+			repo_module.EnvClientIP+"="+context.ClientIP(ctx.Base),
+		)
+		if taskID, ok := ctx.Data["ActionsTaskID"].(int64); ok && ctx.Data["IsActionsToken"] == true {
+			environ = append(environ, fmt.Sprintf("%s=%d", repo_module.EnvAuthID, taskID))
+		} else if tokenID := pathgrant.AccessTokenID(ctx); tokenID > 0 {
+			// the path grants of the token are enforced by pre-receive
+			environ = append(environ, fmt.Sprintf("%s=%d", repo_module.EnvAuthID, tokenID))
+		}
 	}
 
 	if !repoExist {
@@ -250,6 +295,11 @@ func httpBase(ctx *context.Context) *serviceHandler {
 			return nil
 		}
 
+		if oidcauth.GetIdentity(ctx.Data) != nil {
+			ctx.PlainText(http.StatusForbidden, "Push to create is not allowed with federated tokens.")
+			return nil
+		}
+
 		if isWiki { // you cannot send wiki operation before create the repository
 			ctx.PlainText(http.StatusNotFound, "Repository not found")
 			return nil
@@ -295,9 +345,10 @@ func httpBase(ctx *context.Context) *serviceHandler {
 
 	w := ctx.Resp
 	r := ctx.Req
+	// edge servers have no local repositories and only serve the dumb HTTP protocol
 	cfg := &serviceConfig{
-		UploadPack:  true,
-		ReceivePack: true,
+		UploadPack:  !dumbhttp.IsEdge(),
+		ReceivePack: !dumbhttp.IsEdge(),
 		Env:         environ,
 	}
 
@@ -308,7 +359,18 @@ func httpBase(ctx *context.Context) *serviceHandler {
 		dir = repo_model.RepoPath(username, wikiRepoName)
 	}
 
-	return &serviceHandler{cfg, w, r, dir, cfg.Env}
+	return &serviceHandler{
+		cfg:       cfg,
+		w:         w,
+		r:         r,
+		dir:       dir,
+		environ:   cfg.Env,
+		repoID:    repo.ID,
+		ownerName: username,
+		isWiki:    isWiki,
+		userKey:   context.ClientKey(ctx),
+		authType:  transfer.AuthType(ctx),
+	}
 }
 
 var (
@@ -360,11 +422,16 @@ type serviceConfig struct {
 }
 
 type serviceHandler struct {
-	cfg     *serviceConfig
-	w       http.ResponseWriter
-	r       *http.Request
-	dir     string
-	environ []string
+	cfg       *serviceConfig
+	w         http.ResponseWriter
+	r         *http.Request
+	dir       string
+	environ   []string
+	repoID    int64
+	ownerName string
+	isWiki    bool
+	userKey   string // identifies the requester for the per-user admission limit
+	authType  string
 }
 
 func (h *serviceHandler) setHeaderNoCache() {
@@ -401,6 +468,10 @@ func (h *serviceHandler) sendFile(contentType, file string) {
 		h.w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if dumbhttp.IsEdge() {
+		h.sendStoredFile(contentType, file)
+		return
+	}
 	reqFile := path.Join(h.dir, file)
 
 	fi, err := os.Stat(reqFile)
@@ -415,6 +486,32 @@ func (h *serviceHandler) sendFile(contentType, file string) {
 	http.ServeFile(h.w, h.r, reqFile)
 }
 
+// sendStoredFile serves a file of the repository published to the dumb HTTP storage
+func (h *serviceHandler) sendStoredFile(contentType, file string) {
+	p := dumbhttp.ObjectPath(h.repoID, h.isWiki, file)
+	obj, err := storage.DumbHTTP.Open(p)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("Unable to open published file %s: %v", p, err)
+		}
+		h.w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer obj.Close()
+
+	fi, err := obj.Stat()
+	if err != nil {
+		log.Error("Unable to stat published file %s: %v", p, err)
+		h.w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.w.Header().Set("Content-Type", contentType)
+	h.w.Header().Set("Content-Length", fmt.Sprintf("%d", fi.Size()))
+	h.w.Header().Set("Last-Modified", fi.ModTime().Format(http.TimeFormat))
+	http.ServeContent(h.w, h.r, path.Base(file), fi.ModTime(), obj)
+}
+
 // one or more key=value pairs separated by colons
 var safeGitProtocolHeader = regexp.MustCompile(`^[0-9a-zA-Z]+=[0-9a-zA-Z]+(:[0-9a-zA-Z]+=[0-9a-zA-Z]+)*$`)
 
@@ -452,7 +549,20 @@ func serviceRPC(h *serviceHandler, service string) {
 
 	h.w.Header().Set("Content-Type", fmt.Sprintf("application/x-git-%s-result", service))
 
-	reqBody := h.r.Body
+	in := transfer.NewCountingReader(h.r.Body)
+	out := transfer.NewCountingWriter(h.w)
+	defer func() {
+		transfer.Record(transfer.Transfer{
+			RepoID:    h.repoID,
+			OwnerName: h.ownerName,
+			Service:   service,
+			AuthType:  h.authType,
+			BytesIn:   in.Count(),
+			BytesOut:  out.Count(),
+		})
+	}()
+
+	var reqBody io.Reader = in
 
 	// Handle GZIP.
 	if h.r.Header.Get("Content-Encoding") == "gzip" {
@@ -471,22 +581,87 @@ func serviceRPC(h *serviceHandler, service string) {
 		h.environ = append(h.environ, "GIT_PROTOCOL="+protocol)
 	}
 
+	if service == "upload-pack" && packcache.Enabled() {
+		serviceUploadPackCached(h, cmd, reqBody, out)
+		return
+	}
+
+	_ = runServiceCmd(h, cmd, service, reqBody, out)
+}
+
+// runServiceCmd runs the stateless-rpc git command once the pack process is admitted
+func runServiceCmd(h *serviceHandler, cmd *git.Command, service string, stdin io.Reader, stdout io.Writer) error {
+	ticket, err := acquirePackSlot(h, service)
+	if err != nil {
+		return err
+	}
+	defer ticket.Release()
+
 	var stderr bytes.Buffer
 	cmd.AddArguments("--stateless-rpc").AddDynamicArguments(h.dir)
 	cmd.SetDescription(fmt.Sprintf("%s %s %s [repo_path: %s]", git.GitExecutable, service, "--stateless-rpc", h.dir))
 	if err := cmd.Run(&git.RunOpts{
 		Dir:               h.dir,
-		Env:               append(os.Environ(), h.environ...),
-		Stdout:            h.w,
-		Stdin:             reqBody,
+		Env:               repo_module.HiddenRefsEnv(append(os.Environ(), h.environ...)),
+		Stdout:            stdout,
+		Stdin:             stdin,
 		Stderr:            &stderr,
 		UseContextTimeout: true,
 	}); err != nil {
 		if err.Error() != "signal: killed" {
 			log.Error("Fail to serve RPC(%s) in %s: %v - %s", service, h.dir, err, stderr.String())
 		}
+		return err
+	}
+	return nil
+}
+
+// serviceUploadPackCached serves a complete fetch negotiation from the pack cache,
+// identical requests which arrive at the same time share one upload-pack process.
+func serviceUploadPackCached(h *serviceHandler, cmd *git.Command, reqBody io.Reader, out io.Writer) {
+	body, err := io.ReadAll(io.LimitReader(reqBody, setting.PackCache.MaxRequestSize+1))
+	if err != nil {
+		log.Error("Fail to read upload-pack request in %s: %v", h.dir, err)
+		h.w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if int64(len(body)) > setting.PackCache.MaxRequestSize {
+		_ = runServiceCmd(h, cmd, "upload-pack", io.MultiReader(bytes.NewReader(body), reqBody), out)
 		return
 	}
+
+	key, ok := packcache.RequestKey(h.repoID, h.isWiki, h.r.Header.Get("Git-Protocol"), body)
+	if !ok {
+		_ = runServiceCmd(h, cmd, "upload-pack", bytes.NewReader(body), out)
+		return
+	}
+
+	if err := packcache.GetCache().Serve(h.r.Context(), h.repoID, h.isWiki, key, out, func(w io.Writer) error {
+		return runServiceCmd(h, cmd, "upload-pack", bytes.NewReader(body), w)
+	}); err != nil {
+		log.Debug("Fail to serve cached upload-pack in %s: %v", h.dir, err)
+	}
+}
+
+// acquirePackSlot waits until the pack process is admitted, the error response has been written if it fails
+func acquirePackSlot(h *serviceHandler, service string) (*admission.Ticket, error) {
+	if admission.PackController() == nil {
+		return nil, nil
+	}
+
+	ctx, _, finished := process.GetManager().AddContext(h.r.Context(), fmt.Sprintf("Waiting for %s slot [repo_path: %s]", service, h.dir))
+	defer finished()
+
+	ticket, err := admission.AcquirePack(ctx, h.repoID, h.userKey)
+	if err != nil {
+		if errors.Is(err, admission.ErrQueueFull) || errors.Is(err, admission.ErrQueueTimeout) {
+			log.Warn("Unable to admit %s in %s for %s: %v", service, h.dir, h.userKey, err)
+			h.w.Header().Set("Retry-After", strconv.Itoa(int(setting.PackAdmission.RetryAfter.Seconds())))
+			h.w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return nil, err
+	}
+	return ticket, nil
 }
 
 // ServiceUploadPack implements Git Smart HTTP protocol
@@ -513,12 +688,10 @@ func getServiceType(r *http.Request) string {
 	return strings.TrimPrefix(serviceType, "git-")
 }
 
-func updateServerInfo(ctx gocontext.Context, dir string) []byte {
-	out, _, err := git.NewCommand(ctx, "update-server-info").RunStdBytes(&git.RunOpts{Dir: dir})
-	if err != nil {
-		log.Error(fmt.Sprintf("%v - %s", err, string(out)))
+func updateServerInfo(ctx gocontext.Context, dir string) {
+	if err := repo_module.UpdateServerInfo(ctx, dir); err != nil {
+		log.Error("%v", err)
 	}
-	return out
 }
 
 func packetWrite(str string) []byte {
@@ -542,7 +715,7 @@ func GetInfoRefs(ctx *context.Context) {
 		if protocol := h.r.Header.Get("Git-Protocol"); protocol != "" && safeGitProtocolHeader.MatchString(protocol) {
 			h.environ = append(h.environ, "GIT_PROTOCOL="+protocol)
 		}
-		h.environ = append(os.Environ(), h.environ...)
+		h.environ = repo_module.HiddenRefsEnv(append(os.Environ(), h.environ...))
 
 		refs, _, err := cmd.AddArguments("--stateless-rpc", "--advertise-refs", ".").RunStdBytes(&git.RunOpts{Env: h.environ, Dir: h.dir})
 		if err != nil {
@@ -555,7 +728,9 @@ func GetInfoRefs(ctx *context.Context) {
 		_, _ = h.w.Write([]byte("0000"))
 		_, _ = h.w.Write(refs)
 	} else {
-		updateServerInfo(ctx, h.dir)
+		if !dumbhttp.IsEdge() {
+			updateServerInfo(ctx, h.dir)
+		}
 		h.sendFile("text/plain; charset=utf-8", "info/refs")
 	}
 }
@@ -0,0 +1,78 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	auth_model "code.gitea.io/gitea/models/auth"
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/models/unittest"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/contexttest"
+	"code.gitea.io/gitea/modules/timeutil"
+	"code.gitea.io/gitea/routers/common"
+	auth_service "code.gitea.io/gitea/services/auth"
+
+	data_auth_model "github.com/openmerlin/gitea_data/models/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPBaseAccessTokenPolicy(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+
+	token := &auth_model.AccessToken{UID: 2, Name: "git-policy", Scope: auth_model.AccessTokenScopeAll}
+	require.NoError(t, auth_model.NewAccessToken(db.DefaultContext, token))
+	owner := unittest.AssertExistsAndLoadBean(t, &user_model.User{ID: 2})
+
+	// httpBase returns the handler of an accepted request
+	push := func(t *testing.T, reponame, authorization string) (bool, int) {
+		ctx, resp := contexttest.MockContext(t, "POST /user2/"+reponame+"/git-receive-pack")
+		ctx.Req.Header = http.Header{"Authorization": []string{authorization}}
+		ctx.SetParams(":username", "user2")
+		ctx.SetParams(":reponame", reponame)
+		ctx.ContextUser = owner
+
+		ar, err := common.AuthShared(ctx.Base, nil, auth_service.NewGroup(&auth_service.OAuth2{}, &auth_service.Basic{}))
+		require.NoError(t, err)
+		require.NotNil(t, ar.Doer)
+		ctx.Doer, ctx.IsSigned, ctx.IsBasicAuth = ar.Doer, true, ar.IsBasicAuth
+
+		return httpBase(ctx) != nil, resp.Code
+	}
+	setPolicy := func(t *testing.T, policy *data_auth_model.AccessTokenPolicy) {
+		policy.TokenID = token.ID
+		require.NoError(t, data_auth_model.SetAccessTokenPolicy(db.DefaultContext, policy))
+	}
+
+	for name, authorization := range map[string]string{
+		"BasicPassword": "Basic " + base64.StdEncoding.EncodeToString([]byte("user2:"+token.Token)),
+		"BasicUsername": "Basic " + base64.StdEncoding.EncodeToString([]byte(token.Token+":x-oauth-basic")),
+		"TokenHeader":   "token " + token.Token,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setPolicy(t, &data_auth_model.AccessTokenPolicy{RepoIDs: []int64{1}})
+			accepted, _ := push(t, "repo1.git", authorization)
+			assert.True(t, accepted)
+
+			accepted, code := push(t, "repo2.git", authorization)
+			assert.False(t, accepted)
+			assert.Equal(t, http.StatusForbidden, code)
+
+			// a token bound to repositories can't create one by pushing
+			accepted, code = push(t, "not-existing.git", authorization)
+			assert.False(t, accepted)
+			assert.Equal(t, http.StatusForbidden, code)
+
+			setPolicy(t, &data_auth_model.AccessTokenPolicy{ExpiresUnix: timeutil.TimeStampNow() - 60})
+			accepted, code = push(t, "repo1.git", authorization)
+			assert.False(t, accepted)
+			assert.Equal(t, http.StatusUnauthorized, code)
+		})
+	}
+}
@@ -17,9 +17,13 @@ import (
 	"code.gitea.io/gitea/routers/common"
 	auth_service "code.gitea.io/gitea/services/auth"
 	auth_model "github.com/openmerlin/gitea_data/models/auth"
+	"github.com/openmerlin/gitea_data/modules/admission"
+	"github.com/openmerlin/gitea_data/modules/ratelimit"
 	"github.com/openmerlin/gitea_data/modules/setting"
 	"github.com/openmerlin/gitea_data/routers/web/misc"
 	"github.com/openmerlin/gitea_data/services/lfs"
+	"github.com/openmerlin/gitea_data/services/oidcauth"
+	"github.com/openmerlin/gitea_data/services/transfer"
 
 	_ "code.gitea.io/gitea/modules/session" // to registers all internal adapters
 
@@ -63,6 +67,7 @@ func CorsHandler() func(next http.Handler) http.Handler {
 func buildAuthGroup() *auth_service.Group {
 	group := auth_service.NewGroup(
 		&auth_service.OAuth2{}, // FIXME: this should be removed and only applied in download and oauth related routers
+		&oidcauth.Method{},     // before Basic, which would try a federated token as a password
 		&auth_service.Basic{},  // FIXME: this should be removed and only applied in download and git/lfs routers
 		&auth_service.Session{},
 	)
@@ -209,6 +214,13 @@ func Routes() *web.Route {
 
 	if setting.Metrics.Enabled {
 		prometheus.MustRegister(metrics.NewCollector())
+		prometheus.MustRegister(transfer.NewCollector())
+		if setting.PackAdmission.Enabled {
+			prometheus.MustRegister(admission.NewCollector())
+		}
+		if setting.RateLimit.Enabled {
+			prometheus.MustRegister(ratelimit.NewCollector())
+		}
 		routes.Get("/metrics", append(mid, Metrics)...)
 	}
 
@@ -252,23 +264,28 @@ func registerRoutes(m *web.Route) {
 		m.Get("/swagger.v1.json", SwaggerV1Json)
 	}
 
+	// the public keys verifying LFS tokens, for services which accept them too
+	m.Get("/.well-known/lfs-jwks.json", lfsServerEnabled, lfs.JWKSHandler)
+
 	m.Group("/{username}", func() {
 		m.Group("/{reponame}", func() {
 			m.Group("/info/lfs", func() {
-				m.Post("/objects/batch", lfs.CheckAcceptMediaType, lfs.BatchHandler)
-				m.Put("/objects/{oid}/{size}", lfs.UploadHandler)
-				m.Get("/objects/{oid}/{filename}", lfs.DownloadHandler)
-				m.Get("/objects/{oid}", lfs.DownloadHandler)
-				m.Post("/verify", lfs.CheckAcceptMediaType, lfs.VerifyHandler)
-				m.Group("/locks", func() {
-					m.Get("/", lfs.GetListLockHandler)
-					m.Post("/", lfs.PostLockHandler)
-					m.Post("/verify", lfs.VerifyLockHandler)
-					m.Post("/{lid}/unlock", lfs.UnLockHandler)
-				}, lfs.CheckAcceptMediaType)
-				m.Any("/*", func(ctx *context.Context) {
-					ctx.NotFound("", nil)
-				})
+				m.Get("/objects/{oid}/{filename}", ratelimit.Middleware("lfs_download"), lfs.DownloadHandler)
+				m.Get("/objects/{oid}", ratelimit.Middleware("lfs_download"), lfs.DownloadHandler)
+				m.Group("", func() {
+					m.Post("/objects/batch", lfs.CheckAcceptMediaType, lfs.BatchHandler)
+					m.Put("/objects/{oid}/{size}", lfs.UploadHandler)
+					m.Post("/verify", lfs.CheckAcceptMediaType, lfs.VerifyHandler)
+					m.Group("/locks", func() {
+						m.Get("/", lfs.GetListLockHandler)
+						m.Post("/", lfs.PostLockHandler)
+						m.Post("/verify", lfs.VerifyLockHandler)
+						m.Post("/{lid}/unlock", lfs.UnLockHandler)
+					}, lfs.CheckAcceptMediaType)
+					m.Any("/*", func(ctx *context.Context) {
+						ctx.NotFound("", nil)
+					})
+				}, ratelimit.Middleware("lfs"))
 			}, ignSignInAndCsrf, lfsServerEnabled)
 
 			gitHTTPRouters(m)
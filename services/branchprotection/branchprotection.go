@@ -0,0 +1,330 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package branchprotection manages the protected branch rules of a repository as code: an admin enables it,
+// after which the rules are replaced by those of the file on the default branch whenever it changes.
+package branchprotection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/models/organization"
+	access_model "code.gitea.io/gitea/models/perm/access"
+	repo_model "code.gitea.io/gitea/models/repo"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	"github.com/openmerlin/gitea_data/modules/branchprotection"
+	"github.com/openmerlin/gitea_data/modules/validator"
+)
+
+// ErrNotAdmin is returned when a user who isn't an admin of the repository tries to enable or disable the management
+var ErrNotAdmin = errors.New("only an admin of the repository can manage its branch protection as code")
+
+// ErrInvalidFile is returned for a file which can't be applied
+type ErrInvalidFile struct {
+	Problems []validator.Problem
+}
+
+func (err ErrInvalidFile) Error() string {
+	msgs := make([]string, 0, len(err.Problems))
+	for _, problem := range err.Problems {
+		msgs = append(msgs, problem.String())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// IsErrInvalidFile checks if an error is a ErrInvalidFile
+func IsErrInvalidFile(err error) bool {
+	_, ok := err.(ErrInvalidFile)
+	return ok
+}
+
+// IsAdmin returns whether the user may enable or disable the management as code of the repository
+func IsAdmin(ctx context.Context, repo *repo_model.Repository, user *user_model.User) (bool, error) {
+	if user.IsAdmin {
+		return true, nil
+	}
+	perm, err := access_model.GetUserRepoPermission(ctx, repo, user)
+	if err != nil {
+		return false, err
+	}
+	return perm.IsAdmin(), nil
+}
+
+// BlobID returns the ID of the file at commitID, or an empty string if the commit doesn't have it.
+// env is passed to git, e.g. to read the objects of a push in pre-receive.
+func BlobID(ctx context.Context, repoPath, commitID string, env []string) (string, error) {
+	if commitID == "" || commitID == git.EmptySHA {
+		return "", nil
+	}
+	stdout, _, err := git.NewCommand(ctx, "rev-parse", "--verify", "--quiet").AddDynamicArguments(commitID + ":" + branchprotection.FilePath).RunStdString(&git.RunOpts{Dir: repoPath, Env: env})
+	if err != nil {
+		if err.IsExitCode(1) {
+			return "", nil
+		}
+		return "", fmt.Errorf("rev-parse %s: %w", branchprotection.FilePath, err)
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// resolvedRule is a rule of the file with the IDs of the users and teams it names
+type resolvedRule struct {
+	*branchprotection.Rule
+	whitelist git_model.WhitelistOptions
+}
+
+// resolver maps the names of the users and teams of a file to their IDs, collecting the unknown names
+type resolver struct {
+	ctx      context.Context
+	repo     *repo_model.Repository
+	problems []validator.Problem
+	err      error
+}
+
+func (r *resolver) addProblem(rule *branchprotection.Rule, format string, args ...any) {
+	r.problems = append(r.problems, validator.Problem{Path: branchprotection.FilePath, Line: rule.Line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *resolver) userIDs(rule *branchprotection.Rule, field string, names []string) []int64 {
+	ids := make([]int64, 0, len(names))
+	for _, name := range names {
+		u, err := user_model.GetUserByName(r.ctx, name)
+		if err != nil {
+			if user_model.IsErrUserNotExist(err) {
+				r.addProblem(rule, "%s: user %q does not exist", field, name)
+			} else if r.err == nil {
+				r.err = err
+			}
+			continue
+		}
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
+
+func (r *resolver) teamIDs(rule *branchprotection.Rule, field string, names []string) []int64 {
+	if len(names) == 0 {
+		return nil
+	}
+	if !r.repo.Owner.IsOrganization() {
+		r.addProblem(rule, "%s: only the repositories of organizations have teams", field)
+		return nil
+	}
+	ids := make([]int64, 0, len(names))
+	for _, name := range names {
+		team, err := organization.GetTeam(r.ctx, r.repo.OwnerID, name)
+		if err != nil {
+			if organization.IsErrTeamNotExist(err) {
+				r.addProblem(rule, "%s: team %q does not exist", field, name)
+			} else if r.err == nil {
+				r.err = err
+			}
+			continue
+		}
+		ids = append(ids, team.ID)
+	}
+	return ids
+}
+
+// resolve parses the file and maps the names it contains. The file must keep a rule for the default branch,
+// otherwise a push of the file could lift the protection of the branch the file itself is protected by.
+func resolve(ctx context.Context, repo *repo_model.Repository, content []byte) ([]*resolvedRule, error) {
+	file, problems := branchprotection.Parse(content)
+	if len(problems) > 0 {
+		return nil, ErrInvalidFile{Problems: problems}
+	}
+	if err := repo.LoadOwner(ctx); err != nil {
+		return nil, err
+	}
+
+	r := &resolver{ctx: ctx, repo: repo}
+	rules := make([]*resolvedRule, 0, len(file.Rules))
+	protectsDefault := false
+	for _, rule := range file.Rules {
+		rules = append(rules, &resolvedRule{
+			Rule: rule,
+			whitelist: git_model.WhitelistOptions{
+				UserIDs:          r.userIDs(rule, "push_whitelist_usernames", rule.PushWhitelistUsernames),
+				TeamIDs:          r.teamIDs(rule, "push_whitelist_teams", rule.PushWhitelistTeams),
+				MergeUserIDs:     r.userIDs(rule, "merge_whitelist_usernames", rule.MergeWhitelistUsernames),
+				MergeTeamIDs:     r.teamIDs(rule, "merge_whitelist_teams", rule.MergeWhitelistTeams),
+				ApprovalsUserIDs: r.userIDs(rule, "approvals_whitelist_usernames", rule.ApprovalsWhitelistUsernames),
+				ApprovalsTeamIDs: r.teamIDs(rule, "approvals_whitelist_teams", rule.ApprovalsWhitelistTeams),
+			},
+		})
+		if (&git_model.ProtectedBranch{RuleName: rule.RuleName}).Match(repo.DefaultBranch) {
+			protectsDefault = true
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !protectsDefault {
+		r.problems = append(r.problems, validator.Problem{
+			Path:    branchprotection.FilePath,
+			Message: fmt.Sprintf("a rule must match the default branch %s", repo.DefaultBranch),
+		})
+	}
+	if len(r.problems) > 0 {
+		return nil, ErrInvalidFile{Problems: r.problems}
+	}
+	return rules, nil
+}
+
+// Check validates the file at commitID without applying it. A missing file is invalid,
+// the rules of a repository managed as code can't be removed by deleting it.
+func Check(ctx context.Context, repo *repo_model.Repository, commitID string, env []string) error {
+	blobID, err := BlobID(ctx, repo.RepoPath(), commitID, env)
+	if err != nil {
+		return err
+	}
+	content, err := readBlob(ctx, repo.RepoPath(), blobID, env)
+	if err != nil {
+		return err
+	}
+	_, err = resolve(ctx, repo, content)
+	return err
+}
+
+func readBlob(ctx context.Context, repoPath, blobID string, env []string) ([]byte, error) {
+	if blobID == "" {
+		return nil, ErrInvalidFile{Problems: []validator.Problem{{
+			Path:    branchprotection.FilePath,
+			Message: "the file is required while the branch protection is managed as code",
+		}}}
+	}
+	stdout, _, err := git.NewCommand(ctx, "cat-file", "blob").AddDynamicArguments(blobID).RunStdBytes(&git.RunOpts{Dir: repoPath, Env: env})
+	if err != nil {
+		return nil, fmt.Errorf("cat-file %s: %w", blobID, err)
+	}
+	return stdout, nil
+}
+
+func toProtectedBranch(repo *repo_model.Repository, rule *resolvedRule) *git_model.ProtectedBranch {
+	return &git_model.ProtectedBranch{
+		RepoID:                        repo.ID,
+		RuleName:                      rule.RuleName,
+		CanPush:                       rule.EnablePush,
+		EnableWhitelist:               rule.EnablePushWhitelist,
+		WhitelistDeployKeys:           rule.PushWhitelistDeployKeys,
+		EnableMergeWhitelist:          rule.EnableMergeWhitelist,
+		EnableStatusCheck:             rule.EnableStatusCheck,
+		StatusCheckContexts:           rule.StatusCheckContexts,
+		StatusCheckOnPush:             rule.StatusCheckOnPush,
+		StatusCheckPushParent:         rule.StatusCheckPushParent,
+		EnableApprovalsWhitelist:      rule.EnableApprovalsWhitelist,
+		RequiredApprovals:             rule.RequiredApprovals,
+		BlockOnRejectedReviews:        rule.BlockOnRejectedReviews,
+		BlockOnOfficialReviewRequests: rule.BlockOnOfficialReviewRequests,
+		BlockOnOutdatedBranch:         rule.BlockOnOutdatedBranch,
+		DismissStaleApprovals:         rule.DismissStaleApprovals,
+		RequireSignedCommits:          rule.RequireSignedCommits,
+		ProtectedFilePatterns:         rule.ProtectedFilePatterns,
+		UnprotectedFilePatterns:       rule.UnprotectedFilePatterns,
+	}
+}
+
+// apply replaces the protected branch rules of the repository with those of the file
+func apply(ctx context.Context, repo *repo_model.Repository, rules []*resolvedRule) error {
+	return db.WithTx(ctx, func(ctx context.Context) error {
+		existing, err := git_model.FindRepoProtectedBranchRules(ctx, repo.ID)
+		if err != nil {
+			return err
+		}
+		byName := make(map[string]*git_model.ProtectedBranch, len(existing))
+		for _, pb := range existing {
+			byName[strings.ToLower(pb.RuleName)] = pb
+		}
+
+		for _, rule := range rules {
+			pb := toProtectedBranch(repo, rule)
+			if old, ok := byName[strings.ToLower(rule.RuleName)]; ok {
+				pb.ID = old.ID
+				pb.CreatedUnix = old.CreatedUnix
+				delete(byName, strings.ToLower(rule.RuleName))
+			}
+			if err := git_model.UpdateProtectBranch(ctx, repo, pb, rule.whitelist); err != nil {
+				return fmt.Errorf("update rule %s: %w", rule.RuleName, err)
+			}
+		}
+		for _, pb := range byName {
+			if err := git_model.DeleteProtectedBranch(ctx, repo, pb.ID); err != nil {
+				return fmt.Errorf("delete rule %s: %w", pb.RuleName, err)
+			}
+		}
+		return nil
+	})
+}
+
+// applyAt applies the file at commitID unless it has been applied already
+func applyAt(ctx context.Context, repo *repo_model.Repository, enabledByID int64, commitID string, state *git_model.BranchProtectionFile) error {
+	repoPath := repo.RepoPath()
+	blobID, err := BlobID(ctx, repoPath, commitID, nil)
+	if err != nil {
+		return err
+	}
+	if state != nil && state.AppliedBlobID == blobID {
+		return nil
+	}
+	content, err := readBlob(ctx, repoPath, blobID, nil)
+	if err != nil {
+		return err
+	}
+	rules, err := resolve(ctx, repo, content)
+	if err != nil {
+		return err
+	}
+	if err := apply(ctx, repo, rules); err != nil {
+		return err
+	}
+	log.Info("Applied the branch protection of %s from %s at %s", repo.FullName(), branchprotection.FilePath, commitID)
+	return git_model.SaveBranchProtectionFile(ctx, repo.ID, enabledByID, commitID, blobID)
+}
+
+// Apply applies the file pushed at commitID of the default branch, if the repository is managed as code.
+// A failure is kept in the state of the repository until a file is applied, as the push can't be rejected anymore.
+func Apply(ctx context.Context, repo *repo_model.Repository, commitID string) error {
+	state, err := git_model.GetBranchProtectionFile(ctx, repo.ID)
+	if err != nil || state == nil {
+		return err
+	}
+	if err := applyAt(ctx, repo, state.EnabledByID, commitID, state); err != nil {
+		if saveErr := git_model.SaveBranchProtectionFileError(ctx, repo.ID, commitID, err.Error()); saveErr != nil {
+			log.Error("Unable to save the error of %s of %s: %v", branchprotection.FilePath, repo.FullName(), saveErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Enable makes the file on the default branch manage the protected branch rules of the repository, applying it
+// right away. It's also used to apply the file again, e.g. after a user or team it names has been created.
+func Enable(ctx context.Context, doer *user_model.User, repo *repo_model.Repository) error {
+	if ok, err := IsAdmin(ctx, repo, doer); err != nil {
+		return err
+	} else if !ok {
+		return ErrNotAdmin
+	}
+	commitID, _, err := git.NewCommand(ctx, "rev-parse", "--verify").AddDynamicArguments(git.BranchPrefix + repo.DefaultBranch).RunStdString(&git.RunOpts{Dir: repo.RepoPath()})
+	if err != nil {
+		return fmt.Errorf("rev-parse %s: %w", repo.DefaultBranch, err)
+	}
+	return applyAt(ctx, repo, doer.ID, strings.TrimSpace(commitID), nil)
+}
+
+// Disable stops managing the protected branch rules of the repository as code, the current rules are kept
+func Disable(ctx context.Context, doer *user_model.User, repo *repo_model.Repository) error {
+	if ok, err := IsAdmin(ctx, repo, doer); err != nil {
+		return err
+	} else if !ok {
+		return ErrNotAdmin
+	}
+	return git_model.DeleteBranchProtectionFile(ctx, repo.ID)
+}
@@ -0,0 +1,236 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package branchprotection
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/git"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runGit(t *testing.T, repoPath, stdin string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", repoPath}, args...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.Output()
+	require.NoError(t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
+
+// commitFile returns a new commit whose tree only has the rule file with content, or nothing if content is empty
+func commitFile(t *testing.T, repoPath, content string) string {
+	var rootEntries string
+	if content != "" {
+		blob := runGit(t, repoPath, content, "hash-object", "-w", "--stdin")
+		dir := runGit(t, repoPath, "100644 blob "+blob+"\tbranch-protection.yaml\n", "mktree")
+		rootEntries = "040000 tree " + dir + "\t.gitea\n"
+	}
+	root := runGit(t, repoPath, rootEntries, "mktree")
+	return runGit(t, repoPath, "", "commit-tree", root, "-m", "branch protection")
+}
+
+// setDefaultBranch points a new branch at commitID and makes it the default branch of repo, in memory only
+func setDefaultBranch(t *testing.T, repo *repo_model.Repository, branchName, commitID string) {
+	runGit(t, repo.RepoPath(), "", "update-ref", git.BranchPrefix+branchName, commitID)
+	repo.DefaultBranch = branchName
+}
+
+func ruleNames(t *testing.T, repoID int64) []string {
+	rules, err := git_model.FindRepoProtectedBranchRules(db.DefaultContext, repoID)
+	require.NoError(t, err)
+	names := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		names = append(names, rule.RuleName)
+	}
+	return names
+}
+
+func TestResolve(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	repo1 := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	repo3 := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 3})
+
+	t.Run("Valid", func(t *testing.T) {
+		rules, err := resolve(db.DefaultContext, repo1, []byte(`rules:
+  - rule_name: master
+    enable_push: true
+    enable_push_whitelist: true
+    push_whitelist_usernames: [user2, user4]
+    enable_approvals_whitelist: true
+    approvals_whitelist_usernames: [user5]
+  - rule_name: release/*
+`))
+		require.NoError(t, err)
+		require.Len(t, rules, 2)
+		assert.Equal(t, []int64{2, 4}, rules[0].whitelist.UserIDs)
+		assert.Equal(t, []int64{5}, rules[0].whitelist.ApprovalsUserIDs)
+		assert.Empty(t, rules[0].whitelist.TeamIDs)
+		assert.Equal(t, "release/*", rules[1].RuleName)
+	})
+
+	t.Run("Teams", func(t *testing.T) {
+		// repo3 belongs to the organization user3
+		rules, err := resolve(db.DefaultContext, repo3, []byte("rules:\n  - rule_name: \"*\"\n    enable_merge_whitelist: true\n    merge_whitelist_teams: [team1]\n"))
+		require.NoError(t, err)
+		require.Len(t, rules, 1)
+		assert.Equal(t, []int64{2}, rules[0].whitelist.MergeTeamIDs)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := resolve(db.DefaultContext, repo1, []byte(`rules:
+  - rule_name: master
+    enable_push: true
+    enable_push_whitelist: true
+    push_whitelist_usernames: [no-such-user]
+    enable_merge_whitelist: true
+    merge_whitelist_teams: [team1]
+`))
+		require.True(t, IsErrInvalidFile(err), "%v", err)
+		assert.Equal(t, []string{
+			`push_whitelist_usernames: user "no-such-user" does not exist`,
+			"merge_whitelist_teams: only the repositories of organizations have teams",
+		}, problemMessages(err))
+
+		// the teams of repo3 are looked up in its organization
+		_, err = resolve(db.DefaultContext, repo3, []byte("rules:\n  - rule_name: \"*\"\n    enable_push: true\n    enable_push_whitelist: true\n    push_whitelist_teams: [no-such-team]\n"))
+		require.True(t, IsErrInvalidFile(err), "%v", err)
+		assert.Equal(t, []string{`push_whitelist_teams: team "no-such-team" does not exist`}, problemMessages(err))
+
+		_, err = resolve(db.DefaultContext, repo1, []byte("rules: [\n"))
+		assert.True(t, IsErrInvalidFile(err), "%v", err)
+	})
+
+	t.Run("DefaultBranchUnprotected", func(t *testing.T) {
+		_, err := resolve(db.DefaultContext, repo1, []byte("rules:\n  - rule_name: release/*\n"))
+		require.True(t, IsErrInvalidFile(err), "%v", err)
+		assert.Equal(t, []string{"a rule must match the default branch master"}, problemMessages(err))
+
+		_, err = resolve(db.DefaultContext, repo1, []byte("rules: []\n"))
+		assert.True(t, IsErrInvalidFile(err), "%v", err)
+	})
+}
+
+func problemMessages(err error) []string {
+	msgs := make([]string, 0, len(err.(ErrInvalidFile).Problems))
+	for _, problem := range err.(ErrInvalidFile).Problems {
+		msgs = append(msgs, problem.Message)
+	}
+	return msgs
+}
+
+func TestApply(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+
+	rules, err := resolve(db.DefaultContext, repo, []byte(`rules:
+  - rule_name: master
+    enable_push: true
+    enable_push_whitelist: true
+    push_whitelist_usernames: [user2]
+  - rule_name: release/*
+    required_approvals: 1
+`))
+	require.NoError(t, err)
+	require.NoError(t, apply(db.DefaultContext, repo, rules))
+	assert.ElementsMatch(t, []string{"master", "release/*"}, ruleNames(t, repo.ID))
+	master, err := git_model.GetProtectedBranchRuleByName(db.DefaultContext, repo.ID, "master")
+	require.NoError(t, err)
+	assert.True(t, master.CanPush)
+	assert.True(t, master.EnableWhitelist)
+	assert.Equal(t, []int64{2}, master.WhitelistUserIDs)
+
+	// the rules of the file replace the others, a rule which is kept is updated in place
+	rules, err = resolve(db.DefaultContext, repo, []byte("rules:\n  - rule_name: master\n    required_approvals: 2\n"))
+	require.NoError(t, err)
+	require.NoError(t, apply(db.DefaultContext, repo, rules))
+	assert.Equal(t, []string{"master"}, ruleNames(t, repo.ID))
+	updated, err := git_model.GetProtectedBranchRuleByName(db.DefaultContext, repo.ID, "master")
+	require.NoError(t, err)
+	assert.Equal(t, master.ID, updated.ID)
+	assert.EqualValues(t, 2, updated.RequiredApprovals)
+	assert.False(t, updated.CanPush)
+	assert.Empty(t, updated.WhitelistUserIDs)
+}
+
+func TestEnableDisable(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	t.Cleanup(func() { removeBranchProtectionFile(t, 1) })
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	owner := unittest.AssertExistsAndLoadBean(t, &user_model.User{ID: 2})
+	// user4 can only read the public repo1
+	reader := unittest.AssertExistsAndLoadBean(t, &user_model.User{ID: 4})
+	repoPath := repo.RepoPath()
+
+	assert.Equal(t, ErrNotAdmin, Enable(db.DefaultContext, reader, repo))
+	assert.Equal(t, ErrNotAdmin, Disable(db.DefaultContext, reader, repo))
+
+	// the default branch has to have a valid file
+	setDefaultBranch(t, repo, "branch-protection-missing", commitFile(t, repoPath, ""))
+	assert.True(t, IsErrInvalidFile(Enable(db.DefaultContext, owner, repo)))
+	unittest.AssertNotExistsBean(t, &git_model.BranchProtectionFile{RepoID: repo.ID})
+
+	commitID := commitFile(t, repoPath, "rules:\n  - rule_name: branch-protection-*\n")
+	setDefaultBranch(t, repo, "branch-protection-enable", commitID)
+	require.NoError(t, Enable(db.DefaultContext, owner, repo))
+	state := unittest.AssertExistsAndLoadBean(t, &git_model.BranchProtectionFile{RepoID: repo.ID})
+	assert.EqualValues(t, owner.ID, state.EnabledByID)
+	assert.Equal(t, commitID, state.AppliedCommitID)
+	assert.NotZero(t, state.AppliedUnix)
+	assert.Equal(t, []string{"branch-protection-*"}, ruleNames(t, repo.ID))
+
+	// the rules are kept once the file doesn't manage them anymore
+	require.NoError(t, Disable(db.DefaultContext, owner, repo))
+	unittest.AssertNotExistsBean(t, &git_model.BranchProtectionFile{RepoID: repo.ID})
+	assert.Equal(t, []string{"branch-protection-*"}, ruleNames(t, repo.ID))
+}
+
+func TestApplyPushedFile(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	t.Cleanup(func() { removeBranchProtectionFile(t, 1) })
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	repoPath := repo.RepoPath()
+
+	// nothing is applied to a repository which isn't managed by the file
+	commitID := commitFile(t, repoPath, "rules:\n  - rule_name: master\n")
+	require.NoError(t, Apply(db.DefaultContext, repo, commitID))
+	assert.Empty(t, ruleNames(t, repo.ID))
+
+	require.NoError(t, git_model.SaveBranchProtectionFile(db.DefaultContext, repo.ID, 2, "", ""))
+	require.NoError(t, Apply(db.DefaultContext, repo, commitID))
+	assert.Equal(t, []string{"master"}, ruleNames(t, repo.ID))
+
+	// a file which can't be applied keeps the rules, the failure is kept until a file is applied
+	invalidCommitID := commitFile(t, repoPath, "rules:\n  - rule_name: master\n    enable_merge_whitelist: true\n    merge_whitelist_usernames: [no-such-user]\n")
+	assert.True(t, IsErrInvalidFile(Apply(db.DefaultContext, repo, invalidCommitID)))
+	assert.Equal(t, []string{"master"}, ruleNames(t, repo.ID))
+	state := unittest.AssertExistsAndLoadBean(t, &git_model.BranchProtectionFile{RepoID: repo.ID})
+	assert.Equal(t, commitID, state.AppliedCommitID)
+	assert.Equal(t, invalidCommitID, state.LastErrorCommitID)
+	assert.Contains(t, state.LastError, `user "no-such-user" does not exist`)
+	assert.NotZero(t, state.LastErrorUnix)
+
+	validCommitID := commitFile(t, repoPath, "rules:\n  - rule_name: \"*\"\n")
+	require.NoError(t, Apply(db.DefaultContext, repo, validCommitID))
+	assert.Equal(t, []string{"*"}, ruleNames(t, repo.ID))
+	state = unittest.AssertExistsAndLoadBean(t, &git_model.BranchProtectionFile{RepoID: repo.ID})
+	assert.Equal(t, validCommitID, state.AppliedCommitID)
+	assert.Empty(t, state.LastErrorCommitID)
+	assert.Empty(t, state.LastError)
+	assert.Zero(t, state.LastErrorUnix)
+}
+
+// removeBranchProtectionFile removes the state of the repository, whose table has no fixtures to be reset to
+func removeBranchProtectionFile(t *testing.T, repoID int64) {
+	require.NoError(t, git_model.DeleteBranchProtectionFile(db.DefaultContext, repoID))
+}
@@ -0,0 +1,285 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package downstream pushes the refs updated by every push to the downstream mirrors of a repository.
+//
+// Post-receive puts a task for every mirror into a persistent queue, the queue workers push the refs
+// and optionally upload the new LFS objects. Failed syncs are retried with an exponential backoff:
+// a failed task waits on a timer and is pushed back into the queue once its backoff has passed.
+// A mirror which still fails after the last attempt is marked as failed and the alert users are mailed.
+package downstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/graceful"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/private"
+	"code.gitea.io/gitea/modules/queue"
+	"code.gitea.io/gitea/modules/timeutil"
+	"code.gitea.io/gitea/modules/util"
+
+	data_repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/modules/lfs"
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+var (
+	mirrorQueue *queue.WorkerPoolQueue[*Task]
+	retries     = newRetryTimers(func(task *Task) error { return mirrorQueue.Push(task) })
+)
+
+// Init starts the sync queue
+func Init() error {
+	if !setting.DownstreamMirror.Enabled {
+		return nil
+	}
+
+	mirrorQueue = queue.CreateSimpleQueue(graceful.GetManager().ShutdownContext(), "downstream_mirror", handle)
+	if mirrorQueue == nil {
+		return errors.New("unable to create downstream_mirror queue")
+	}
+	go graceful.GetManager().RunWithCancel(queueRunner{mirrorQueue})
+	return nil
+}
+
+// queueRunner hands the tasks waiting for their backoff back to the persistent queue before it shuts down,
+// so that they are retried after a restart
+type queueRunner struct {
+	*queue.WorkerPoolQueue[*Task]
+}
+
+// Cancel implements graceful.RunCanceler
+func (r queueRunner) Cancel() {
+	retries.close()
+	r.WorkerPoolQueue.Cancel()
+}
+
+// Enabled returns whether pushes are mirrored downstream
+func Enabled() bool {
+	return mirrorQueue != nil
+}
+
+// RefUpdate is a ref update of a push
+type RefUpdate struct {
+	Ref         string
+	OldCommitID string
+	NewCommitID string
+}
+
+// Task syncs a downstream mirror
+type Task struct {
+	MirrorID int64
+	RepoID   int64
+	// Updates are the ref updates of the push, a task without updates syncs all branches and tags
+	Updates []RefUpdate
+	// TipsBeforePush are the commits the refs pointed to before the push, the LFS objects a created ref adds
+	// are those which aren't reachable from them
+	TipsBeforePush []string
+	// Attempt is the number of failed attempts so far
+	Attempt   int
+	NotBefore time.Time
+}
+
+// Notify queues a sync of the updated refs for every downstream mirror of the repository
+func Notify(ctx context.Context, repo *repo_model.Repository, updates []RefUpdate) {
+	if mirrorQueue == nil || len(updates) == 0 {
+		return
+	}
+	mirrors, err := data_repo_model.GetDownstreamMirrors(ctx, repo.ID)
+	if err != nil {
+		log.Error("Unable to get the downstream mirrors of %s: %v", repo.FullName(), err)
+		return
+	} else if len(mirrors) == 0 {
+		return
+	}
+
+	// the objects added by a created ref are computed by the queue, against the refs before the push
+	var tips []string
+	oldCommitIDs := make(map[string]string, len(updates))
+	for _, update := range updates {
+		oldCommitIDs[update.Ref] = update.OldCommitID
+	}
+	for _, update := range updates {
+		if update.OldCommitID != git.EmptySHA || update.NewCommitID == git.EmptySHA || !isMirroredRef(update.Ref) {
+			continue
+		}
+		if tips, err = lfs.TipsBeforePush(ctx, repo.RepoPath(), oldCommitIDs); err != nil {
+			log.Error("Unable to get the refs of %s before the push: %v", repo.FullName(), err)
+		}
+		break
+	}
+
+	for _, m := range mirrors {
+		if err := mirrorQueue.Push(&Task{MirrorID: m.ID, RepoID: repo.ID, Updates: updates, TipsBeforePush: tips}); err != nil {
+			log.Error("Unable to queue the sync of downstream mirror %d of %s: %v", m.ID, repo.FullName(), err)
+		}
+	}
+}
+
+// SyncAll queues a sync of all branches and tags to a downstream mirror
+func SyncAll(m *data_repo_model.DownstreamMirror) error {
+	if mirrorQueue == nil {
+		return errors.New("downstream mirroring is disabled")
+	}
+	return mirrorQueue.Push(&Task{MirrorID: m.ID, RepoID: m.RepoID})
+}
+
+// handle syncs the due tasks, the failed ones and those which aren't due yet wait for their backoff outside of
+// the queue. Only while the queue shuts down they are returned as unhandled, so that the queue keeps them.
+func handle(tasks ...*Task) []*Task {
+	return handleTasks(graceful.GetManager().ShutdownContext(), tasks)
+}
+
+func handleTasks(ctx context.Context, tasks []*Task) (unhandled []*Task) {
+	for _, task := range tasks {
+		if time.Now().Before(task.NotBefore) || run(ctx, task) {
+			// the queue keeps the unhandled tasks once it's shutting down, the timers wouldn't fire anymore
+			if ctx.Err() != nil || !retries.schedule(task) {
+				unhandled = append(unhandled, task)
+			}
+		}
+	}
+	return unhandled
+}
+
+// retryTimers holds the tasks waiting for their backoff
+type retryTimers struct {
+	push   func(*Task) error
+	mu     sync.Mutex
+	closed bool
+	timers map[*Task]*time.Timer
+}
+
+func newRetryTimers(push func(*Task) error) *retryTimers {
+	return &retryTimers{push: push, timers: make(map[*Task]*time.Timer)}
+}
+
+// schedule pushes the task into the queue once its NotBefore has passed, it returns false once the timers are closed
+func (r *retryTimers) schedule(task *Task) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	r.timers[task] = time.AfterFunc(time.Until(task.NotBefore), func() {
+		r.mu.Lock()
+		_, ok := r.timers[task]
+		delete(r.timers, task)
+		r.mu.Unlock()
+		// a task which isn't there anymore has been pushed by close
+		if ok {
+			r.requeue(task)
+		}
+	})
+	return true
+}
+
+// close stops the timers and pushes their tasks into the queue right away, they keep their NotBefore
+func (r *retryTimers) close() {
+	r.mu.Lock()
+	r.closed = true
+	tasks := make([]*Task, 0, len(r.timers))
+	for task, timer := range r.timers {
+		timer.Stop()
+		tasks = append(tasks, task)
+	}
+	r.timers = make(map[*Task]*time.Timer)
+	r.mu.Unlock()
+
+	for _, task := range tasks {
+		r.requeue(task)
+	}
+}
+
+func (r *retryTimers) requeue(task *Task) {
+	if err := r.push(task); err != nil {
+		log.Error("Unable to queue the retry of downstream mirror %d of repo %d: %v", task.MirrorID, task.RepoID, err)
+	}
+}
+
+// backoff returns the delay before the retry following attempt
+func backoff(attempt int) time.Duration {
+	d := setting.DownstreamMirror.RetryBackoff
+	for i := 1; i < attempt && d < setting.DownstreamMirror.MaxRetryBackoff; i++ {
+		d *= 2
+	}
+	return min(d, setting.DownstreamMirror.MaxRetryBackoff)
+}
+
+// run syncs the mirror of the task and records the result, it returns true if the task has to be retried
+func run(ctx context.Context, task *Task) bool {
+	m, err := data_repo_model.GetDownstreamMirror(ctx, task.RepoID, task.MirrorID)
+	if errors.Is(err, util.ErrNotExist) {
+		return false
+	} else if err != nil {
+		log.Error("Unable to get downstream mirror %d of repo %d: %v", task.MirrorID, task.RepoID, err)
+		return true
+	}
+	repo, err := repo_model.GetRepositoryByID(ctx, task.RepoID)
+	if repo_model.IsErrRepoNotExist(err) {
+		return false
+	} else if err != nil {
+		log.Error("Unable to get repo %d to sync downstream mirror %d: %v", task.RepoID, m.ID, err)
+		return true
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, setting.DownstreamMirror.Timeout)
+	err = syncMirror(syncCtx, repo, m, task)
+	cancel()
+	if err != nil && ctx.Err() != nil {
+		// the sync has been interrupted by the shutdown, which isn't an attempt of its own
+		log.Info("Sync of downstream mirror %d of %s interrupted by shutdown, it is handed back to the queue: %v", m.ID, repo.FullName(), err)
+		return true
+	}
+
+	now := timeutil.TimeStampNow()
+	wasFailed := m.Status == data_repo_model.DownstreamMirrorFailed
+	m.LastAttempt = now
+	retry := false
+	if err == nil {
+		m.Status = data_repo_model.DownstreamMirrorSynced
+		m.LastError = ""
+		m.FailureCount = 0
+		m.LastSync = now
+	} else {
+		task.Attempt++
+		m.FailureCount++
+		m.LastError = util.SanitizeCredentialURLs(err.Error())
+		if task.Attempt < setting.DownstreamMirror.MaxAttempts {
+			m.Status = data_repo_model.DownstreamMirrorRetrying
+			task.NotBefore = time.Now().Add(backoff(task.Attempt))
+			retry = true
+			log.Warn("Unable to sync downstream mirror %d of %s, attempt %d will be retried: %s", m.ID, repo.FullName(), task.Attempt, m.LastError)
+		} else {
+			m.Status = data_repo_model.DownstreamMirrorFailed
+			log.Error("Unable to sync downstream mirror %d of %s after %d attempts: %s", m.ID, repo.FullName(), task.Attempt, m.LastError)
+		}
+	}
+	if err := data_repo_model.UpdateDownstreamMirrorStatus(ctx, m); err != nil {
+		log.Error("Unable to update the status of downstream mirror %d of %s: %v", m.ID, repo.FullName(), err)
+	}
+	if m.Status == data_repo_model.DownstreamMirrorFailed && !wasFailed {
+		alert(ctx, repo, m)
+	}
+	return retry
+}
+
+// alert mails the alert users about a mirror which has started failing
+func alert(ctx context.Context, repo *repo_model.Repository, m *data_repo_model.DownstreamMirror) {
+	if len(setting.DownstreamMirror.AlertUsers) == 0 {
+		return
+	}
+	subject := fmt.Sprintf("Downstream mirror of %s is failing", repo.FullName())
+	message := fmt.Sprintf("Pushes to %s could not be mirrored to %s after %d attempts.\n\nLast error: %s\n",
+		repo.FullName(), util.SanitizeCredentialURLs(m.RemoteURL), m.FailureCount, m.LastError)
+	if _, extra := private.SendEmail(ctx, subject, message, setting.DownstreamMirror.AlertUsers); extra.Error != nil {
+		log.Error("Unable to mail the failure of downstream mirror %d of %s: %v", m.ID, repo.FullName(), extra.Error)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package downstream
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/models/db"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/test"
+
+	data_repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runGit(t *testing.T, repoPath, stdin string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", repoPath}, args...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.Output()
+	require.NoError(t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
+
+// pushRecorder records the tasks pushed back into the queue
+type pushRecorder struct {
+	mu    sync.Mutex
+	tasks []*Task
+}
+
+func (r *pushRecorder) push(task *Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks = append(r.tasks, task)
+	return nil
+}
+
+func (r *pushRecorder) pushed() []*Task {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Task(nil), r.tasks...)
+}
+
+func setRetries(t *testing.T) *pushRecorder {
+	recorder := &pushRecorder{}
+	oldRetries := retries
+	retries = newRetryTimers(recorder.push)
+	t.Cleanup(func() {
+		retries = oldRetries
+	})
+	return recorder
+}
+
+func TestBackoff(t *testing.T) {
+	oldDownstreamMirror := setting.DownstreamMirror
+	defer func() {
+		setting.DownstreamMirror = oldDownstreamMirror
+	}()
+	setting.DownstreamMirror.RetryBackoff = time.Second
+	setting.DownstreamMirror.MaxRetryBackoff = 5 * time.Second
+
+	assert.Equal(t, time.Second, backoff(1))
+	assert.Equal(t, 2*time.Second, backoff(2))
+	assert.Equal(t, 4*time.Second, backoff(3))
+	assert.Equal(t, 5*time.Second, backoff(4))
+	assert.Equal(t, 5*time.Second, backoff(100))
+}
+
+func TestRetryTimers(t *testing.T) {
+	recorder := &pushRecorder{}
+	r := newRetryTimers(recorder.push)
+
+	due := &Task{MirrorID: 1, NotBefore: time.Now().Add(10 * time.Millisecond)}
+	assert.True(t, r.schedule(due))
+	assert.Empty(t, recorder.pushed(), "a task is only pushed once its backoff has passed")
+	assert.Eventually(t, func() bool { return len(recorder.pushed()) == 1 }, 5*time.Second, 5*time.Millisecond)
+	assert.Same(t, due, recorder.pushed()[0])
+
+	// closing pushes the waiting tasks right away, later tasks are left to the queue
+	waiting := &Task{MirrorID: 2, NotBefore: time.Now().Add(time.Hour)}
+	assert.True(t, r.schedule(waiting))
+	r.close()
+	pushed := recorder.pushed()
+	require.Len(t, pushed, 2)
+	assert.Same(t, waiting, pushed[1])
+	assert.False(t, r.schedule(&Task{MirrorID: 3, NotBefore: time.Now().Add(time.Hour)}))
+}
+
+func TestHandle(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	oldDownstreamMirror := setting.DownstreamMirror
+	defer func() {
+		setting.DownstreamMirror = oldDownstreamMirror
+	}()
+	setting.DownstreamMirror.MaxAttempts = 2
+
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	master := runGit(t, repo.RepoPath(), "", "rev-parse", "refs/heads/master")
+	updates := []RefUpdate{{Ref: "refs/heads/master", OldCommitID: git.EmptySHA, NewCommitID: master}}
+
+	remotePath := filepath.Join(t.TempDir(), "mirror.git")
+	runGit(t, remotePath, "", "init", "--bare")
+	synced := &data_repo_model.DownstreamMirror{RepoID: repo.ID, RemoteURL: remotePath}
+	require.NoError(t, data_repo_model.InsertDownstreamMirror(db.DefaultContext, synced))
+	failing := &data_repo_model.DownstreamMirror{RepoID: repo.ID, RemoteURL: filepath.Join(t.TempDir(), "missing.git")}
+	require.NoError(t, data_repo_model.InsertDownstreamMirror(db.DefaultContext, failing))
+
+	t.Run("Synced", func(t *testing.T) {
+		recorder := setRetries(t)
+		assert.Empty(t, handleTasks(db.DefaultContext, []*Task{{MirrorID: synced.ID, RepoID: repo.ID, Updates: updates}}))
+		assert.Empty(t, recorder.pushed())
+		assert.Equal(t, master, runGit(t, remotePath, "", "rev-parse", "refs/heads/master"))
+
+		m, err := data_repo_model.GetDownstreamMirror(db.DefaultContext, repo.ID, synced.ID)
+		require.NoError(t, err)
+		assert.Equal(t, data_repo_model.DownstreamMirrorSynced, m.Status)
+		assert.NotZero(t, m.LastSync)
+	})
+
+	t.Run("NotDue", func(t *testing.T) {
+		recorder := setRetries(t)
+		task := &Task{MirrorID: failing.ID, RepoID: repo.ID, Updates: updates, NotBefore: time.Now().Add(20 * time.Millisecond)}
+		assert.Empty(t, handleTasks(db.DefaultContext, []*Task{task}), "a task waiting for its backoff must not go back into the queue right away")
+
+		m, err := data_repo_model.GetDownstreamMirror(db.DefaultContext, repo.ID, failing.ID)
+		require.NoError(t, err)
+		assert.Zero(t, m.LastAttempt, "a task isn't run before its backoff has passed")
+		assert.Eventually(t, func() bool { return len(recorder.pushed()) == 1 }, 5*time.Second, 5*time.Millisecond)
+		assert.Zero(t, task.Attempt)
+	})
+
+	t.Run("Retried", func(t *testing.T) {
+		recorder := setRetries(t)
+		task := &Task{MirrorID: failing.ID, RepoID: repo.ID, Updates: updates}
+		before := time.Now()
+		assert.Empty(t, handleTasks(db.DefaultContext, []*Task{task}))
+		assert.Equal(t, 1, task.Attempt)
+		assert.True(t, task.NotBefore.After(before))
+		assert.Empty(t, recorder.pushed(), "the retry waits for the backoff")
+
+		m, err := data_repo_model.GetDownstreamMirror(db.DefaultContext, repo.ID, failing.ID)
+		require.NoError(t, err)
+		assert.Equal(t, data_repo_model.DownstreamMirrorRetrying, m.Status)
+		assert.Equal(t, 1, m.FailureCount)
+		assert.NotEmpty(t, m.LastError)
+
+		// the queue keeps the retry while it shuts down
+		retries.close()
+		assert.Equal(t, []*Task{task}, recorder.pushed())
+		task.NotBefore = time.Now().Add(time.Hour)
+		assert.Equal(t, []*Task{task}, handleTasks(db.DefaultContext, []*Task{task}))
+	})
+
+	t.Run("Interrupted", func(t *testing.T) {
+		recorder := setRetries(t)
+		ctx, cancel := context.WithCancel(db.DefaultContext)
+		defer test.MockVariableValue(&syncMirror, func(syncCtx context.Context, _ *repo_model.Repository, _ *data_repo_model.DownstreamMirror, _ *Task) error {
+			cancel()
+			<-syncCtx.Done()
+			return syncCtx.Err()
+		})()
+		task := &Task{MirrorID: failing.ID, RepoID: repo.ID, Updates: updates, Attempt: 1}
+		assert.Equal(t, []*Task{task}, handleTasks(ctx, []*Task{task}), "the queue keeps a sync interrupted by the shutdown")
+		assert.Equal(t, 1, task.Attempt, "the interrupted sync isn't counted as an attempt")
+		retries.close()
+		assert.Empty(t, recorder.pushed())
+
+		m, err := data_repo_model.GetDownstreamMirror(db.DefaultContext, repo.ID, failing.ID)
+		require.NoError(t, err)
+		assert.Equal(t, data_repo_model.DownstreamMirrorRetrying, m.Status)
+		assert.Equal(t, 1, m.FailureCount)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		recorder := setRetries(t)
+		task := &Task{MirrorID: failing.ID, RepoID: repo.ID, Updates: updates, Attempt: 1}
+		assert.Empty(t, handleTasks(db.DefaultContext, []*Task{task}))
+		assert.Equal(t, 2, task.Attempt)
+		assert.Empty(t, recorder.pushed())
+		retries.close()
+		assert.Empty(t, recorder.pushed(), "the last attempt isn't retried")
+
+		m, err := data_repo_model.GetDownstreamMirror(db.DefaultContext, repo.ID, failing.ID)
+		require.NoError(t, err)
+		assert.Equal(t, data_repo_model.DownstreamMirrorFailed, m.Status)
+		assert.Equal(t, 2, m.FailureCount)
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		recorder := setRetries(t)
+		assert.Empty(t, handleTasks(db.DefaultContext, []*Task{{MirrorID: 1000, RepoID: repo.ID, Updates: updates}}))
+		retries.close()
+		assert.Empty(t, recorder.pushed())
+	})
+}
+
+func TestAddedPointers(t *testing.T) {
+	repoPath := filepath.Join(t.TempDir(), "repo.git")
+	runGit(t, repoPath, "", "init", "--bare")
+	pointer := func(n int) string {
+		return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", strings.Repeat(fmt.Sprint(n), 64), n)
+	}
+	commit := func(contents []string, parents ...string) string {
+		var tree strings.Builder
+		for i, content := range contents {
+			fmt.Fprintf(&tree, "100644 blob %s\tfile%d.bin\n", runGit(t, repoPath, content, "hash-object", "-w", "--stdin"), i)
+		}
+		args := []string{"commit-tree", runGit(t, repoPath, tree.String(), "mktree"), "-m", "commit"}
+		for _, parent := range parents {
+			args = append(args, "-p", parent)
+		}
+		return runGit(t, repoPath, "", args...)
+	}
+	first := commit([]string{pointer(1)})
+	second := commit([]string{pointer(1), pointer(2)}, first)
+
+	// feature was created at second while main was at first, main has been fast-forwarded since
+	runGit(t, repoPath, "", "update-ref", "refs/heads/main", second)
+	runGit(t, repoPath, "", "update-ref", "refs/heads/feature", second)
+	update := RefUpdate{Ref: "refs/heads/feature", OldCommitID: git.EmptySHA, NewCommitID: second}
+
+	pointers, err := addedPointers(db.DefaultContext, repoPath, &Task{TipsBeforePush: []string{first}}, update)
+	require.NoError(t, err)
+	require.Len(t, pointers, 1)
+	assert.Equal(t, strings.Repeat("2", 64), pointers[0].Oid)
+
+	// without the tips the objects are compared with the current refs
+	pointers, err = addedPointers(db.DefaultContext, repoPath, &Task{}, update)
+	require.NoError(t, err)
+	assert.Empty(t, pointers)
+}
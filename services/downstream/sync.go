@@ -0,0 +1,253 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package downstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/util"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	data_repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/modules/lfs"
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// ValidateRemoteURL makes sure a mirror is pushed over the network, a local path would let the mirror write to the server
+func ValidateRemoteURL(remoteURL string) error {
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "ssh":
+	default:
+		return fmt.Errorf("unsupported scheme %q, use http, https or ssh", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if u.User != nil {
+		return fmt.Errorf("credentials must be given separately")
+	}
+	return nil
+}
+
+// withCredentials returns rawURL with the credentials of the mirror
+func withCredentials(m *data_repo_model.DownstreamMirror, rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if m.Username == "" {
+		return u, nil
+	}
+	password, err := m.Password()
+	if err != nil {
+		return nil, fmt.Errorf("decrypt password: %w", err)
+	}
+	if password == "" {
+		u.User = url.User(m.Username)
+	} else {
+		u.User = url.UserPassword(m.Username, password)
+	}
+	return u, nil
+}
+
+// isMirroredRef returns whether ref is pushed downstream, internal refs like pull request heads and backups stay local
+func isMirroredRef(ref string) bool {
+	return strings.HasPrefix(ref, git.BranchPrefix) || strings.HasPrefix(ref, git.TagPrefix)
+}
+
+// syncMirror pushes the refs updated by the task to the mirror and uploads the LFS objects they add
+var syncMirror = func(ctx context.Context, repo *repo_model.Repository, m *data_repo_model.DownstreamMirror, task *Task) error {
+	remote, err := withCredentials(m, m.RemoteURL)
+	if err != nil {
+		return err
+	}
+	repoPath := repo.RepoPath()
+
+	refspecs, err := refspecsOf(ctx, repoPath, remote.String(), task.Updates)
+	if err != nil {
+		return err
+	}
+	if len(refspecs) == 0 {
+		return nil
+	}
+
+	if m.SyncLFS && setting.LFS.StartServer {
+		// the objects go first so that the mirror never has pointers without objects
+		if err := uploadLFS(ctx, repo, m, task); err != nil {
+			return fmt.Errorf("upload LFS objects: %w", err)
+		}
+	}
+
+	_, stderr, runErr := git.NewCommand(ctx, "push", "--porcelain").AddDynamicArguments(remote.String()).AddDynamicArguments(refspecs...).
+		RunStdString(&git.RunOpts{Dir: repoPath, Env: []string{"GIT_TERMINAL_PROMPT=0"}, UseContextTimeout: true})
+	if runErr != nil {
+		return fmt.Errorf("push: %s - %s", util.SanitizeCredentialURLs(runErr.Error()), util.SanitizeCredentialURLs(stderr))
+	}
+	return nil
+}
+
+// refspecsOf returns the refspecs pushing updates. A ref is pushed as it is now rather than as the push left it,
+// so that a retried sync never rolls back a later push. Deleted refs are only deleted from the mirror if they exist there.
+func refspecsOf(ctx context.Context, repoPath, remote string, updates []RefUpdate) ([]string, error) {
+	if len(updates) == 0 {
+		return []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}, nil
+	}
+
+	refs := make([]string, 0, len(updates))
+	for _, update := range updates {
+		if isMirroredRef(update.Ref) {
+			refs = append(refs, update.Ref)
+		}
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	local, err := existingRefs(ctx, repoPath, "", refs)
+	if err != nil {
+		return nil, err
+	}
+	refspecs := make([]string, 0, len(refs))
+	var deleted []string
+	for _, ref := range refs {
+		if local[ref] {
+			refspecs = append(refspecs, "+"+ref+":"+ref)
+		} else {
+			deleted = append(deleted, ref)
+		}
+	}
+	if len(deleted) > 0 {
+		remoteRefs, err := existingRefs(ctx, repoPath, remote, deleted)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range deleted {
+			if remoteRefs[ref] {
+				refspecs = append(refspecs, ":"+ref)
+			}
+		}
+	}
+	return refspecs, nil
+}
+
+// existingRefs returns which of refs exist in the repository, or in the remote if it isn't empty
+func existingRefs(ctx context.Context, repoPath, remote string, refs []string) (map[string]bool, error) {
+	var cmd *git.Command
+	if remote == "" {
+		cmd = git.NewCommand(ctx, "for-each-ref", "--format=%(refname)")
+	} else {
+		cmd = git.NewCommand(ctx, "ls-remote", "--refs").AddDynamicArguments(remote)
+	}
+	stdout, stderr, err := cmd.AddDashesAndList(refs...).RunStdString(&git.RunOpts{Dir: repoPath, Env: []string{"GIT_TERMINAL_PROMPT=0"}, UseContextTimeout: true})
+	if err != nil {
+		return nil, fmt.Errorf("list refs: %s - %s", util.SanitizeCredentialURLs(err.Error()), util.SanitizeCredentialURLs(stderr))
+	}
+
+	wanted := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		wanted[ref] = true
+	}
+	existing := make(map[string]bool, len(refs))
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// ls-remote prints the object before the ref and matches the patterns as suffixes
+		if ref := fields[len(fields)-1]; wanted[ref] {
+			existing[ref] = true
+		}
+	}
+	return existing, nil
+}
+
+// uploadLFS uploads the LFS objects added by the updates of the task to the mirror, or all objects of the repository
+// if there are no updates. The mirror only asks for the objects it doesn't have yet.
+func uploadLFS(ctx context.Context, repo *repo_model.Repository, m *data_repo_model.DownstreamMirror, task *Task) error {
+	var pointers []lfs.Pointer
+	if len(task.Updates) == 0 {
+		for page := 1; ; page++ {
+			metas, err := git_model.GetLFSMetaObjects(ctx, repo.ID, page, setting.Database.IterateBufferSize)
+			if err != nil {
+				return err
+			}
+			for _, meta := range metas {
+				pointers = append(pointers, meta.Pointer)
+			}
+			if len(metas) < setting.Database.IterateBufferSize {
+				break
+			}
+		}
+	} else {
+		seen := make(map[string]bool)
+		for _, update := range task.Updates {
+			if !isMirroredRef(update.Ref) || update.NewCommitID == git.EmptySHA {
+				continue
+			}
+			added, err := addedPointers(ctx, repo.RepoPath(), task, update)
+			if err != nil {
+				return err
+			}
+			for _, p := range added {
+				if seen[p.Oid] {
+					continue
+				}
+				seen[p.Oid] = true
+				// only the objects uploaded to this repository can be sent
+				if _, err := git_model.GetLFSMetaObjectByOid(ctx, repo.ID, p.Oid); err == git_model.ErrLFSObjectNotExist {
+					continue
+				} else if err != nil {
+					return err
+				}
+				pointers = append(pointers, p)
+			}
+		}
+	}
+	if len(pointers) == 0 {
+		return nil
+	}
+
+	endpoint := lfs.DetermineEndpoint(m.RemoteURL, m.LFSURL)
+	if endpoint == nil {
+		return fmt.Errorf("unable to determine the LFS endpoint of %s", util.SanitizeCredentialURLs(m.RemoteURL))
+	}
+	endpoint, err := withCredentials(m, endpoint.String())
+	if err != nil {
+		return err
+	}
+	client := lfs.NewClient(endpoint, nil)
+	for len(pointers) > 0 {
+		batch := pointers[:min(len(pointers), client.BatchSize())]
+		pointers = pointers[len(batch):]
+		err := client.Upload(ctx, batch, func(p lfs.Pointer, objectError error) (io.ReadCloser, error) {
+			if objectError != nil {
+				return nil, objectError
+			}
+			return lfs.ReadMetaObject(p)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addedPointers returns the LFS pointers added by update. The refs may have moved on since the push, so a created ref
+// is compared with the tips before the push, tasks queued without them fall back to the current refs.
+func addedPointers(ctx context.Context, repoPath string, task *Task, update RefUpdate) ([]lfs.Pointer, error) {
+	if update.OldCommitID == git.EmptySHA && task.TipsBeforePush != nil {
+		return lfs.AddedPointersExcluding(ctx, repoPath, nil, update.NewCommitID, task.TipsBeforePush)
+	}
+	return lfs.AddedPointers(ctx, repoPath, nil, update.Ref, update.OldCommitID, update.NewCommitID)
+}
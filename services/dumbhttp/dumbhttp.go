@@ -0,0 +1,86 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package dumbhttp publishes the files needed by the git dumb HTTP protocol to an object storage,
+// so that read-only edge servers can serve clones and fetches without local repositories.
+package dumbhttp
+
+import (
+	"errors"
+	"fmt"
+
+	"code.gitea.io/gitea/modules/graceful"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/queue"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+	"github.com/openmerlin/gitea_data/modules/setting"
+	"github.com/openmerlin/gitea_data/modules/storage"
+)
+
+// PublishRequest identifies a repository whose dumb HTTP files have to be published
+type PublishRequest struct {
+	RepoID    int64
+	OwnerName string
+	RepoName  string
+	IsWiki    bool
+}
+
+var publishQueue *queue.WorkerPoolQueue[*PublishRequest]
+
+// IsPublisher returns true if this server publishes the repositories after pushes
+func IsPublisher() bool {
+	return setting.DumbHTTP.Mode == setting.DumbHTTPModePublish
+}
+
+// IsEdge returns true if this server serves the dumb HTTP routes from the storage
+func IsEdge() bool {
+	return setting.DumbHTTP.Mode == setting.DumbHTTPModeEdge
+}
+
+// ObjectPath returns the storage path of a file of a published repository
+func ObjectPath(repoID int64, isWiki bool, file string) string {
+	if isWiki {
+		return fmt.Sprintf("%d.wiki/%s", repoID, file)
+	}
+	return fmt.Sprintf("%d/%s", repoID, file)
+}
+
+// Init starts the publish queue if this server is a publisher
+func Init() error {
+	if !IsPublisher() {
+		return nil
+	}
+
+	handler := func(items ...*PublishRequest) []*PublishRequest {
+		ctx := graceful.GetManager().ShutdownContext()
+		for _, req := range items {
+			repoName := req.RepoName
+			if req.IsWiki {
+				repoName += ".wiki"
+			}
+			repoPath := repo_model.RepoPath(req.OwnerName, repoName)
+			if err := Publish(ctx, storage.DumbHTTP, repoPath, ObjectPath(req.RepoID, req.IsWiki, "")); err != nil {
+				log.Error("Unable to publish dumb HTTP files of %s: %v", repoPath, err)
+			}
+		}
+		return nil
+	}
+
+	publishQueue = queue.CreateUniqueQueue(graceful.GetManager().ShutdownContext(), "dumb_http_publish", handler)
+	if publishQueue == nil {
+		return errors.New("unable to create dumb_http_publish queue")
+	}
+	go graceful.GetManager().RunWithCancel(publishQueue)
+	return nil
+}
+
+// AddToPublishQueue schedules the publication of a repository, pushes which arrive before it runs are coalesced
+func AddToPublishQueue(req *PublishRequest) {
+	if publishQueue == nil {
+		return
+	}
+	if err := publishQueue.Push(req); err != nil && !errors.Is(err, queue.ErrAlreadyInQueue) {
+		log.Error("Unable to add %s/%s to the dumb HTTP publish queue: %v", req.OwnerName, req.RepoName, err)
+	}
+}
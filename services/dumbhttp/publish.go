@@ -0,0 +1,143 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package dumbhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.gitea.io/gitea/modules/log"
+
+	repo_module "github.com/openmerlin/gitea_data/modules/repository"
+	"github.com/openmerlin/gitea_data/modules/storage"
+)
+
+// Publish uploads the files a dumb HTTP client reads to store under prefix.
+// Objects are immutable and are only uploaded once, the refs are uploaded last
+// so that a client never sees refs pointing to objects which aren't published yet.
+func Publish(ctx context.Context, store storage.ObjectStorage, repoPath, prefix string) error {
+	if err := repo_module.UpdateServerInfo(ctx, repoPath); err != nil {
+		return err
+	}
+
+	packs, err := publishPacks(store, repoPath, prefix)
+	if err != nil {
+		return err
+	}
+	if err := publishLooseObjects(store, repoPath, prefix); err != nil {
+		return err
+	}
+
+	for _, file := range []string{"objects/info/packs", "HEAD", "info/refs"} {
+		if err := uploadFile(store, repoPath, prefix, file); err != nil {
+			return err
+		}
+	}
+
+	return removeStalePacks(store, prefix, packs)
+}
+
+// publishPacks uploads all packs which aren't published yet and returns the names of the local packs.
+// A pack is uploaded before its index because clients look for the index first.
+func publishPacks(store storage.ObjectStorage, repoPath, prefix string) (map[string]bool, error) {
+	entries, err := os.ReadDir(filepath.Join(repoPath, "objects", "pack"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	packs := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "pack-") || !strings.HasSuffix(name, ".pack") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".pack")
+		// packs without an index are still being written
+		if _, err := os.Stat(filepath.Join(repoPath, "objects", "pack", base+".idx")); err != nil {
+			continue
+		}
+		for _, file := range []string{base + ".pack", base + ".idx"} {
+			packs[file] = true
+			if err := uploadObject(store, repoPath, prefix, "objects/pack/"+file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return packs, nil
+}
+
+func publishLooseObjects(store storage.ObjectStorage, repoPath, prefix string) error {
+	dirs, err := os.ReadDir(filepath.Join(repoPath, "objects"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		objects, err := os.ReadDir(filepath.Join(repoPath, "objects", dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			if obj.IsDir() || strings.HasPrefix(obj.Name(), "tmp_obj_") {
+				continue
+			}
+			if err := uploadObject(store, repoPath, prefix, "objects/"+dir.Name()+"/"+obj.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// uploadObject uploads an immutable file unless it has been published already
+func uploadObject(store storage.ObjectStorage, repoPath, prefix, file string) error {
+	fi, err := os.Stat(filepath.Join(repoPath, filepath.FromSlash(file)))
+	if err != nil {
+		return err
+	}
+	if stored, err := store.Stat(prefix + file); err == nil && stored.Size() == fi.Size() {
+		return nil
+	}
+	return uploadFile(store, repoPath, prefix, file)
+}
+
+func uploadFile(store storage.ObjectStorage, repoPath, prefix, file string) error {
+	f, err := os.Open(filepath.Join(repoPath, filepath.FromSlash(file)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := store.Save(prefix+file, f, fi.Size()); err != nil {
+		return fmt.Errorf("unable to publish %s: %w", file, err)
+	}
+	return nil
+}
+
+// removeStalePacks deletes the published packs which have been removed locally, e.g. by a repack
+func removeStalePacks(store storage.ObjectStorage, prefix string, packs map[string]bool) error {
+	packPrefix := prefix + "objects/pack/"
+	err := store.IterateObjects(packPrefix, func(p string, obj storage.Object) error {
+		_ = obj.Close()
+		if packs[strings.TrimPrefix(p, packPrefix)] {
+			return nil
+		}
+		log.Trace("Removing stale published pack %s", p)
+		return store.Delete(p)
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package dumbhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+	"github.com/openmerlin/gitea_data/modules/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runGit(t *testing.T, repoPath string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", repoPath}, args...)...)
+	out, err := cmd.Output()
+	require.NoError(t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
+
+func commit(t *testing.T, repoPath, message string, parents ...string) string {
+	tree := runGit(t, repoPath, "write-tree")
+	args := []string{"commit-tree", tree, "-m", message}
+	for _, parent := range parents {
+		args = append(args, "-p", parent)
+	}
+	return runGit(t, repoPath, args...)
+}
+
+func publishedPacks(t *testing.T, store storage.ObjectStorage, prefix string) []string {
+	var packs []string
+	err := store.IterateObjects(prefix+"objects/pack/", func(p string, obj storage.Object) error {
+		packs = append(packs, filepath.Base(p))
+		return obj.Close()
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		assert.NoError(t, err)
+	}
+	sort.Strings(packs)
+	return packs
+}
+
+func readObject(t *testing.T, store storage.ObjectStorage, p string) string {
+	obj, err := store.Open(p)
+	require.NoError(t, err)
+	defer obj.Close()
+	content, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestObjectPath(t *testing.T) {
+	assert.Equal(t, "1/info/refs", ObjectPath(1, false, "info/refs"))
+	assert.Equal(t, "1.wiki/HEAD", ObjectPath(1, true, "HEAD"))
+	assert.Equal(t, "2/", ObjectPath(2, false, ""))
+}
+
+func TestPublish(t *testing.T) {
+	ctx := context.Background()
+	repoPath := filepath.Join(t.TempDir(), "repo.git")
+	require.NoError(t, exec.Command("git", "init", "--bare", repoPath).Run())
+	store, err := storage.NewLocalStorage(ctx, &setting.Storage{Path: t.TempDir()})
+	require.NoError(t, err)
+	prefix := ObjectPath(1, false, "")
+
+	first := commit(t, repoPath, "first")
+	runGit(t, repoPath, "update-ref", "refs/heads/main", first)
+	runGit(t, repoPath, "symbolic-ref", "HEAD", "refs/heads/main")
+	runGit(t, repoPath, "update-ref", "refs/gitea-backup/main/1", first)
+
+	// loose objects and the refs without the hidden ones
+	require.NoError(t, Publish(ctx, store, repoPath, prefix))
+	infoRefs := readObject(t, store, prefix+"info/refs")
+	assert.Contains(t, infoRefs, first+"\trefs/heads/main")
+	assert.NotContains(t, infoRefs, "refs/gitea-backup/")
+	assert.Equal(t, "ref: refs/heads/main\n", readObject(t, store, prefix+"HEAD"))
+	_, err = store.Stat(prefix + "objects/" + first[:2] + "/" + first[2:])
+	assert.NoError(t, err)
+	assert.Empty(t, publishedPacks(t, store, prefix))
+
+	// packs are published with their index
+	runGit(t, repoPath, "repack", "-a", "-d")
+	require.NoError(t, Publish(ctx, store, repoPath, prefix))
+	oldPacks := publishedPacks(t, store, prefix)
+	if assert.Len(t, oldPacks, 2) {
+		assert.True(t, strings.HasSuffix(oldPacks[0], ".idx"))
+		assert.True(t, strings.HasSuffix(oldPacks[1], ".pack"))
+	}
+	assert.Contains(t, readObject(t, store, prefix+"objects/info/packs"), strings.TrimSuffix(oldPacks[1], ".pack"))
+
+	// the packs replaced by a repack are removed
+	second := commit(t, repoPath, "second", first)
+	runGit(t, repoPath, "update-ref", "refs/heads/main", second)
+	runGit(t, repoPath, "repack", "-a", "-d")
+	require.NoError(t, Publish(ctx, store, repoPath, prefix))
+	newPacks := publishedPacks(t, store, prefix)
+	assert.Len(t, newPacks, 2)
+	assert.NotContains(t, newPacks, oldPacks[1])
+	assert.Contains(t, readObject(t, store, prefix+"info/refs"), second+"\trefs/heads/main")
+}
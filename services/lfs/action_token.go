@@ -0,0 +1,79 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package lfs
+
+import (
+	"fmt"
+	"time"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/log"
+
+	lfs_module "github.com/openmerlin/gitea_data/modules/lfs"
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// lfsObjectDataKey keeps the object a request is about, object bound tokens are only valid for it
+const lfsObjectDataKey = "LFSObject"
+
+// setRequestObject records the object of the request before it is authenticated
+func setRequestObject(ctx *context.Context, p lfs_module.Pointer) {
+	ctx.Data[lfsObjectDataKey] = p
+}
+
+// actionLink returns a link for op on the object. Instead of the credentials of the batch request
+// the link carries a token which only allows op on this object and expires after LFS_ACTION_LINK_EXPIRY.
+func (rc *requestContext) actionLink(href string, p lfs_module.Pointer, op string) *lfs_module.Link {
+	link := &lfs_module.Link{Href: href, Header: map[string]string{}}
+	// anonymous requests can only read public repositories, which needs no token
+	if rc.UserID == 0 {
+		return link
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(setting.LFS.ActionLinkExpiry)
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		RepoID:      rc.RepoID,
+		Op:          op,
+		UserID:      rc.UserID,
+		DeployKeyID: rc.DeployKeyID,
+		Oid:         p.Oid,
+		Size:        p.Size,
+	}
+	token, err := SignToken(&claims)
+	if err != nil {
+		// the client gets a 401 when it follows the link
+		log.Error("Unable to sign the %s token of LFS object %s in %s/%s: %v", op, p.Oid, rc.User, rc.Repo, err)
+		return link
+	}
+	link.Header["Authorization"] = "Bearer " + token
+	link.ExpiresAt = &expiresAt
+	return link
+}
+
+// checkObjectClaims makes sure a token bound to an object is only used for that object
+func checkObjectClaims(ctx *context.Context, claims *Claims) error {
+	if claims.Oid == "" {
+		return nil
+	}
+	p, ok := ctx.Data[lfsObjectDataKey].(lfs_module.Pointer)
+	if !ok {
+		return fmt.Errorf("token is bound to object %s", claims.Oid)
+	}
+	if p.Oid != claims.Oid {
+		return fmt.Errorf("token is bound to object %s, not %s", claims.Oid, p.Oid)
+	}
+	// downloads don't state the size, the object ID determines it anyway
+	if p.Size != 0 && p.Size != claims.Size {
+		return fmt.Errorf("token is bound to %d bytes of object %s, not %d", claims.Size, claims.Oid, p.Size)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package lfs
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/contexttest"
+	"code.gitea.io/gitea/modules/test"
+
+	lfs_module "github.com/openmerlin/gitea_data/modules/lfs"
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockSigningKeys signs with a LFS_JWT_SECRET and a keys file of its own
+func mockSigningKeys(t *testing.T) {
+	t.Cleanup(test.MockVariableValue(&setting.LFS.JWTSecretBytes, []byte(strings.Repeat("s", 32))))
+	t.Cleanup(test.MockVariableValue(&setting.LFS.JWTKeysFile, filepath.Join(t.TempDir(), "jwt_keys.json")))
+	signingKeys.set = nil
+	t.Cleanup(func() {
+		signingKeys.set = nil
+	})
+}
+
+func TestActionLinkObjectBinding(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	mockSigningKeys(t)
+	defer test.MockVariableValue(&setting.LFS.ActionLinkExpiry, 10*time.Minute)()
+
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 54})
+	object := lfs_module.Pointer{Oid: "0b8d8b5f15046343fd32f451df93acc2bdd9e6373be478b968e4cad6b6647351", Size: 107}
+	other := lfs_module.Pointer{Oid: strings.Repeat("1", 64), Size: 107}
+
+	rc := &requestContext{User: "user2", Repo: "lfs", RepoID: repo.ID, UserID: 2}
+	download := rc.actionLink("https://example.com/download", object, "download").Header["Authorization"]
+	upload := rc.actionLink("https://example.com/upload", object, "upload").Header["Authorization"]
+	require.True(t, strings.HasPrefix(download, "Bearer "))
+	require.True(t, strings.HasPrefix(upload, "Bearer "))
+
+	// authenticate the request of an action link for p the way the object handlers do
+	check := func(authorization string, p lfs_module.Pointer, requireWrite bool) error {
+		ctx, _ := contexttest.MockContext(t, "/user2/lfs.git/info/lfs/objects/"+p.Oid)
+		setRequestObject(ctx, p)
+		if err := authenticate(ctx, repo, authorization, true, requireWrite); err != nil {
+			return err
+		}
+		assert.EqualValues(t, 2, ctx.Doer.ID)
+		return nil
+	}
+
+	assert.NoError(t, check(download, object, false))
+	assert.NoError(t, check(upload, object, true))
+	// downloads don't state the size
+	assert.NoError(t, check(download, lfs_module.Pointer{Oid: object.Oid}, false))
+
+	t.Run("OtherObject", func(t *testing.T) {
+		assert.ErrorContains(t, check(download, other, false), "token is bound to object "+object.Oid)
+		assert.ErrorContains(t, check(upload, other, true), "token is bound to object "+object.Oid)
+	})
+	t.Run("OtherSize", func(t *testing.T) {
+		assert.ErrorContains(t, check(upload, lfs_module.Pointer{Oid: object.Oid, Size: 108}, true), "token is bound to 107 bytes")
+		assert.ErrorContains(t, check(download, lfs_module.Pointer{Oid: object.Oid, Size: 1}, false), "token is bound to 107 bytes")
+	})
+	t.Run("OtherOperation", func(t *testing.T) {
+		assert.Error(t, check(download, object, true))
+	})
+	t.Run("NoObject", func(t *testing.T) {
+		// a batch request names no single object
+		ctx, _ := contexttest.MockContext(t, "/user2/lfs.git/info/lfs/objects/batch")
+		assert.Error(t, authenticate(ctx, repo, upload, true, true))
+	})
+}
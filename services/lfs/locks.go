@@ -65,10 +65,8 @@ func GetListLockHandler(ctx *context.Context) {
 		return
 	}
 
-	authenticated := authenticate(ctx, repository, rv.Authorization, true, false)
-	if !authenticated {
-		ctx.Resp.Header().Set("WWW-Authenticate", "Basic realm=gitea-lfs")
-		ctx.JSON(http.StatusUnauthorized, api.LFSLockError{
+	if err := authenticate(ctx, repository, rv.Authorization, true, false); err != nil {
+		ctx.JSON(authChallenge(ctx, err), api.LFSLockError{
 			Message: "You must have pull access to list locks",
 		})
 		return
@@ -157,10 +155,8 @@ func PostLockHandler(ctx *context.Context) {
 		return
 	}
 
-	authenticated := authenticate(ctx, repository, authorization, true, true)
-	if !authenticated {
-		ctx.Resp.Header().Set("WWW-Authenticate", "Basic realm=gitea-lfs")
-		ctx.JSON(http.StatusUnauthorized, api.LFSLockError{
+	if err := authenticate(ctx, repository, authorization, true, true); err != nil {
+		ctx.JSON(authChallenge(ctx, err), api.LFSLockError{
 			Message: "You must have push access to create locks",
 		})
 		return
@@ -229,10 +225,8 @@ func VerifyLockHandler(ctx *context.Context) {
 		return
 	}
 
-	authenticated := authenticate(ctx, repository, authorization, true, true)
-	if !authenticated {
-		ctx.Resp.Header().Set("WWW-Authenticate", "Basic realm=gitea-lfs")
-		ctx.JSON(http.StatusUnauthorized, api.LFSLockError{
+	if err := authenticate(ctx, repository, authorization, true, true); err != nil {
+		ctx.JSON(authChallenge(ctx, err), api.LFSLockError{
 			Message: "You must have push access to verify locks",
 		})
 		return
@@ -300,10 +294,8 @@ func UnLockHandler(ctx *context.Context) {
 		return
 	}
 
-	authenticated := authenticate(ctx, repository, authorization, true, true)
-	if !authenticated {
-		ctx.Resp.Header().Set("WWW-Authenticate", "Basic realm=gitea-lfs")
-		ctx.JSON(http.StatusUnauthorized, api.LFSLockError{
+	if err := authenticate(ctx, repository, authorization, true, true); err != nil {
+		ctx.JSON(authChallenge(ctx, err), api.LFSLockError{
 			Message: "You must have push access to delete locks",
 		})
 		return
@@ -1,22 +1,29 @@
 package lfs
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
 	"strconv"
+	"time"
 
 	auth_model "code.gitea.io/gitea/models/auth"
 	"code.gitea.io/gitea/models/perm"
+	access_model "code.gitea.io/gitea/models/perm/access"
 	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unit"
 	user_model "code.gitea.io/gitea/models/user"
 	"code.gitea.io/gitea/modules/context"
 	"code.gitea.io/gitea/modules/json"
 	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/timeutil"
+	"code.gitea.io/gitea/modules/util"
 	"github.com/openmerlin/gitea_data/modules/setting"
 
+	data_auth_model "github.com/openmerlin/gitea_data/models/auth"
 	git_model "github.com/openmerlin/gitea_data/models/git"
 	lfs_module "github.com/openmerlin/gitea_data/modules/lfs"
 	"github.com/openmerlin/gitea_data/modules/storage"
@@ -174,7 +181,13 @@ func MultiPartVerifyHandler(ctx *context.Context) {
 		return
 	}
 
+	var p = lfs_module.Pointer{
+		Oid:  ctx.Req.URL.Query().Get("oid"),
+		Size: size,
+	}
+
 	rc := getRequestContext(ctx)
+	setRequestObject(ctx, p)
 	repository := getAuthenticatedRepository(ctx, rc, true)
 	if repository == nil {
 		log.Error("lfs[multipart] failed to authenticate repository")
@@ -182,11 +195,6 @@ func MultiPartVerifyHandler(ctx *context.Context) {
 		return
 	}
 
-	var p = lfs_module.Pointer{
-		Oid:  ctx.Req.URL.Query().Get("oid"),
-		Size: size,
-	}
-
 	contentStore := lfs_module.NewContentStore()
 	//check whether object exists
 	exists, err := contentStore.Exists(p)
@@ -240,12 +248,6 @@ func buildMultiPartObjectResponse(
 	} else {
 		rep.Actions = lfs_module.ObjectResponseActionWithMultipart{}
 
-		header := make(map[string]string)
-
-		if len(rc.Authorization) > 0 {
-			header["Authorization"] = rc.Authorization
-		}
-
 		if download {
 			var link *structs.MultipartEndpoint
 			if setting.LFS.Storage.MinioConfig.ServeDirect {
@@ -254,12 +256,11 @@ func buildMultiPartObjectResponse(
 				if u != nil && err == nil {
 					// Presigned url does not need the Authorization header
 					// https://github.com/go-gitea/gitea/issues/21525
-					delete(header, "Authorization")
-					link = &structs.MultipartEndpoint{Href: u.String(), Headers: &header}
+					link = &structs.MultipartEndpoint{Href: u.String(), Headers: &map[string]string{}}
 				}
 			}
 			if link == nil {
-				link = &structs.MultipartEndpoint{Href: rc.DownloadLink(pointer), Headers: &header}
+				link = multipartEndpoint(rc.actionLink(rc.DownloadLink(pointer), pointer, "download"))
 			}
 			rep.Actions.Download = link
 		}
@@ -270,13 +271,15 @@ func buildMultiPartObjectResponse(
 				headers := make(map[string]string)
 				verify.Headers = &headers
 			}
-			for key, value := range header {
+			verifyLink := rc.actionLink(rc.MultipartVerifyLink(pointer), pointer, "upload")
+			for key, value := range verifyLink.Header {
 				(*verify.Headers)[key] = value
 			}
+			verify.ExpiresIn = multipartEndpoint(verifyLink).ExpiresIn
 			// This is only needed to workaround https://github.com/git-lfs/git-lfs/issues/3662
 			(*verify.Headers)["Accept"] = lfs_module.MediaType
 			//add verify
-			verify.Href = rc.MultipartVerifyLink(pointer)
+			verify.Href = verifyLink.Href
 			verify.Method = http.MethodPost
 			rep.Actions.Verify = verify
 		}
@@ -284,6 +287,15 @@ func buildMultiPartObjectResponse(
 	return rep
 }
 
+// multipartEndpoint converts an action link to the endpoint of a multipart transfer
+func multipartEndpoint(link *lfs_module.Link) *structs.MultipartEndpoint {
+	endpoint := &structs.MultipartEndpoint{Href: link.Href, Headers: &link.Header}
+	if link.ExpiresAt != nil {
+		endpoint.ExpiresIn = int(time.Until(*link.ExpiresAt).Seconds())
+	}
+	return endpoint
+}
+
 func isMultipartTransfers(transfers []string) bool {
 	for _, a := range transfers {
 		if a == "multipart" {
@@ -293,19 +305,106 @@ func isMultipartTransfers(transfers []string) bool {
 	return false
 }
 
+// accessTokenError rejects an access token of the "access_token" scheme. A token which is invalid or expired
+// is answered with 401 so that the client asks for credentials again, a valid token lacking rights with 403.
+type accessTokenError struct {
+	Forbidden bool
+	Reason    string
+}
+
+func (err accessTokenError) Error() string {
+	return err.Reason
+}
+
+func (err accessTokenError) Unwrap() error {
+	if err.Forbidden {
+		return util.ErrPermissionDenied
+	}
+	return util.ErrInvalidArgument
+}
+
 func handleLFSAccessToken(ctx *context.Context, accesToken string, target *repo_model.Repository, mode perm.AccessMode) (*user_model.User, error) {
 	token, err := auth_model.GetAccessTokenBySHA(ctx, accesToken)
 	if err != nil {
+		if auth_model.IsErrAccessTokenNotExist(err) || auth_model.IsErrAccessTokenEmpty(err) {
+			return nil, accessTokenError{Reason: "invalid access token"}
+		}
 		log.Error("unable to get user access token for lfs operation %v", err)
 		return nil, err
 	}
+	if err := checkLFSAccessToken(ctx, token, target, mode); err != nil {
+		log.Warn("LFS access token[%d] rejected for %s access to %-v: %v", token.ID, mode, target, err)
+		return nil, err
+	}
+
 	u, err := user_model.GetUserByID(ctx, token.UID)
-	log.Trace("Basic Authorization: Valid AccessToken for user[%d]", u.ID)
 	if err != nil {
 		log.Error("unable to get user id by token for lfs operation %v", err)
 		return nil, err
 	}
+	if !u.IsActive || u.ProhibitLogin {
+		log.Warn("LFS access token[%d] rejected: user %-v may not sign in", token.ID, u)
+		return nil, accessTokenError{Forbidden: true, Reason: "user may not sign in"}
+	}
+	// the token never grants more than its user has
+	userPerm, err := access_model.GetUserRepoPermission(ctx, target, u)
+	if err != nil {
+		log.Error("Unable to GetUserRepoPermission for user %-v in repo %-v Error: %v", u, target, err)
+		return nil, err
+	}
+	if !userPerm.CanAccess(mode, unit.TypeCode) {
+		log.Warn("LFS access token[%d] rejected: user %-v has no %s access to %-v", token.ID, u, mode, target)
+		return nil, accessTokenError{Forbidden: true, Reason: "insufficient repository permission"}
+	}
+	log.Trace("Basic Authorization: Valid AccessToken for user[%d]", u.ID)
+
+	token.UpdatedUnix = timeutil.TimeStampNow()
+	if err := auth_model.UpdateAccessToken(ctx, token); err != nil {
+		log.Error("UpdateAccessToken: %v", err)
+	}
+
 	ctx.Data["IsApiToken"] = true
 	ctx.Data["ApiTokenScope"] = token.Scope
+	ctx.Data["AccessTokenID"] = token.ID
 	return u, nil
 }
+
+// checkAccessTokenPolicy checks the expiry and the repository binding of the token for the access to target
+func checkAccessTokenPolicy(ctx *context.Context, tokenID int64, target *repo_model.Repository) error {
+	err := data_auth_model.CheckAccessTokenPolicy(ctx, tokenID, target.ID)
+	var expired data_auth_model.ErrAccessTokenExpired
+	var notBound data_auth_model.ErrAccessTokenNotBound
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &expired):
+		return accessTokenError{Reason: "access token has expired"}
+	case errors.As(err, &notBound):
+		return accessTokenError{Forbidden: true, Reason: "access token is not bound to the repository"}
+	}
+	return fmt.Errorf("CheckAccessTokenPolicy: %w", err)
+}
+
+// checkLFSAccessToken checks the scope, the repository binding and the expiry of the token for the access to target
+func checkLFSAccessToken(ctx *context.Context, token *auth_model.AccessToken, target *repo_model.Repository, mode perm.AccessMode) error {
+	if err := checkAccessTokenPolicy(ctx, token.ID, target); err != nil {
+		return err
+	}
+
+	publicOnly, err := token.Scope.PublicOnly()
+	if err != nil {
+		return fmt.Errorf("PublicOnly: %w", err)
+	}
+	if publicOnly && target.IsPrivate {
+		return accessTokenError{Forbidden: true, Reason: "access token is limited to public repositories"}
+	}
+	requiredScopes := auth_model.GetRequiredScopes(data_auth_model.GetScopeLevelFromAccessMode(mode), auth_model.AccessTokenScopeCategoryRepository)
+	scopeMatched, err := token.Scope.HasScope(requiredScopes...)
+	if err != nil {
+		return fmt.Errorf("HasScope: %w", err)
+	}
+	if !scopeMatched {
+		return accessTokenError{Forbidden: true, Reason: fmt.Sprintf("access token requires scope %v", requiredScopes)}
+	}
+	return nil
+}
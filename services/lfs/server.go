@@ -4,7 +4,6 @@
 package lfs
 
 import (
-	stdCtx "context"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
@@ -30,8 +29,14 @@ import (
 	"github.com/openmerlin/gitea_data/modules/setting"
 
 	git_model "github.com/openmerlin/gitea_data/models/git"
+	data_repo_model "github.com/openmerlin/gitea_data/models/repo"
+	data_context "github.com/openmerlin/gitea_data/modules/context"
 	lfs_module "github.com/openmerlin/gitea_data/modules/lfs"
+	"github.com/openmerlin/gitea_data/modules/ratelimit"
 	"github.com/openmerlin/gitea_data/modules/storage"
+	"github.com/openmerlin/gitea_data/services/oidcauth"
+	"github.com/openmerlin/gitea_data/services/pathgrant"
+	"github.com/openmerlin/gitea_data/services/transfer"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/minio/sha256-simd"
@@ -42,6 +47,10 @@ type requestContext struct {
 	User          string
 	Repo          string
 	Authorization string
+	// RepoID, UserID and DeployKeyID are what the action links are signed for once the request is authenticated
+	RepoID      int64
+	UserID      int64
+	DeployKeyID int64
 }
 
 // Claims is a JWT Token Claims
@@ -49,6 +58,11 @@ type Claims struct {
 	RepoID int64
 	Op     string
 	UserID int64
+	// DeployKeyID is set when the token was issued to a deploy key, so that its path grants apply
+	DeployKeyID int64 `json:",omitempty"`
+	// Oid and Size bind the token of an action link to its object
+	Oid  string `json:",omitempty"`
+	Size int64  `json:",omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -135,6 +149,10 @@ func DownloadHandler(ctx *context.Context) {
 	}
 
 	contentLength := toByte + 1 - fromByte
+	if !ratelimit.TakeBytes(ctx, "lfs_download", contentLength) {
+		return
+	}
+
 	ctx.Resp.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
 	ctx.Resp.Header().Set("Content-Type", "application/octet-stream")
 
@@ -148,9 +166,17 @@ func DownloadHandler(ctx *context.Context) {
 	}
 
 	ctx.Resp.WriteHeader(statusCode)
-	if written, err := io.CopyN(ctx.Resp, content, contentLength); err != nil {
+	written, err := io.CopyN(ctx.Resp, content, contentLength)
+	if err != nil {
 		log.Error("Error whilst copying LFS OID[%s] to the response after %d bytes. Error: %v", meta.Oid, written, err)
 	}
+	transfer.Record(transfer.Transfer{
+		RepoID:    meta.RepositoryID,
+		OwnerName: rc.User,
+		Service:   transfer.ServiceLFSDownload,
+		AuthType:  transfer.AuthType(ctx),
+		BytesOut:  written,
+	})
 }
 
 // BatchHandler provides the batch api
@@ -173,6 +199,18 @@ func BatchHandler(ctx *context.Context, br *lfs_module.BatchRequest) {
 		return
 	}
 
+	var prefixes []string
+	if isUpload {
+		deployKeyID, _ := ctx.Data["DeployKeyID"].(int64)
+		var err error
+		prefixes, err = pathgrant.Get(ctx, repository.ID, deployKeyID, pathgrant.AccessTokenID(ctx))
+		if err != nil {
+			log.Error("Unable to get the path grants of the credential for %s/%s. Error: %v", rc.User, rc.Repo, err)
+			writeStatus(ctx, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	contentStore := lfs_module.NewContentStore()
 
 	var responseObjects []*lfs_module.ObjectResponse
@@ -211,6 +249,14 @@ func BatchHandler(ctx *context.Context, br *lfs_module.BatchRequest) {
 		var responseObject *lfs_module.ObjectResponse
 		if isUpload {
 			var err *lfs_module.ObjectError
+			// the path of an object is only known if the client hints it for the ref it pushes
+			if path, ok := br.PathHints[p.Oid]; ok && br.Ref != nil && !pathgrant.Allowed(prefixes, path) {
+				responseObjects = append(responseObjects, buildObjectResponse(rc, p, false, false, &lfs_module.ObjectError{
+					Code:    http.StatusForbidden,
+					Message: fmt.Sprintf("The credential may not write to %s", path),
+				}))
+				continue
+			}
 			if !exists && setting.LFS.MaxFileSize > 0 && p.Size > setting.LFS.MaxFileSize {
 				err = &lfs_module.ObjectError{
 					Code:    http.StatusUnprocessableEntity,
@@ -278,6 +324,7 @@ func UploadHandler(ctx *context.Context) {
 		return
 	}
 
+	setRequestObject(ctx, p)
 	repository := getAuthenticatedRepository(ctx, rc, true)
 	if repository == nil {
 		return
@@ -291,6 +338,17 @@ func UploadHandler(ctx *context.Context) {
 		return
 	}
 
+	body := transfer.NewCountingReader(ctx.Req.Body)
+	defer func() {
+		transfer.Record(transfer.Transfer{
+			RepoID:    repository.ID,
+			OwnerName: rc.User,
+			Service:   transfer.ServiceLFSUpload,
+			AuthType:  transfer.AuthType(ctx),
+			BytesIn:   body.Count(),
+		})
+	}()
+
 	uploadOrVerify := func() error {
 		if exists {
 			accessible, err := git_model.LFSObjectAccessible(ctx, ctx.Doer, p.Oid)
@@ -302,7 +360,7 @@ func UploadHandler(ctx *context.Context) {
 				// The file exists but the user has no access to it.
 				// The upload gets verified by hashing and size comparison to prove access to it.
 				hash := sha256.New()
-				written, err := io.Copy(hash, ctx.Req.Body)
+				written, err := io.Copy(hash, body)
 				if err != nil {
 					log.Error("Error creating hash. Error: %v", err)
 					return err
@@ -315,7 +373,7 @@ func UploadHandler(ctx *context.Context) {
 					return lfs_module.ErrHashMismatch
 				}
 			}
-		} else if err := contentStore.Put(p, ctx.Req.Body); err != nil {
+		} else if err := contentStore.Put(p, body); err != nil {
 			log.Error("Error putting LFS MetaObject [%s] into content store. Error: %v", p.Oid, err)
 			return err
 		}
@@ -338,9 +396,35 @@ func UploadHandler(ctx *context.Context) {
 		return
 	}
 
+	auditLFSUpload(ctx, repository, p)
+
 	writeStatus(ctx, http.StatusOK)
 }
 
+// auditLFSUpload records an upload in the push audit log, with the client IP like the pushes of the other transports
+func auditLFSUpload(ctx *context.Context, repository *repo_model.Repository, p lfs_module.Pointer) {
+	audit := &data_repo_model.PushAudit{
+		RepoID:     repository.ID,
+		OwnerName:  repository.OwnerName,
+		RepoName:   repository.Name,
+		AuthMethod: transfer.AuthType(ctx),
+		ClientIP:   data_context.ClientIP(ctx.Base),
+		Event:      data_repo_model.PushAuditLFSUpload,
+		LFSOid:     p.Oid,
+		LFSSize:    p.Size,
+	}
+	if ctx.Doer != nil {
+		audit.PusherID = ctx.Doer.ID
+		audit.PusherName = ctx.Doer.Name
+	}
+	if taskID, ok := ctx.Data["ActionsTaskID"].(int64); ok && ctx.Data["IsActionsToken"] == true {
+		audit.AuthID = taskID
+	}
+	if err := data_repo_model.InsertPushAudit(ctx, audit); err != nil {
+		log.Error("Unable to record the LFS upload audit of OID[%s] in %s: %v", p.Oid, repository.FullName(), err)
+	}
+}
+
 // VerifyHandler verify oid and its size from the content store
 func VerifyHandler(ctx *context.Context) {
 	var p lfs_module.Pointer
@@ -391,6 +475,7 @@ func getAuthenticatedMeta(ctx *context.Context, rc *requestContext, p lfs_module
 		return nil
 	}
 
+	setRequestObject(ctx, p)
 	repository := getAuthenticatedRepository(ctx, rc, requireWrite)
 	if repository == nil {
 		return nil
@@ -414,10 +499,15 @@ func getAuthenticatedRepository(ctx *context.Context, rc *requestContext, requir
 		return nil
 	}
 
-	if !authenticate(ctx, repository, rc.Authorization, false, requireWrite) {
-		requireAuth(ctx)
+	if err := authenticate(ctx, repository, rc.Authorization, false, requireWrite); err != nil {
+		requireAuth(ctx, err)
 		return nil
 	}
+	rc.RepoID = repository.ID
+	if ctx.Doer != nil {
+		rc.UserID = ctx.Doer.ID
+	}
+	rc.DeployKeyID, _ = ctx.Data["DeployKeyID"].(int64)
 
 	if requireWrite {
 		context.CheckRepoScopedToken(ctx, repository, auth_model.Write)
@@ -439,12 +529,6 @@ func buildObjectResponse(rc *requestContext, pointer lfs_module.Pointer, downloa
 	} else {
 		rep.Actions = make(map[string]*lfs_module.Link)
 
-		header := make(map[string]string)
-
-		if len(rc.Authorization) > 0 {
-			header["Authorization"] = rc.Authorization
-		}
-
 		if download {
 			var link *lfs_module.Link
 			if setting.LFS.Storage.MinioConfig.ServeDirect {
@@ -453,27 +537,22 @@ func buildObjectResponse(rc *requestContext, pointer lfs_module.Pointer, downloa
 				if u != nil && err == nil {
 					// Presigned url does not need the Authorization header
 					// https://github.com/go-gitea/gitea/issues/21525
-					delete(header, "Authorization")
-					link = &lfs_module.Link{Href: u.String(), Header: header}
+					link = &lfs_module.Link{Href: u.String()}
 				}
 			}
 			if link == nil {
-				link = &lfs_module.Link{Href: rc.DownloadLink(pointer), Header: header}
+				link = rc.actionLink(rc.DownloadLink(pointer), pointer, "download")
 			}
 			rep.Actions["download"] = link
 		}
 		if upload {
-			rep.Actions["upload"] = &lfs_module.Link{Href: rc.UploadLink(pointer), Header: header}
-
-			verifyHeader := make(map[string]string)
-			for key, value := range header {
-				verifyHeader[key] = value
-			}
+			rep.Actions["upload"] = rc.actionLink(rc.UploadLink(pointer), pointer, "upload")
 
+			verify := rc.actionLink(rc.VerifyLink(pointer), pointer, "upload")
 			// This is only needed to workaround https://github.com/git-lfs/git-lfs/issues/3662
-			verifyHeader["Accept"] = lfs_module.MediaType
+			verify.Header["Accept"] = lfs_module.MediaType
 
-			rep.Actions["verify"] = &lfs_module.Link{Href: rc.VerifyLink(pointer), Header: verifyHeader}
+			rep.Actions["verify"] = verify
 		}
 	}
 	return rep
@@ -495,9 +574,13 @@ func writeStatusMessage(ctx *context.Context, status int, message string) {
 	}
 }
 
+// errActionsTaskDenied rejects an actions token used outside of its repository or rights
+var errActionsTaskDenied = errors.New("actions task has no access to the repository")
+
 // authenticate uses the authorization string to determine whether
 // or not to proceed. This server assumes an HTTP Basic auth format.
-func authenticate(ctx *context.Context, repository *repo_model.Repository, authorization string, requireSigned, requireWrite bool) bool {
+// The returned error tells requireAuth how to reject the request.
+func authenticate(ctx *context.Context, repository *repo_model.Repository, authorization string, requireSigned, requireWrite bool) error {
 	accessMode := perm.AccessModeRead
 	if requireWrite {
 		accessMode = perm.AccessModeWrite
@@ -508,50 +591,57 @@ func authenticate(ctx *context.Context, repository *repo_model.Repository, autho
 		task, err := actions_model.GetTaskByID(ctx, taskID)
 		if err != nil {
 			log.Error("Unable to GetTaskByID for task[%d] Error: %v", taskID, err)
-			return false
+			return err
 		}
 		if task.RepoID != repository.ID {
-			return false
+			return errActionsTaskDenied
 		}
 
-		if task.IsForkPullRequest {
-			return accessMode <= perm.AccessModeRead
+		if task.IsForkPullRequest && accessMode > perm.AccessModeRead {
+			return errActionsTaskDenied
+		}
+		return nil
+	}
+
+	if identity := oidcauth.GetIdentity(ctx.Data); identity != nil {
+		return checkOIDCIdentity(ctx, identity, repository, accessMode)
+	}
+
+	// the policy of a personal access token applies however the token was sent
+	if tokenID := pathgrant.AccessTokenID(ctx); tokenID > 0 {
+		if err := checkAccessTokenPolicy(ctx, tokenID, repository); err != nil {
+			log.Warn("Access token[%d] rejected for %s access to %-v: %v", tokenID, accessMode, repository, err)
+			return err
 		}
-		return accessMode <= perm.AccessModeWrite
 	}
 
 	// ctx.IsSigned is unnecessary here, this will be checked in perm.CanAccess
 	perm, err := access_model.GetUserRepoPermission(ctx, repository, ctx.Doer)
 	if err != nil {
 		log.Error("Unable to GetUserRepoPermission for user %-v in repo %-v Error: %v", ctx.Doer, repository, err)
-		return false
+		return err
 	}
 
 	canRead := perm.CanAccess(accessMode, unit.TypeCode)
 	if canRead && (!requireSigned || ctx.IsSigned) {
-		return true
+		return nil
 	}
 
 	user, err := parseToken(ctx, authorization, repository, accessMode)
 	if err != nil {
 		// Most of these are Warn level - the true internal server errors are logged in parseToken already
 		log.Warn("Authentication failure for provided token with Error: %v", err)
-		return false
+		return err
 	}
 	ctx.Doer = user
-	return true
+	return nil
 }
 
-func handleLFSToken(ctx stdCtx.Context, tokenSHA string, target *repo_model.Repository, mode perm.AccessMode) (*user_model.User, error) {
+func handleLFSToken(ctx *context.Context, tokenSHA string, target *repo_model.Repository, mode perm.AccessMode) (*user_model.User, error) {
 	if !strings.Contains(tokenSHA, ".") {
 		return nil, nil
 	}
-	token, err := jwt.ParseWithClaims(tokenSHA, &Claims{}, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-		return setting.LFS.JWTSecretBytes, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenSHA, &Claims{}, verificationKey)
 	if err != nil {
 		return nil, nil
 	}
@@ -569,14 +659,53 @@ func handleLFSToken(ctx stdCtx.Context, tokenSHA string, target *repo_model.Repo
 		return nil, fmt.Errorf("invalid token claim")
 	}
 
-	u, err := user_model.GetUserByID(ctx, claims.UserID)
+	if err := checkObjectClaims(ctx, claims); err != nil {
+		return nil, fmt.Errorf("invalid token claim: %w", err)
+	}
+
+	var u *user_model.User
+	if claims.UserID == user_model.ActionsUserID {
+		// action links are signed for the actions user too
+		u = user_model.NewActionsUser()
+	} else {
+		u, err = user_model.GetUserByID(ctx, claims.UserID)
+	}
 	if err != nil {
 		log.Error("Unable to GetUserById[%d]: Error: %v", claims.UserID, err)
 		return nil, err
 	}
+	if claims.DeployKeyID > 0 {
+		ctx.Data["DeployKeyID"] = claims.DeployKeyID
+	}
 	return u, nil
 }
 
+// handleOIDCToken authenticates a JWT of a trusted OIDC issuer, the rules matching it limit the access
+func handleOIDCToken(ctx *context.Context, raw string, target *repo_model.Repository, mode perm.AccessMode) (*user_model.User, error) {
+	identity, err := oidcauth.Authenticate(ctx, raw)
+	if err != nil {
+		return nil, accessTokenError{Reason: err.Error()}
+	}
+	if err := checkOIDCIdentity(ctx, identity, target, mode); err != nil {
+		return nil, err
+	}
+	oidcauth.SetIdentity(ctx.Data, identity)
+	ctx.Data["AuthedMethod"] = oidcauth.MethodName
+	return identity.User, nil
+}
+
+func checkOIDCIdentity(ctx *context.Context, identity *oidcauth.Identity, target *repo_model.Repository, mode perm.AccessMode) error {
+	ok, err := identity.CanAccess(ctx, target, mode, unit.TypeCode)
+	if err != nil {
+		log.Error("Unable to check the access of the federated token of %s for %q to %-v: %v", identity.Issuer, identity.Subject, target, err)
+		return err
+	}
+	if !ok {
+		return accessTokenError{Forbidden: true, Reason: fmt.Sprintf("federated token of %s for %q has no %s access to the repository", identity.Issuer, identity.Subject, mode)}
+	}
+	return nil
+}
+
 func parseToken(ctx *context.Context, authorization string, target *repo_model.Repository, mode perm.AccessMode) (*user_model.User, error) {
 	if authorization == "" {
 		return nil, fmt.Errorf("no token")
@@ -591,6 +720,9 @@ func parseToken(ctx *context.Context, authorization string, target *repo_model.R
 	case "access_token":
 		return handleLFSAccessToken(ctx, tokenSHA, target, mode)
 	case "bearer":
+		if oidcauth.IsTrustedToken(tokenSHA) {
+			return handleOIDCToken(ctx, tokenSHA, target, mode)
+		}
 		fallthrough
 	case "token":
 		return handleLFSToken(ctx, tokenSHA, target, mode)
@@ -598,7 +730,22 @@ func parseToken(ctx *context.Context, authorization string, target *repo_model.R
 	return nil, fmt.Errorf("token not found")
 }
 
-func requireAuth(ctx *context.Context) {
-	ctx.Resp.Header().Set("WWW-Authenticate", "Basic realm=gitea-lfs")
-	writeStatus(ctx, http.StatusUnauthorized)
+func requireAuth(ctx *context.Context, err error) {
+	writeStatus(ctx, authChallenge(ctx, err))
+}
+
+// authChallenge sets the WWW-Authenticate header for an authentication failure and returns the status to respond with:
+// 403 for an access token lacking rights, otherwise 401 so that the client asks for credentials
+func authChallenge(ctx *context.Context, err error) int {
+	var tokenErr accessTokenError
+	if !errors.As(err, &tokenErr) {
+		ctx.Resp.Header().Set("WWW-Authenticate", "Basic realm=gitea-lfs")
+		return http.StatusUnauthorized
+	}
+	if tokenErr.Forbidden {
+		ctx.Resp.Header().Set("WWW-Authenticate", `Basic realm=gitea-lfs, error="insufficient_scope"`)
+		return http.StatusForbidden
+	}
+	ctx.Resp.Header().Set("WWW-Authenticate", `Basic realm=gitea-lfs, error="invalid_token"`)
+	return http.StatusUnauthorized
 }
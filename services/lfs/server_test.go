@@ -0,0 +1,91 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package lfs
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	auth_model "code.gitea.io/gitea/models/auth"
+	"code.gitea.io/gitea/models/db"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/contexttest"
+	"code.gitea.io/gitea/modules/setting"
+	"code.gitea.io/gitea/modules/test"
+	"code.gitea.io/gitea/modules/timeutil"
+	"code.gitea.io/gitea/routers/common"
+	auth_service "code.gitea.io/gitea/services/auth"
+
+	data_auth_model "github.com/openmerlin/gitea_data/models/auth"
+	data_repo_model "github.com/openmerlin/gitea_data/models/repo"
+	lfs_module "github.com/openmerlin/gitea_data/modules/lfs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTokenRequest returns the context of a request authenticated by the auth methods of the routes
+func newTokenRequest(t *testing.T, reqPath, authorization string) *context.Context {
+	ctx, _ := contexttest.MockContext(t, reqPath)
+	ctx.Req.Header = http.Header{"Authorization": []string{authorization}}
+	ctx.SetParams("username", "user2")
+	ctx.SetParams("reponame", "repo1.git")
+
+	ar, err := common.AuthShared(ctx.Base, nil, auth_service.NewGroup(&auth_service.OAuth2{}, &auth_service.Basic{}))
+	require.NoError(t, err)
+	require.NotNil(t, ar.Doer)
+	ctx.Doer, ctx.IsSigned, ctx.IsBasicAuth = ar.Doer, true, ar.IsBasicAuth
+	return ctx
+}
+
+func TestBatchHandlerAccessTokenPolicy(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	defer test.MockVariableValue(&setting.LFS.StartServer, true)()
+
+	token := &auth_model.AccessToken{UID: 2, Name: "lfs-policy", Scope: auth_model.AccessTokenScopeAll}
+	require.NoError(t, auth_model.NewAccessToken(db.DefaultContext, token))
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("user2:"+token.Token))
+
+	batch := func(t *testing.T, policy *data_auth_model.AccessTokenPolicy) int {
+		policy.TokenID = token.ID
+		require.NoError(t, data_auth_model.SetAccessTokenPolicy(db.DefaultContext, policy))
+		ctx := newTokenRequest(t, "POST /user2/repo1.git/info/lfs/objects/batch", basic)
+		BatchHandler(ctx, &lfs_module.BatchRequest{Operation: "download"})
+		return ctx.Resp.Status()
+	}
+
+	t.Run("Unrestricted", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, batch(t, &data_auth_model.AccessTokenPolicy{}))
+	})
+	t.Run("BoundToRepo", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, batch(t, &data_auth_model.AccessTokenPolicy{RepoIDs: []int64{1}}))
+	})
+	t.Run("BoundToOtherRepo", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, batch(t, &data_auth_model.AccessTokenPolicy{RepoIDs: []int64{2}}))
+	})
+	t.Run("Expired", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, batch(t, &data_auth_model.AccessTokenPolicy{ExpiresUnix: timeutil.TimeStampNow() - 60}))
+	})
+}
+
+func TestAuditLFSUpload(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+
+	ctx, _ := contexttest.MockContext(t, "PUT /user2/repo1.git/info/lfs/objects/oid/6")
+	ctx.Req.RemoteAddr = "192.0.2.1:50001"
+	contexttest.LoadUser(t, ctx, 2)
+	p := lfs_module.Pointer{Oid: "2eccdb43825d2a49d99d542daa20075cff1d97d9d2349a8977efe9c03661737c", Size: 6}
+	auditLFSUpload(ctx, repo, p)
+
+	// the client IP without the port, like the IP of the pushes over SSH
+	audit := unittest.AssertExistsAndLoadBean(t, &data_repo_model.PushAudit{RepoID: repo.ID, LFSOid: p.Oid})
+	assert.Equal(t, data_repo_model.PushAuditLFSUpload, audit.Event)
+	assert.Equal(t, "192.0.2.1", audit.ClientIP)
+	assert.EqualValues(t, 2, audit.PusherID)
+	assert.EqualValues(t, 6, audit.LFSSize)
+}
@@ -0,0 +1,502 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package lfs
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/util"
+	"code.gitea.io/gitea/services/auth/source/oauth2"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The tokens are signed by the active key of a key set kept in LFS_JWT_KEYS_FILE, so that serv signs with the same keys
+// as the web server. A key is rotated without downtime by staging it first, so that it is published and accepted
+// everywhere before it signs, and then activating it. The replaced key keeps verifying until its tokens have expired.
+// Without a keys file the LFS_JWT_SECRET is the only key, its tokens have no "kid". The file only keeps the state of that key,
+// its secret always comes from the configuration, so that changing LFS_JWT_SECRET still replaces it.
+
+// SigningKey is what the private API shows of a key of the key set, the key material never leaves the server
+type SigningKey struct {
+	KID       string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Active    bool   `json:"active"`
+	// Staged keys are accepted but don't sign yet, retired keys are accepted until their tokens have expired
+	CreatedUnix   int64 `json:"created_unix"`
+	ActivatedUnix int64 `json:"activated_unix,omitempty"`
+	RetiredUnix   int64 `json:"retired_unix,omitempty"`
+}
+
+// storedSigningKey is a key in the keys file
+type storedSigningKey struct {
+	SigningKey
+	// Key is the base64 encoded HMAC secret or PKCS#8 private key, it is empty for the LFS_JWT_SECRET
+	Key string `json:"key,omitempty"`
+}
+
+type storedKeySet struct {
+	Active string              `json:"active"`
+	Keys   []*storedSigningKey `json:"keys"`
+}
+
+// keySet is a loaded key set, modTime and size tell whether the keys file changed since
+type keySet struct {
+	stored  *storedKeySet
+	keys    map[string]oauth2.JWTSigningKey
+	modTime time.Time
+	size    int64
+}
+
+// ErrSigningKeyNotExist represents a "SigningKeyNotExist" kind of error.
+type ErrSigningKeyNotExist struct {
+	KID string
+}
+
+func (err ErrSigningKeyNotExist) Error() string {
+	return fmt.Sprintf("LFS signing key does not exist [kid: %s]", err.KID)
+}
+
+func (err ErrSigningKeyNotExist) Unwrap() error {
+	return util.ErrNotExist
+}
+
+var signingKeys struct {
+	sync.Mutex
+	set *keySet
+}
+
+// tokenLifetime is how long a token can be valid, a retired key is accepted that long
+func tokenLifetime() time.Duration {
+	return max(setting.LFS.HTTPAuthExpiry, setting.LFS.ActionLinkExpiry)
+}
+
+func (k *storedSigningKey) isExpired(now time.Time) bool {
+	return k.RetiredUnix > 0 && time.Unix(k.RetiredUnix, 0).Add(tokenLifetime()).Before(now)
+}
+
+// legacyKeySet is the key set of a server without keys file
+func legacyKeySet() *storedKeySet {
+	return &storedKeySet{
+		Keys: []*storedSigningKey{{
+			SigningKey: SigningKey{Algorithm: "HS256"},
+			Key:        legacySecret(),
+		}},
+	}
+}
+
+// legacySecret is the key of the LFS_JWT_SECRET, the key without "kid"
+func legacySecret() string {
+	return base64.RawURLEncoding.EncodeToString(setting.LFS.JWTSecretBytes)
+}
+
+func decodeSigningKey(k *storedSigningKey) (oauth2.JWTSigningKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	var key any = raw
+	if !strings.HasPrefix(k.Algorithm, "HS") {
+		if key, err = x509.ParsePKCS8PrivateKey(raw); err != nil {
+			return nil, err
+		}
+	}
+	return oauth2.CreateJWTSigningKey(k.Algorithm, key)
+}
+
+func newKeySet(stored *storedKeySet) (*keySet, error) {
+	set := &keySet{stored: stored, keys: make(map[string]oauth2.JWTSigningKey, len(stored.Keys))}
+	for _, k := range stored.Keys {
+		key, err := decodeSigningKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LFS signing key %q: %w", k.KID, err)
+		}
+		set.keys[k.KID] = key
+	}
+	if _, ok := set.keys[stored.Active]; !ok {
+		return nil, fmt.Errorf("active LFS signing key %q does not exist", stored.Active)
+	}
+	return set, nil
+}
+
+// currentKeySet returns the key set, it is loaded again whenever the keys file changed. The caller holds the lock.
+func currentKeySet() (*keySet, error) {
+	fi, err := os.Stat(setting.LFS.JWTKeysFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if fi == nil {
+		if signingKeys.set == nil || !signingKeys.set.modTime.IsZero() {
+			if signingKeys.set, err = newKeySet(legacyKeySet()); err != nil {
+				return nil, err
+			}
+		}
+		return signingKeys.set, nil
+	}
+	if set := signingKeys.set; set != nil && set.modTime.Equal(fi.ModTime()) && set.size == fi.Size() {
+		return set, nil
+	}
+
+	content, err := os.ReadFile(setting.LFS.JWTKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	stored := &storedKeySet{}
+	if err := json.Unmarshal(content, stored); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", setting.LFS.JWTKeysFile, err)
+	}
+	for _, k := range stored.Keys {
+		if k.KID == "" {
+			k.Key = legacySecret()
+		}
+	}
+	set, err := newKeySet(stored)
+	if err != nil {
+		return nil, err
+	}
+	set.modTime, set.size = fi.ModTime(), fi.Size()
+	signingKeys.set = set
+	return set, nil
+}
+
+// saveKeySet writes the key set to the keys file, keys whose tokens have all expired are dropped. The caller holds the lock.
+func saveKeySet(stored *storedKeySet) error {
+	now := time.Now()
+	keys := make([]*storedSigningKey, 0, len(stored.Keys))
+	for _, k := range stored.Keys {
+		if !k.isExpired(now) {
+			keys = append(keys, k)
+		}
+	}
+	stored.Keys = keys
+	if _, err := newKeySet(stored); err != nil {
+		return err
+	}
+
+	// the secret of the LFS_JWT_SECRET stays in the configuration
+	saved := stored.clone()
+	for _, k := range saved.Keys {
+		if k.KID == "" {
+			k.Key = ""
+		}
+	}
+	content, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(setting.LFS.JWTKeysFile)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	// the file is replaced at once, so that serv never reads half of it
+	f, err := os.CreateTemp(dir, filepath.Base(setting.LFS.JWTKeysFile)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer util.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := util.Rename(f.Name(), setting.LFS.JWTKeysFile); err != nil {
+		return err
+	}
+	signingKeys.set = nil
+	return nil
+}
+
+// generateSigningKey returns a new key for the algorithm
+func generateSigningKey(algorithm string) (*storedSigningKey, error) {
+	var key any
+	var err error
+	switch algorithm {
+	case "HS256", "HS384", "HS512":
+		key, err = util.CryptoRandomBytes(32)
+	case "RS256", "RS384", "RS512":
+		key, err = rsa.GenerateKey(rand.Reader, 4096)
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, oauth2.ErrInvalidAlgorithmType{Algorithm: algorithm}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k := &storedSigningKey{SigningKey: SigningKey{Algorithm: algorithm, CreatedUnix: time.Now().Unix()}}
+	if secret, ok := key.([]byte); ok {
+		k.Key = base64.RawURLEncoding.EncodeToString(secret)
+		// secrets are never published, so their ID only has to be unique
+		id, err := util.CryptoRandomBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		k.KID = hex.EncodeToString(id)
+		return k, nil
+	}
+
+	raw, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	k.Key = base64.RawURLEncoding.EncodeToString(raw)
+	signingKey, err := oauth2.CreateJWTSigningKey(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := signingKey.ToJWK()
+	if err != nil {
+		return nil, err
+	}
+	k.KID = jwk["kid"]
+	return k, nil
+}
+
+// clone returns a copy of the key set to change, the loaded key set is shared
+func (s *storedKeySet) clone() *storedKeySet {
+	c := &storedKeySet{Active: s.Active, Keys: make([]*storedSigningKey, 0, len(s.Keys))}
+	for _, k := range s.Keys {
+		key := *k
+		c.Keys = append(c.Keys, &key)
+	}
+	return c
+}
+
+// activate makes the key sign, the key signing so far is retired
+func (s *storedKeySet) activate(k *storedSigningKey) {
+	now := time.Now().Unix()
+	for _, other := range s.Keys {
+		if other.KID == s.Active && other != k {
+			other.RetiredUnix = now
+		}
+	}
+	s.Active = k.KID
+	k.ActivatedUnix = now
+	k.RetiredUnix = 0
+}
+
+// InitSigningKeys creates the keys file for the LFS_JWT_SECRET, with a new active key if LFS_JWT_SIGNING_ALGORITHM isn't HS256
+func InitSigningKeys() error {
+	if !setting.LFS.StartServer {
+		return nil
+	}
+	signingKeys.Lock()
+	defer signingKeys.Unlock()
+
+	if exist, err := util.IsExist(setting.LFS.JWTKeysFile); err != nil {
+		return err
+	} else if exist {
+		_, err := currentKeySet()
+		return err
+	}
+
+	stored := legacyKeySet()
+	if setting.LFS.JWTSigningAlgorithm != "HS256" {
+		k, err := generateSigningKey(setting.LFS.JWTSigningAlgorithm)
+		if err != nil {
+			return fmt.Errorf("unable to generate the LFS signing key: %w", err)
+		}
+		stored.Keys = append(stored.Keys, k)
+		stored.activate(k)
+	}
+	return saveKeySet(stored)
+}
+
+// SignToken signs the claims with the active key
+func SignToken(claims *Claims) (string, error) {
+	signingKeys.Lock()
+	set, err := currentKeySet()
+	signingKeys.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	key := set.keys[set.stored.Active]
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	if set.stored.Active != "" {
+		token.Header["kid"] = set.stored.Active
+	}
+	return token.SignedString(key.SignKey())
+}
+
+// verificationKey is the jwt.Keyfunc of the tokens, a token is verified by the key named by its "kid"
+func verificationKey(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	signingKeys.Lock()
+	set, err := currentKeySet()
+	signingKeys.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := set.keys[kid]
+	if !ok {
+		return nil, ErrSigningKeyNotExist{KID: kid}
+	}
+	for _, k := range set.stored.Keys {
+		if k.KID == kid && k.isExpired(time.Now()) {
+			return nil, fmt.Errorf("LFS signing key %q has been retired", kid)
+		}
+	}
+	if t.Method.Alg() != key.SigningMethod().Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+	return key.VerifyKey(), nil
+}
+
+// SigningKeys returns the keys of the key set, the active key first
+func SigningKeys() ([]*SigningKey, error) {
+	signingKeys.Lock()
+	set, err := currentKeySet()
+	signingKeys.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	keys := make([]*SigningKey, 0, len(set.stored.Keys))
+	for _, k := range set.stored.Keys {
+		if k.isExpired(now) {
+			continue
+		}
+		info := k.SigningKey
+		info.Active = k.KID == set.stored.Active
+		keys = append(keys, &info)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].Active && !keys[j].Active
+	})
+	return keys, nil
+}
+
+// AddSigningKey adds a new key to the key set. Unless it is activated right away the key only verifies tokens,
+// so that it is known everywhere before it signs.
+func AddSigningKey(algorithm string, activate bool) (*SigningKey, error) {
+	if algorithm == "" {
+		algorithm = setting.LFS.JWTSigningAlgorithm
+	}
+	k, err := generateSigningKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKeys.Lock()
+	defer signingKeys.Unlock()
+	set, err := currentKeySet()
+	if err != nil {
+		return nil, err
+	}
+	stored := set.stored.clone()
+	stored.Keys = append(stored.Keys, k)
+	if activate {
+		stored.activate(k)
+	}
+	if err := saveKeySet(stored); err != nil {
+		return nil, err
+	}
+	info := k.SigningKey
+	info.Active = activate
+	return &info, nil
+}
+
+// ActivateSigningKey makes a staged key sign, the key signing so far is retired
+func ActivateSigningKey(kid string) (*SigningKey, error) {
+	signingKeys.Lock()
+	defer signingKeys.Unlock()
+	set, err := currentKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	stored := set.stored.clone()
+	for _, k := range stored.Keys {
+		if k.KID != kid || k.isExpired(time.Now()) {
+			continue
+		}
+		if k.KID != stored.Active {
+			stored.activate(k)
+			if err := saveKeySet(stored); err != nil {
+				return nil, err
+			}
+		}
+		info := k.SigningKey
+		info.Active = true
+		return &info, nil
+	}
+	return nil, ErrSigningKeyNotExist{KID: kid}
+}
+
+// JWKS returns the public keys of the key set, so that other services can verify tokens. Secrets are never published.
+func JWKS() ([]map[string]string, error) {
+	signingKeys.Lock()
+	set, err := currentKeySet()
+	signingKeys.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	jwks := make([]map[string]string, 0, len(set.stored.Keys))
+	for _, k := range set.stored.Keys {
+		key := set.keys[k.KID]
+		if key.IsSymmetric() || k.isExpired(now) {
+			continue
+		}
+		jwk, err := key.ToJWK()
+		if err != nil {
+			return nil, err
+		}
+		jwk["kid"] = k.KID
+		jwk["alg"] = k.Algorithm
+		jwk["use"] = "sig"
+		jwks = append(jwks, jwk)
+	}
+	return jwks, nil
+}
+
+// JWKSHandler serves the public keys verifying the tokens as JSON Web Key Set
+func JWKSHandler(ctx *context.Context) {
+	jwks, err := JWKS()
+	if err != nil {
+		log.Error("Unable to get the LFS signing keys: %v", err)
+		ctx.Error(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Resp.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(ctx.Resp)
+	if err := enc.Encode(map[string][]map[string]string{"keys": jwks}); err != nil {
+		log.Error("Failed to encode representation as json. Error: %v", err)
+	}
+}
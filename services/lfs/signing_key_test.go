@@ -0,0 +1,156 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package lfs
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/modules/contexttest"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signAndVerify signs a token with the active key, makes sure it verifies and returns it with its "kid"
+func signAndVerify(t *testing.T) (string, string) {
+	token, err := SignToken(&Claims{RepoID: 1, Op: "download", UserID: 2})
+	require.NoError(t, err)
+	parsed, err := jwt.ParseWithClaims(token, &Claims{}, verificationKey)
+	require.NoError(t, err)
+	kid, _ := parsed.Header["kid"].(string)
+	return token, kid
+}
+
+func verifies(token string) bool {
+	_, err := jwt.ParseWithClaims(token, &Claims{}, verificationKey)
+	return err == nil
+}
+
+func TestInitSigningKeys(t *testing.T) {
+	mockSigningKeys(t)
+	defer test.MockVariableValue(&setting.LFS.StartServer, true)()
+	defer test.MockVariableValue(&setting.LFS.JWTSigningAlgorithm, "HS256")()
+
+	require.NoError(t, InitSigningKeys())
+	content, err := os.ReadFile(setting.LFS.JWTKeysFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), legacySecret(), "the LFS_JWT_SECRET is never written to the keys file")
+
+	token, kid := signAndVerify(t)
+	assert.Empty(t, kid, "the LFS_JWT_SECRET signs without kid")
+
+	// a changed LFS_JWT_SECRET replaces the key, like without keys file
+	defer test.MockVariableValue(&setting.LFS.JWTSecretBytes, []byte(strings.Repeat("t", 32)))()
+	signingKeys.set = nil
+	require.NoError(t, InitSigningKeys())
+	assert.False(t, verifies(token))
+	_, kid = signAndVerify(t)
+	assert.Empty(t, kid)
+}
+
+func TestInitSigningKeysAlgorithm(t *testing.T) {
+	mockSigningKeys(t)
+	defer test.MockVariableValue(&setting.LFS.StartServer, true)()
+	defer test.MockVariableValue(&setting.LFS.JWTSigningAlgorithm, "EdDSA")()
+	defer test.MockVariableValue(&setting.LFS.HTTPAuthExpiry, 20*time.Minute)()
+
+	require.NoError(t, InitSigningKeys())
+	keys, err := SigningKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.True(t, keys[0].Active)
+	assert.Equal(t, "EdDSA", keys[0].Algorithm)
+	assert.NotEmpty(t, keys[0].KID)
+	// the LFS_JWT_SECRET keeps verifying the tokens it signed before
+	assert.Equal(t, "HS256", keys[1].Algorithm)
+	assert.NotZero(t, keys[1].RetiredUnix)
+
+	_, kid := signAndVerify(t)
+	assert.Equal(t, keys[0].KID, kid)
+
+	// a second start keeps the keys
+	signingKeys.set = nil
+	require.NoError(t, InitSigningKeys())
+	_, kid = signAndVerify(t)
+	assert.Equal(t, keys[0].KID, kid)
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	mockSigningKeys(t)
+	defer test.MockVariableValue(&setting.LFS.HTTPAuthExpiry, 20*time.Minute)()
+
+	before, kid := signAndVerify(t)
+	assert.Empty(t, kid)
+
+	// a staged key verifies and is published but doesn't sign yet
+	staged, err := AddSigningKey("ES256", false)
+	require.NoError(t, err)
+	assert.False(t, staged.Active)
+	_, kid = signAndVerify(t)
+	assert.Empty(t, kid)
+	jwks, err := JWKS()
+	require.NoError(t, err)
+	require.Len(t, jwks, 1)
+	assert.Equal(t, staged.KID, jwks[0]["kid"])
+	assert.Equal(t, "ES256", jwks[0]["alg"])
+
+	activated, err := ActivateSigningKey(staged.KID)
+	require.NoError(t, err)
+	assert.True(t, activated.Active)
+	after, kid := signAndVerify(t)
+	assert.Equal(t, staged.KID, kid)
+	assert.True(t, verifies(before), "the retired key verifies until its tokens have expired")
+
+	keys, err := SigningKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, staged.KID, keys[0].KID)
+	assert.NotZero(t, keys[1].RetiredUnix)
+
+	_, err = ActivateSigningKey("unknown")
+	assert.ErrorIs(t, err, ErrSigningKeyNotExist{KID: "unknown"})
+
+	// once the tokens of the retired key have expired it is neither accepted nor listed
+	defer test.MockVariableValue(&setting.LFS.HTTPAuthExpiry, -time.Hour)()
+	defer test.MockVariableValue(&setting.LFS.ActionLinkExpiry, -time.Hour)()
+	assert.False(t, verifies(before))
+	assert.True(t, verifies(after))
+	keys, err = SigningKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, staged.KID, keys[0].KID)
+}
+
+func TestJWKSHandler(t *testing.T) {
+	mockSigningKeys(t)
+
+	// secrets are never published
+	_, err := AddSigningKey("HS512", false)
+	require.NoError(t, err)
+	published, err := AddSigningKey("EdDSA", true)
+	require.NoError(t, err)
+
+	ctx, resp := contexttest.MockContext(t, "/.well-known/lfs-jwks.json")
+	JWKSHandler(ctx)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var body struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	require.Len(t, body.Keys, 1)
+	assert.Equal(t, published.KID, body.Keys[0]["kid"])
+	assert.Equal(t, "EdDSA", body.Keys[0]["alg"])
+	assert.Equal(t, "sig", body.Keys[0]["use"])
+	assert.Equal(t, "OKP", body.Keys[0]["kty"])
+	assert.NotContains(t, body.Keys[0], "d", "the private key is never published")
+}
@@ -0,0 +1,234 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package oidcauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/proxy"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// refetchInterval limits how often the keys of an issuer are fetched for an unknown "kid"
+const refetchInterval = time.Minute
+
+var httpClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: &http.Transport{Proxy: proxy.Proxy()},
+}
+
+// jsonWebKey is a public key of a JSON Web Key Set
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC and OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// cachedKeys are the keys of a JSON Web Key Set, the lock is held while they are fetched
+type cachedKeys struct {
+	sync.Mutex
+	keys    map[string]any
+	fetched time.Time
+}
+
+var jwksCache = struct {
+	sync.Mutex
+	// jwksURLs are the jwks_uri of the discovery documents by issuer
+	jwksURLs map[string]string
+	keys     map[string]*cachedKeys
+}{
+	jwksURLs: make(map[string]string),
+	keys:     make(map[string]*cachedKeys),
+}
+
+func getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// jwksURLOf returns the URL of the keys of the issuer, the discovery document is only fetched once
+func jwksURLOf(ctx context.Context, issuer, configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+
+	jwksCache.Lock()
+	url, ok := jwksCache.jwksURLs[issuer]
+	jwksCache.Unlock()
+	if ok {
+		return url, nil
+	}
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return "", fmt.Errorf("discover the keys of %s: %w", issuer, err)
+	}
+	// the keys of another issuer must not verify the tokens of this one
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return "", fmt.Errorf("the discovery document of %s is for issuer %q", issuer, discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("the discovery document of %s has no jwks_uri", issuer)
+	}
+
+	jwksCache.Lock()
+	jwksCache.jwksURLs[issuer] = discovery.JWKSURI
+	jwksCache.Unlock()
+	return discovery.JWKSURI, nil
+}
+
+// publicKey returns the key of the JSON Web Key Set, the set is fetched again after JWKS_CACHE_TTL
+// or if it doesn't have the key, e.g. after the issuer rotated its keys. While a set is fetched only the
+// tokens verified by the same set wait for it.
+func publicKey(ctx context.Context, jwksURL, kid string) (any, error) {
+	jwksCache.Lock()
+	cached, ok := jwksCache.keys[jwksURL]
+	if !ok {
+		cached = &cachedKeys{}
+		jwksCache.keys[jwksURL] = cached
+	}
+	jwksCache.Unlock()
+
+	cached.Lock()
+	defer cached.Unlock()
+	now := time.Now()
+	if !cached.fetched.IsZero() && now.Sub(cached.fetched) < setting.OIDCFederation.JWKSCacheTTL {
+		if key, ok := cached.keys[kid]; ok {
+			return key, nil
+		}
+		if now.Sub(cached.fetched) < refetchInterval {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+	}
+
+	keys, err := fetchKeys(ctx, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	cached.keys, cached.fetched = keys, now
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func fetchKeys(ctx context.Context, jwksURL string) (map[string]any, error) {
+	var jwks struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, jwksURL, &jwks); err != nil {
+		return nil, fmt.Errorf("fetch keys: %w", err)
+	}
+
+	keys := make(map[string]any, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// keys of unsupported types are skipped, the issuer may publish keys for other uses
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (jwk *jsonWebKey) publicKey() (any, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
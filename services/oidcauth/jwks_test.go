@@ -0,0 +1,228 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package oidcauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/modules/json"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetJWKSCache(t *testing.T) {
+	reset := func() {
+		jwksCache.Lock()
+		jwksCache.jwksURLs = make(map[string]string)
+		jwksCache.keys = make(map[string]*cachedKeys)
+		jwksCache.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// toJWK returns the JSON Web Key of a public key
+func toJWK(t *testing.T, kid string, key any) *jsonWebKey {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return &jsonWebKey{Kty: "RSA", Kid: kid, N: encodeBigInt(key.N), E: encodeBigInt(big.NewInt(int64(key.E)))}
+	case *ecdsa.PublicKey:
+		return &jsonWebKey{Kty: "EC", Kid: kid, Crv: key.Curve.Params().Name, X: encodeBigInt(key.X), Y: encodeBigInt(key.Y)}
+	case ed25519.PublicKey:
+		return &jsonWebKey{Kty: "OKP", Kid: kid, Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(key)}
+	}
+	t.Fatalf("unsupported key %T", key)
+	return nil
+}
+
+func TestJSONWebKeyPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for _, key := range []any{&rsaKey.PublicKey, &ecKey.PublicKey, edKey} {
+		parsed, err := toJWK(t, "kid", key).publicKey()
+		require.NoError(t, err)
+		assert.EqualValues(t, key, parsed)
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		notOnCurve := toJWK(t, "kid", &ecKey.PublicKey)
+		notOnCurve.Y = encodeBigInt(new(big.Int).Add(ecKey.Y, big.NewInt(1)))
+		shortEd25519 := toJWK(t, "kid", edKey)
+		shortEd25519.X = base64.RawURLEncoding.EncodeToString(edKey[:16])
+		unknownCurve := toJWK(t, "kid", &ecKey.PublicKey)
+		unknownCurve.Crv = "secp256k1"
+		badModulus := toJWK(t, "kid", &rsaKey.PublicKey)
+		badModulus.N = "not base64!"
+
+		for name, jwk := range map[string]*jsonWebKey{
+			"NotOnCurve":     notOnCurve,
+			"ShortEd25519":   shortEd25519,
+			"UnknownCurve":   unknownCurve,
+			"BadModulus":     badModulus,
+			"X25519":         {Kty: "OKP", Crv: "X25519", X: shortEd25519.X},
+			"SymmetricKey":   {Kty: "oct"},
+			"MissingKeyType": {},
+		} {
+			_, err := jwk.publicKey()
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestFetchKeys(t *testing.T) {
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sig := toJWK(t, "sig", edKey)
+	sig.Use = "sig"
+	enc := toJWK(t, "enc", edKey)
+	enc.Use = "enc"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []any{
+			sig, enc, toJWK(t, "any", edKey), &jsonWebKey{Kty: "oct", Kid: "oct"},
+		}})
+	}))
+	defer srv.Close()
+
+	keys, err := fetchKeys(context.Background(), srv.URL)
+	require.NoError(t, err)
+	// keys for encryption and keys of unsupported types are skipped
+	assert.Len(t, keys, 2)
+	assert.EqualValues(t, edKey, keys["sig"])
+	assert.EqualValues(t, edKey, keys["any"])
+}
+
+func TestJWKSURLOf(t *testing.T) {
+	resetJWKSCache(t)
+
+	var issuer string
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/.well-known/openid-configuration", r.URL.Path)
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": "https://keys.example.com/jwks"})
+	}))
+	defer srv.Close()
+
+	url, err := jwksURLOf(context.Background(), srv.URL, "https://configured.example.com/jwks")
+	require.NoError(t, err)
+	assert.Equal(t, "https://configured.example.com/jwks", url)
+	assert.Zero(t, requests)
+
+	t.Run("IssuerMismatch", func(t *testing.T) {
+		issuer = "https://other.example.com"
+		_, err := jwksURLOf(context.Background(), srv.URL, "")
+		assert.ErrorContains(t, err, "is for issuer")
+		// a rejected document isn't cached
+		_, err = jwksURLOf(context.Background(), srv.URL, "")
+		assert.Error(t, err)
+		assert.Equal(t, 2, requests)
+	})
+
+	t.Run("Discovered", func(t *testing.T) {
+		issuer = srv.URL + "/"
+		url, err := jwksURLOf(context.Background(), srv.URL, "")
+		require.NoError(t, err)
+		assert.Equal(t, "https://keys.example.com/jwks", url)
+		// the discovery document is only fetched once
+		url, err = jwksURLOf(context.Background(), srv.URL, "")
+		require.NoError(t, err)
+		assert.Equal(t, "https://keys.example.com/jwks", url)
+		assert.Equal(t, 3, requests)
+	})
+}
+
+func TestPublicKey(t *testing.T) {
+	resetJWKSCache(t)
+
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keys := []*jsonWebKey{toJWK(t, "first", edKey)}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	defer srv.Close()
+
+	key, err := publicKey(context.Background(), srv.URL, "first")
+	require.NoError(t, err)
+	assert.EqualValues(t, edKey, key)
+	_, err = publicKey(context.Background(), srv.URL, "first")
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests)
+
+	// an unknown key is only looked up again after refetchInterval
+	keys = append(keys, toJWK(t, "rotated", edKey))
+	_, err = publicKey(context.Background(), srv.URL, "rotated")
+	assert.ErrorContains(t, err, "unknown key")
+	assert.Equal(t, 1, requests)
+
+	jwksCache.keys[srv.URL].fetched = time.Now().Add(-refetchInterval)
+	key, err = publicKey(context.Background(), srv.URL, "rotated")
+	require.NoError(t, err)
+	assert.EqualValues(t, edKey, key)
+	assert.Equal(t, 2, requests)
+}
+
+func TestPublicKeySlowIssuer(t *testing.T) {
+	resetJWKSCache(t)
+
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keys := map[string]any{"keys": []*jsonWebKey{toJWK(t, "kid", edKey)}}
+
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		_ = json.NewEncoder(w).Encode(keys)
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(keys)
+	}))
+	defer fast.Close()
+
+	go func() {
+		_, _ = publicKey(context.Background(), slow.URL, "kid")
+	}()
+	<-requested
+
+	// the keys of another issuer don't wait for the slow one
+	done := make(chan error, 1)
+	go func() {
+		_, err := publicKey(context.Background(), fast.URL, "kid")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the keys of an issuer waited for the keys of another one")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package oidcauth
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/base"
+	"code.gitea.io/gitea/modules/log"
+	auth_service "code.gitea.io/gitea/services/auth"
+)
+
+// MethodName is the name of the authentication method of federated tokens
+const MethodName = "oidc_federation"
+
+// IdentityDataKey keeps the *Identity of a request authenticated by a federated token
+const IdentityDataKey = "OIDCIdentity"
+
+var _ auth_service.Method = &Method{}
+
+// federated tokens are only accepted for git and LFS
+var gitOrLFSPathRe = regexp.MustCompile(`^/[a-zA-Z0-9_.-]+/[a-zA-Z0-9_.-]+/(?:git-(?:upload|receive)-pack$|info/refs$|HEAD$|objects/|info/lfs/)`)
+
+// Method authenticates git and LFS requests with a JWT of a trusted OIDC issuer,
+// sent as a bearer token or as the password (or username) of basic authentication
+type Method struct{}
+
+// Name represents the name of auth method
+func (m *Method) Name() string {
+	return MethodName
+}
+
+// tokenFromHeader returns the token of the Authorization header
+func tokenFromHeader(header string) string {
+	auths := strings.SplitN(header, " ", 2)
+	if len(auths) != 2 {
+		return ""
+	}
+	switch strings.ToLower(auths[0]) {
+	case "bearer":
+		return strings.TrimSpace(auths[1])
+	case "basic":
+		uname, passwd, _ := base.BasicAuthDecode(auths[1])
+		if passwd == "" || passwd == "x-oauth-basic" {
+			return uname
+		}
+		return passwd
+	}
+	return ""
+}
+
+// Verify returns the user a token of a trusted issuer acts as. Tokens of other issuers are left
+// to the other methods, while an invalid token of a trusted issuer is an error.
+func (m *Method) Verify(req *http.Request, w http.ResponseWriter, store auth_service.DataStore, sess auth_service.SessionStore) (*user_model.User, error) {
+	if !gitOrLFSPathRe.MatchString(req.URL.Path) {
+		return nil, nil
+	}
+	token := tokenFromHeader(req.Header.Get("Authorization"))
+	if token == "" || !IsTrustedToken(token) {
+		return nil, nil
+	}
+
+	identity, err := Authenticate(req.Context(), token)
+	if err != nil {
+		log.Warn("Federated token rejected for %s: %v", req.URL.Path, err)
+		return nil, err
+	}
+	log.Trace("Federated token of %s for subject %q acts as %s", identity.Issuer, identity.Subject, identity.User.Name)
+	SetIdentity(store.GetData(), identity)
+	return identity.User, nil
+}
+
+// SetIdentity records the identity of a federated token in the data of the request
+func SetIdentity(data map[string]any, identity *Identity) {
+	data["IsOIDCToken"] = true
+	data[IdentityDataKey] = identity
+}
+
+// GetIdentity returns the identity of the federated token the request was authenticated with, if any
+func GetIdentity(data map[string]any) *Identity {
+	identity, _ := data[IdentityDataKey].(*Identity)
+	return identity
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package oidcauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/models/perm"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/base"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/test"
+	"code.gitea.io/gitea/modules/web/middleware"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityRepoMode(t *testing.T) {
+	identity := &Identity{rules: []*setting.OIDCFederationRule{
+		{Repos: []string{"user2/*", "org3/repo3"}, Permission: "read"},
+		{Repos: []string{"User2/Repo1"}, Permission: "write"},
+	}}
+
+	assert.Equal(t, perm.AccessModeWrite, identity.RepoMode("user2", "repo1"))
+	assert.Equal(t, perm.AccessModeWrite, identity.RepoMode("USER2", "REPO1"))
+	assert.Equal(t, perm.AccessModeRead, identity.RepoMode("user2", "repo2"))
+	assert.Equal(t, perm.AccessModeRead, identity.RepoMode("org3", "repo3"))
+	assert.Equal(t, perm.AccessModeNone, identity.RepoMode("org3", "repo5"))
+	// "*" doesn't match across the owner and the name
+	assert.Equal(t, perm.AccessModeNone, identity.RepoMode("user2x", "repo1"))
+}
+
+func TestMethodVerify(t *testing.T) {
+	resetJWKSCache(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	var issuer string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/jwks"})
+		case "/jwks":
+			_ = json.NewEncoder(w).Encode(map[string]any{"keys": []*jsonWebKey{toJWK(t, "kid", pub)}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	issuer = srv.URL
+
+	defer test.MockVariableValue(&setting.OIDCFederation.Enabled, true)()
+	defer test.MockVariableValue(&setting.OIDCFederation.Rules, []*setting.OIDCFederationRule{{
+		Name:       "ci",
+		Issuer:     issuer,
+		Audience:   "gitea",
+		Subject:    regexp.MustCompile(`^repo:user2/repo1:.*$`),
+		Repos:      []string{"user2/repo1"},
+		Permission: "write",
+	}})()
+
+	sign := func(claims jwt.RegisteredClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = "kid"
+		signed, err := token.SignedString(priv)
+		require.NoError(t, err)
+		return signed
+	}
+	valid := jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Subject:   "repo:user2/repo1:ref:refs/heads/main",
+		Audience:  jwt.ClaimStrings{"gitea"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	verify := func(path, authorization string) (*user_model.User, middleware.ContextData, error) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", authorization)
+		data := middleware.ContextData{}
+		u, err := (&Method{}).Verify(req, httptest.NewRecorder(), data, nil)
+		return u, data, err
+	}
+
+	t.Run("Bearer", func(t *testing.T) {
+		u, data, err := verify("/user2/repo1.git/info/refs", "Bearer "+sign(valid))
+		require.NoError(t, err)
+		require.NotNil(t, u)
+		assert.EqualValues(t, user_model.ActionsUserID, u.ID)
+
+		identity := GetIdentity(data)
+		require.NotNil(t, identity)
+		assert.True(t, identity.IsVirtual())
+		assert.Equal(t, valid.Subject, identity.Subject)
+		assert.Equal(t, perm.AccessModeWrite, identity.RepoMode("user2", "repo1"))
+	})
+
+	t.Run("BasicPassword", func(t *testing.T) {
+		u, _, err := verify("/user2/repo1.git/info/lfs/objects/batch", "Basic "+base.BasicAuthEncode("x-access-token", sign(valid)))
+		require.NoError(t, err)
+		require.NotNil(t, u)
+	})
+
+	t.Run("BasicUsername", func(t *testing.T) {
+		u, _, err := verify("/user2/repo1.git/git-upload-pack", "Basic "+base.BasicAuthEncode(sign(valid), "x-oauth-basic"))
+		require.NoError(t, err)
+		require.NotNil(t, u)
+	})
+
+	t.Run("NotGit", func(t *testing.T) {
+		u, data, err := verify("/user2/repo1/settings", "Bearer "+sign(valid))
+		assert.NoError(t, err)
+		assert.Nil(t, u)
+		assert.Nil(t, GetIdentity(data))
+	})
+
+	t.Run("UntrustedIssuer", func(t *testing.T) {
+		claims := valid
+		claims.Issuer = "https://other.example.com"
+		// left to the other methods
+		u, _, err := verify("/user2/repo1.git/info/refs", "Bearer "+sign(claims))
+		assert.NoError(t, err)
+		assert.Nil(t, u)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		defer test.MockVariableValue(&setting.OIDCFederation.Enabled, false)()
+		u, _, err := verify("/user2/repo1.git/info/refs", "Bearer "+sign(valid))
+		assert.NoError(t, err)
+		assert.Nil(t, u)
+	})
+
+	for name, claims := range map[string]func(*jwt.RegisteredClaims){
+		"WrongAudience": func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other"} },
+		"WrongSubject":  func(c *jwt.RegisteredClaims) { c.Subject = "repo:user2/repo2:ref:refs/heads/main" },
+		"Expired":       func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) },
+		"WithoutExpiry": func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil },
+		"NotYetValid":   func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := valid
+			claims(&c)
+			u, _, err := verify("/user2/repo1.git/info/refs", "Bearer "+sign(c))
+			assert.Error(t, err)
+			assert.Nil(t, u)
+		})
+	}
+
+	t.Run("SignedByOtherKey", func(t *testing.T) {
+		_, other, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, valid)
+		token.Header["kid"] = "kid"
+		signed, err := token.SignedString(other)
+		require.NoError(t, err)
+		u, _, err := verify("/user2/repo1.git/info/refs", "Bearer "+signed)
+		assert.Error(t, err)
+		assert.Nil(t, u)
+	})
+
+	t.Run("SymmetricAlgorithm", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, valid)
+		token.Header["kid"] = "kid"
+		signed, err := token.SignedString([]byte(pub))
+		require.NoError(t, err)
+		u, _, err := verify("/user2/repo1.git/info/refs", "Bearer "+signed)
+		assert.Error(t, err)
+		assert.Nil(t, u)
+	})
+}
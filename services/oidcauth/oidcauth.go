@@ -0,0 +1,172 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package oidcauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"code.gitea.io/gitea/models/perm"
+	access_model "code.gitea.io/gitea/models/perm/access"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unit"
+	user_model "code.gitea.io/gitea/models/user"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// only asymmetric algorithms, the issuers publish public keys
+var validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ErrUntrustedIssuer is returned for tokens of issuers without a rule
+var ErrUntrustedIssuer = errors.New("untrusted issuer")
+
+// Identity is who a verified token acts as and which repositories it may access
+type Identity struct {
+	Issuer  string
+	Subject string
+	// User is the mapped user, or the built-in actions user for a virtual identity
+	User  *user_model.User
+	rules []*setting.OIDCFederationRule
+}
+
+// IsVirtual returns whether the token acts as no real user, so that only the rules grant access
+func (id *Identity) IsVirtual() bool {
+	return id.User.ID == user_model.ActionsUserID
+}
+
+// RepoMode returns the highest access the rules grant the token on the repository ownerName/repoName
+func (id *Identity) RepoMode(ownerName, repoName string) perm.AccessMode {
+	name := strings.ToLower(ownerName + "/" + repoName)
+	mode := perm.AccessModeNone
+	for _, rule := range id.rules {
+		for _, pattern := range rule.Repos {
+			if ok, _ := path.Match(strings.ToLower(pattern), name); !ok {
+				continue
+			}
+			ruleMode := perm.AccessModeRead
+			if rule.Permission == "write" {
+				ruleMode = perm.AccessModeWrite
+			}
+			if ruleMode > mode {
+				mode = ruleMode
+			}
+		}
+	}
+	return mode
+}
+
+// CanAccess returns whether the token may access the unit of the repository with mode. The rules limit
+// the access of a mapped user, who also needs the permission itself.
+func (id *Identity) CanAccess(ctx context.Context, repo *repo_model.Repository, mode perm.AccessMode, unitType unit.Type) (bool, error) {
+	if id.RepoMode(repo.OwnerName, repo.Name) < mode {
+		return false, nil
+	}
+	if id.IsVirtual() {
+		return true, nil
+	}
+	p, err := access_model.GetUserRepoPermission(ctx, repo, id.User)
+	if err != nil {
+		return false, err
+	}
+	return p.CanAccess(mode, unitType), nil
+}
+
+func trustedRules(issuer string) []*setting.OIDCFederationRule {
+	if !setting.OIDCFederation.Enabled {
+		return nil
+	}
+	issuer = strings.TrimSuffix(issuer, "/")
+	var rules []*setting.OIDCFederationRule
+	for _, rule := range setting.OIDCFederation.Rules {
+		if rule.Issuer == issuer {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// IsTrustedToken returns whether raw looks like a JWT of a trusted issuer, it doesn't verify the token
+func IsTrustedToken(raw string) bool {
+	if !setting.OIDCFederation.Enabled || strings.Count(raw, ".") != 2 {
+		return false
+	}
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, &claims); err != nil {
+		return false
+	}
+	return len(trustedRules(claims.Issuer)) > 0
+}
+
+// Authenticate verifies the token against the keys of its issuer and maps it by the first matching rule
+func Authenticate(ctx context.Context, raw string) (*Identity, error) {
+	claims := jwt.RegisteredClaims{}
+	var rules []*setting.OIDCFederationRule
+	_, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (any, error) {
+		iss, err := t.Claims.GetIssuer()
+		if err != nil {
+			return nil, err
+		}
+		if rules = trustedRules(iss); len(rules) == 0 {
+			return nil, ErrUntrustedIssuer
+		}
+		jwksURL, err := jwksURLOf(ctx, rules[0].Issuer, rules[0].JWKSURL)
+		if err != nil {
+			return nil, err
+		}
+		kid, _ := t.Header["kid"].(string)
+		return publicKey(ctx, jwksURL, kid)
+	},
+		jwt.WithValidMethods(validMethods),
+		jwt.WithLeeway(setting.OIDCFederation.ClockSkew),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("verify OIDC token: %w", err)
+	}
+
+	var matched []*setting.OIDCFederationRule
+	for _, rule := range rules {
+		if !containsAudience(claims.Audience, rule.Audience) || !rule.Subject.MatchString(claims.Subject) {
+			continue
+		}
+		// the rules of a token all act as the same user
+		if len(matched) > 0 && matched[0].User != rule.User {
+			continue
+		}
+		matched = append(matched, rule)
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no rule of issuer %s matches subject %q", claims.Issuer, claims.Subject)
+	}
+
+	identity := &Identity{Issuer: claims.Issuer, Subject: claims.Subject, rules: matched}
+	if matched[0].User == "" {
+		identity.User = user_model.NewActionsUser()
+		return identity, nil
+	}
+	u, err := user_model.GetUserByName(ctx, matched[0].User)
+	if err != nil {
+		return nil, fmt.Errorf("user %s of rule %s: %w", matched[0].User, matched[0].Name, err)
+	}
+	if !u.IsActive || u.ProhibitLogin {
+		return nil, fmt.Errorf("user %s of rule %s is disabled", u.Name, matched[0].Name)
+	}
+	identity.User = u
+	return identity, nil
+}
+
+func containsAudience(audience jwt.ClaimStrings, want string) bool {
+	for _, aud := range audience {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
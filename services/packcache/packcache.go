@@ -0,0 +1,65 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package packcache keeps the upload-pack cache in shape: entries are dropped when a mirror sync changes the refs
+// of their repository, and the expired and the oldest entries are evicted periodically.
+package packcache
+
+import (
+	"context"
+	"time"
+
+	repo_model "code.gitea.io/gitea/models/repo"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/graceful"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/repository"
+	notify_service "code.gitea.io/gitea/services/notify"
+
+	"github.com/openmerlin/gitea_data/modules/packcache"
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// Init registers the notifier of the mirror syncs and starts evicting entries every PackCache.EvictInterval
+func Init() error {
+	if !packcache.Enabled() {
+		return nil
+	}
+	notify_service.RegisterNotifier(&mirrorNotifier{})
+	go graceful.GetManager().RunWithShutdownContext(func(ctx context.Context) {
+		ticker := time.NewTicker(setting.PackCache.EvictInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := packcache.GetCache().Evict(ctx); err != nil && ctx.Err() == nil {
+					log.Error("Unable to evict the entries of the pack cache: %v", err)
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// mirrorNotifier invalidates the cache of a mirror whose refs have been changed by a sync, which runs no hooks
+type mirrorNotifier struct {
+	notify_service.NullNotifier
+}
+
+// SyncPushCommits implements notify_service.Notifier
+func (n *mirrorNotifier) SyncPushCommits(_ context.Context, _ *user_model.User, repo *repo_model.Repository, _ *repository.PushUpdateOptions, _ *repository.PushCommits) {
+	packcache.Invalidate(repo.ID)
+}
+
+// SyncCreateRef implements notify_service.Notifier
+func (n *mirrorNotifier) SyncCreateRef(_ context.Context, _ *user_model.User, repo *repo_model.Repository, _ git.RefName, _ string) {
+	packcache.Invalidate(repo.ID)
+}
+
+// SyncDeleteRef implements notify_service.Notifier
+func (n *mirrorNotifier) SyncDeleteRef(_ context.Context, _ *user_model.User, repo *repo_model.Repository, _ git.RefName) {
+	packcache.Invalidate(repo.ID)
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package packcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/test"
+
+	"github.com/openmerlin/gitea_data/modules/packcache"
+	"github.com/openmerlin/gitea_data/modules/setting"
+	"github.com/openmerlin/gitea_data/modules/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorNotifier(t *testing.T) {
+	store, err := storage.NewLocalStorage(context.Background(), &setting.Storage{Path: t.TempDir()})
+	require.NoError(t, err)
+	defer test.MockVariableValue(&storage.PackCache, store)()
+	defer test.MockVariableValue(&setting.PackCache.Enabled, true)()
+
+	repo := &repo_model.Repository{ID: 1}
+	key := strings.Repeat("ab", 32)
+	serve := func(content string) string {
+		var out bytes.Buffer
+		require.NoError(t, packcache.GetCache().Serve(context.Background(), repo.ID, false, key, &out, func(w io.Writer) error {
+			_, err := w.Write([]byte(content))
+			return err
+		}))
+		return out.String()
+	}
+
+	n := &mirrorNotifier{}
+	for i, notify := range []func(){
+		func() { n.SyncPushCommits(context.Background(), nil, repo, nil, nil) },
+		func() { n.SyncCreateRef(context.Background(), nil, repo, git.RefNameFromBranch("synced"), "") },
+		func() { n.SyncDeleteRef(context.Background(), nil, repo, git.RefNameFromBranch("synced")) },
+	} {
+		assert.Equal(t, "PACK-old", serve("PACK-old"), i)
+		assert.Equal(t, "PACK-old", serve("PACK-new"), i)
+		notify()
+		assert.Equal(t, "PACK-new", serve("PACK-new"), i)
+		require.NoError(t, packcache.GetCache().Invalidate(repo.ID))
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package pathgrant restricts the writes of deploy keys and access tokens to path prefixes inside a repository.
+package pathgrant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	auth_model "code.gitea.io/gitea/models/auth"
+	"code.gitea.io/gitea/modules/base"
+	gitea_context "code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+)
+
+// NormalizePrefix returns prefix relative to the repository root with a trailing slash,
+// or an empty string if it doesn't restrict anything
+func NormalizePrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" || prefix == "." {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// Allowed returns whether a credential granted prefixes may write to path, no prefixes grant everything
+func Allowed(prefixes []string, path string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangedPaths returns the paths changed from base to head. Renames are listed as the deletion of the old path
+// and the addition of the new one, so that moving a file into a granted prefix still touches its old path.
+// env gives access to the quarantined objects of a push.
+func ChangedPaths(ctx context.Context, repoPath string, env []string, base, head string) ([]string, error) {
+	stdout, _, err := git.NewCommand(ctx, "diff", "--name-only", "--no-renames", "-z").AddDynamicArguments(base, head).RunStdString(&git.RunOpts{Dir: repoPath, Env: env})
+	if err != nil {
+		return nil, fmt.Errorf("diff --name-only: %w", err)
+	}
+	paths := strings.Split(strings.TrimSuffix(stdout, "\x00"), "\x00")
+	if len(paths) == 1 && paths[0] == "" {
+		return nil, nil
+	}
+	return paths, nil
+}
+
+// Get returns the prefixes the deploy key or the access token may write to in the repository,
+// a nil result means the credential isn't restricted
+func Get(ctx context.Context, repoID, deployKeyID, accessTokenID int64) ([]string, error) {
+	if deployKeyID <= 0 && accessTokenID <= 0 {
+		return nil, nil
+	}
+	prefixes, err := repo_model.GetPathGrants(ctx, repoID, deployKeyID, accessTokenID)
+	if err != nil || len(prefixes) == 0 {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// Set replaces the prefixes the deploy key or the access token may write to in the repository
+func Set(ctx context.Context, repoID, deployKeyID, accessTokenID int64, prefixes []string) ([]string, error) {
+	normalized := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix = NormalizePrefix(prefix); prefix != "" {
+			normalized = append(normalized, prefix)
+		}
+	}
+	if err := repo_model.SetPathGrants(ctx, repoID, deployKeyID, accessTokenID, normalized); err != nil {
+		return nil, err
+	}
+	return repo_model.GetPathGrants(ctx, repoID, deployKeyID, accessTokenID)
+}
+
+// AccessTokenID returns the ID of the access token the request authenticated with, or 0 if it didn't use one.
+// The authentication methods only keep the scope of the token, so the token is looked up again from the header.
+func AccessTokenID(ctx *gitea_context.Context) int64 {
+	if id, ok := ctx.Data["AccessTokenID"].(int64); ok {
+		return id
+	}
+	if ctx.Data["IsApiToken"] != true {
+		return 0
+	}
+
+	var tokenSHA string
+	auth := strings.SplitN(ctx.Req.Header.Get("Authorization"), " ", 2)
+	if len(auth) != 2 {
+		return 0
+	}
+	switch strings.ToLower(auth[0]) {
+	case "basic":
+		uname, passwd, _ := base.BasicAuthDecode(auth[1])
+		if len(passwd) == 0 || passwd == "x-oauth-basic" {
+			tokenSHA = uname
+		} else {
+			tokenSHA = passwd
+		}
+	case "token", "bearer":
+		tokenSHA = auth[1]
+	default:
+		return 0
+	}
+
+	token, err := auth_model.GetAccessTokenBySHA(ctx, tokenSHA)
+	if err != nil {
+		// OAuth2 tokens are API tokens too but can't be scoped
+		if !auth_model.IsErrAccessTokenNotExist(err) && !auth_model.IsErrAccessTokenEmpty(err) {
+			log.Error("Unable to get the access token of the request: %v", err)
+		}
+		return 0
+	}
+	ctx.Data["AccessTokenID"] = token.ID
+	return token.ID
+}
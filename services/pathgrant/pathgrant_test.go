@@ -0,0 +1,99 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pathgrant
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePrefix(t *testing.T) {
+	for prefix, expected := range map[string]string{
+		"data":       "data/",
+		"/data/src/": "data/src/",
+		" data/src ": "data/src/",
+		"":           "",
+		"/":          "",
+		".":          "",
+	} {
+		assert.Equal(t, expected, NormalizePrefix(prefix), prefix)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	assert.True(t, Allowed(nil, "README.md"))
+
+	prefixes := []string{"data/", "models/bert/"}
+	assert.True(t, Allowed(prefixes, "data/train.csv"))
+	assert.True(t, Allowed(prefixes, "models/bert/config.json"))
+	assert.False(t, Allowed(prefixes, "README.md"))
+	assert.False(t, Allowed(prefixes, "data"))
+	assert.False(t, Allowed(prefixes, "database/dump.sql"))
+	assert.False(t, Allowed(prefixes, "models/gpt/config.json"))
+}
+
+func TestChangedPaths(t *testing.T) {
+	repoPath := filepath.Join(t.TempDir(), "repo.git")
+	require.NoError(t, exec.Command("git", "init", "--bare", repoPath).Run())
+	runGit := func(stdin string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", repoPath}, args...)...)
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.Output()
+		require.NoError(t, err, "git %v", args)
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(files map[string]string, parents ...string) string {
+		var tree strings.Builder
+		for name, content := range files {
+			fmt.Fprintf(&tree, "100644 blob %s\t%s\n", runGit(content, "hash-object", "-w", "--stdin"), name)
+		}
+		// mktree only takes the entries of one directory, so the nested files go through an index
+		indexFile := filepath.Join(t.TempDir(), "index")
+		cmd := exec.Command("git", "--git-dir", repoPath, "update-index", "--add", "--index-info")
+		cmd.Env = append(cmd.Environ(), "GIT_INDEX_FILE="+indexFile)
+		cmd.Stdin = strings.NewReader(tree.String())
+		require.NoError(t, cmd.Run())
+		cmd = exec.Command("git", "--git-dir", repoPath, "write-tree")
+		cmd.Env = append(cmd.Environ(), "GIT_INDEX_FILE="+indexFile)
+		treeID, err := cmd.Output()
+		require.NoError(t, err)
+
+		args := []string{"commit-tree", strings.TrimSpace(string(treeID)), "-m", "commit"}
+		for _, parent := range parents {
+			args = append(args, "-p", parent)
+		}
+		return runGit("", args...)
+	}
+	ctx := context.Background()
+
+	readme := "a readme which is long enough to be detected as renamed\n"
+	base := commit(map[string]string{"README.md": readme, "data/train.csv": "1,2"})
+
+	// moving a file into the granted prefix changes its old path too
+	renamed := commit(map[string]string{"data/src/README.md": readme, "data/train.csv": "1,2"}, base)
+	paths, err := ChangedPaths(ctx, repoPath, nil, base, renamed)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"README.md", "data/src/README.md"}, paths)
+
+	deleted := commit(map[string]string{"README.md": readme}, base)
+	paths, err = ChangedPaths(ctx, repoPath, nil, base, deleted)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"data/train.csv"}, paths)
+
+	paths, err = ChangedPaths(ctx, repoPath, nil, base, base)
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+
+	// a branch without a base is diffed against the empty tree
+	paths, err = ChangedPaths(ctx, repoPath, nil, "4b825dc642cb6eb9a060e54bf8d69288fbee4904", base)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"README.md", "data/train.csv"}, paths)
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushevent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/openmerlin/gitea_data/modules/git"
+	"github.com/openmerlin/gitea_data/modules/lfs"
+)
+
+// LFSObject is an LFS pointer added by a push
+type LFSObject struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// The types of the events
+const (
+	EventTypePush         = "push"
+	EventTypeCommitStatus = "commit_status"
+)
+
+// CommitStatus is a status reported for a commit, e.g. by CI or an evaluation service
+type CommitStatus struct {
+	SHA         string `json:"sha"`
+	Context     string `json:"context"`
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url"`
+	Description string `json:"description"`
+	// CombinedState is the state of the commit over the latest status of every context after the change
+	CombinedState string `json:"combined_state"`
+}
+
+// Event describes a single ref update or a status reported for a commit
+type Event struct {
+	ID          string      `json:"id"`
+	Type        string      `json:"type"`
+	Timestamp   time.Time   `json:"timestamp"`
+	RepoID      int64       `json:"repo_id"`
+	OwnerName   string      `json:"owner"`
+	RepoName    string      `json:"repo"`
+	IsWiki      bool        `json:"is_wiki"`
+	PusherID    int64       `json:"pusher_id"`
+	PusherName  string      `json:"pusher"`
+	Ref         string      `json:"ref"`
+	OldCommitID string      `json:"old_sha"`
+	NewCommitID string      `json:"new_sha"`
+	Created     bool        `json:"created"`
+	Deleted     bool        `json:"deleted"`
+	Forced      bool        `json:"forced"`
+	LFSObjects  []LFSObject `json:"lfs_objects"`
+
+	// CommitStatus is set for events of type commit_status, PusherID and PusherName are its creator then
+	CommitStatus *CommitStatus `json:"commit_status,omitempty"`
+
+	// RepoPath, ExcludedCommitIDs, Enriched and Delivered are the delivery state kept in the queue.
+	// ExcludedCommitIDs are the tips of the refs before the push for a created ref.
+	RepoPath          string   `json:"repo_path,omitempty"`
+	ExcludedCommitIDs []string `json:"excluded_commit_ids,omitempty"`
+	Enriched          bool     `json:"enriched,omitempty"`
+	Delivered         []string `json:"delivered,omitempty"`
+}
+
+// eventType returns the type of the event, events queued before there were types are pushes
+func (e *Event) eventType() string {
+	if e.Type == "" {
+		return EventTypePush
+	}
+	return e.Type
+}
+
+func (e *Event) isDelivered(sink string) bool {
+	for _, name := range e.Delivered {
+		if name == sink {
+			return true
+		}
+	}
+	return false
+}
+
+// tipsBeforePush returns the commits the refs of a repository pointed to before a push: the old commits of the
+// refs the push updated and the tips of all other refs.
+func tipsBeforePush(ctx context.Context, repoPath string, updates []RefUpdate) ([]string, error) {
+	oldCommitIDs := make(map[string]string, len(updates))
+	for _, update := range updates {
+		oldCommitIDs[update.Ref] = update.OldCommitID
+	}
+	return lfs.TipsBeforePush(ctx, repoPath, oldCommitIDs)
+}
+
+// enrich computes whether the update was forced and which LFS pointers it added.
+// It runs in the queue so that the push itself doesn't wait for it.
+func (e *Event) enrich(ctx context.Context) error {
+	e.Created = e.OldCommitID == git.EmptySHA
+	e.Deleted = e.NewCommitID == git.EmptySHA
+	if e.Deleted {
+		e.Enriched = true
+		return nil
+	}
+
+	if !e.Created {
+		_, _, err := git.NewCommand(ctx, "merge-base", "--is-ancestor").AddDynamicArguments(e.OldCommitID, e.NewCommitID).RunStdString(&git.RunOpts{Dir: e.RepoPath})
+		switch {
+		case err == nil:
+		case err.IsExitCode(1):
+			e.Forced = true
+		default:
+			return fmt.Errorf("merge-base --is-ancestor: %w", err)
+		}
+	}
+
+	var pointers []lfs.Pointer
+	var err error
+	if e.Created && e.ExcludedCommitIDs != nil {
+		// the other refs may have changed since the push
+		pointers, err = lfs.AddedPointersExcluding(ctx, e.RepoPath, nil, e.NewCommitID, e.ExcludedCommitIDs)
+	} else {
+		pointers, err = lfs.AddedPointers(ctx, e.RepoPath, nil, e.Ref, e.OldCommitID, e.NewCommitID)
+	}
+	if err != nil {
+		return err
+	}
+	e.LFSObjects = make([]LFSObject, 0, len(pointers))
+	for _, p := range pointers {
+		e.LFSObjects = append(e.LFSObjects, LFSObject{Oid: p.Oid, Size: p.Size})
+	}
+	e.Enriched = true
+	return nil
+}
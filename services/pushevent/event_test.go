@@ -0,0 +1,128 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushevent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openmerlin/gitea_data/modules/git"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testRepo struct {
+	t    *testing.T
+	path string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	path := filepath.Join(t.TempDir(), "repo.git")
+	require.NoError(t, exec.Command("git", "init", "--bare", path).Run())
+	return &testRepo{t: t, path: path}
+}
+
+func (r *testRepo) git(stdin string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", r.path}, args...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.Output()
+	require.NoError(r.t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
+
+// pointer returns the content of an LFS pointer whose oid is made of c
+func pointer(c string, size int64) string {
+	return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", strings.Repeat(c, 64), size)
+}
+
+// commit creates a commit of the files, given as name and content, on top of the parents
+func (r *testRepo) commit(files map[string]string, parents ...string) string {
+	var tree strings.Builder
+	for name, content := range files {
+		blob := r.git(content, "hash-object", "-w", "--stdin")
+		fmt.Fprintf(&tree, "100644 blob %s\t%s\n", blob, name)
+	}
+	args := []string{"commit-tree", r.git(tree.String(), "mktree"), "-m", "commit"}
+	for _, parent := range parents {
+		args = append(args, "-p", parent)
+	}
+	return r.git("", args...)
+}
+
+func lfsOids(objects []LFSObject) []string {
+	oids := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		oids = append(oids, obj.Oid[:1])
+	}
+	return oids
+}
+
+func TestEventEnrich(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+	first := repo.commit(map[string]string{"a.bin": pointer("a", 1), "README": "readme"})
+	second := repo.commit(map[string]string{"a.bin": pointer("a", 1), "b.bin": pointer("b", 2)}, first)
+	unrelated := repo.commit(map[string]string{"c.bin": pointer("c", 3)})
+
+	// a fast-forward adds the pointers which weren't reachable from the old commit
+	event := &Event{Ref: "refs/heads/main", OldCommitID: first, NewCommitID: second, RepoPath: repo.path}
+	assert.NoError(t, event.enrich(ctx))
+	assert.True(t, event.Enriched)
+	assert.False(t, event.Created)
+	assert.False(t, event.Forced)
+	assert.Equal(t, []string{"b"}, lfsOids(event.LFSObjects))
+	assert.EqualValues(t, 2, event.LFSObjects[0].Size)
+
+	event = &Event{Ref: "refs/heads/main", OldCommitID: second, NewCommitID: unrelated, RepoPath: repo.path}
+	assert.NoError(t, event.enrich(ctx))
+	assert.True(t, event.Forced)
+	assert.Equal(t, []string{"c"}, lfsOids(event.LFSObjects))
+
+	event = &Event{Ref: "refs/heads/main", OldCommitID: second, NewCommitID: git.EmptySHA, RepoPath: repo.path}
+	assert.NoError(t, event.enrich(ctx))
+	assert.True(t, event.Deleted)
+	assert.Empty(t, event.LFSObjects)
+
+	// a created ref is compared with the tips of the refs before the push, not with the refs when it is enriched
+	repo.git("", "update-ref", "refs/heads/main", second)
+	event = &Event{Ref: "refs/heads/feature", OldCommitID: git.EmptySHA, NewCommitID: second, RepoPath: repo.path, ExcludedCommitIDs: []string{first}}
+	assert.NoError(t, event.enrich(ctx))
+	assert.True(t, event.Created)
+	assert.Equal(t, []string{"b"}, lfsOids(event.LFSObjects))
+
+	event = &Event{Ref: "refs/heads/feature", OldCommitID: git.EmptySHA, NewCommitID: second, RepoPath: repo.path, ExcludedCommitIDs: []string{}}
+	assert.NoError(t, event.enrich(ctx))
+	assert.ElementsMatch(t, []string{"a", "b"}, lfsOids(event.LFSObjects))
+
+	// events without the tips before the push are compared with the other refs
+	event = &Event{Ref: "refs/heads/feature", OldCommitID: git.EmptySHA, NewCommitID: second, RepoPath: repo.path}
+	assert.NoError(t, event.enrich(ctx))
+	assert.Empty(t, event.LFSObjects)
+}
+
+func TestTipsBeforePush(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+	first := repo.commit(map[string]string{"README": "1"})
+	second := repo.commit(map[string]string{"README": "2"}, first)
+	other := repo.commit(map[string]string{"README": "other"})
+
+	// the refs after a push which moved main and created feature
+	repo.git("", "update-ref", "refs/heads/main", second)
+	repo.git("", "update-ref", "refs/heads/feature", second)
+	repo.git("", "update-ref", "refs/heads/other", other)
+	repo.git("", "update-ref", "refs/tags/v1", first)
+
+	tips, err := tipsBeforePush(ctx, repo.path, []RefUpdate{
+		{Ref: "refs/heads/main", OldCommitID: first, NewCommitID: second},
+		{Ref: "refs/heads/feature", OldCommitID: git.EmptySHA, NewCommitID: second},
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{first, other}, tips)
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package pushevent publishes a structured event for every ref update, and for every status reported
+// for a commit, to external sinks.
+//
+// Events are put into a persistent queue by post-receive and delivered by the queue workers,
+// failed deliveries are retried by the queue so that an unavailable sink never slows down a push.
+package pushevent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.gitea.io/gitea/modules/graceful"
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/queue"
+	"code.gitea.io/gitea/modules/util"
+
+	"github.com/openmerlin/gitea_data/modules/git"
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+var (
+	eventQueue *queue.WorkerPoolQueue[*Event]
+	sinks      []Sink
+)
+
+// Init creates the sinks and starts the delivery queue
+func Init() error {
+	if !setting.PushEvents.Enabled {
+		return nil
+	}
+
+	var err error
+	if sinks, err = newSinks(); err != nil {
+		return err
+	}
+
+	eventQueue = queue.CreateSimpleQueue(graceful.GetManager().ShutdownContext(), "push_events", handle)
+	if eventQueue == nil {
+		return errors.New("unable to create push_events queue")
+	}
+	go graceful.GetManager().RunWithCancel(eventQueue)
+	return nil
+}
+
+// RefUpdate is a single ref update of a push
+type RefUpdate struct {
+	Ref         string
+	OldCommitID string
+	NewCommitID string
+}
+
+// Repository identifies the pushed repository
+type Repository struct {
+	ID        int64
+	OwnerName string
+	Name      string
+	IsWiki    bool
+	Path      string
+}
+
+// Notify queues an event for every ref update of a push. The LFS pointers added by a created ref are computed
+// in the queue against the tips of the refs before the push, which are looked up here.
+func Notify(ctx context.Context, repo Repository, pusherID int64, pusherName string, updates []RefUpdate) {
+	if eventQueue == nil {
+		return
+	}
+
+	var tips []string
+	for _, update := range updates {
+		if update.OldCommitID != git.EmptySHA || update.NewCommitID == git.EmptySHA {
+			continue
+		}
+		var err error
+		if tips, err = tipsBeforePush(ctx, repo.Path, updates); err != nil {
+			log.Error("Unable to get the refs of %s/%s before the push: %v", repo.OwnerName, repo.Name, err)
+		}
+		break
+	}
+
+	now := time.Now()
+	for _, update := range updates {
+		id, err := util.CryptoRandomString(20)
+		if err != nil {
+			log.Error("Unable to generate push event id: %v", err)
+			continue
+		}
+		event := &Event{
+			ID:          id,
+			Type:        EventTypePush,
+			Timestamp:   now,
+			RepoID:      repo.ID,
+			OwnerName:   repo.OwnerName,
+			RepoName:    repo.Name,
+			IsWiki:      repo.IsWiki,
+			PusherID:    pusherID,
+			PusherName:  pusherName,
+			Ref:         update.Ref,
+			OldCommitID: update.OldCommitID,
+			NewCommitID: update.NewCommitID,
+			RepoPath:    repo.Path,
+		}
+		if update.OldCommitID == git.EmptySHA {
+			event.ExcludedCommitIDs = tips
+		}
+		if err := eventQueue.Push(event); err != nil {
+			log.Error("Unable to queue push event for %s/%s %s: %v", repo.OwnerName, repo.Name, update.Ref, err)
+		}
+	}
+}
+
+// NotifyCommitStatus queues an event for a status reported for a commit of the repository
+func NotifyCommitStatus(repo Repository, creatorID int64, creatorName string, status *CommitStatus) {
+	if eventQueue == nil {
+		return
+	}
+	id, err := util.CryptoRandomString(20)
+	if err != nil {
+		log.Error("Unable to generate push event id: %v", err)
+		return
+	}
+	event := &Event{
+		ID:           id,
+		Type:         EventTypeCommitStatus,
+		Timestamp:    time.Now(),
+		RepoID:       repo.ID,
+		OwnerName:    repo.OwnerName,
+		RepoName:     repo.Name,
+		PusherID:     creatorID,
+		PusherName:   creatorName,
+		CommitStatus: status,
+		// there is nothing to compute for a status
+		Enriched: true,
+	}
+	if err := eventQueue.Push(event); err != nil {
+		log.Error("Unable to queue commit status event for %s/%s %s: %v", repo.OwnerName, repo.Name, status.SHA, err)
+	}
+}
+
+// handle delivers the events to all sinks, events which couldn't be delivered to every sink are retried
+func handle(events ...*Event) (unhandled []*Event) {
+	ctx := graceful.GetManager().ShutdownContext()
+	for _, event := range events {
+		if !event.Enriched {
+			if err := event.enrich(ctx); err != nil {
+				// the objects may be gone already, deliver what we know instead of retrying forever
+				log.Warn("Unable to enrich push event %s for %s/%s %s: %v", event.ID, event.OwnerName, event.RepoName, event.Ref, err)
+				event.Enriched = true
+			}
+		}
+		if !deliver(ctx, event) {
+			unhandled = append(unhandled, event)
+		}
+	}
+	return unhandled
+}
+
+// deliver sends the event to all sinks which haven't received it yet, it returns true if all sinks have it
+func deliver(ctx context.Context, event *Event) bool {
+	// the delivery state isn't part of the event
+	published := *event
+	published.Type = event.eventType()
+	published.RepoPath = ""
+	published.ExcludedCommitIDs = nil
+	published.Enriched = false
+	published.Delivered = nil
+	payload, err := json.Marshal(&published)
+	if err != nil {
+		log.Error("Unable to marshal push event %s: %v", event.ID, err)
+		return true
+	}
+
+	ok := true
+	for _, sink := range sinks {
+		if event.isDelivered(sink.Name()) {
+			continue
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := sink.Send(sendCtx, event, payload)
+		cancel()
+		if err != nil {
+			log.Warn("Unable to deliver push event %s to %s, it will be retried: %v", event.ID, sink.Name(), err)
+			ok = false
+			continue
+		}
+		event.Delivered = append(event.Delivered, sink.Name())
+	}
+	return ok
+}
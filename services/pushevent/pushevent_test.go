@@ -0,0 +1,125 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushevent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/modules/json"
+	"code.gitea.io/gitea/modules/queue"
+	gitea_setting "code.gitea.io/gitea/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSink struct {
+	name     string
+	err      error
+	payloads [][]byte
+}
+
+func (s *testSink) Name() string { return s.name }
+
+func (s *testSink) Send(_ context.Context, _ *Event, payload []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.payloads = append(s.payloads, payload)
+	return nil
+}
+
+func TestDeliver(t *testing.T) {
+	webhook := &testSink{name: "webhook"}
+	kafka := &testSink{name: "kafka", err: errors.New("unavailable")}
+	defer func(old []Sink) { sinks = old }(sinks)
+	sinks = []Sink{webhook, kafka}
+
+	event := &Event{
+		ID:                "1",
+		Ref:               "refs/heads/main",
+		RepoPath:          "/data/repo.git",
+		ExcludedCommitIDs: []string{"0123"},
+		Enriched:          true,
+	}
+	assert.False(t, deliver(context.Background(), event))
+	assert.Equal(t, []string{"webhook"}, event.Delivered)
+	if assert.Len(t, webhook.payloads, 1) {
+		published := map[string]any{}
+		assert.NoError(t, json.Unmarshal(webhook.payloads[0], &published))
+		assert.Equal(t, EventTypePush, published["type"])
+		assert.NotContains(t, published, "repo_path")
+		assert.NotContains(t, published, "excluded_commit_ids")
+		assert.NotContains(t, published, "enriched")
+		assert.NotContains(t, published, "delivered")
+	}
+
+	// a retry only goes to the sinks which don't have the event yet
+	kafka.err = nil
+	assert.True(t, deliver(context.Background(), event))
+	assert.Equal(t, []string{"webhook", "kafka"}, event.Delivered)
+	assert.Len(t, webhook.payloads, 1)
+	assert.Len(t, kafka.payloads, 1)
+}
+
+func TestNotifyCommitStatus(t *testing.T) {
+	queued := make(chan *Event, 1)
+	q, err := queue.NewWorkerPoolQueueWithContext(context.Background(), "push_events",
+		gitea_setting.QueueSettings{Type: "channel", Length: 10, BatchLength: 1, MaxWorkers: 1},
+		func(events ...*Event) []*Event {
+			for _, event := range events {
+				queued <- event
+			}
+			return nil
+		}, false)
+	require.NoError(t, err)
+	go q.Run()
+	defer q.ShutdownWait(5 * time.Second)
+	defer func(old *queue.WorkerPoolQueue[*Event]) { eventQueue = old }(eventQueue)
+	eventQueue = q
+
+	NotifyCommitStatus(Repository{ID: 1, OwnerName: "user2", Name: "repo1"}, 2, "user2", &CommitStatus{
+		SHA:           "65f1bf27bc3bf70f64657658635e66094edbcb4d",
+		Context:       "ci/build",
+		State:         "failure",
+		CombinedState: "failure",
+	})
+	var event *Event
+	select {
+	case event = <-queued:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the commit status event wasn't queued")
+	}
+	assert.Equal(t, EventTypeCommitStatus, event.Type)
+	// there is nothing to look up in the repository for a status
+	assert.True(t, event.Enriched)
+	assert.Empty(t, event.RepoPath)
+
+	sink := &testSink{name: "webhook"}
+	defer func(old []Sink) { sinks = old }(sinks)
+	sinks = []Sink{sink}
+	assert.Empty(t, handle(event))
+	if assert.Len(t, sink.payloads, 1) {
+		published := map[string]any{}
+		assert.NoError(t, json.Unmarshal(sink.payloads[0], &published))
+		assert.Equal(t, EventTypeCommitStatus, published["type"])
+		assert.EqualValues(t, 2, published["pusher_id"])
+		assert.Equal(t, map[string]any{
+			"sha":            "65f1bf27bc3bf70f64657658635e66094edbcb4d",
+			"context":        "ci/build",
+			"state":          "failure",
+			"target_url":     "",
+			"description":    "",
+			"combined_state": "failure",
+		}, published["commit_status"])
+	}
+
+	// push events carry no status
+	payload, err := json.Marshal(&Event{Type: EventTypePush})
+	require.NoError(t, err)
+	assert.NotContains(t, string(payload), "commit_status")
+}
@@ -0,0 +1,165 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushevent
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// Sink delivers events to an external system
+type Sink interface {
+	Name() string
+	// Send delivers the JSON encoded event, it must be safe to send the same event again
+	Send(ctx context.Context, event *Event, payload []byte) error
+}
+
+func newSinks() ([]Sink, error) {
+	sinks := make([]Sink, 0, len(setting.PushEvents.Sinks))
+	for _, name := range setting.PushEvents.Sinks {
+		switch name {
+		case "webhook":
+			sinks = append(sinks, &WebhookSink{
+				URL:    setting.PushEvents.Webhook.URL,
+				Secret: setting.PushEvents.Webhook.Secret,
+				Client: &http.Client{Timeout: setting.PushEvents.Webhook.Timeout},
+			})
+		case "kafka":
+			sinks = append(sinks, &KafkaRESTSink{
+				URL:    setting.PushEvents.Kafka.URL,
+				Topic:  setting.PushEvents.Kafka.Topic,
+				Client: &http.Client{Timeout: setting.PushEvents.Kafka.Timeout},
+			})
+		case "file":
+			sinks = append(sinks, &FileSink{Path: setting.PushEvents.File.Path})
+		default:
+			return nil, fmt.Errorf("unknown push event sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+func checkResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected status %s: %s", resp.Status, body)
+}
+
+// WebhookSink posts every event to an HTTP endpoint.
+// The body is signed with HMAC-SHA256 of Secret in the X-Gitea-Signature-256 header.
+type WebhookSink struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+// Name implements Sink
+func (s *WebhookSink) Name() string { return "webhook" }
+
+// Sign returns the hex encoded HMAC-SHA256 signature of payload
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send implements Sink
+func (s *WebhookSink) Send(ctx context.Context, event *Event, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gitea-Event", event.eventType())
+	req.Header.Set("X-Gitea-Delivery", event.ID)
+	if s.Secret != "" {
+		req.Header.Set("X-Gitea-Signature-256", "sha256="+Sign(s.Secret, payload))
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(resp)
+}
+
+// KafkaRESTSink produces every event to a topic through the Kafka REST proxy API (v2),
+// which is served by the Confluent REST proxy and Kafka compatible brokers like Redpanda.
+// Events are keyed by repository so that the events of a repository stay ordered.
+type KafkaRESTSink struct {
+	URL    string
+	Topic  string
+	Client *http.Client
+}
+
+// Name implements Sink
+func (s *KafkaRESTSink) Name() string { return "kafka" }
+
+// Send implements Sink
+func (s *KafkaRESTSink) Send(ctx context.Context, event *Event, payload []byte) error {
+	var records bytes.Buffer
+	fmt.Fprintf(&records, `{"records":[{"key":"%d","value":`, event.RepoID)
+	records.Write(payload)
+	records.WriteString(`}]}`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL+"/topics/"+s.Topic, &records)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkResponse(resp)
+}
+
+// FileSink appends every event as a JSON line to a local file, it stands in for a message bus in development
+type FileSink struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Name implements Sink
+func (s *FileSink) Name() string { return "file" }
+
+// Send implements Sink
+func (s *FileSink) Send(_ context.Context, _ *Event, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(payload, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sendTimeout bounds a single delivery attempt of a sink without its own client timeout
+const sendTimeout = 30 * time.Second
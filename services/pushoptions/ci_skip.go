@@ -0,0 +1,114 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushoptions
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	repo_model "code.gitea.io/gitea/models/repo"
+	unit_model "code.gitea.io/gitea/models/unit"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/cache"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/repository"
+	notify_service "code.gitea.io/gitea/services/notify"
+)
+
+// ciSkipTimeout is how long, in seconds, the ref updates of a push are remembered for ci.skip,
+// the push update queue should have processed them by then
+const ciSkipTimeout = 60 * 60
+
+func ciSkipKey(repoID int64, commitID string) string {
+	return fmt.Sprintf("push_options_ci_skip_%d_%s", repoID, commitID)
+}
+
+// prepareCISkip remembers the pushed commits, the workflow runs are created when the push update queue processes them
+func prepareCISkip(_ context.Context, push *Push, value string) error {
+	if skip, _ := strconv.ParseBool(value); !skip {
+		return nil
+	}
+	for _, update := range push.Updates {
+		if update.NewCommitID == git.EmptySHA {
+			continue
+		}
+		if err := cache.GetCache().Put(ciSkipKey(push.Repo.ID, update.NewCommitID), true, ciSkipTimeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Init registers the actions notifier with registerActions between the notifiers of ci.skip.
+// Notifiers are called in the order they were registered, so for the commits pushed with ci.skip the actions
+// notifier sees a repository without the actions unit and creates no workflow runs.
+func Init(registerActions func()) error {
+	hidden := &sync.Map{}
+	notify_service.RegisterNotifier(&ciSkipNotifier{hidden: hidden})
+	registerActions()
+	notify_service.RegisterNotifier(&ciSkipRestoreNotifier{hidden: hidden})
+	return nil
+}
+
+// ciSkipNotifier hides the actions unit of a repository while a commit pushed with ci.skip is notified
+type ciSkipNotifier struct {
+	notify_service.NullNotifier
+	// hidden maps the repositories whose actions unit is hidden to their units
+	hidden *sync.Map
+}
+
+// PushCommits implements notify_service.Notifier
+func (n *ciSkipNotifier) PushCommits(ctx context.Context, _ *user_model.User, repo *repo_model.Repository, opts *repository.PushUpdateOptions, _ *repository.PushCommits) {
+	n.hideActions(ctx, repo, opts.NewCommitID)
+}
+
+// CreateRef implements notify_service.Notifier
+func (n *ciSkipNotifier) CreateRef(ctx context.Context, _ *user_model.User, repo *repo_model.Repository, _ git.RefName, refID string) {
+	n.hideActions(ctx, repo, refID)
+}
+
+func (n *ciSkipNotifier) hideActions(ctx context.Context, repo *repo_model.Repository, commitID string) {
+	if !cache.GetCache().IsExist(ciSkipKey(repo.ID, commitID)) {
+		return
+	}
+	// the units are loaded here as LoadUnits keeps the ones already set
+	if err := repo.LoadUnits(ctx); err != nil {
+		log.Error("Unable to load the units of %-v for ci.skip: %v", repo, err)
+		return
+	}
+
+	units := make([]*repo_model.RepoUnit, 0, len(repo.Units))
+	for _, unit := range repo.Units {
+		if unit.Type != unit_model.TypeActions {
+			units = append(units, unit)
+		}
+	}
+	n.hidden.Store(repo, repo.Units)
+	repo.Units = units
+}
+
+// ciSkipRestoreNotifier restores the units hidden by ciSkipNotifier once the actions notifier has been notified
+type ciSkipRestoreNotifier struct {
+	notify_service.NullNotifier
+	hidden *sync.Map
+}
+
+// PushCommits implements notify_service.Notifier
+func (n *ciSkipRestoreNotifier) PushCommits(_ context.Context, _ *user_model.User, repo *repo_model.Repository, _ *repository.PushUpdateOptions, _ *repository.PushCommits) {
+	n.restore(repo)
+}
+
+// CreateRef implements notify_service.Notifier
+func (n *ciSkipRestoreNotifier) CreateRef(_ context.Context, _ *user_model.User, repo *repo_model.Repository, _ git.RefName, _ string) {
+	n.restore(repo)
+}
+
+func (n *ciSkipRestoreNotifier) restore(repo *repo_model.Repository) {
+	if units, ok := n.hidden.LoadAndDelete(repo); ok {
+		repo.Units = units.([]*repo_model.RepoUnit)
+	}
+}
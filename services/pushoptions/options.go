@@ -0,0 +1,205 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushoptions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	git_model "code.gitea.io/gitea/models/git"
+	perm_model "code.gitea.io/gitea/models/perm"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/private"
+
+	"github.com/openmerlin/gitea_data/modules/lfs"
+	repo_service "github.com/openmerlin/gitea_data/services/repository"
+)
+
+// maxDescriptionLength is the length limit of a repository description
+const maxDescriptionLength = 2048
+
+// maxReportedLFSObjects limits the missing LFS objects listed to the pusher
+const maxReportedLFSObjects = 10
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return errors.New("must be true or false")
+	}
+	return nil
+}
+
+func init() {
+	Register(&Option{
+		Name:        private.GitPushOptionRepoPrivate,
+		Values:      "`true`, `false`",
+		Description: "Makes the repository private or public",
+		Permission:  perm_model.AccessModeAdmin,
+		Validate:    validateBool,
+		Apply: func(ctx context.Context, push *Push, value string) error {
+			isPrivate, _ := strconv.ParseBool(value)
+			if isPrivate == push.Repo.IsPrivate {
+				return nil
+			}
+			push.Repo.IsPrivate = isPrivate
+			return repo_service.UpdateRepository(ctx, push.Repo, true)
+		},
+	})
+	Register(&Option{
+		Name:        private.GitPushOptionRepoTemplate,
+		Values:      "`true`, `false`",
+		Description: "Marks the repository as a template or not",
+		Permission:  perm_model.AccessModeAdmin,
+		Validate:    validateBool,
+		Apply: func(ctx context.Context, push *Push, value string) error {
+			push.Repo.IsTemplate, _ = strconv.ParseBool(value)
+			return repo_model.UpdateRepositoryCols(ctx, push.Repo, "is_template")
+		},
+	})
+	Register(&Option{
+		Name:        "repo.description",
+		Values:      "text",
+		Description: fmt.Sprintf("Sets the description of the repository, at most %d characters", maxDescriptionLength),
+		Permission:  perm_model.AccessModeAdmin,
+		Validate: func(value string) error {
+			if utf8.RuneCountInString(value) > maxDescriptionLength {
+				return fmt.Errorf("must be at most %d characters", maxDescriptionLength)
+			}
+			return nil
+		},
+		Apply: func(ctx context.Context, push *Push, value string) error {
+			push.Repo.Description = value
+			return repo_model.UpdateRepositoryCols(ctx, push.Repo, "description")
+		},
+	})
+	Register(&Option{
+		Name:        "repo.default_branch",
+		Values:      "branch name",
+		Description: "Sets the default branch of the repository, the branch must exist after the push",
+		Permission:  perm_model.AccessModeAdmin,
+		Validate: func(value string) error {
+			if !git.IsValidRefPattern(value) || strings.ContainsAny(value, "*?[") {
+				return errors.New("must be a valid branch name")
+			}
+			return nil
+		},
+		Check: checkDefaultBranch,
+		Apply: applyDefaultBranch,
+	})
+	Register(&Option{
+		Name:        "repo.archived",
+		Values:      "`true`, `false`",
+		Description: "Archives the repository after the push, an archived repository can't be pushed to anymore",
+		Permission:  perm_model.AccessModeAdmin,
+		Validate:    validateBool,
+		Apply: func(ctx context.Context, push *Push, value string) error {
+			archived, _ := strconv.ParseBool(value)
+			return repo_service.SetRepositoryArchived(ctx, push.Repo, archived)
+		},
+	})
+	Register(&Option{
+		Name:        "lfs.verify",
+		Values:      "`strict`",
+		Description: "Rejects the push if it adds LFS pointers whose objects haven't been uploaded to the repository",
+		Permission:  perm_model.AccessModeWrite,
+		Validate: func(value string) error {
+			if value != "strict" {
+				return errors.New("the only supported value is strict")
+			}
+			return nil
+		},
+		Check: checkLFSObjects,
+	})
+	Register(&Option{
+		Name:        "ci.skip",
+		Values:      "`true`, `false`",
+		Description: "Doesn't trigger the workflows of the repository for the pushed commits",
+		Permission:  perm_model.AccessModeWrite,
+		Validate:    validateBool,
+		Prepare:     prepareCISkip,
+	})
+	Register(&Option{
+		Name:        "notify",
+		Values:      "`true`, `false`",
+		Description: "`false` doesn't publish push events for the push",
+		Permission:  perm_model.AccessModeWrite,
+		Validate:    validateBool,
+	})
+}
+
+func checkDefaultBranch(ctx context.Context, push *Push, value string) error {
+	if push.IsWiki {
+		return ErrInvalidOption{Name: "repo.default_branch", Message: "can't be used for wiki pushes"}
+	}
+	refName := git.RefNameFromBranch(value)
+	for _, update := range push.Updates {
+		if update.RefFullName == refName {
+			if update.NewCommitID == git.EmptySHA {
+				return ErrInvalidOption{Name: "repo.default_branch", Message: fmt.Sprintf("branch %s is deleted by the push", value)}
+			}
+			return nil
+		}
+	}
+	if _, _, err := git.NewCommand(ctx, "show-ref", "--verify", "--quiet").AddDynamicArguments(refName.String()).
+		RunStdString(&git.RunOpts{Dir: push.RepoPath(), Env: push.Env}); err != nil {
+		if err.IsExitCode(1) {
+			return ErrInvalidOption{Name: "repo.default_branch", Message: fmt.Sprintf("branch %s doesn't exist", value)}
+		}
+		return fmt.Errorf("show-ref: %w", err)
+	}
+	return nil
+}
+
+func applyDefaultBranch(ctx context.Context, push *Push, value string) error {
+	if push.Repo.DefaultBranch == value {
+		return nil
+	}
+	gitRepo, err := git.OpenRepository(ctx, push.Repo.RepoPath())
+	if err != nil {
+		return err
+	}
+	defer gitRepo.Close()
+
+	if err := gitRepo.SetDefaultBranch(value); err != nil && !git.IsErrUnsupportedVersion(err) {
+		return err
+	}
+	push.Repo.DefaultBranch = value
+	return repo_model.UpdateDefaultBranch(ctx, push.Repo)
+}
+
+func checkLFSObjects(ctx context.Context, push *Push, _ string) error {
+	var missing []string
+	for _, update := range push.Updates {
+		if update.NewCommitID == git.EmptySHA {
+			continue
+		}
+		pointers, err := lfs.AddedPointers(ctx, push.RepoPath(), push.Env, update.RefFullName.String(), update.OldCommitID, update.NewCommitID)
+		if err != nil {
+			return err
+		}
+		for _, p := range pointers {
+			if _, err := git_model.GetLFSMetaObjectByOid(ctx, push.Repo.ID, p.Oid); err != nil {
+				if err != git_model.ErrLFSObjectNotExist {
+					return err
+				}
+				missing = append(missing, fmt.Sprintf("%s (%d bytes)", p.Oid, p.Size))
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%d LFS objects haven't been uploaded: ", len(missing))
+	if len(missing) > maxReportedLFSObjects {
+		msg += strings.Join(missing[:maxReportedLFSObjects], ", ") + ", ..."
+	} else {
+		msg += strings.Join(missing, ", ")
+	}
+	return ErrInvalidOption{Name: "lfs.verify", Message: msg}
+}
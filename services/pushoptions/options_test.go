@@ -0,0 +1,93 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushoptions
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	repo_model "code.gitea.io/gitea/models/repo"
+	unit_model "code.gitea.io/gitea/models/unit"
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/cache"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLFSObjects(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+
+	// the fixtures have the LFS objects of user2/lfs, the pushes go to a copy of it
+	repo := &repo_model.Repository{ID: 54, OwnerName: "user2", Name: "lfs-check"}
+	repoPath := repo.RepoPath()
+	require.NoError(t, exec.Command("git", "init", "--bare", repoPath).Run())
+	gitCmd := func(stdin string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", repoPath}, args...)...)
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.Output()
+		require.NoError(t, err, "git %v", args)
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(pointers ...string) string {
+		var tree strings.Builder
+		for i, p := range pointers {
+			fmt.Fprintf(&tree, "100644 blob %s\tfile%d.bin\n", gitCmd(p, "hash-object", "-w", "--stdin"), i)
+		}
+		return gitCmd("", "commit-tree", gitCmd(tree.String(), "mktree"), "-m", "commit")
+	}
+	pointer := func(oid string, size int64) string {
+		return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", oid, size)
+	}
+	const uploaded = "0b8d8b5f15046343fd32f451df93acc2bdd9e6373be478b968e4cad6b6647351"
+	missing := strings.Repeat("1", 64)
+
+	push := &Push{Repo: repo, Updates: []RefUpdate{{RefFullName: git.RefNameFromBranch("main"), OldCommitID: git.EmptySHA, NewCommitID: commit(pointer(uploaded, 107))}}}
+	assert.NoError(t, checkLFSObjects(db.DefaultContext, push, "strict"))
+
+	push.Updates[0].NewCommitID = commit(pointer(uploaded, 107), pointer(missing, 42))
+	err := checkLFSObjects(db.DefaultContext, push, "strict")
+	assert.True(t, IsErrInvalidOption(err), "%v", err)
+	assert.ErrorContains(t, err, "1 LFS objects haven't been uploaded: "+missing+" (42 bytes)")
+
+	// deletions add nothing
+	push.Updates[0] = RefUpdate{RefFullName: git.RefNameFromBranch("main"), OldCommitID: push.Updates[0].NewCommitID, NewCommitID: git.EmptySHA}
+	assert.NoError(t, checkLFSObjects(db.DefaultContext, push, "strict"))
+
+}
+
+func TestCISkipNotifiers(t *testing.T) {
+	// the cache defaults to memory
+	require.NoError(t, cache.NewContext())
+
+	units := []*repo_model.RepoUnit{{Type: unit_model.TypeCode}, {Type: unit_model.TypeActions}}
+	repo := &repo_model.Repository{ID: 1, Units: units}
+	skipped, triggered := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	push := &Push{Repo: repo, Updates: []RefUpdate{
+		{RefFullName: git.RefNameFromBranch("main"), OldCommitID: git.EmptySHA, NewCommitID: skipped},
+		{RefFullName: git.RefNameFromBranch("gone"), OldCommitID: triggered, NewCommitID: git.EmptySHA},
+	}}
+	assert.NoError(t, Prepare(db.DefaultContext, push, map[string]string{"ci.skip": "true"}))
+
+	hidden := &sync.Map{}
+	hide, restore := &ciSkipNotifier{hidden: hidden}, &ciSkipRestoreNotifier{hidden: hidden}
+
+	// the actions notifier is notified in between and sees no actions unit
+	hide.CreateRef(db.DefaultContext, nil, repo, git.RefNameFromBranch("main"), skipped)
+	assert.False(t, repo.UnitEnabled(db.DefaultContext, unit_model.TypeActions))
+	assert.True(t, repo.UnitEnabled(db.DefaultContext, unit_model.TypeCode))
+	restore.CreateRef(db.DefaultContext, nil, repo, git.RefNameFromBranch("main"), skipped)
+	assert.Equal(t, units, repo.Units)
+
+	hide.PushCommits(db.DefaultContext, nil, repo, &repository.PushUpdateOptions{NewCommitID: triggered}, nil)
+	assert.True(t, repo.UnitEnabled(db.DefaultContext, unit_model.TypeActions))
+	restore.PushCommits(db.DefaultContext, nil, repo, &repository.PushUpdateOptions{NewCommitID: triggered}, nil)
+	assert.Equal(t, units, repo.Units)
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package pushoptions is the registry of the push options (git push -o name=value) understood by the server.
+//
+// Every option is validated and permission checked in pre-receive so that an invalid option rejects the push,
+// its effects are applied in post-receive.
+package pushoptions
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	perm_model "code.gitea.io/gitea/models/perm"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/modules/git"
+)
+
+// RefUpdate is a single ref update of the push
+type RefUpdate struct {
+	RefFullName git.RefName
+	OldCommitID string
+	NewCommitID string
+}
+
+// Push describes the push the options were sent with
+type Push struct {
+	Repo     *repo_model.Repository
+	IsWiki   bool
+	PusherID int64
+	// Env is the git environment of pre-receive which gives access to the quarantined objects
+	Env     []string
+	Updates []RefUpdate
+}
+
+// RepoPath returns the path of the pushed repository
+func (p *Push) RepoPath() string {
+	if p.IsWiki {
+		return p.Repo.WikiPath()
+	}
+	return p.Repo.RepoPath()
+}
+
+// Option is a push option
+type Option struct {
+	Name        string
+	Values      string
+	Description string
+	// Permission is the access mode the pusher needs to send the option
+	Permission perm_model.AccessMode
+	// Validate checks the value, it's required
+	Validate func(value string) error
+	// Check verifies the option against the push in pre-receive
+	Check func(ctx context.Context, push *Push, value string) error
+	// Prepare runs in post-receive before the ref updates are processed
+	Prepare func(ctx context.Context, push *Push, value string) error
+	// Apply runs in post-receive after the ref updates have been processed
+	Apply func(ctx context.Context, push *Push, value string) error
+}
+
+// ErrInvalidOption is returned if an option is invalid or not allowed, its message is shown to the pusher
+type ErrInvalidOption struct {
+	Name    string
+	Message string
+}
+
+func (err ErrInvalidOption) Error() string {
+	return fmt.Sprintf("push option %s: %s", err.Name, err.Message)
+}
+
+// IsErrInvalidOption checks if an error is a ErrInvalidOption
+func IsErrInvalidOption(err error) bool {
+	_, ok := err.(ErrInvalidOption)
+	return ok
+}
+
+var options = map[string]*Option{}
+
+// Register adds an option, it panics if the name is taken
+func Register(opt *Option) {
+	if _, ok := options[opt.Name]; ok {
+		panic(fmt.Sprintf("push option %q is already registered", opt.Name))
+	}
+	options[opt.Name] = opt
+}
+
+// Get returns the registered option
+func Get(name string) (*Option, bool) {
+	opt, ok := options[name]
+	return opt, ok
+}
+
+// All returns all registered options sorted by name
+func All() []*Option {
+	all := make([]*Option, 0, len(options))
+	for _, opt := range options {
+		all = append(all, opt)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// sortedNames returns the names of the registered options in pushOptions, unknown options are left to other handlers like AGit
+func sortedNames(pushOptions map[string]string) []string {
+	names := make([]string, 0, len(pushOptions))
+	for name := range pushOptions {
+		if _, ok := options[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate validates the registered options of a push and checks the pusher may send them
+func Validate(ctx context.Context, push *Push, pushOptions map[string]string, mode perm_model.AccessMode) error {
+	for _, name := range sortedNames(pushOptions) {
+		opt, value := options[name], pushOptions[name]
+		if mode < opt.Permission {
+			return ErrInvalidOption{Name: name, Message: fmt.Sprintf("requires %s access to the repository", opt.Permission)}
+		}
+		if err := opt.Validate(value); err != nil {
+			return ErrInvalidOption{Name: name, Message: err.Error()}
+		}
+		if opt.Check != nil {
+			if err := opt.Check(ctx, push, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Prepare runs the options which must take effect before the ref updates are processed
+func Prepare(ctx context.Context, push *Push, pushOptions map[string]string) error {
+	for _, name := range sortedNames(pushOptions) {
+		if opt := options[name]; opt.Prepare != nil {
+			if err := opt.Prepare(ctx, push, pushOptions[name]); err != nil {
+				return fmt.Errorf("push option %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply applies the options of a push once the ref updates have been processed
+func Apply(ctx context.Context, push *Push, pushOptions map[string]string) error {
+	for _, name := range sortedNames(pushOptions) {
+		if opt := options[name]; opt.Apply != nil {
+			if err := opt.Apply(ctx, push, pushOptions[name]); err != nil {
+				return fmt.Errorf("push option %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Bool returns the boolean value of a registered option, or def if it wasn't sent
+func Bool(pushOptions map[string]string, name string, def bool) bool {
+	value, ok := pushOptions[name]
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// Documentation returns the markdown documentation of the registered options
+func Documentation() string {
+	var sb strings.Builder
+	sb.WriteString("| Option | Values | Permission | Description |\n")
+	sb.WriteString("| ------ | ------ | ---------- | ----------- |\n")
+	for _, opt := range All() {
+		fmt.Fprintf(&sb, "| `%s` | %s | %s | %s |\n", opt.Name, opt.Values, opt.Permission, opt.Description)
+	}
+	return sb.String()
+}
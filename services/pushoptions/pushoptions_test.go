@@ -0,0 +1,81 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushoptions
+
+import (
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	perm_model "code.gitea.io/gitea/models/perm"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	"code.gitea.io/gitea/modules/git"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	push := &Push{Repo: repo, Updates: []RefUpdate{{RefFullName: git.RefNameFromBranch("master"), OldCommitID: git.EmptySHA, NewCommitID: "65f1bf27bc3bf70f64657658635e66094edbcb4d"}}}
+
+	assert.NoError(t, Validate(db.DefaultContext, push, map[string]string{"ci.skip": "true", "unknown": "value"}, perm_model.AccessModeWrite))
+	assert.NoError(t, Validate(db.DefaultContext, push, map[string]string{"repo.default_branch": "master", "repo.private": "true"}, perm_model.AccessModeAdmin))
+
+	for _, c := range []struct {
+		options map[string]string
+		mode    perm_model.AccessMode
+	}{
+		{map[string]string{"repo.private": "true"}, perm_model.AccessModeWrite},
+		{map[string]string{"ci.skip": "yes please"}, perm_model.AccessModeWrite},
+		{map[string]string{"lfs.verify": "lax"}, perm_model.AccessModeWrite},
+		{map[string]string{"repo.description": strings.Repeat("a", maxDescriptionLength+1)}, perm_model.AccessModeAdmin},
+		{map[string]string{"repo.default_branch": "feature/*"}, perm_model.AccessModeAdmin},
+		{map[string]string{"repo.default_branch": "no-such-branch"}, perm_model.AccessModeAdmin},
+	} {
+		err := Validate(db.DefaultContext, push, c.options, c.mode)
+		assert.True(t, IsErrInvalidOption(err), "%v: %v", c.options, err)
+	}
+
+	// a branch deleted by the push can't become the default branch
+	push.Updates[0].NewCommitID = git.EmptySHA
+	err := Validate(db.DefaultContext, push, map[string]string{"repo.default_branch": "master"}, perm_model.AccessModeAdmin)
+	assert.True(t, IsErrInvalidOption(err), "%v", err)
+
+	push.IsWiki = true
+	err = Validate(db.DefaultContext, push, map[string]string{"repo.default_branch": "master"}, perm_model.AccessModeAdmin)
+	assert.True(t, IsErrInvalidOption(err), "%v", err)
+}
+
+func TestApply(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	push := &Push{Repo: repo}
+
+	assert.NoError(t, Apply(db.DefaultContext, push, map[string]string{
+		"repo.description": "a new description",
+		"repo.template":    "true",
+		"unknown":          "value",
+	}))
+	repo = unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	assert.Equal(t, "a new description", repo.Description)
+	assert.True(t, repo.IsTemplate)
+}
+
+func TestBool(t *testing.T) {
+	pushOptions := map[string]string{"notify": "false", "ci.skip": "maybe"}
+	assert.False(t, Bool(pushOptions, "notify", true))
+	assert.True(t, Bool(pushOptions, "ci.skip", true))
+	assert.True(t, Bool(pushOptions, "repo.private", true))
+}
+
+func TestDocumentation(t *testing.T) {
+	doc := Documentation()
+	lines := strings.Split(strings.TrimSuffix(doc, "\n"), "\n")
+	assert.Len(t, lines, len(All())+2)
+	assert.Equal(t, "| Option | Values | Permission | Description |", lines[0])
+	assert.Contains(t, doc, "| `lfs.verify` | `strict` | write |")
+	assert.Contains(t, doc, "| `repo.private` | `true`, `false` | admin |")
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushpolicy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/git"
+
+	"github.com/gobwas/glob"
+	"github.com/openmerlin/gitea_data/modules/lfs"
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// Repository is the pushed repository
+type Repository struct {
+	ID            int64  `json:"id"`
+	OwnerName     string `json:"owner"`
+	Name          string `json:"name"`
+	IsWiki        bool   `json:"is_wiki"`
+	IsPrivate     bool   `json:"private"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// Pusher is the doer of the push
+type Pusher struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	IsAdmin     bool   `json:"is_admin"`
+	DeployKeyID int64  `json:"deploy_key_id,omitempty"`
+}
+
+// ChangedFile is a file touched by the pushed commits
+type ChangedFile struct {
+	Path string `json:"path"`
+	// Status is the git status letter of the newest change: A, M, D or T
+	Status string `json:"status"`
+	Size   int64  `json:"size"`
+	// Content is only set for files matching the configured inline patterns
+	Content *string `json:"content,omitempty"`
+}
+
+// LFSObject is an LFS pointer added by the push
+type LFSObject struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// RefUpdate is a single ref update of the push
+type RefUpdate struct {
+	Ref          string        `json:"ref"`
+	OldCommitID  string        `json:"old_sha"`
+	NewCommitID  string        `json:"new_sha"`
+	Created      bool          `json:"created"`
+	Deleted      bool          `json:"deleted"`
+	Forced       bool          `json:"forced"`
+	ChangedFiles []ChangedFile `json:"changed_files"`
+	// Truncated is set if there were more changed files than configured
+	Truncated  bool        `json:"truncated,omitempty"`
+	LFSObjects []LFSObject `json:"lfs_objects"`
+}
+
+// Description is what the policy evaluator decides on
+type Description struct {
+	Repository Repository  `json:"repository"`
+	Pusher     Pusher      `json:"pusher"`
+	RefUpdates []RefUpdate `json:"ref_updates"`
+}
+
+// DescribeRefUpdate fills in what the update of ref from oldCommitID to newCommitID changes.
+// env is the git environment of pre-receive which gives access to the quarantined objects.
+func DescribeRefUpdate(ctx context.Context, repoPath string, env []string, ref, oldCommitID, newCommitID string) (*RefUpdate, error) {
+	update := &RefUpdate{
+		Ref:          ref,
+		OldCommitID:  oldCommitID,
+		NewCommitID:  newCommitID,
+		Created:      oldCommitID == git.EmptySHA,
+		Deleted:      newCommitID == git.EmptySHA,
+		ChangedFiles: []ChangedFile{},
+		LFSObjects:   []LFSObject{},
+	}
+	if update.Deleted {
+		return update, nil
+	}
+
+	if !update.Created {
+		_, _, err := git.NewCommand(ctx, "merge-base", "--is-ancestor").AddDynamicArguments(oldCommitID, newCommitID).RunStdString(&git.RunOpts{Dir: repoPath, Env: env})
+		switch {
+		case err == nil:
+		case err.IsExitCode(1):
+			update.Forced = true
+		default:
+			return nil, fmt.Errorf("merge-base --is-ancestor: %w", err)
+		}
+	}
+
+	if err := update.loadChangedFiles(ctx, repoPath, env); err != nil {
+		return nil, err
+	}
+
+	pointers, err := lfs.AddedPointers(ctx, repoPath, env, ref, oldCommitID, newCommitID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pointers {
+		update.LFSObjects = append(update.LFSObjects, LFSObject{Oid: p.Oid, Size: p.Size})
+	}
+	return update, nil
+}
+
+// loadChangedFiles lists the files touched by any of the pushed commits,
+// so that a file which was added and removed again by the same push is still seen
+func (u *RefUpdate) loadChangedFiles(ctx context.Context, repoPath string, env []string) error {
+	cmd := git.NewCommand(ctx, "log", "--format=", "--name-status", "--no-renames", "-z").AddDynamicArguments(u.NewCommitID)
+	if u.Created {
+		cmd.AddArguments("--not").AddOptionFormat("--exclude=%s", u.Ref).AddArguments("--all")
+	} else {
+		cmd.AddArguments("--not").AddDynamicArguments(u.OldCommitID)
+	}
+	stdout, _, err := cmd.RunStdBytes(&git.RunOpts{Dir: repoPath, Env: env})
+	if err != nil {
+		return fmt.Errorf("log --name-status: %w", err)
+	}
+
+	// the output alternates status and path, the log is newest first so the first status of a path wins
+	seen := make(map[string]bool)
+	fields := bytes.Split(stdout, []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		status := strings.TrimSpace(string(fields[i]))
+		path := string(fields[i+1])
+		if status == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		if len(u.ChangedFiles) >= setting.PushPolicy.MaxChangedFiles {
+			u.Truncated = true
+			break
+		}
+		u.ChangedFiles = append(u.ChangedFiles, ChangedFile{Path: path, Status: status[:1]})
+	}
+
+	return u.loadFileContents(ctx, repoPath, env)
+}
+
+// loadFileContents sets the size of the changed files and the content of the ones matching the inline patterns
+func (u *RefUpdate) loadFileContents(ctx context.Context, repoPath string, env []string) error {
+	globs := make([]glob.Glob, 0, len(setting.PushPolicy.InlineFiles))
+	for _, pattern := range setting.PushPolicy.InlineFiles {
+		g, err := glob.Compile(pattern, '/')
+		if err != nil {
+			return fmt.Errorf("invalid inline file pattern %q: %w", pattern, err)
+		}
+		globs = append(globs, g)
+	}
+
+	var objects bytes.Buffer
+	var indexes []int
+	for i, file := range u.ChangedFiles {
+		if file.Status == "D" {
+			continue
+		}
+		objects.WriteString(u.NewCommitID + ":" + file.Path + "\n")
+		indexes = append(indexes, i)
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	var checks bytes.Buffer
+	if err := git.NewCommand(ctx, "cat-file", "--batch-check=%(objecttype) %(objectsize)").
+		Run(&git.RunOpts{Dir: repoPath, Env: env, Stdin: &objects, Stdout: &checks}); err != nil {
+		return fmt.Errorf("cat-file --batch-check: %w", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(checks.String(), "\n"), "\n")
+	if len(lines) != len(indexes) {
+		return fmt.Errorf("unexpected cat-file --batch-check output for %d files: %d lines", len(indexes), len(lines))
+	}
+
+	for n, line := range lines {
+		file := &u.ChangedFiles[indexes[n]]
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "blob" {
+			// a submodule or a path which doesn't exist in the new commit
+			continue
+		}
+		file.Size, _ = strconv.ParseInt(fields[1], 10, 64)
+		if file.Size > setting.PushPolicy.MaxInlineSize || !matchAny(globs, file.Path) {
+			continue
+		}
+		content, _, err := git.NewCommand(ctx, "cat-file", "blob").AddDynamicArguments(u.NewCommitID + ":" + file.Path).RunStdString(&git.RunOpts{Dir: repoPath, Env: env})
+		if err != nil {
+			return fmt.Errorf("cat-file blob %s: %w", file.Path, err)
+		}
+		file.Content = &content
+	}
+	return nil
+}
+
+func matchAny(globs []glob.Glob, path string) bool {
+	for _, g := range globs {
+		if g.Match(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushpolicy
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/modules/git"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testRepo struct {
+	t    *testing.T
+	path string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	path := filepath.Join(t.TempDir(), "repo.git")
+	require.NoError(t, exec.Command("git", "init", "--bare", path).Run())
+	return &testRepo{t: t, path: path}
+}
+
+func (r *testRepo) git(stdin string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", r.path}, args...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.Output()
+	require.NoError(r.t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
+
+// commit creates a commit of the files, given as name and content, on top of the parents
+func (r *testRepo) commit(files map[string]string, parents ...string) string {
+	var tree strings.Builder
+	for name, content := range files {
+		blob := r.git(content, "hash-object", "-w", "--stdin")
+		fmt.Fprintf(&tree, "100644 blob %s\t%s\n", blob, name)
+	}
+	args := []string{"commit-tree", r.git(tree.String(), "mktree"), "-m", "commit"}
+	for _, parent := range parents {
+		args = append(args, "-p", parent)
+	}
+	return r.git("", args...)
+}
+
+func pointer(c string, size int64) string {
+	return fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", strings.Repeat(c, 64), size)
+}
+
+func changedFiles(update *RefUpdate) map[string]string {
+	files := make(map[string]string, len(update.ChangedFiles))
+	for _, file := range update.ChangedFiles {
+		files[file.Path] = file.Status
+	}
+	return files
+}
+
+func TestDescribeRefUpdate(t *testing.T) {
+	defer func(old []string, size int64, max int) {
+		setting.PushPolicy.InlineFiles, setting.PushPolicy.MaxInlineSize, setting.PushPolicy.MaxChangedFiles = old, size, max
+	}(setting.PushPolicy.InlineFiles, setting.PushPolicy.MaxInlineSize, setting.PushPolicy.MaxChangedFiles)
+	setting.PushPolicy.InlineFiles = []string{"README.md"}
+	setting.PushPolicy.MaxInlineSize = 1024
+	setting.PushPolicy.MaxChangedFiles = 100
+
+	ctx := context.Background()
+	repo := newTestRepo(t)
+	first := repo.commit(map[string]string{"README.md": "license: mit\n", "old.txt": "old"})
+	// the second commit adds a pickle which the third one removes again
+	second := repo.commit(map[string]string{"README.md": "license: mit\n", "old.txt": "old", "model.pkl": "pickle"}, first)
+	third := repo.commit(map[string]string{"README.md": "license: apache-2.0\n", "model.bin": pointer("a", 42)}, second)
+	repo.git("", "update-ref", "refs/heads/main", first)
+
+	update, err := DescribeRefUpdate(ctx, repo.path, nil, "refs/heads/main", first, third)
+	require.NoError(t, err)
+	assert.False(t, update.Created)
+	assert.False(t, update.Forced)
+	assert.Equal(t, map[string]string{"README.md": "M", "old.txt": "D", "model.pkl": "D", "model.bin": "A"}, changedFiles(update))
+	for _, file := range update.ChangedFiles {
+		switch file.Path {
+		case "README.md":
+			if assert.NotNil(t, file.Content) {
+				assert.Equal(t, "license: apache-2.0\n", *file.Content)
+			}
+			assert.EqualValues(t, len("license: apache-2.0\n"), file.Size)
+		case "model.bin":
+			assert.Nil(t, file.Content)
+			assert.EqualValues(t, len(pointer("a", 42)), file.Size)
+		}
+	}
+	assert.Equal(t, []LFSObject{{Oid: strings.Repeat("a", 64), Size: 42}}, update.LFSObjects)
+
+	// a new branch only describes the commits no other ref has
+	update, err = DescribeRefUpdate(ctx, repo.path, nil, "refs/heads/feature", git.EmptySHA, second)
+	require.NoError(t, err)
+	assert.True(t, update.Created)
+	assert.Equal(t, map[string]string{"model.pkl": "A"}, changedFiles(update))
+
+	unrelated := repo.commit(map[string]string{"other.txt": "other"})
+	update, err = DescribeRefUpdate(ctx, repo.path, nil, "refs/heads/main", first, unrelated)
+	require.NoError(t, err)
+	assert.True(t, update.Forced)
+	assert.Equal(t, map[string]string{"other.txt": "A"}, changedFiles(update))
+
+	update, err = DescribeRefUpdate(ctx, repo.path, nil, "refs/heads/main", first, git.EmptySHA)
+	require.NoError(t, err)
+	assert.True(t, update.Deleted)
+	assert.Empty(t, update.ChangedFiles)
+	assert.Empty(t, update.LFSObjects)
+
+	setting.PushPolicy.MaxChangedFiles = 2
+	update, err = DescribeRefUpdate(ctx, repo.path, nil, "refs/heads/main", first, third)
+	require.NoError(t, err)
+	assert.True(t, update.Truncated)
+	assert.Len(t, update.ChangedFiles, 2)
+}
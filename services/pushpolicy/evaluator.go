@@ -0,0 +1,111 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushpolicy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"code.gitea.io/gitea/modules/json"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// Verdict is the decision of the policy evaluator
+type Verdict struct {
+	Allow bool `json:"allow"`
+	// Message is shown to the pusher, it should explain a denial
+	Message string `json:"message"`
+}
+
+// Evaluator decides whether a push is allowed
+type Evaluator interface {
+	Evaluate(ctx context.Context, desc *Description) (*Verdict, error)
+}
+
+func newEvaluator() Evaluator {
+	switch setting.PushPolicy.Evaluator {
+	case "command":
+		return &CommandEvaluator{Command: setting.PushPolicy.Command}
+	default:
+		return &HTTPEvaluator{URL: setting.PushPolicy.URL, Client: &http.Client{}}
+	}
+}
+
+// HTTPEvaluator asks an OPA compatible data API: the description is posted as {"input": ...}
+// and the verdict is read from the "result" of the response.
+type HTTPEvaluator struct {
+	URL    string
+	Client *http.Client
+}
+
+// Evaluate implements Evaluator
+func (e *HTTPEvaluator) Evaluate(ctx context.Context, desc *Description) (*Verdict, error) {
+	body, err := json.Marshal(map[string]any{"input": desc})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, msg)
+	}
+
+	var result struct {
+		Result *Verdict `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode verdict: %w", err)
+	}
+	if result.Result == nil {
+		// OPA omits the result if the rule is undefined
+		return nil, fmt.Errorf("the policy returned no result")
+	}
+	return result.Result, nil
+}
+
+// CommandEvaluator runs a local evaluator, for example `opa eval --stdin-input --format raw <query>`.
+// The description is written to its stdin and the verdict is read as JSON from its stdout.
+type CommandEvaluator struct {
+	Command []string
+}
+
+// Evaluate implements Evaluator
+func (e *CommandEvaluator) Evaluate(ctx context.Context, desc *Description) (*Verdict, error) {
+	input, err := json.Marshal(desc)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w - %s", e.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	verdict := &Verdict{}
+	if err := json.Unmarshal(stdout.Bytes(), verdict); err != nil {
+		return nil, fmt.Errorf("decode verdict: %w", err)
+	}
+	return verdict, nil
+}
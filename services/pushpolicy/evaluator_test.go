@@ -0,0 +1,103 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package pushpolicy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/json"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDescription = &Description{
+	Repository: Repository{ID: 1, OwnerName: "user2", Name: "repo1"},
+	Pusher:     Pusher{ID: 2, Name: "user2"},
+	RefUpdates: []RefUpdate{{Ref: "refs/heads/main", ChangedFiles: []ChangedFile{{Path: "model.pkl", Status: "A"}}}},
+}
+
+func TestHTTPEvaluator(t *testing.T) {
+	var response string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input *Description `json:"input"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, testDescription, body.Input)
+		if response == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, response)
+	}))
+	defer server.Close()
+	evaluator := &HTTPEvaluator{URL: server.URL, Client: server.Client()}
+
+	response = `{"result": {"allow": false, "message": "pickle files are not allowed"}}`
+	verdict, err := evaluator.Evaluate(context.Background(), testDescription)
+	require.NoError(t, err)
+	assert.Equal(t, &Verdict{Allow: false, Message: "pickle files are not allowed"}, verdict)
+
+	// an undefined rule is an error, not a denial
+	response = `{}`
+	_, err = evaluator.Evaluate(context.Background(), testDescription)
+	assert.Error(t, err)
+
+	response = ""
+	_, err = evaluator.Evaluate(context.Background(), testDescription)
+	assert.Error(t, err)
+}
+
+func TestCommandEvaluator(t *testing.T) {
+	// the evaluator allows the push if its description reaches the command
+	evaluator := &CommandEvaluator{Command: []string{"sh", "-c", `grep -q '"path":"model.pkl"' && echo '{"allow": true}'`}}
+	verdict, err := evaluator.Evaluate(context.Background(), testDescription)
+	require.NoError(t, err)
+	assert.True(t, verdict.Allow)
+
+	evaluator = &CommandEvaluator{Command: []string{"sh", "-c", "echo broken >&2; exit 1"}}
+	_, err = evaluator.Evaluate(context.Background(), testDescription)
+	assert.ErrorContains(t, err, "broken")
+}
+
+func TestCheck(t *testing.T) {
+	oldPushPolicy := setting.PushPolicy
+	defer func() {
+		setting.PushPolicy = oldPushPolicy
+	}()
+
+	setting.PushPolicy.Evaluator = "command"
+	setting.PushPolicy.Timeout = 10 * time.Second
+	repo := newTestRepo(t)
+	commitID := repo.commit(map[string]string{"README.md": "readme"})
+	updates := []RefUpdate{{Ref: "refs/heads/main", OldCommitID: git.EmptySHA, NewCommitID: commitID}}
+
+	setting.PushPolicy.Command = []string{"sh", "-c", `echo '{"allow": false, "message": "no license"}'`}
+	verdict := Check(context.Background(), Repository{}, Pusher{}, repo.path, nil, updates)
+	assert.Equal(t, &Verdict{Allow: false, Message: "no license"}, verdict)
+
+	setting.PushPolicy.Command = []string{"sh", "-c", "exit 1"}
+	verdict = Check(context.Background(), Repository{}, Pusher{}, repo.path, nil, updates)
+	assert.Equal(t, &Verdict{Allow: false, Message: unavailableMessage}, verdict)
+
+	setting.PushPolicy.FailOpen = true
+	verdict = Check(context.Background(), Repository{}, Pusher{}, repo.path, nil, updates)
+	assert.True(t, verdict.Allow)
+
+	// an evaluator which doesn't answer in time is a failure too
+	setting.PushPolicy.FailOpen = false
+	setting.PushPolicy.Timeout = 100 * time.Millisecond
+	setting.PushPolicy.Command = []string{"sleep", "10"}
+	verdict = Check(context.Background(), Repository{}, Pusher{}, repo.path, nil, updates)
+	assert.False(t, verdict.Allow)
+}
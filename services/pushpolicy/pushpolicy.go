@@ -0,0 +1,59 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package pushpolicy asks an external policy evaluator whether a push is allowed.
+//
+// It runs in pre-receive after the built-in checks, the evaluator gets a JSON description of the push
+// with the ref updates, the files changed by the pushed commits, the added LFS pointers and the pusher.
+package pushpolicy
+
+import (
+	"context"
+	"fmt"
+
+	"code.gitea.io/gitea/modules/log"
+
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// unavailableMessage is shown to the pusher if the evaluator failed and the policy fails closed
+const unavailableMessage = "the push policy could not be evaluated, please try again later"
+
+// Enabled returns whether pushes are checked by the policy evaluator
+func Enabled() bool {
+	return setting.PushPolicy.Enabled
+}
+
+// Check describes the ref updates of the push and evaluates the policy for it, only Ref, OldCommitID and NewCommitID
+// of the updates need to be set. Failures are turned into a verdict according to FAIL_OPEN.
+func Check(ctx context.Context, repo Repository, pusher Pusher, repoPath string, env []string, updates []RefUpdate) *Verdict {
+	ctx, cancel := context.WithTimeout(ctx, setting.PushPolicy.Timeout)
+	defer cancel()
+
+	verdict, err := check(ctx, repo, pusher, repoPath, env, updates)
+	if err != nil {
+		if setting.PushPolicy.FailOpen {
+			log.Warn("Unable to evaluate the push policy for %s/%s, allowing the push: %v", repo.OwnerName, repo.Name, err)
+			return &Verdict{Allow: true}
+		}
+		log.Error("Unable to evaluate the push policy for %s/%s, denying the push: %v", repo.OwnerName, repo.Name, err)
+		return &Verdict{Allow: false, Message: unavailableMessage}
+	}
+	return verdict
+}
+
+func check(ctx context.Context, repo Repository, pusher Pusher, repoPath string, env []string, updates []RefUpdate) (*Verdict, error) {
+	desc := &Description{
+		Repository: repo,
+		Pusher:     pusher,
+		RefUpdates: make([]RefUpdate, 0, len(updates)),
+	}
+	for _, update := range updates {
+		described, err := DescribeRefUpdate(ctx, repoPath, env, update.Ref, update.OldCommitID, update.NewCommitID)
+		if err != nil {
+			return nil, fmt.Errorf("describe %s: %w", update.Ref, err)
+		}
+		desc.RefUpdates = append(desc.RefUpdates, *described)
+	}
+	return newEvaluator().Evaluate(ctx, desc)
+}
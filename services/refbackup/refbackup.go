@@ -0,0 +1,207 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package refbackup keeps the branch tips overwritten by force pushes under hidden backup refs,
+// so that history lost on unprotected branches can be restored.
+package refbackup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.gitea.io/gitea/models/db"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/graceful"
+	"code.gitea.io/gitea/modules/log"
+
+	repo_module "github.com/openmerlin/gitea_data/modules/repository"
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// Backup is a branch tip overwritten by a force push
+type Backup struct {
+	Ref      string    `json:"ref"`
+	Branch   string    `json:"branch"`
+	CommitID string    `json:"commit_id"`
+	Created  time.Time `json:"created"`
+}
+
+// Enabled returns whether force pushes are backed up
+func Enabled() bool {
+	return setting.ForcePushBackup.Enabled
+}
+
+// RefName returns the name of the backup ref of branch created at t
+func RefName(branch string, t time.Time) string {
+	return repo_module.BackupRefPrefix + branch + "/" + strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// parseRefName splits a backup ref into the branch and the time it was created
+func parseRefName(ref string) (branch string, created time.Time, ok bool) {
+	name, found := strings.CutPrefix(ref, repo_module.BackupRefPrefix)
+	if !found {
+		return "", time.Time{}, false
+	}
+	idx := strings.LastIndexByte(name, '/')
+	if idx <= 0 {
+		return "", time.Time{}, false
+	}
+	nano, err := strconv.ParseInt(name[idx+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return name[:idx], time.Unix(0, nano), true
+}
+
+// IsForced returns whether the update of a ref from oldCommitID to newCommitID overwrites history
+func IsForced(ctx context.Context, repoPath, oldCommitID, newCommitID string) (bool, error) {
+	if oldCommitID == git.EmptySHA || newCommitID == git.EmptySHA {
+		return false, nil
+	}
+	_, _, err := git.NewCommand(ctx, "merge-base", "--is-ancestor").AddDynamicArguments(oldCommitID, newCommitID).RunStdString(&git.RunOpts{Dir: repoPath})
+	switch {
+	case err == nil:
+		return false, nil
+	case err.IsExitCode(1):
+		return true, nil
+	default:
+		return false, fmt.Errorf("merge-base --is-ancestor: %w", err)
+	}
+}
+
+// BackupForcePush stores oldCommitID under a backup ref if updating branch to newCommitID isn't a fast-forward.
+// It returns the backup ref, or an empty string if the update didn't need a backup.
+func BackupForcePush(ctx context.Context, repoPath, branch, oldCommitID, newCommitID string) (string, error) {
+	forced, err := IsForced(ctx, repoPath, oldCommitID, newCommitID)
+	if err != nil || !forced {
+		return "", err
+	}
+	ref := RefName(branch, time.Now())
+	// the empty old value makes sure an existing backup is never overwritten
+	if _, _, err := git.NewCommand(ctx, "update-ref", "-m", "force push backup").AddDynamicArguments(ref, oldCommitID, "").RunStdString(&git.RunOpts{Dir: repoPath}); err != nil {
+		return "", fmt.Errorf("update-ref %s: %w", ref, err)
+	}
+	return ref, nil
+}
+
+// List returns the backups of branch, or of all branches if branch is empty, newest first
+func List(ctx context.Context, repoPath, branch string) ([]*Backup, error) {
+	pattern := repo_module.BackupRefPrefix
+	if branch != "" {
+		pattern += branch + "/"
+	}
+	stdout, _, err := git.NewCommand(ctx, "for-each-ref", "--format=%(objectname) %(refname)").AddDynamicArguments(pattern).RunStdString(&git.RunOpts{Dir: repoPath})
+	if err != nil {
+		return nil, fmt.Errorf("for-each-ref: %w", err)
+	}
+
+	backups := make([]*Backup, 0, 10)
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		commitID, ref, found := strings.Cut(line, " ")
+		if !found {
+			continue
+		}
+		refBranch, created, ok := parseRefName(ref)
+		// the pattern of a branch also matches the backups of the branches below it
+		if !ok || (branch != "" && refBranch != branch) {
+			continue
+		}
+		backups = append(backups, &Backup{Ref: ref, Branch: refBranch, CommitID: commitID, Created: created})
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Created.After(backups[j].Created)
+	})
+	return backups, nil
+}
+
+// Get returns the backup stored under ref
+func Get(ctx context.Context, repoPath, ref string) (*Backup, error) {
+	branch, _, ok := parseRefName(ref)
+	if !ok {
+		return nil, ErrBackupNotExist{Ref: ref}
+	}
+	backups, err := List(ctx, repoPath, branch)
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range backups {
+		if backup.Ref == ref {
+			return backup, nil
+		}
+	}
+	return nil, ErrBackupNotExist{Ref: ref}
+}
+
+// Prune deletes the backups which are older than MAX_AGE or exceed MAX_PER_BRANCH
+func Prune(ctx context.Context, repoPath string) error {
+	backups, err := List(ctx, repoPath, "")
+	if err != nil {
+		return err
+	}
+
+	var stdin bytes.Buffer
+	kept := make(map[string]int)
+	for _, backup := range backups {
+		expired := setting.ForcePushBackup.MaxAge > 0 && time.Since(backup.Created) > setting.ForcePushBackup.MaxAge
+		excess := setting.ForcePushBackup.MaxPerBranch > 0 && kept[backup.Branch] >= setting.ForcePushBackup.MaxPerBranch
+		if expired || excess {
+			fmt.Fprintf(&stdin, "delete %s %s\n", backup.Ref, backup.CommitID)
+			continue
+		}
+		kept[backup.Branch]++
+	}
+	if stdin.Len() == 0 {
+		return nil
+	}
+
+	var stderr bytes.Buffer
+	if err := git.NewCommand(ctx, "update-ref", "--stdin").Run(&git.RunOpts{Dir: repoPath, Stdin: &stdin, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("update-ref --stdin: %w - %s", err, stderr.String())
+	}
+	log.Trace("Pruned force push backups in %s", repoPath)
+	return nil
+}
+
+// PruneAll prunes the backups of all repositories, a failing repository doesn't stop the others
+func PruneAll(ctx context.Context) error {
+	return db.Iterate(ctx, nil, func(ctx context.Context, repo *repo_model.Repository) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := Prune(ctx, repo.RepoPath()); err != nil {
+			log.Error("Unable to prune the force push backups of %s: %v", repo.FullName(), err)
+		}
+		return nil
+	})
+}
+
+// InitPrune starts pruning the backups of all repositories every ForcePushBackup.PruneInterval,
+// so that MAX_AGE applies to the repositories which aren't force pushed to anymore
+func InitPrune() error {
+	if !setting.ForcePushBackup.Enabled {
+		return nil
+	}
+	go graceful.GetManager().RunWithShutdownContext(func(ctx context.Context) {
+		ticker := time.NewTicker(setting.ForcePushBackup.PruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := PruneAll(ctx); err != nil && ctx.Err() == nil {
+					log.Error("Unable to prune the force push backups of all repositories: %v", err)
+				}
+			}
+		}
+	})
+	return nil
+}
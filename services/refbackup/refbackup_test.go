@@ -0,0 +1,186 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package refbackup
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/models/db"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/git"
+	repo_module "code.gitea.io/gitea/modules/repository"
+	"code.gitea.io/gitea/modules/test"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	"github.com/openmerlin/gitea_data/modules/setting"
+	repo_service "github.com/openmerlin/gitea_data/services/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runGit(t *testing.T, repoPath string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", repoPath}, args...)...)
+	out, err := cmd.Output()
+	require.NoError(t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
+
+// orphanCommit creates a commit which no branch of the repository contains
+func orphanCommit(t *testing.T, repoPath, message string) string {
+	emptyTree := runGit(t, repoPath, "hash-object", "-t", "tree", "-w", "/dev/null")
+	return runGit(t, repoPath, "commit-tree", emptyTree, "-m", message)
+}
+
+func backupRefs(t *testing.T, repoPath, branch string) []string {
+	backups, err := List(db.DefaultContext, repoPath, branch)
+	require.NoError(t, err)
+	refs := make([]string, 0, len(backups))
+	for _, backup := range backups {
+		refs = append(refs, backup.Ref)
+	}
+	return refs
+}
+
+func TestParseRefName(t *testing.T) {
+	created := time.Unix(0, 1700000000123456789)
+	branch, parsed, ok := parseRefName(RefName("feature/x", created))
+	assert.True(t, ok)
+	assert.Equal(t, "feature/x", branch)
+	assert.True(t, created.Equal(parsed))
+
+	for _, ref := range []string{"refs/heads/main", "refs/gitea-backup/main", "refs/gitea-backup/main/now", "refs/gitea-backup//1"} {
+		_, _, ok := parseRefName(ref)
+		assert.False(t, ok, ref)
+	}
+}
+
+func TestBackupForcePush(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	repoPath := repo.RepoPath()
+	master := runGit(t, repoPath, "rev-parse", "refs/heads/master")
+	orphan := orphanCommit(t, repoPath, "rewritten")
+	child := runGit(t, repoPath, "commit-tree", runGit(t, repoPath, "rev-parse", orphan+"^{tree}"), "-p", orphan, "-m", "child")
+
+	// fast-forwards, creations and deletions don't need a backup
+	for _, update := range [][2]string{{orphan, child}, {git.EmptySHA, master}, {master, git.EmptySHA}} {
+		ref, err := BackupForcePush(db.DefaultContext, repoPath, "master", update[0], update[1])
+		assert.NoError(t, err)
+		assert.Empty(t, ref)
+	}
+
+	ref, err := BackupForcePush(db.DefaultContext, repoPath, "master", master, orphan)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ref}, backupRefs(t, repoPath, "master"))
+	backup, err := Get(db.DefaultContext, repoPath, ref)
+	require.NoError(t, err)
+	assert.Equal(t, &Backup{Ref: ref, Branch: "master", CommitID: master, Created: backup.Created}, backup)
+
+	// the backups of master/x aren't the ones of master
+	_, err = BackupForcePush(db.DefaultContext, repoPath, "master/x", master, orphan)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ref}, backupRefs(t, repoPath, "master"))
+	assert.Len(t, backupRefs(t, repoPath, ""), 2)
+
+	_, err = Get(db.DefaultContext, repoPath, RefName("master", time.Now()))
+	assert.True(t, IsErrBackupNotExist(err))
+	_, err = Get(db.DefaultContext, repoPath, "refs/heads/master")
+	assert.True(t, IsErrBackupNotExist(err))
+}
+
+func TestPrune(t *testing.T) {
+	defer func(maxAge time.Duration, maxPerBranch int) {
+		setting.ForcePushBackup.MaxAge, setting.ForcePushBackup.MaxPerBranch = maxAge, maxPerBranch
+	}(setting.ForcePushBackup.MaxAge, setting.ForcePushBackup.MaxPerBranch)
+	setting.ForcePushBackup.MaxAge = time.Hour
+	setting.ForcePushBackup.MaxPerBranch = 2
+
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	repoPath := repo.RepoPath()
+	commitID := orphanCommit(t, repoPath, "pruned")
+	now := time.Now()
+	refs := []string{
+		RefName("prune-a", now.Add(-3*time.Minute)),
+		RefName("prune-a", now.Add(-2*time.Minute)),
+		RefName("prune-a", now.Add(-time.Minute)),
+		RefName("prune-b", now.Add(-2*time.Hour)),
+		RefName("prune-c", now.Add(-time.Minute)),
+	}
+	for _, ref := range refs {
+		runGit(t, repoPath, "update-ref", ref, commitID)
+	}
+
+	// repositories which aren't force pushed to anymore are pruned by PruneAll
+	assert.NoError(t, PruneAll(db.DefaultContext))
+	assert.Equal(t, []string{refs[2], refs[1]}, backupRefs(t, repoPath, "prune-a"))
+	assert.Empty(t, backupRefs(t, repoPath, "prune-b"))
+	assert.Equal(t, []string{refs[4]}, backupRefs(t, repoPath, "prune-c"))
+}
+
+func TestRestore(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	doer := unittest.AssertExistsAndLoadBean(t, &user_model.User{ID: 2})
+	repoPath := repo.RepoPath()
+	master := runGit(t, repoPath, "rev-parse", "refs/heads/master")
+	orphan := orphanCommit(t, repoPath, "restored")
+	// the backup keeps a tip which master was force pushed over
+	ref, err := BackupForcePush(db.DefaultContext, repoPath, "master", orphan, master)
+	require.NoError(t, err)
+
+	_, err = Restore(db.DefaultContext, doer, repo, RefName("master", time.Now()), "")
+	assert.True(t, IsErrBackupNotExist(err))
+
+	// a branch which is at the backup already is left alone
+	runGit(t, repoPath, "update-ref", "refs/heads/restore-same", orphan)
+	restored, err := Restore(db.DefaultContext, doer, repo, ref, "restore-same")
+	require.NoError(t, err)
+	assert.Equal(t, &Restored{Branch: "restore-same", OldCommitID: orphan, NewCommitID: orphan}, restored)
+
+	// protected branches can only be restored by those who may push to them
+	require.NoError(t, db.Insert(db.DefaultContext, &git_model.ProtectedBranch{RepoID: repo.ID, RuleName: "master"}))
+	_, err = Restore(db.DefaultContext, doer, repo, ref, "")
+	assert.True(t, repo_service.IsErrBranchProtected(err), "%v", err)
+	assert.Equal(t, master, runGit(t, repoPath, "rev-parse", "refs/heads/master"))
+
+	// and only if restoring is a fast-forward, as force pushes are refused
+	_, err = db.GetEngine(db.DefaultContext).Where("repo_id = ? AND branch_name = ?", repo.ID, "master").Cols("can_push").Update(&git_model.ProtectedBranch{CanPush: true})
+	require.NoError(t, err)
+	_, err = Restore(db.DefaultContext, doer, repo, ref, "")
+	assert.True(t, IsErrNotFastForward(err), "%v", err)
+	assert.Equal(t, master, runGit(t, repoPath, "rev-parse", "refs/heads/master"))
+
+	// the restore is told about like a push
+	var updates []*repo_module.PushUpdateOptions
+	defer test.MockVariableValue(&pushUpdates, func(opts []*repo_module.PushUpdateOptions) error {
+		updates = append(updates, opts...)
+		return nil
+	})()
+	type notified struct{ ref, oldCommitID, newCommitID string }
+	var notifications []notified
+	defer test.MockVariableValue(&notifyRestored, func(_ context.Context, _ *user_model.User, _ *repo_model.Repository, ref, oldCommitID, newCommitID string) {
+		notifications = append(notifications, notified{ref, oldCommitID, newCommitID})
+	})()
+	child := runGit(t, repoPath, "commit-tree", master+"^{tree}", "-p", master, "-m", "descendant")
+	ref, err = BackupForcePush(db.DefaultContext, repoPath, "master", child, master)
+	require.NoError(t, err)
+	t.Cleanup(func() { runGit(t, repoPath, "update-ref", "refs/heads/master", master) })
+	restored, err = Restore(db.DefaultContext, doer, repo, ref, "")
+	require.NoError(t, err)
+	assert.Equal(t, child, restored.NewCommitID)
+	assert.Equal(t, child, runGit(t, repoPath, "rev-parse", "refs/heads/master"))
+	if assert.Len(t, updates, 1) {
+		assert.Equal(t, master, updates[0].OldCommitID)
+		assert.Equal(t, child, updates[0].NewCommitID)
+	}
+	assert.Equal(t, []notified{{"refs/heads/master", master, child}}, notifications)
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package refbackup
+
+import (
+	"context"
+	"fmt"
+
+	repo_model "code.gitea.io/gitea/models/repo"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/log"
+	repo_module "code.gitea.io/gitea/modules/repository"
+	repo_service "code.gitea.io/gitea/services/repository"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	"github.com/openmerlin/gitea_data/modules/packcache"
+	"github.com/openmerlin/gitea_data/modules/setting"
+	"github.com/openmerlin/gitea_data/services/downstream"
+	"github.com/openmerlin/gitea_data/services/dumbhttp"
+	"github.com/openmerlin/gitea_data/services/pushevent"
+	data_repo_service "github.com/openmerlin/gitea_data/services/repository"
+)
+
+// ErrBackupNotExist represents a "BackupNotExist" kind of error.
+type ErrBackupNotExist struct {
+	Ref string
+}
+
+func (err ErrBackupNotExist) Error() string {
+	return fmt.Sprintf("force push backup does not exist [ref: %s]", err.Ref)
+}
+
+// IsErrBackupNotExist checks if an error is a ErrBackupNotExist
+func IsErrBackupNotExist(err error) bool {
+	_, ok := err.(ErrBackupNotExist)
+	return ok
+}
+
+// ErrNotFastForward means a restore would rewrite the history of a protected branch,
+// which refuses force pushes from everyone
+type ErrNotFastForward struct {
+	Branch   string
+	RuleName string
+}
+
+func (err ErrNotFastForward) Error() string {
+	return fmt.Sprintf("restoring branch %s isn't a fast-forward, which its protection rule %s forbids", err.Branch, err.RuleName)
+}
+
+// IsErrNotFastForward checks if an error is a ErrNotFastForward
+func IsErrNotFastForward(err error) bool {
+	_, ok := err.(ErrNotFastForward)
+	return ok
+}
+
+// pushUpdates queues the update of a restore like the ones of a push
+var pushUpdates = repo_service.PushUpdates
+
+// Restored is the result of restoring a backup
+type Restored struct {
+	Branch      string `json:"branch"`
+	OldCommitID string `json:"old_commit_id"`
+	NewCommitID string `json:"new_commit_id"`
+	// Backup is the ref the replaced tip was stored under, if restoring overwrote history
+	Backup string `json:"backup,omitempty"`
+}
+
+// Restore resets branch to the commit of the backup stored under ref, the branch the backup was taken of
+// is used if branch is empty. The doer must be allowed to push to the branch if it's protected, and
+// the backup must be a descendant of its tip as protected branches can't be force pushed.
+// The replaced tip is backed up itself so that restoring can be undone.
+func Restore(ctx context.Context, doer *user_model.User, repo *repo_model.Repository, ref, branch string) (*Restored, error) {
+	repoPath := repo.RepoPath()
+	backup, err := Get(ctx, repoPath, ref)
+	if err != nil {
+		return nil, err
+	}
+	if branch == "" {
+		branch = backup.Branch
+	}
+	rule, err := git_model.GetFirstMatchProtectedBranchRule(ctx, repo.ID, branch)
+	if err != nil {
+		return nil, err
+	}
+	if rule != nil && !rule.CanUserPush(ctx, doer) {
+		return nil, data_repo_service.ErrBranchProtected{Branch: branch, RuleName: rule.RuleName}
+	}
+	refName := git.RefNameFromBranch(branch)
+
+	oldCommitID := git.EmptySHA
+	stdout, _, runErr := git.NewCommand(ctx, "rev-parse", "--verify", "--quiet").AddDynamicArguments(refName.String()).RunStdString(&git.RunOpts{Dir: repoPath})
+	if runErr == nil {
+		oldCommitID = stdout[:len(stdout)-1]
+	} else if !runErr.IsExitCode(1) {
+		return nil, fmt.Errorf("rev-parse %s: %w", refName, runErr)
+	}
+
+	restored := &Restored{Branch: branch, OldCommitID: oldCommitID, NewCommitID: backup.CommitID}
+	if oldCommitID == backup.CommitID {
+		return restored, nil
+	}
+	if rule != nil && oldCommitID != git.EmptySHA {
+		_, _, runErr := git.NewCommand(ctx, "merge-base", "--is-ancestor").AddDynamicArguments(oldCommitID, backup.CommitID).RunStdString(&git.RunOpts{Dir: repoPath})
+		if runErr != nil {
+			if runErr.IsExitCode(1) {
+				return nil, ErrNotFastForward{Branch: branch, RuleName: rule.RuleName}
+			}
+			return nil, fmt.Errorf("merge-base %s %s: %w", oldCommitID, backup.CommitID, runErr)
+		}
+	}
+	if restored.Backup, err = BackupForcePush(ctx, repoPath, branch, oldCommitID, backup.CommitID); err != nil {
+		return nil, err
+	}
+
+	// the old value makes the update fail if the branch has been pushed to in the meantime
+	if _, _, err := git.NewCommand(ctx, "update-ref", "-m", "restore force push backup").AddDynamicArguments(refName.String(), backup.CommitID, oldCommitID).RunStdString(&git.RunOpts{Dir: repoPath}); err != nil {
+		return nil, fmt.Errorf("update-ref %s: %w", refName, err)
+	}
+	// no hooks run for the update, which would have invalidated the cached packs
+	packcache.Invalidate(repo.ID)
+
+	if err := pushUpdates([]*repo_module.PushUpdateOptions{{
+		PusherID:     doer.ID,
+		PusherName:   doer.Name,
+		RepoUserName: repo.OwnerName,
+		RepoName:     repo.Name,
+		RefFullName:  refName,
+		OldCommitID:  oldCommitID,
+		NewCommitID:  backup.CommitID,
+	}}); err != nil {
+		return nil, fmt.Errorf("push updates: %w", err)
+	}
+	notifyRestored(ctx, doer, repo, refName.String(), oldCommitID, backup.CommitID)
+
+	if err := Prune(ctx, repoPath); err != nil {
+		log.Error("Unable to prune the force push backups of %s: %v", repo.FullName(), err)
+	}
+	return restored, nil
+}
+
+// notifyRestored queues what post-receive queues for a push, as no hooks run for the update of a restore
+var notifyRestored = func(ctx context.Context, doer *user_model.User, repo *repo_model.Repository, ref, oldCommitID, newCommitID string) {
+	if dumbhttp.IsPublisher() {
+		dumbhttp.AddToPublishQueue(&dumbhttp.PublishRequest{
+			RepoID:    repo.ID,
+			OwnerName: repo.OwnerName,
+			RepoName:  repo.Name,
+		})
+	}
+
+	if setting.PushEvents.Enabled {
+		target := pushevent.Repository{
+			ID:        repo.ID,
+			OwnerName: repo.OwnerName,
+			Name:      repo.Name,
+			Path:      repo.RepoPath(),
+		}
+		pushevent.Notify(ctx, target, doer.ID, doer.Name, []pushevent.RefUpdate{{Ref: ref, OldCommitID: oldCommitID, NewCommitID: newCommitID}})
+	}
+
+	if downstream.Enabled() {
+		downstream.Notify(ctx, repo, []downstream.RefUpdate{{Ref: ref, OldCommitID: oldCommitID, NewCommitID: newCommitID}})
+	}
+}
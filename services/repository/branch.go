@@ -0,0 +1,450 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.gitea.io/gitea/models/db"
+	repo_model "code.gitea.io/gitea/models/repo"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/git"
+	"code.gitea.io/gitea/modules/git/foreachref"
+	"code.gitea.io/gitea/modules/graceful"
+	"code.gitea.io/gitea/modules/log"
+	repo_module "code.gitea.io/gitea/modules/repository"
+	"code.gitea.io/gitea/modules/timeutil"
+	"code.gitea.io/gitea/modules/util"
+	repo_service "code.gitea.io/gitea/services/repository"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	"github.com/openmerlin/gitea_data/modules/packcache"
+	data_repo_module "github.com/openmerlin/gitea_data/modules/repository"
+	"github.com/openmerlin/gitea_data/modules/setting"
+)
+
+// pushUpdates queues the ref updates made on behalf of the doer like the ones of a push
+var pushUpdates = repo_service.PushUpdates
+
+// ErrBranchCommitNotExist means the commit of a deleted branch has been garbage collected
+type ErrBranchCommitNotExist struct {
+	Branch   string
+	CommitID string
+}
+
+func (err ErrBranchCommitNotExist) Error() string {
+	return fmt.Sprintf("commit %s of deleted branch %s does not exist anymore", err.CommitID, err.Branch)
+}
+
+// IsErrBranchCommitNotExist checks if an error is a ErrBranchCommitNotExist
+func IsErrBranchCommitNotExist(err error) bool {
+	_, ok := err.(ErrBranchCommitNotExist)
+	return ok
+}
+
+// ErrBranchProtected means the doer isn't allowed to push to a protected branch
+type ErrBranchProtected struct {
+	Branch   string
+	RuleName string
+}
+
+func (err ErrBranchProtected) Error() string {
+	return fmt.Sprintf("branch %s is protected by rule %s", err.Branch, err.RuleName)
+}
+
+// IsErrBranchProtected checks if an error is a ErrBranchProtected
+func IsErrBranchProtected(err error) bool {
+	_, ok := err.(ErrBranchProtected)
+	return ok
+}
+
+// ErrInvalidBranchName means a name can't be used for a branch
+type ErrInvalidBranchName struct {
+	BranchName string
+}
+
+func (err ErrInvalidBranchName) Error() string {
+	return fmt.Sprintf("%q is not a valid branch name", err.BranchName)
+}
+
+// IsErrInvalidBranchName checks if an error is a ErrInvalidBranchName
+func IsErrInvalidBranchName(err error) bool {
+	_, ok := err.(ErrInvalidBranchName)
+	return ok
+}
+
+// BranchSyncResult tells how a sync changed the branch table
+type BranchSyncResult struct {
+	Total   int `json:"total"`
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+}
+
+// listBranchesOnDisk returns the branches of the repository by name
+func listBranchesOnDisk(ctx context.Context, repoPath string) (map[string]*git_model.Branch, error) {
+	format := foreachref.NewFormat("refname:lstrip=2", "objectname", "committerdate:unix", "contents:subject")
+	stdout := &bytes.Buffer{}
+	if err := git.NewCommand(ctx, "for-each-ref").
+		AddOptionFormat("--format=%s", format.Flag()).
+		AddArguments(git.BranchPrefix).
+		Run(&git.RunOpts{Dir: repoPath, Stdout: stdout}); err != nil {
+		return nil, fmt.Errorf("for-each-ref: %w", err)
+	}
+
+	branches := make(map[string]*git_model.Branch)
+	parser := format.Parser(stdout)
+	for {
+		ref := parser.Next()
+		if ref == nil {
+			break
+		}
+		commitTime, _ := strconv.ParseInt(ref["committerdate:unix"], 10, 64)
+		branches[ref["refname:lstrip=2"]] = &git_model.Branch{
+			Name:          ref["refname:lstrip=2"],
+			CommitID:      ref["objectname"],
+			CommitMessage: ref["contents:subject"],
+			CommitTime:    timeutil.TimeStamp(commitTime),
+		}
+	}
+	if err := parser.Err(); err != nil {
+		return nil, fmt.Errorf("parse for-each-ref: %w", err)
+	}
+	return branches, nil
+}
+
+// SyncBranches reconciles the branch table of the repository with its branches on disk. Missing branches
+// are added as pushed by the doer and changed ones updated, branches gone from disk are marked deleted by
+// the doer and kept alive. Keep-alive refs of branches which aren't deleted anymore are pruned.
+func SyncBranches(ctx context.Context, repo *repo_model.Repository, doerID int64) (*BranchSyncResult, error) {
+	// The table is read before the disk. A push updates the disk before the table, so the rows a concurrent
+	// push changes don't match what was read anymore and are left to the push.
+	inDB, err := git_model.FindBranches(ctx, git_model.FindBranchOptions{
+		ListOptions: db.ListOptions{ListAll: true},
+		RepoID:      repo.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	onDisk, err := listBranchesOnDisk(ctx, repo.RepoPath())
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BranchSyncResult{Total: len(onDisk)}
+	var deleted []*git_model.Branch
+	err = db.WithTx(ctx, func(ctx context.Context) error {
+		for _, dbBranch := range inDB {
+			branch, ok := onDisk[dbBranch.Name]
+			if !ok {
+				if dbBranch.IsDeleted {
+					continue
+				}
+				marked, err := git_model.MarkBranchDeletedIfUnchanged(ctx, dbBranch, doerID)
+				if err != nil {
+					return err
+				}
+				if marked {
+					deleted = append(deleted, dbBranch)
+				}
+				continue
+			}
+			delete(onDisk, dbBranch.Name)
+			if dbBranch.CommitID == branch.CommitID && !dbBranch.IsDeleted {
+				continue
+			}
+			updated, err := git_model.UpdateBranchIfUnchanged(ctx, dbBranch, branch)
+			if err != nil {
+				return err
+			}
+			if updated {
+				result.Updated++
+			}
+		}
+
+		toAdd := make([]*git_model.Branch, 0, len(onDisk))
+		for _, branch := range onDisk {
+			// a concurrent push may have added the branch since the table was read
+			if _, err := git_model.GetBranch(ctx, repo.ID, branch.Name); err == nil {
+				continue
+			} else if !git_model.IsErrBranchNotExist(err) {
+				return err
+			}
+			branch.RepoID = repo.ID
+			branch.PusherID = doerID
+			toAdd = append(toAdd, branch)
+		}
+		result.Added = len(toAdd)
+		return git_model.AddBranches(ctx, toAdd)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Deleted = len(deleted)
+
+	for _, branch := range deleted {
+		if err := KeepDeletedBranch(ctx, repo, branch.Name, branch.CommitID); err != nil {
+			log.Warn("Unable to keep the deleted branch %s of %s alive: %v", branch.Name, repo.FullName(), err)
+		}
+	}
+	if err := pruneKeepAliveRefs(ctx, repo); err != nil {
+		log.Error("Unable to prune the keep-alive refs of %s: %v", repo.FullName(), err)
+	}
+
+	if result.Added > 0 || result.Updated > 0 || result.Deleted > 0 {
+		// the refs have been changed without the hooks, which would have invalidated the cached packs
+		packcache.Invalidate(repo.ID)
+		log.Info("Synced the branches of %s: %d added, %d updated, %d deleted", repo.FullName(), result.Added, result.Updated, result.Deleted)
+	}
+	return result, nil
+}
+
+// SyncAllBranches syncs the branch tables of all repositories, a failing repository doesn't stop the others
+func SyncAllBranches(ctx context.Context) error {
+	return db.Iterate(ctx, nil, func(ctx context.Context, repo *repo_model.Repository) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if _, err := SyncBranches(ctx, repo, user_model.NewGhostUser().ID); err != nil {
+			log.Error("Unable to sync the branches of %s: %v", repo.FullName(), err)
+		}
+		return nil
+	})
+}
+
+// InitBranchSync starts syncing the branch tables of all repositories every BranchSync.Interval
+func InitBranchSync() error {
+	if !setting.BranchSync.Enabled {
+		return nil
+	}
+	go graceful.GetManager().RunWithShutdownContext(func(ctx context.Context) {
+		ticker := time.NewTicker(setting.BranchSync.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := SyncAllBranches(ctx); err != nil && ctx.Err() == nil {
+					log.Error("Unable to sync the branches of all repositories: %v", err)
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// PruneAllKeepAliveRefs prunes the keep-alive refs of all repositories, a failing repository doesn't stop the others
+func PruneAllKeepAliveRefs(ctx context.Context) error {
+	return db.Iterate(ctx, nil, func(ctx context.Context, repo *repo_model.Repository) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := pruneKeepAliveRefs(ctx, repo); err != nil {
+			log.Error("Unable to prune the keep-alive refs of %s: %v", repo.FullName(), err)
+		}
+		return nil
+	})
+}
+
+// InitKeepAlivePrune starts pruning the keep-alive refs of all repositories every DeletedBranch.KeepAlivePruneInterval,
+// which removes the refs of the deleted branches cleaned up from the branch table
+func InitKeepAlivePrune() error {
+	go graceful.GetManager().RunWithShutdownContext(func(ctx context.Context) {
+		ticker := time.NewTicker(setting.DeletedBranch.KeepAlivePruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := PruneAllKeepAliveRefs(ctx); err != nil && ctx.Err() == nil {
+					log.Error("Unable to prune the keep-alive refs of all repositories: %v", err)
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// KeepDeletedBranch stores the tip of a deleted branch under its keep-alive ref, so that the branch can be
+// restored even after the commit became unreachable. Branches without a row in the branch table are skipped.
+func KeepDeletedBranch(ctx context.Context, repo *repo_model.Repository, branchName, commitID string) error {
+	branch, err := git_model.GetBranch(ctx, repo.ID, branchName)
+	if err != nil {
+		if git_model.IsErrBranchNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	ref := data_repo_module.KeepAliveRefName(branch.ID)
+	if _, _, err := git.NewCommand(ctx, "update-ref", "-m", "keep deleted branch").AddDynamicArguments(ref, commitID).RunStdString(&git.RunOpts{Dir: repo.RepoPath()}); err != nil {
+		return fmt.Errorf("update-ref %s: %w", ref, err)
+	}
+	return nil
+}
+
+// pruneKeepAliveRefs deletes the keep-alive refs of the repository whose branch is no longer deleted,
+// or no longer in the branch table
+func pruneKeepAliveRefs(ctx context.Context, repo *repo_model.Repository) error {
+	deleted, err := git_model.FindBranches(ctx, git_model.FindBranchOptions{
+		ListOptions:     db.ListOptions{ListAll: true},
+		RepoID:          repo.ID,
+		IsDeletedBranch: util.OptionalBoolTrue,
+	})
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]bool, len(deleted))
+	for _, branch := range deleted {
+		kept[data_repo_module.KeepAliveRefName(branch.ID)] = true
+	}
+
+	repoPath := repo.RepoPath()
+	stdout, _, err := git.NewCommand(ctx, "for-each-ref", "--format=%(objectname) %(refname)").AddDynamicArguments(data_repo_module.KeepAliveRefPrefix).RunStdString(&git.RunOpts{Dir: repoPath})
+	if err != nil {
+		return fmt.Errorf("for-each-ref: %w", err)
+	}
+	var stdin bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		commitID, ref, found := strings.Cut(line, " ")
+		if found && !kept[ref] {
+			fmt.Fprintf(&stdin, "delete %s %s\n", ref, commitID)
+		}
+	}
+	if stdin.Len() == 0 {
+		return nil
+	}
+	var stderr bytes.Buffer
+	if err := git.NewCommand(ctx, "update-ref", "--stdin").Run(&git.RunOpts{Dir: repoPath, Stdin: &stdin, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("update-ref --stdin: %w - %s", err, stderr.String())
+	}
+	return nil
+}
+
+// checkCanPushBranch returns ErrBranchProtected if the branch is protected and the doer isn't allowed to push to it
+func checkCanPushBranch(ctx context.Context, doer *user_model.User, repo *repo_model.Repository, branchName string) error {
+	rule, err := git_model.GetFirstMatchProtectedBranchRule(ctx, repo.ID, branchName)
+	if err != nil {
+		return err
+	}
+	if rule != nil && !rule.CanUserPush(ctx, doer) {
+		return ErrBranchProtected{Branch: branchName, RuleName: rule.RuleName}
+	}
+	return nil
+}
+
+// restorableCommit returns the commit a deleted branch can be restored to: the commit of the branch table
+// if it still exists, otherwise the tip kept under the keep-alive ref of the branch
+func restorableCommit(ctx context.Context, repoPath string, branch *git_model.Branch) (string, error) {
+	if _, _, err := git.NewCommand(ctx, "cat-file", "-e").AddDynamicArguments(branch.CommitID + "^{commit}").RunStdString(&git.RunOpts{Dir: repoPath}); err == nil {
+		return branch.CommitID, nil
+	}
+	stdout, _, err := git.NewCommand(ctx, "rev-parse", "--verify", "--quiet").AddDynamicArguments(data_repo_module.KeepAliveRefName(branch.ID) + "^{commit}").RunStdString(&git.RunOpts{Dir: repoPath})
+	if err != nil {
+		return "", ErrBranchCommitNotExist{Branch: branch.Name, CommitID: branch.CommitID}
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// RestoreBranch recreates a deleted branch as the doer, who must be allowed to push to it if it's protected.
+// The branch is restored at its last commit, or at the tip kept alive when it was deleted if the commit is gone.
+func RestoreBranch(ctx context.Context, doer *user_model.User, repo *repo_model.Repository, branchID int64) (*git_model.Branch, error) {
+	branch, err := git_model.GetDeletedBranchByID(ctx, repo.ID, branchID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanPushBranch(ctx, doer, repo, branch.Name); err != nil {
+		return nil, err
+	}
+
+	repoPath := repo.RepoPath()
+	commitID, err := restorableCommit(ctx, repoPath, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	// the empty old value makes the update fail if the branch has been created in the meantime
+	refName := git.RefNameFromBranch(branch.Name)
+	if _, _, err := git.NewCommand(ctx, "update-ref", "-m", "restore deleted branch").AddDynamicArguments(refName.String(), commitID, git.EmptySHA).RunStdString(&git.RunOpts{Dir: repoPath}); err != nil {
+		if git.IsBranchExist(ctx, repoPath, branch.Name) {
+			return nil, git_model.ErrBranchAlreadyExists{BranchName: branch.Name}
+		}
+		return nil, fmt.Errorf("update-ref %s: %w", refName, err)
+	}
+	// no hooks run for the update, which would have invalidated the cached packs
+	packcache.Invalidate(repo.ID)
+
+	gitRepo, err := git.OpenRepository(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer gitRepo.Close()
+	commit, err := gitRepo.GetCommit(commitID)
+	if err != nil {
+		return nil, err
+	}
+	if err := git_model.RestoreDeletedBranch(ctx, repo.ID, branch.ID, doer.ID, commit); err != nil {
+		return nil, err
+	}
+
+	ref := data_repo_module.KeepAliveRefName(branch.ID)
+	if _, _, err := git.NewCommand(ctx, "update-ref", "-d").AddDynamicArguments(ref).RunStdString(&git.RunOpts{Dir: repoPath}); err != nil {
+		log.Error("Unable to delete the keep-alive ref %s of %s: %v", ref, repo.FullName(), err)
+	}
+
+	// notifies the push like any other branch creation
+	if err := pushUpdates([]*repo_module.PushUpdateOptions{{
+		PusherID:     doer.ID,
+		PusherName:   doer.Name,
+		RepoUserName: repo.OwnerName,
+		RepoName:     repo.Name,
+		RefFullName:  refName,
+		OldCommitID:  git.EmptySHA,
+		NewCommitID:  commitID,
+	}}); err != nil {
+		return nil, fmt.Errorf("push updates: %w", err)
+	}
+	return git_model.GetBranch(ctx, repo.ID, branch.Name)
+}
+
+// RenameBranch renames a branch of the repository, the old name keeps redirecting to the new one.
+// The doer must be allowed to push to both names if they are protected.
+func RenameBranch(ctx context.Context, doer *user_model.User, repo *repo_model.Repository, from, to string) error {
+	if !git.IsValidRefPattern(to) {
+		return ErrInvalidBranchName{BranchName: to}
+	}
+	for _, name := range []string{from, to} {
+		if err := checkCanPushBranch(ctx, doer, repo, name); err != nil {
+			return err
+		}
+	}
+	gitRepo, err := git.OpenRepository(ctx, repo.RepoPath())
+	if err != nil {
+		return err
+	}
+	defer gitRepo.Close()
+
+	msg, err := repo_service.RenameBranch(ctx, repo, doer, gitRepo, from, to)
+	if err != nil {
+		return err
+	}
+	switch msg {
+	case "target_exist":
+		return git_model.ErrBranchAlreadyExists{BranchName: to}
+	case "from_not_exist":
+		return git_model.ErrBranchNotExist{RepoID: repo.ID, BranchName: from}
+	}
+	packcache.Invalidate(repo.ID)
+	return nil
+}
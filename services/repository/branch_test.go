@@ -0,0 +1,254 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repository
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	repo_model "code.gitea.io/gitea/models/repo"
+	"code.gitea.io/gitea/models/unittest"
+	user_model "code.gitea.io/gitea/models/user"
+	"code.gitea.io/gitea/modules/git"
+	repo_module "code.gitea.io/gitea/modules/repository"
+	"code.gitea.io/gitea/modules/test"
+
+	git_model "github.com/openmerlin/gitea_data/models/git"
+	data_repo_module "github.com/openmerlin/gitea_data/modules/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the heads of master and home-md-img-check of user2/repo1
+const (
+	repo1Master   = "65f1bf27bc3bf70f64657658635e66094edbcb4d"
+	repo1ImgCheck = "78fb907e3a3309eae4fe8fef030874cebbf1cd5e"
+)
+
+func runGit(t *testing.T, repoPath string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=gitea", "-c", "user.email=gitea@example.com", "--git-dir", repoPath}, args...)...)
+	out, err := cmd.Output()
+	require.NoError(t, err, "git %v", args)
+	return strings.TrimSpace(string(out))
+}
+
+// orphanCommit creates a commit which no branch of the repository contains
+func orphanCommit(t *testing.T, repoPath, message string) string {
+	emptyTree := runGit(t, repoPath, "hash-object", "-t", "tree", "-w", "/dev/null")
+	return runGit(t, repoPath, "commit-tree", emptyTree, "-m", message)
+}
+
+func refExists(repoPath, ref string) bool {
+	return exec.Command("git", "--git-dir", repoPath, "rev-parse", "--verify", "--quiet", ref).Run() == nil
+}
+
+// addDeletedBranch adds a row of a deleted branch to the branch table
+func addDeletedBranch(t *testing.T, repoID int64, name, commitID string) *git_model.Branch {
+	branch := &git_model.Branch{RepoID: repoID, Name: name, CommitID: commitID, PusherID: 2, IsDeleted: true, DeletedByID: 2}
+	require.NoError(t, db.Insert(db.DefaultContext, branch))
+	return branch
+}
+
+// recordPushUpdates replaces pushUpdates for the test, the updates are recorded instead of being queued
+func recordPushUpdates(t *testing.T) *[]*repo_module.PushUpdateOptions {
+	var updates []*repo_module.PushUpdateOptions
+	t.Cleanup(test.MockVariableValue(&pushUpdates, func(opts []*repo_module.PushUpdateOptions) error {
+		updates = append(updates, opts...)
+		return nil
+	}))
+	return &updates
+}
+
+func TestSyncBranches(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	repoPath := repo.RepoPath()
+
+	// branch2 has been force pushed and the deleted branch foo pushed again behind the table's back,
+	// the row of sync-gone has no branch anymore
+	runGit(t, repoPath, "update-ref", "refs/heads/branch2", repo1ImgCheck)
+	runGit(t, repoPath, "update-ref", "refs/heads/foo", repo1Master)
+	runGit(t, repoPath, "update-ref", data_repo_module.KeepAliveRefName(1), repo1Master)
+	gone := &git_model.Branch{RepoID: repo.ID, Name: "sync-gone", CommitID: repo1ImgCheck, PusherID: 2}
+	require.NoError(t, git_model.AddBranches(db.DefaultContext, []*git_model.Branch{gone}))
+	onDisk := strings.Split(runGit(t, repoPath, "for-each-ref", "--format=%(refname)", "refs/heads/"), "\n")
+
+	result, err := SyncBranches(db.DefaultContext, repo, 1)
+	require.NoError(t, err)
+	// the fixtures only have branch2 and master of the branches on disk
+	assert.Equal(t, &BranchSyncResult{Total: len(onDisk), Added: len(onDisk) - 3, Updated: 2, Deleted: 1}, result)
+
+	branch2 := unittest.AssertExistsAndLoadBean(t, &git_model.Branch{RepoID: repo.ID, Name: "branch2"})
+	assert.Equal(t, repo1ImgCheck, branch2.CommitID)
+	// the pusher of the row isn't known, so it's left alone
+	assert.EqualValues(t, 1, branch2.PusherID)
+	foo := unittest.AssertExistsAndLoadBean(t, &git_model.Branch{RepoID: repo.ID, Name: "foo"})
+	assert.False(t, foo.IsDeleted)
+	assert.Equal(t, repo1Master, foo.CommitID)
+	assert.False(t, refExists(repoPath, data_repo_module.KeepAliveRefName(foo.ID)))
+	unittest.AssertExistsAndLoadBean(t, &git_model.Branch{RepoID: repo.ID, Name: "develop", CommitID: repo1Master, PusherID: 1})
+
+	gone = unittest.AssertExistsAndLoadBean(t, &git_model.Branch{ID: gone.ID})
+	assert.True(t, gone.IsDeleted)
+	assert.EqualValues(t, 1, gone.DeletedByID)
+	assert.EqualValues(t, 2, gone.PusherID)
+	assert.Equal(t, repo1ImgCheck, runGit(t, repoPath, "rev-parse", data_repo_module.KeepAliveRefName(gone.ID)))
+
+	result, err = SyncBranches(db.DefaultContext, repo, 1)
+	require.NoError(t, err)
+	assert.Equal(t, &BranchSyncResult{Total: len(onDisk)}, result)
+
+	t.Run("ConcurrentPush", func(t *testing.T) {
+		// the row as the sync read it before a push changed it
+		stale := *branch2
+		stale.CommitID = repo1Master
+		updated, err := git_model.UpdateBranchIfUnchanged(db.DefaultContext, &stale, &git_model.Branch{CommitID: repo1Master})
+		require.NoError(t, err)
+		assert.False(t, updated)
+		marked, err := git_model.MarkBranchDeletedIfUnchanged(db.DefaultContext, &stale, 1)
+		require.NoError(t, err)
+		assert.False(t, marked)
+		unittest.AssertExistsAndLoadBean(t, &git_model.Branch{ID: branch2.ID, CommitID: repo1ImgCheck, IsDeleted: false})
+	})
+}
+
+func TestRestoreBranch(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	doer := unittest.AssertExistsAndLoadBean(t, &user_model.User{ID: 2})
+	repoPath := repo.RepoPath()
+	updates := recordPushUpdates(t)
+
+	t.Run("Restore", func(t *testing.T) {
+		branch := addDeletedBranch(t, repo.ID, "restore-kept", repo1ImgCheck)
+		runGit(t, repoPath, "update-ref", data_repo_module.KeepAliveRefName(branch.ID), repo1ImgCheck)
+
+		restored, err := RestoreBranch(db.DefaultContext, doer, repo, branch.ID)
+		require.NoError(t, err)
+		assert.False(t, restored.IsDeleted)
+		assert.Equal(t, repo1ImgCheck, restored.CommitID)
+		assert.EqualValues(t, doer.ID, restored.PusherID)
+		assert.Equal(t, repo1ImgCheck, runGit(t, repoPath, "rev-parse", "refs/heads/restore-kept"))
+		assert.False(t, refExists(repoPath, data_repo_module.KeepAliveRefName(branch.ID)))
+		if assert.Len(t, *updates, 1) {
+			assert.Equal(t, git.EmptySHA, (*updates)[0].OldCommitID)
+			assert.Equal(t, repo1ImgCheck, (*updates)[0].NewCommitID)
+			assert.Equal(t, git.RefNameFromBranch("restore-kept"), (*updates)[0].RefFullName)
+		}
+
+		// it isn't deleted anymore
+		_, err = RestoreBranch(db.DefaultContext, doer, repo, branch.ID)
+		assert.True(t, git_model.IsErrBranchNotExist(err), "%v", err)
+	})
+
+	t.Run("KeptAlive", func(t *testing.T) {
+		// the commit of the row has been garbage collected, the keep-alive ref still has the tip
+		orphan := orphanCommit(t, repoPath, "kept alive")
+		branch := addDeletedBranch(t, repo.ID, "restore-gc", "0123456789012345678901234567890123456789")
+		runGit(t, repoPath, "update-ref", data_repo_module.KeepAliveRefName(branch.ID), orphan)
+
+		restored, err := RestoreBranch(db.DefaultContext, doer, repo, branch.ID)
+		require.NoError(t, err)
+		assert.Equal(t, orphan, restored.CommitID)
+		assert.Equal(t, orphan, runGit(t, repoPath, "rev-parse", "refs/heads/restore-gc"))
+	})
+
+	t.Run("CommitGone", func(t *testing.T) {
+		branch := addDeletedBranch(t, repo.ID, "restore-lost", "0123456789012345678901234567890123456789")
+		_, err := RestoreBranch(db.DefaultContext, doer, repo, branch.ID)
+		assert.True(t, IsErrBranchCommitNotExist(err), "%v", err)
+	})
+
+	t.Run("AlreadyExists", func(t *testing.T) {
+		branch := addDeletedBranch(t, repo.ID, "restore-exists", repo1ImgCheck)
+		runGit(t, repoPath, "update-ref", "refs/heads/restore-exists", repo1Master)
+		_, err := RestoreBranch(db.DefaultContext, doer, repo, branch.ID)
+		assert.True(t, git_model.IsErrBranchAlreadyExists(err), "%v", err)
+		assert.Equal(t, repo1Master, runGit(t, repoPath, "rev-parse", "refs/heads/restore-exists"))
+	})
+
+	t.Run("Protected", func(t *testing.T) {
+		require.NoError(t, db.Insert(db.DefaultContext, &git_model.ProtectedBranch{RepoID: repo.ID, RuleName: "restore-protected"}))
+		branch := addDeletedBranch(t, repo.ID, "restore-protected", repo1ImgCheck)
+		_, err := RestoreBranch(db.DefaultContext, doer, repo, branch.ID)
+		assert.True(t, IsErrBranchProtected(err), "%v", err)
+		assert.False(t, refExists(repoPath, "refs/heads/restore-protected"))
+	})
+}
+
+func TestRenameBranch(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	doer := unittest.AssertExistsAndLoadBean(t, &user_model.User{ID: 2})
+	repoPath := repo.RepoPath()
+
+	addBranch := func(t *testing.T, name string) {
+		runGit(t, repoPath, "update-ref", "refs/heads/"+name, repo1ImgCheck)
+		require.NoError(t, git_model.AddBranches(db.DefaultContext, []*git_model.Branch{
+			{RepoID: repo.ID, Name: name, CommitID: repo1ImgCheck, PusherID: doer.ID},
+		}))
+	}
+
+	t.Run("Rename", func(t *testing.T) {
+		addBranch(t, "rename-from")
+		require.NoError(t, RenameBranch(db.DefaultContext, doer, repo, "rename-from", "rename-to"))
+		assert.False(t, refExists(repoPath, "refs/heads/rename-from"))
+		assert.Equal(t, repo1ImgCheck, runGit(t, repoPath, "rev-parse", "refs/heads/rename-to"))
+		unittest.AssertExistsAndLoadBean(t, &git_model.Branch{RepoID: repo.ID, Name: "rename-to"})
+		renamed, exist, err := git_model.FindRenamedBranch(db.DefaultContext, repo.ID, "rename-from")
+		require.NoError(t, err)
+		assert.True(t, exist)
+		assert.Equal(t, "rename-to", renamed.To)
+	})
+
+	t.Run("InvalidName", func(t *testing.T) {
+		err := RenameBranch(db.DefaultContext, doer, repo, "master", "a..b")
+		assert.True(t, IsErrInvalidBranchName(err), "%v", err)
+		assert.False(t, git_model.IsErrBranchNameConflict(err))
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		err := RenameBranch(db.DefaultContext, doer, repo, "develop", "master")
+		assert.True(t, git_model.IsErrBranchAlreadyExists(err), "%v", err)
+	})
+
+	t.Run("NotExist", func(t *testing.T) {
+		err := RenameBranch(db.DefaultContext, doer, repo, "rename-missing", "rename-missing-to")
+		assert.True(t, git_model.IsErrBranchNotExist(err), "%v", err)
+	})
+
+	t.Run("Protected", func(t *testing.T) {
+		require.NoError(t, db.Insert(db.DefaultContext, &git_model.ProtectedBranch{RepoID: repo.ID, RuleName: "rename-protected*"}))
+		addBranch(t, "rename-protected")
+		addBranch(t, "rename-unprotected")
+
+		// neither away from nor onto a protected name
+		err := RenameBranch(db.DefaultContext, doer, repo, "rename-protected", "rename-other")
+		assert.True(t, IsErrBranchProtected(err), "%v", err)
+		err = RenameBranch(db.DefaultContext, doer, repo, "rename-unprotected", "rename-protected-too")
+		assert.True(t, IsErrBranchProtected(err), "%v", err)
+		assert.True(t, refExists(repoPath, "refs/heads/rename-protected"))
+		assert.True(t, refExists(repoPath, "refs/heads/rename-unprotected"))
+	})
+}
+
+func TestPruneAllKeepAliveRefs(t *testing.T) {
+	require.NoError(t, unittest.PrepareTestDatabase())
+	repo := unittest.AssertExistsAndLoadBean(t, &repo_model.Repository{ID: 1})
+	repoPath := repo.RepoPath()
+
+	kept := addDeletedBranch(t, repo.ID, "prune-kept", orphanCommit(t, repoPath, "kept"))
+	keptRef := data_repo_module.KeepAliveRefName(kept.ID)
+	runGit(t, repoPath, "update-ref", keptRef, kept.CommitID)
+	// the row of this ref has been removed by the cleanup of the branch table
+	orphanRef := data_repo_module.KeepAliveRefName(kept.ID + 1000)
+	runGit(t, repoPath, "update-ref", orphanRef, orphanCommit(t, repoPath, "orphan"))
+
+	require.NoError(t, PruneAllKeepAliveRefs(db.DefaultContext))
+	assert.True(t, refExists(repoPath, keptRef))
+	assert.False(t, refExists(repoPath, orphanRef))
+}
@@ -25,7 +25,12 @@ import (
 	repo_service "code.gitea.io/gitea/services/repository"
 )
 
-type CreateRepoOptions = repo_service.CreateRepoOptions
+// CreateRepoOptions are the options of CreateRepositoryDirectly
+type CreateRepoOptions struct {
+	repo_service.CreateRepoOptions
+	// GitAttributes is written to .gitattributes in the initial commit if AutoInit is set
+	GitAttributes string
+}
 
 // CreateRepositoryDirectly creates a repository for the user/organization.
 func CreateRepositoryDirectly(ctx context.Context, doer, u *user_model.User, opts CreateRepoOptions) (*repo_model.Repository, error) {
@@ -292,5 +297,12 @@ func prepareRepoCommit(ctx context.Context, repo *repo_model.Repository, tmpDir,
 		}
 	}
 
+	// .gitattributes
+	if len(opts.GitAttributes) > 0 {
+		if err = os.WriteFile(filepath.Join(tmpDir, ".gitattributes"), []byte(opts.GitAttributes), 0o644); err != nil {
+			return fmt.Errorf("write .gitattributes: %w", err)
+		}
+	}
+
 	return nil
 }
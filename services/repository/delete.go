@@ -28,6 +28,7 @@ import (
 	"code.gitea.io/gitea/modules/log"
 	"code.gitea.io/gitea/modules/storage"
 
+	data_repo_model "github.com/openmerlin/gitea_data/models/repo"
 	"xorm.io/builder"
 )
 
@@ -151,6 +152,9 @@ func DeleteRepositoryDirectly(ctx context.Context, doer *user_model.User, uid, r
 		&repo_model.RepoIndexerStatus{RepoID: repoID},
 		&repo_model.Redirect{RedirectRepoID: repoID},
 		&repo_model.RepoUnit{RepoID: repoID},
+		&data_repo_model.RepoValidator{RepoID: repoID},
+		&data_repo_model.RepoPathGrant{RepoID: repoID},
+		&data_repo_model.DownstreamMirror{RepoID: repoID},
 		&repo_model.Star{RepoID: repoID},
 		&admin_model.Task{RepoID: repoID},
 		&repo_model.Watch{RepoID: repoID},
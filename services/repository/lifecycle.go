@@ -0,0 +1,59 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package repository
+
+import (
+	"context"
+	"strings"
+
+	packages_model "code.gitea.io/gitea/models/packages"
+	repo_model "code.gitea.io/gitea/models/repo"
+	user_model "code.gitea.io/gitea/models/user"
+	notify_service "code.gitea.io/gitea/services/notify"
+	repo_service "code.gitea.io/gitea/services/repository"
+)
+
+// LFSGitAttributes returns the .gitattributes content which tracks the patterns with LFS
+func LFSGitAttributes(patterns []string) string {
+	var sb strings.Builder
+	for _, pattern := range patterns {
+		sb.WriteString(pattern)
+		sb.WriteString(" filter=lfs diff=lfs merge=lfs -text\n")
+	}
+	return sb.String()
+}
+
+// RenameRepository renames the repository and leaves a redirect for the old name, renaming to the current name does nothing
+func RenameRepository(ctx context.Context, doer *user_model.User, repo *repo_model.Repository, newName string) error {
+	if repo.Name == newName {
+		return nil
+	}
+	return repo_service.ChangeRepositoryName(ctx, doer, repo, newName)
+}
+
+// TransferRepository transfers the repository to newOwner and leaves a redirect under the old owner,
+// transferring to the current owner does nothing
+func TransferRepository(ctx context.Context, doer, newOwner *user_model.User, repo *repo_model.Repository) error {
+	if repo.OwnerID == newOwner.ID {
+		return nil
+	}
+	return repo_service.TransferOwnership(ctx, doer, newOwner, repo, nil)
+}
+
+// SetRepositoryArchived archives or unarchives the repository
+func SetRepositoryArchived(ctx context.Context, repo *repo_model.Repository, archived bool) error {
+	if repo.IsArchived == archived {
+		return nil
+	}
+	return repo_model.SetArchiveRepoState(ctx, repo, archived)
+}
+
+// DeleteRepository deletes the repository with all its data
+func DeleteRepository(ctx context.Context, doer *user_model.User, repo *repo_model.Repository) error {
+	notify_service.DeleteRepository(ctx, doer, repo)
+	if err := DeleteRepositoryDirectly(ctx, doer, repo.OwnerID, repo.ID); err != nil {
+		return err
+	}
+	return packages_model.UnlinkRepositoryFromAllPackages(ctx, repo.ID)
+}
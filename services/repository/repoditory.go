@@ -14,6 +14,7 @@ import (
 	repo_module "code.gitea.io/gitea/modules/repository"
 	"code.gitea.io/gitea/modules/setting"
 	notify_service "code.gitea.io/gitea/services/notify"
+	repo_service "code.gitea.io/gitea/services/repository"
 )
 
 // PushCreateRepo creates a repository when a new repository is pushed to an appropriate namespace
@@ -31,8 +32,10 @@ func PushCreateRepo(ctx context.Context, authUser, owner *user_model.User, repoN
 	}
 
 	repo, err := CreateRepository(ctx, authUser, owner, CreateRepoOptions{
-		Name:      repoName,
-		IsPrivate: setting.Repository.DefaultPushCreatePrivate,
+		CreateRepoOptions: repo_service.CreateRepoOptions{
+			Name:      repoName,
+			IsPrivate: setting.Repository.DefaultPushCreatePrivate,
+		},
 	})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,57 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package transfer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "gitea_transfer_"
+
+// Collector exposes the transferred bytes for prometheus
+type Collector struct {
+	Requests      *prometheus.Desc
+	ReceivedBytes *prometheus.Desc
+	SentBytes     *prometheus.Desc
+}
+
+// NewCollector returns a new Collector with all prometheus.Desc initialized
+func NewCollector() Collector {
+	labels := []string{"owner", "service", "auth_type"}
+	return Collector{
+		Requests: prometheus.NewDesc(
+			namespace+"requests_total",
+			"Number of git and LFS transfer requests",
+			labels, nil,
+		),
+		ReceivedBytes: prometheus.NewDesc(
+			namespace+"received_bytes_total",
+			"Number of bytes received from clients",
+			labels, nil,
+		),
+		SentBytes: prometheus.NewDesc(
+			namespace+"sent_bytes_total",
+			"Number of bytes sent to clients",
+			labels, nil,
+		),
+	}
+}
+
+// Describe returns all possible prometheus.Desc
+func (c Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.Requests
+	ch <- c.ReceivedBytes
+	ch <- c.SentBytes
+}
+
+// Collect returns the metrics with values
+func (c Collector) Collect(ch chan<- prometheus.Metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	for key, value := range totals {
+		ch <- prometheus.MustNewConstMetric(c.Requests, prometheus.CounterValue, float64(value.requests), key.owner, key.service, key.authType)
+		ch <- prometheus.MustNewConstMetric(c.ReceivedBytes, prometheus.CounterValue, float64(value.bytesIn), key.owner, key.service, key.authType)
+		ch <- prometheus.MustNewConstMetric(c.SentBytes, prometheus.CounterValue, float64(value.bytesOut), key.owner, key.service, key.authType)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package transfer
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// CountingReader counts the bytes read through it
+type CountingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+// NewCountingReader wraps r
+func NewCountingReader(r io.Reader) *CountingReader {
+	return &CountingReader{r: r}
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes read so far
+func (c *CountingReader) Count() int64 {
+	return c.n.Load()
+}
+
+// CountingWriter counts the bytes written through it
+type CountingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+// NewCountingWriter wraps w
+func NewCountingWriter(w io.Writer) *CountingWriter {
+	return &CountingWriter{w: w}
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes written so far
+func (c *CountingWriter) Count() int64 {
+	return c.n.Load()
+}
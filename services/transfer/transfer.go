@@ -0,0 +1,159 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+// Package transfer accounts the bytes repositories transfer over git and LFS.
+// The counts are exposed as prometheus counters and aggregated per hour in the database for billing.
+package transfer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	gitea_context "code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/graceful"
+	"code.gitea.io/gitea/modules/log"
+	"code.gitea.io/gitea/modules/timeutil"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+)
+
+// The services bytes are accounted for
+const (
+	ServiceUploadPack  = "upload-pack"
+	ServiceReceivePack = "receive-pack"
+	ServiceLFSDownload = "lfs-download"
+	ServiceLFSUpload   = "lfs-upload"
+)
+
+// flushInterval is how often the pending hourly aggregates are written to the database
+const flushInterval = time.Minute
+
+// Transfer is the traffic of a single request
+type Transfer struct {
+	RepoID    int64
+	OwnerName string
+	Service   string
+	AuthType  string
+	BytesIn   int64
+	BytesOut  int64
+}
+
+type aggregateKey struct {
+	repoID   int64
+	service  string
+	authType string
+	hour     timeutil.TimeStamp
+}
+
+type metricKey struct {
+	owner    string
+	service  string
+	authType string
+}
+
+type metricValue struct {
+	requests int64
+	bytesIn  int64
+	bytesOut int64
+}
+
+var (
+	mu      sync.Mutex
+	pending = make(map[aggregateKey]*repo_model.TransferStat)
+	totals  = make(map[metricKey]*metricValue)
+)
+
+// AuthType returns how the doer of a request authenticated
+func AuthType(ctx *gitea_context.Context) string {
+	if ctx.Doer == nil {
+		return "anonymous"
+	}
+	if ctx.Data["IsActionsToken"] == true {
+		return "actions"
+	}
+	if ctx.Data["IsApiToken"] == true {
+		return "token"
+	}
+	if method, ok := ctx.Data["AuthedMethod"].(string); ok && method != "" {
+		return method
+	}
+	// the doer was set by the LFS authorization token
+	return "lfs_token"
+}
+
+// Record accounts the traffic of a request
+func Record(t Transfer) {
+	hour := timeutil.TimeStamp(time.Now().Truncate(time.Hour).Unix())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	mk := metricKey{owner: t.OwnerName, service: t.Service, authType: t.AuthType}
+	total, ok := totals[mk]
+	if !ok {
+		total = &metricValue{}
+		totals[mk] = total
+	}
+	total.requests++
+	total.bytesIn += t.BytesIn
+	total.bytesOut += t.BytesOut
+
+	ak := aggregateKey{repoID: t.RepoID, service: t.Service, authType: t.AuthType, hour: hour}
+	stat, ok := pending[ak]
+	if !ok {
+		stat = &repo_model.TransferStat{
+			RepoID:    t.RepoID,
+			OwnerName: t.OwnerName,
+			Service:   t.Service,
+			AuthType:  t.AuthType,
+			Hour:      hour,
+		}
+		pending[ak] = stat
+	}
+	stat.Requests++
+	stat.BytesIn += t.BytesIn
+	stat.BytesOut += t.BytesOut
+}
+
+// Flush writes the pending aggregates to the database, failed aggregates are kept for the next flush
+func Flush(ctx context.Context) {
+	mu.Lock()
+	stats := pending
+	pending = make(map[aggregateKey]*repo_model.TransferStat)
+	mu.Unlock()
+
+	for key, stat := range stats {
+		if err := repo_model.AddTransferStat(ctx, stat); err != nil {
+			log.Error("Unable to store transfer stat of repository %d: %v", stat.RepoID, err)
+			mu.Lock()
+			if newer, ok := pending[key]; ok {
+				newer.Requests += stat.Requests
+				newer.BytesIn += stat.BytesIn
+				newer.BytesOut += stat.BytesOut
+			} else {
+				pending[key] = stat
+			}
+			mu.Unlock()
+		}
+	}
+}
+
+// Init starts writing the hourly aggregates to the database
+func Init() error {
+	go graceful.GetManager().RunWithShutdownContext(func(ctx context.Context) {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// the database is still available until the hammer
+				Flush(graceful.GetManager().HammerContext())
+				return
+			case <-ticker.C:
+				Flush(ctx)
+			}
+		}
+	})
+	return nil
+}
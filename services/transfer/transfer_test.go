@@ -0,0 +1,84 @@
+// Copyright 2024 The Gitea Authors. All rights reserved.
+// SPDX-License-Identifier: MIT
+
+package transfer
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"code.gitea.io/gitea/models/db"
+	"code.gitea.io/gitea/models/unittest"
+	user_model "code.gitea.io/gitea/models/user"
+	gitea_context "code.gitea.io/gitea/modules/context"
+	"code.gitea.io/gitea/modules/web/middleware"
+
+	repo_model "github.com/openmerlin/gitea_data/models/repo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounting(t *testing.T) {
+	r := NewCountingReader(strings.NewReader("0123456789"))
+	_, err := io.CopyN(io.Discard, r, 4)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, r.Count())
+	_, err = io.Copy(io.Discard, r)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 10, r.Count())
+
+	var buf bytes.Buffer
+	w := NewCountingWriter(&buf)
+	_, _ = w.Write([]byte("abc"))
+	_, _ = w.Write([]byte("de"))
+	assert.EqualValues(t, 5, w.Count())
+	assert.Equal(t, "abcde", buf.String())
+}
+
+func TestAuthType(t *testing.T) {
+	newContext := func(doer *user_model.User, data middleware.ContextData) *gitea_context.Context {
+		return &gitea_context.Context{Base: &gitea_context.Base{Data: data}, Doer: doer}
+	}
+	doer := &user_model.User{ID: 2}
+
+	assert.Equal(t, "anonymous", AuthType(newContext(nil, middleware.ContextData{})))
+	assert.Equal(t, "actions", AuthType(newContext(doer, middleware.ContextData{"IsActionsToken": true})))
+	assert.Equal(t, "token", AuthType(newContext(doer, middleware.ContextData{"IsApiToken": true})))
+	assert.Equal(t, "basic", AuthType(newContext(doer, middleware.ContextData{"AuthedMethod": "basic"})))
+	assert.Equal(t, "lfs_token", AuthType(newContext(doer, middleware.ContextData{})))
+}
+
+func TestRecordAndFlush(t *testing.T) {
+	assert.NoError(t, unittest.PrepareTestDatabase())
+
+	Record(Transfer{RepoID: 1, OwnerName: "user2", Service: ServiceUploadPack, AuthType: "token", BytesIn: 10, BytesOut: 100})
+	Record(Transfer{RepoID: 1, OwnerName: "user2", Service: ServiceUploadPack, AuthType: "token", BytesIn: 5, BytesOut: 50})
+	Record(Transfer{RepoID: 1, OwnerName: "user2", Service: ServiceLFSUpload, AuthType: "token", BytesIn: 1000})
+	Flush(db.DefaultContext)
+
+	stats, err := repo_model.FindTransferStats(db.DefaultContext, &repo_model.FindTransferStatsOptions{RepoID: 1, Service: ServiceUploadPack})
+	assert.NoError(t, err)
+	if assert.Len(t, stats, 1) {
+		assert.EqualValues(t, 2, stats[0].Requests)
+		assert.EqualValues(t, 15, stats[0].BytesIn)
+		assert.EqualValues(t, 150, stats[0].BytesOut)
+		assert.Equal(t, "user2", stats[0].OwnerName)
+	}
+
+	// a later flush of the same hour adds to the aggregate
+	Record(Transfer{RepoID: 1, OwnerName: "user2", Service: ServiceUploadPack, AuthType: "token", BytesOut: 1})
+	Flush(db.DefaultContext)
+	stats, err = repo_model.FindTransferStats(db.DefaultContext, &repo_model.FindTransferStatsOptions{RepoID: 1, Service: ServiceUploadPack})
+	assert.NoError(t, err)
+	if assert.Len(t, stats, 1) {
+		assert.EqualValues(t, 3, stats[0].Requests)
+		assert.EqualValues(t, 151, stats[0].BytesOut)
+	}
+
+	stats, err = repo_model.FindTransferStats(db.DefaultContext, &repo_model.FindTransferStatsOptions{OwnerName: "user2"})
+	assert.NoError(t, err)
+	assert.Len(t, stats, 2)
+	assert.Empty(t, pending)
+}